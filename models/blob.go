@@ -0,0 +1,10 @@
+package models
+
+// Blob is a piece of content stored once under its SHA-256, shared by every File with that hash.
+type Blob struct {
+	Hash      string `bson:"hash" json:"hash"`
+	Size      int64  `bson:"size" json:"size"`
+	Path      string `bson:"path" json:"path"`
+	RefCount  int64  `bson:"ref_count" json:"ref_count"`
+	CreatedAt int64  `bson:"created_at" json:"created_at"`
+}
@@ -1,10 +1,32 @@
 package models
 
+import "time"
+
 const (
 	UserContextKey string = "userContext"
 
 	ConnectDBMaxAttempts = 3
 
+	// Sessions
+	AccessTokenTTL  = 1 * time.Hour
+	RefreshTokenTTL = 30 * 24 * time.Hour
+
+	// A session is only extended once less than SessionExtendThreshold of it is left, so
+	// an active session is written about every AccessTokenTTL - SessionExtendThreshold.
+	SessionExtendThreshold = 50 * time.Minute
+
+	// Sessions are deleted from MongoDB ExpiredSessionRetention after they can no longer
+	// be used or refreshed, so the sessions list keeps some history.
+	ExpiredSessionRetention = 7 * 24 * time.Hour
+
+	// Authenticated sessions are cached for DefaultAuthCacheTTL, see AuthCacheTTLSeconds
+	// in config.
+	DefaultAuthCacheTTL = 5 * time.Second
+
+	// Share links. The password of a protected link is sent in SharePasswordHeader.
+	SharePath           = "/s/"
+	SharePasswordHeader = "X-Share-Password"
+
 	// Middleware
 	MiddlewareBearerScheme = "bearer"
 	MiddlewareSpace        = " "
@@ -12,6 +34,57 @@ const (
 	// server Error Message.
 	ServerErrorMsg   = "Internal Server Error occurred. Please contact your administrator."
 	DefaultDirectory = "storage"
-)
+	UploadsDirectory = ".uploads"
+	BlobsPrefix      = "blobs"
+
+	// Limits on names supplied by clients.
+	MaxFilenameBytes  = 255
+	MinUsernameLength = 3
+	MaxUsernameLength = 32
+
+	// Password limits. bcrypt ignores everything past 72 bytes.
+	MinPasswordLength = 8
+	MaxPasswordBytes  = 72
 
-var JwtSigningSecretKey = []byte("supersecretkey")
+	// Login protection defaults.
+	DefaultLoginMaxFailures   = 5
+	DefaultLoginBaseLockout   = 30 * time.Second
+	DefaultLoginMaxLockout    = 1 * time.Hour
+	DefaultLoginFailureWindow = 15 * time.Minute
+	LoginFailedMsg            = "invalid username or password"
+
+	// StatusClientClosedRequest is logged for requests the client gave up on before they
+	// finished; nobody is left to receive the response.
+	StatusClientClosedRequest = 499
+
+	// Database timeout defaults, see DBTimeouts.
+	DefaultDBQueryTimeout = 5 * time.Second
+	DefaultDBBatchTimeout = 10 * time.Second
+	DefaultDBScanTimeout  = 60 * time.Second
+
+	// Storage objects younger than this are never reported as orphans, as they may
+	// belong to an upload whose metadata is still being written.
+	ReconcileGracePeriod = 1 * time.Hour
+
+	// File listing.
+	DefaultFilePageSize = 50
+	FileSortByName      = "name"
+	FileSortBySize      = "size"
+	FileSortByDate      = "date"
+
+	// Folders. RootFolderID names the top level in URLs; it is stored as "".
+	RootFolderID = "root"
+
+	// Roles.
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+
+	// Resumable upload headers.
+	UploadOffsetHeader = "Upload-Offset"
+	UploadLengthHeader = "Upload-Length"
+
+	// A resumable upload expires UploadTTL after it was created or last received data,
+	// and a user can have at most MaxOpenUploads of them open at once.
+	UploadTTL      = 24 * time.Hour
+	MaxOpenUploads = 5
+)
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// DBTimeouts bounds database calls by class. Query covers single record reads and
+// writes, Batch covers calls that touch several records or run a transaction, and Scan
+// covers whole-collection work such as migrations, indexes and reconciliation.
+type DBTimeouts struct {
+	Query time.Duration
+	Batch time.Duration
+	Scan  time.Duration
+}
@@ -0,0 +1,29 @@
+package models
+
+import "errors"
+
+var (
+	ErrNotFound            = errors.New("not found")
+	ErrInsufficientStorage = errors.New("insufficient storage")
+	ErrFileTooLarge        = errors.New("file too large")
+	ErrFileLimitReached    = errors.New("file limit reached")
+	ErrDuplicateFile       = errors.New("duplicate file")
+	ErrNameTaken           = errors.New("name already taken")
+	ErrFolderNotFound      = errors.New("folder not found")
+	ErrFolderCycle         = errors.New("folder cannot be moved into itself")
+	ErrShareExpired        = errors.New("share link expired")
+	ErrShareExhausted      = errors.New("share link download limit reached")
+	ErrUploadOffsetChanged = errors.New("upload offset changed")
+	ErrTooManyUploads      = errors.New("too many open uploads")
+	ErrObjectNotFound      = errors.New("storage object not found")
+	ErrInvalidStorageKey   = errors.New("invalid storage key")
+	ErrInvalidFilename     = errors.New("invalid filename")
+	ErrInvalidCursor       = errors.New("invalid cursor")
+	ErrInvalidUsername     = errors.New("invalid username")
+	ErrUsernameTaken       = errors.New("username already taken")
+	ErrRequiredIndex       = errors.New("required database index missing")
+	ErrWeakPassword        = errors.New("password does not meet the policy")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+	ErrConcurrentUpdate    = errors.New("record changed since it was read")
+)
@@ -1,11 +1,53 @@
 package models
 
+// File is a user's logical file. Size, Path, Hash and UploadedAt describe its current version.
 type File struct {
-	ID         string `bson:"id" json:"id"`
-	UserID     string `bson:"user_id" json:"user_id"`
-	Filename   string `bson:"filename" json:"filename"`
-	Size       int64  `bson:"size" json:"size"`
-	Path       string `bson:"path" json:"path"`
-	Hash       string `bson:"hash" json:"hash"`
-	UploadedAt int64  `bson:"uploaded_at" json:"uploaded_at"`
+	ID          string `bson:"id" json:"id"`
+	UserID      string `bson:"user_id" json:"user_id"`
+	Filename    string `bson:"filename" json:"filename"`
+	FolderID    string `bson:"folder_id" json:"folder_id"`
+	Size        int64  `bson:"size" json:"size"`
+	Path        string `bson:"path" json:"path"`
+	Hash        string `bson:"hash" json:"hash"`
+	ContentType string `bson:"content_type" json:"content_type"`
+	UploadedAt  int64  `bson:"uploaded_at" json:"uploaded_at"`
+	Version     int64  `bson:"version" json:"version"`
+	LastVersion int64  `bson:"last_version" json:"-"`
+}
+
+// FileVersion is one stored revision of a File. Every version counts against the quota.
+type FileVersion struct {
+	ID          string `bson:"id" json:"id"`
+	FileID      string `bson:"file_id" json:"file_id"`
+	UserID      string `bson:"user_id" json:"user_id"`
+	Version     int64  `bson:"version" json:"version"`
+	Size        int64  `bson:"size" json:"size"`
+	Path        string `bson:"path" json:"path"`
+	Hash        string `bson:"hash" json:"hash"`
+	ContentType string `bson:"content_type" json:"content_type"`
+	UploadedAt  int64  `bson:"uploaded_at" json:"uploaded_at"`
+}
+
+// FileQuery selects a page of a user's files. Zero values leave a filter out.
+type FileQuery struct {
+	UserID         string
+	FolderID       *string
+	NamePrefix     string
+	ContentType    string
+	MinSize        *int64
+	MaxSize        *int64
+	UploadedAfter  *int64
+	UploadedBefore *int64
+	SortBy         string
+	Descending     bool
+	Limit          int64
+	Cursor         string
+}
+
+// FilePage is one page of a FileQuery. Total counts every match, not just this page;
+// NextCursor is empty on the last page.
+type FilePage struct {
+	Files      []File `json:"files"`
+	Total      int64  `json:"total"`
+	NextCursor string `json:"next_cursor"`
 }
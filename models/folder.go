@@ -0,0 +1,12 @@
+package models
+
+// Folder groups a user's files. ParentID is empty for folders at the top level. Folders
+// are metadata only: where a file's content is stored does not depend on its folder.
+type Folder struct {
+	ID        string `bson:"id" json:"id"`
+	UserID    string `bson:"user_id" json:"user_id"`
+	Name      string `bson:"name" json:"name"`
+	ParentID  string `bson:"parent_id" json:"parent_id"`
+	CreatedAt int64  `bson:"created_at" json:"created_at"`
+	UpdatedAt int64  `bson:"updated_at" json:"updated_at"`
+}
@@ -6,4 +6,12 @@ type ClientError struct {
 	Err           string `json:"error"`
 	StatusCode    int    `json:"statusCode"`
 	IsClientError bool   `json:"isClientError"`
+
+	// FieldErrors lists what is wrong with each field of an invalid request body.
+	FieldErrors []FieldError `json:"fieldErrors,omitempty"`
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
 }
@@ -0,0 +1,9 @@
+package models
+
+// LoginAttempt counts the recent failed logins for a username or a client IP.
+type LoginAttempt struct {
+	Key           string `json:"key" bson:"key"`
+	Failures      int64  `json:"failures" bson:"failures"`
+	LastFailureAt int64  `json:"last_failure_at" bson:"lastFailureAt"`
+	LockedUntil   int64  `json:"locked_until" bson:"lockedUntil"`
+}
@@ -0,0 +1,9 @@
+package models
+
+// Plan is a named set of limits. Sizes are in bytes; a MaxFileSize or MaxFiles of 0 means unlimited.
+type Plan struct {
+	Name        string `json:"name" bson:"name"`
+	Quota       int64  `json:"quota" bson:"quota"`
+	MaxFileSize int64  `json:"max_file_size" bson:"max_file_size"`
+	MaxFiles    int64  `json:"max_files" bson:"max_files"`
+}
@@ -0,0 +1,45 @@
+package models
+
+// ReconcileReport lists where recorded usage and metadata disagree with what is
+// actually stored. When Repaired is set, every listed difference has been fixed,
+// except those named in Errors.
+type ReconcileReport struct {
+	StartedAt       int64                `json:"started_at"`
+	FinishedAt      int64                `json:"finished_at"`
+	Repaired        bool                 `json:"repaired"`
+	Usage           []UsageCorrection    `json:"usage"`
+	OrphanedObjects []StorageObjectInfo  `json:"orphaned_objects"`
+	MissingContent  []MissingContent     `json:"missing_content"`
+	BlobRefCounts   []RefCountCorrection `json:"blob_ref_counts"`
+	ExpiredUploads  []Upload             `json:"expired_uploads"`
+	OrphanedUploads []string             `json:"orphaned_uploads"`
+	Errors          []string             `json:"errors"`
+}
+
+// UsageCorrection is a user whose recorded usage differs from the sum of their file versions.
+type UsageCorrection struct {
+	UserID          string `json:"user_id"`
+	Username        string `json:"username"`
+	RecordedStorage int64  `json:"recorded_storage"`
+	ActualStorage   int64  `json:"actual_storage"`
+	RecordedFiles   int64  `json:"recorded_files"`
+	ActualFiles     int64  `json:"actual_files"`
+}
+
+// MissingContent is a file version whose content is not in storage.
+type MissingContent struct {
+	UserID    string `json:"user_id"`
+	FileID    string `json:"file_id"`
+	Filename  string `json:"filename"`
+	VersionID string `json:"version_id"`
+	Version   int64  `json:"version"`
+	Path      string `json:"path"`
+}
+
+// RefCountCorrection is a blob whose recorded reference count differs from the
+// number of file versions that use it.
+type RefCountCorrection struct {
+	Hash     string `json:"hash"`
+	Recorded int64  `json:"recorded"`
+	Actual   int64  `json:"actual"`
+}
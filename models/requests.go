@@ -0,0 +1,74 @@
+package models
+
+// Request bodies. Each handler decodes into its own type, so clients can only set the
+// fields listed here; the binding tags are checked by utils.DecodeJSONBody.
+
+type RegisterRequest struct {
+	Name     string `json:"name" binding:"max=100"`
+	Username string `json:"username" binding:"required,username"`
+	Password string `json:"password" binding:"required"`
+}
+
+type UsernameAndPassword struct {
+	Password string `json:"password" bson:"password" binding:"required"`
+	Username string `json:"username" bson:"username" binding:"required"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type CreateUploadRequest struct {
+	Filename string `json:"filename" binding:"required"`
+	FolderID string `json:"folder_id"`
+	Size     int64  `json:"size" binding:"required,gt=0"`
+}
+
+type UpdateQuotaRequest struct {
+	Quota *int64 `json:"quota" binding:"required,min=0"`
+}
+
+type AssignPlanRequest struct {
+	Plan string `json:"plan" binding:"required"`
+}
+
+// ListFilesRequest is the query string of GET /files. Sizes are in bytes and dates are Unix seconds.
+type ListFilesRequest struct {
+	Limit          int64   `form:"limit" json:"limit" binding:"omitempty,min=1,max=200"`
+	Cursor         string  `form:"cursor" json:"cursor"`
+	FolderID       *string `form:"folder_id" json:"folder_id"`
+	NamePrefix     string  `form:"name_prefix" json:"name_prefix" binding:"max=255"`
+	ContentType    string  `form:"content_type" json:"content_type" binding:"max=255"`
+	MinSize        *int64  `form:"min_size" json:"min_size" binding:"omitempty,min=0"`
+	MaxSize        *int64  `form:"max_size" json:"max_size" binding:"omitempty,min=0"`
+	UploadedAfter  *int64  `form:"uploaded_after" json:"uploaded_after"`
+	UploadedBefore *int64  `form:"uploaded_before" json:"uploaded_before"`
+	Sort           string  `form:"sort" json:"sort" binding:"omitempty,oneof=name size date"`
+	Order          string  `form:"order" json:"order" binding:"omitempty,oneof=asc desc"`
+}
+
+type CreateFolderRequest struct {
+	Name     string `json:"name" binding:"required"`
+	ParentID string `json:"parent_id"`
+}
+
+// UpdateFolderRequest renames and/or moves a folder. A ParentID of "" moves it to the top level.
+type UpdateFolderRequest struct {
+	Name     *string `json:"name"`
+	ParentID *string `json:"parent_id"`
+}
+
+// UpdateFileRequest renames and/or moves a file. A FolderID of "" moves it to the top level.
+type UpdateFileRequest struct {
+	Filename *string `json:"filename"`
+	FolderID *string `json:"folder_id"`
+}
+
+// CreateShareRequest describes a share link. ExpiresIn is in seconds, at most a year;
+// zero values mean the link never expires, has no password or has no download limit.
+// The password is limited to MaxPasswordBytes by createShare.
+type CreateShareRequest struct {
+	ExpiresIn    int64  `json:"expires_in" binding:"min=0,max=31536000"`
+	Password     string `json:"password"`
+	MaxDownloads int64  `json:"max_downloads" binding:"min=0"`
+}
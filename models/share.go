@@ -0,0 +1,18 @@
+package models
+
+// Share is a public link to one of a user's files. Only the token's hash is stored.
+// ExpiresAt and MaxDownloads are 0 when the link never expires or has no download limit.
+type Share struct {
+	ID           string `bson:"id" json:"id"`
+	UserID       string `bson:"user_id" json:"user_id"`
+	FileID       string `bson:"file_id" json:"file_id"`
+	TokenHash    string `bson:"token_hash" json:"-"`
+	PasswordHash string `bson:"password_hash" json:"-"`
+	ExpiresAt    int64  `bson:"expires_at" json:"expires_at"`
+	MaxDownloads int64  `bson:"max_downloads" json:"max_downloads"`
+	Downloads    int64  `bson:"downloads" json:"downloads"`
+	CreatedAt    int64  `bson:"created_at" json:"created_at"`
+
+	// Token is only set on a freshly created share.
+	Token string `bson:"-" json:"-"`
+}
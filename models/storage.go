@@ -0,0 +1,8 @@
+package models
+
+// StorageObjectInfo describes an object held by a storage backend.
+type StorageObjectInfo struct {
+	Key     string `json:"key"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mod_time"`
+}
@@ -0,0 +1,16 @@
+package models
+
+// Upload tracks a resumable upload session until it is finalized into a File.
+type Upload struct {
+	ID        string `bson:"id" json:"id"`
+	UserID    string `bson:"user_id" json:"user_id"`
+	Filename  string `bson:"filename" json:"filename"`
+	FolderID  string `bson:"folder_id" json:"folder_id"`
+	Size      int64  `bson:"size" json:"size"`
+	Offset    int64  `bson:"offset" json:"offset"`
+	TempPath  string `bson:"temp_path" json:"-"`
+	HashState []byte `bson:"hash_state" json:"-"`
+	CreatedAt int64  `bson:"created_at" json:"created_at"`
+	UpdatedAt int64  `bson:"updated_at" json:"updated_at"`
+	ExpiresAt int64  `bson:"expires_at" json:"expires_at"`
+}
@@ -8,25 +8,58 @@ type User struct {
 	UsedStorage int64  `json:"used_storage" bson:"used_storage"`
 	Quota       int64  `json:"quota" bson:"quota"`
 	CreatedAt   int64  `json:"createdAt" bson:"createdAt"`
+	Role        string `json:"role" bson:"role"`
+	Disabled    bool   `json:"disabled" bson:"disabled"`
+	Plan        string `json:"plan" bson:"plan"`
+	MaxFileSize int64  `json:"max_file_size" bson:"max_file_size"`
+	MaxFiles    int64  `json:"max_files" bson:"max_files"`
+	FileCount   int64  `json:"file_count" bson:"file_count"`
 }
 
 type UserContext struct {
 	ID          string `json:"id" bson:"id"`
+	SessionID   string `json:"sessionId" bson:"sessionId"`
 	Name        string `json:"name" bson:"name"`
 	Username    string `json:"username" bson:"username"`
 	UsedStorage int64  `json:"used_storage" bson:"used_storage"`
 	Quota       int64  `json:"quota" bson:"quota"`
+	Role        string `json:"role" bson:"role"`
+	Plan        string `json:"plan" bson:"plan"`
+	MaxFileSize int64  `json:"max_file_size" bson:"max_file_size"`
+	MaxFiles    int64  `json:"max_files" bson:"max_files"`
+	FileCount   int64  `json:"file_count" bson:"file_count"`
 }
 
-type UsernameAndPassword struct {
-	Password string `json:"password" bson:"password"`
-	Username string `json:"username" bson:"username"`
+// UserSession is one link in a chain of sessions started by a login. Every refresh
+// replaces the session with a new one in the same family; presenting a refresh token
+// that was already used revokes the whole family.
+type UserSession struct {
+	ID               string `json:"id" bson:"id"`
+	UserID           string `json:"userId" bson:"userId"`
+	FamilyID         string `json:"familyId" bson:"familyId"`
+	StartTime        int64  `json:"startTime" bson:"startTime"`
+	EndTime          int64  `json:"endTime" bson:"endTime"`
+	Token            string `json:"token" bson:"token"`
+	RefreshTokenHash string `json:"-" bson:"refreshTokenHash"`
+	RefreshExpiresAt int64  `json:"refreshExpiresAt" bson:"refreshExpiresAt"`
+	RefreshUsed      bool   `json:"-" bson:"refreshUsed"`
+	UserAgent        string `json:"userAgent" bson:"userAgent"`
+	IPAddress        string `json:"ipAddress" bson:"ipAddress"`
+	LastSeenAt       int64  `json:"lastSeenAt" bson:"lastSeenAt"`
+
+	// RefreshToken is only set on a freshly created session; just its hash is stored.
+	RefreshToken string `json:"-" bson:"-"`
 }
 
-type UserSession struct {
-	ID        string `json:"id" bson:"id"`
-	UserID    string `json:"userId" bson:"userId"`
-	StartTime int64  `json:"startTime" bson:"startTime"`
-	EndTime   int64  `json:"endTime" bson:"endTime"`
-	Token     string `json:"token" bson:"token"`
+// SessionWithUser is a session together with the user it belongs to, read in a single
+// lookup to authenticate a request.
+type SessionWithUser struct {
+	Session UserSession `bson:"session"`
+	User    User        `bson:"user"`
+}
+
+// SessionClient describes the device a session was started or refreshed from.
+type SessionClient struct {
+	UserAgent string
+	IPAddress string
 }
@@ -1,31 +1,64 @@
 package authProvider
 
 import (
+	"fmt"
+	"sort"
 	"strconv"
 	"time"
 
+	"github.com/file_upload/config"
 	"github.com/file_upload/models"
+	"github.com/file_upload/providers"
 	"github.com/golang-jwt/jwt"
 	"github.com/sirupsen/logrus"
 )
 
-func GenerateJWT(user models.User, sessionToken string) (tokenString string, err error) {
+type authProvider struct {
+	keys      map[string]*signingKey
+	activeKey *signingKey
+}
+
+// NewAuthProvider loads the signing keys from the config. fallbackSecret, the old
+// jwt_secret setting, is used as the only key when none are configured.
+func NewAuthProvider(jwtConfig config.JWTConfig, fallbackSecret string) (providers.AuthProvider, error) {
+
+	keys, activeKeyID, err := loadKeys(jwtConfig, fallbackSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	activeKey, ok := keys[activeKeyID]
+	if !ok {
+		return nil, fmt.Errorf("active jwt key %q is not configured", activeKeyID)
+	}
+	if activeKey.signKey == nil {
+		return nil, fmt.Errorf("active jwt key %q has no private key to sign with", activeKeyID)
+	}
+
+	return &authProvider{
+		keys:      keys,
+		activeKey: activeKey,
+	}, nil
+}
+
+func (ap *authProvider) GenerateJWT(user models.User, sessionToken string) (tokenString string, err error) {
 
 	claims := &jwt.MapClaims{
 		"iss": user.ID,
-		"exp": time.Now().Add(1 * time.Hour).Unix(),
+		"exp": time.Now().Add(models.AccessTokenTTL).Unix(),
 		"data": map[string]string{
 			"id":        user.ID,
 			"username":  user.Username,
-			"expiresAt": strconv.Itoa(int(time.Now().Add(1 * time.Hour).Unix())),
+			"expiresAt": strconv.Itoa(int(time.Now().Add(models.AccessTokenTTL).Unix())),
 			"token":     sessionToken,
 			"issuer":    user.ID,
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token := jwt.NewWithClaims(ap.activeKey.method, claims)
+	token.Header["kid"] = ap.activeKey.keyID
 
-	tokenString, err = token.SignedString(models.JwtSigningSecretKey)
+	tokenString, err = token.SignedString(ap.activeKey.signKey)
 	if err != nil {
 		logrus.Errorf("GenerateJWT: error signing the token: %v ", err)
 		// utils.LogError(err)
@@ -34,3 +67,69 @@ func GenerateJWT(user models.User, sessionToken string) (tokenString string, err
 
 	return tokenString, nil
 }
+
+// GetClaimsFromToken verifies the token against the key named by its kid header.
+// Tokens issued before kids were added are checked against every key of their algorithm.
+func (ap *authProvider) GetClaimsFromToken(tokenString string) (jwt.MapClaims, error) {
+
+	var candidates []*signingKey
+
+	parser := &jwt.Parser{ValidMethods: []string{
+		jwt.SigningMethodHS256.Alg(), jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg(),
+	}}
+
+	unverified, _, err := parser.ParseUnverified(tokenString, jwt.MapClaims{})
+	if err != nil {
+		return jwt.MapClaims{}, err
+	}
+
+	if keyID, ok := unverified.Header["kid"].(string); ok {
+		key, found := ap.keys[keyID]
+		if !found {
+			return jwt.MapClaims{}, fmt.Errorf("unknown signing key %q", keyID)
+		}
+		candidates = append(candidates, key)
+	} else {
+		for _, key := range ap.keys {
+			candidates = append(candidates, key)
+		}
+	}
+
+	lastErr := fmt.Errorf("no key to verify the token with")
+	for _, key := range candidates {
+		token, err := parser.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+			if token.Method.Alg() != key.method.Alg() {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return key.verifyKey, nil
+		})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+			return claims, nil
+		}
+	}
+
+	return jwt.MapClaims{}, lastErr
+}
+
+// JWKS publishes the public keys, so other services can verify tokens themselves.
+// HS256 secrets are never published.
+func (ap *authProvider) JWKS() models.JWKS {
+
+	jwks := models.JWKS{Keys: []models.JWK{}}
+	for _, key := range ap.keys {
+		if jwk, ok := key.jwk(); ok {
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+
+	sort.Slice(jwks.Keys, func(i, j int) bool {
+		return jwks.Keys[i].KeyID < jwks.Keys[j].KeyID
+	})
+
+	return jwks
+}
@@ -0,0 +1,177 @@
+package authProvider
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/file_upload/config"
+	"github.com/file_upload/models"
+	"github.com/golang-jwt/jwt"
+)
+
+// signingKey is one configured key. signKey is nil for keys that may only verify.
+type signingKey struct {
+	keyID     string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// loadKeys reads the keys listed in the config and in its key file.
+func loadKeys(jwtConfig config.JWTConfig, fallbackSecret string) (map[string]*signingKey, string, error) {
+
+	keyConfigs := jwtConfig.Keys
+	activeKeyID := jwtConfig.ActiveKeyID
+
+	if jwtConfig.KeyFile != "" {
+		var fileConfig config.JWTConfig
+
+		data, err := os.ReadFile(jwtConfig.KeyFile)
+		if err != nil {
+			return nil, "", fmt.Errorf("reading jwt key file: %v", err)
+		}
+		if err := json.Unmarshal(data, &fileConfig); err != nil {
+			return nil, "", fmt.Errorf("decoding jwt key file: %v", err)
+		}
+
+		keyConfigs = append(keyConfigs, fileConfig.Keys...)
+		if fileConfig.ActiveKeyID != "" {
+			activeKeyID = fileConfig.ActiveKeyID
+		}
+	}
+
+	if len(keyConfigs) == 0 {
+		if fallbackSecret == "" {
+			return nil, "", fmt.Errorf("no jwt signing keys configured")
+		}
+		keyConfigs = []config.JWTKeyConfig{{KeyID: "default", Algorithm: jwt.SigningMethodHS256.Alg(), Secret: fallbackSecret}}
+	}
+
+	// With a single key there is nothing to choose between.
+	if activeKeyID == "" && len(keyConfigs) == 1 {
+		activeKeyID = keyConfigs[0].KeyID
+	}
+
+	keys := make(map[string]*signingKey, len(keyConfigs))
+	for _, keyConfig := range keyConfigs {
+		if keyConfig.KeyID == "" {
+			return nil, "", fmt.Errorf("jwt key without a kid")
+		}
+		if _, duplicate := keys[keyConfig.KeyID]; duplicate {
+			return nil, "", fmt.Errorf("jwt key %q is configured twice", keyConfig.KeyID)
+		}
+
+		key, err := loadKey(keyConfig)
+		if err != nil {
+			return nil, "", fmt.Errorf("jwt key %q: %v", keyConfig.KeyID, err)
+		}
+		keys[keyConfig.KeyID] = key
+	}
+
+	return keys, activeKeyID, nil
+}
+
+func loadKey(keyConfig config.JWTKeyConfig) (*signingKey, error) {
+
+	key := &signingKey{keyID: keyConfig.KeyID}
+
+	switch keyConfig.Algorithm {
+	case "", jwt.SigningMethodHS256.Alg():
+		if keyConfig.Secret == "" {
+			return nil, fmt.Errorf("HS256 key needs a secret")
+		}
+		key.method = jwt.SigningMethodHS256
+		key.signKey = []byte(keyConfig.Secret)
+		key.verifyKey = []byte(keyConfig.Secret)
+
+	case jwt.SigningMethodRS256.Alg():
+		key.method = jwt.SigningMethodRS256
+		if keyConfig.PrivateKeyFile != "" {
+			privateKey, err := readPEM(keyConfig.PrivateKeyFile, func(data []byte) (interface{}, error) {
+				return jwt.ParseRSAPrivateKeyFromPEM(data)
+			})
+			if err != nil {
+				return nil, err
+			}
+			key.signKey = privateKey
+			key.verifyKey = &privateKey.(*rsa.PrivateKey).PublicKey
+		} else {
+			publicKey, err := readPEM(keyConfig.PublicKeyFile, func(data []byte) (interface{}, error) {
+				return jwt.ParseRSAPublicKeyFromPEM(data)
+			})
+			if err != nil {
+				return nil, err
+			}
+			key.verifyKey = publicKey
+		}
+
+	case jwt.SigningMethodEdDSA.Alg():
+		key.method = jwt.SigningMethodEdDSA
+		if keyConfig.PrivateKeyFile != "" {
+			privateKey, err := readPEM(keyConfig.PrivateKeyFile, func(data []byte) (interface{}, error) {
+				return jwt.ParseEdPrivateKeyFromPEM(data)
+			})
+			if err != nil {
+				return nil, err
+			}
+			key.signKey = privateKey
+			key.verifyKey = privateKey.(ed25519.PrivateKey).Public()
+		} else {
+			publicKey, err := readPEM(keyConfig.PublicKeyFile, func(data []byte) (interface{}, error) {
+				return jwt.ParseEdPublicKeyFromPEM(data)
+			})
+			if err != nil {
+				return nil, err
+			}
+			key.verifyKey = publicKey
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", keyConfig.Algorithm)
+	}
+
+	return key, nil
+}
+
+func readPEM(path string, parse func([]byte) (interface{}, error)) (interface{}, error) {
+	if path == "" {
+		return nil, fmt.Errorf("key needs a private_key_file or public_key_file")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parse(data)
+}
+
+// jwk returns the public key in JWK form. HMAC keys have no public half.
+func (key *signingKey) jwk() (models.JWK, bool) {
+
+	jwk := models.JWK{
+		KeyID:     key.keyID,
+		Algorithm: key.method.Alg(),
+		Use:       "sig",
+	}
+
+	switch publicKey := key.verifyKey.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+	default:
+		return jwk, false
+	}
+
+	return jwk, true
+}
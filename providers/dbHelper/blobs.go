@@ -0,0 +1,101 @@
+package dbHelper
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AcquireBlob adds a reference to the blob, creating its record on first use.
+// It reports whether the record was newly created.
+func (dh *DBHelper) AcquireBlob(ctx context.Context, blob models.Blob) (bool, error) {
+	utils.LogInfo("AcquireBlob", "adding blob reference", fmt.Sprintf("Hash: %s", blob.Hash), nil)
+
+	filter := bson.M{"hash": blob.Hash}
+	update := bson.M{
+		"$inc": bson.M{"ref_count": 1},
+		"$setOnInsert": bson.M{
+			"size":       blob.Size,
+			"path":       blob.Path,
+			"created_at": blob.CreatedAt,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	result, err := dh.BlobCollection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		utils.LogError("AcquireBlob", "error adding blob reference", fmt.Sprintf("Hash: %s", blob.Hash), err)
+		return false, err
+	}
+
+	return result.UpsertedCount == 1, nil
+}
+
+// GetBlob returns the blob record for the hash.
+func (dh *DBHelper) GetBlob(ctx context.Context, hash string) (*models.Blob, error) {
+	utils.LogInfo("GetBlob", "fetching blob", fmt.Sprintf("Hash: %s", hash), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	var blob models.Blob
+	err := dh.BlobCollection.FindOne(ctx, bson.M{"hash": hash}).Decode(&blob)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return &blob, nil
+}
+
+// CountBlobVersions returns how many file versions keep their content in the blob.
+func (dh *DBHelper) CountBlobVersions(ctx context.Context, hash string) (int64, error) {
+	utils.LogInfo("CountBlobVersions", "counting versions using blob", fmt.Sprintf("Hash: %s", hash), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	blobKey := utils.BlobKey(hash)
+	filter := bson.M{"hash": hash, "path": bson.M{"$in": bson.A{blobKey, models.DefaultDirectory + "/" + blobKey}}}
+
+	count, err := dh.FileVersionCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		utils.LogError("CountBlobVersions", "error counting versions using blob", fmt.Sprintf("Hash: %s", hash), err)
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// ReleaseBlob drops a reference to the blob and returns it with the remaining count.
+// The record is removed once the count reaches zero; deleting the content is up to the caller.
+func (dh *DBHelper) ReleaseBlob(ctx context.Context, hash string) (*models.Blob, error) {
+	utils.LogInfo("ReleaseBlob", "dropping blob reference", fmt.Sprintf("Hash: %s", hash), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	var blob models.Blob
+	err := dh.BlobCollection.FindOneAndUpdate(ctx, bson.M{"hash": hash}, bson.M{"$inc": bson.M{"ref_count": -1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&blob)
+	if err != nil {
+		utils.LogError("ReleaseBlob", "blob not found or error dropping reference", fmt.Sprintf("Hash: %s", hash), err)
+		return nil, notFound(err)
+	}
+
+	if blob.RefCount <= 0 {
+		_, err = dh.BlobCollection.DeleteOne(ctx, bson.M{"hash": hash, "ref_count": bson.M{"$lte": 0}})
+		if err != nil {
+			utils.LogError("ReleaseBlob", "error deleting unreferenced blob", fmt.Sprintf("Hash: %s", hash), err)
+			return nil, err
+		}
+		utils.LogInfo("ReleaseBlob", "last reference dropped, blob record deleted", fmt.Sprintf("Hash: %s", hash), nil)
+	}
+
+	return &blob, nil
+}
@@ -1,6 +1,9 @@
 package dbHelper
 
 import (
+	"errors"
+
+	"github.com/file_upload/models"
 	"github.com/file_upload/providers"
 	"go.mongodb.org/mongo-driver/mongo"
 )
@@ -9,12 +12,36 @@ type DBHelper struct {
 	UserCollection         *mongo.Collection
 	UserSessionsCollection *mongo.Collection
 	FileCollection         *mongo.Collection
+	FileVersionCollection  *mongo.Collection
+	UploadCollection       *mongo.Collection
+	BlobCollection         *mongo.Collection
+	LoginAttemptCollection *mongo.Collection
+	FolderCollection       *mongo.Collection
+	ShareCollection        *mongo.Collection
+
+	Timeouts models.DBTimeouts
 }
 
-func NewDBHelperProvider(db *mongo.Client) providers.DBHelperProvider {
+func NewDBHelperProvider(db *mongo.Client, timeouts models.DBTimeouts) providers.DBHelperProvider {
 	return &DBHelper{
 		UserCollection:         (*mongo.Collection)(db.Database("WOBOT_AI").Collection("users")),
 		FileCollection:         (*mongo.Collection)(db.Database("WOBOT_AI").Collection("files")),
+		FileVersionCollection:  (*mongo.Collection)(db.Database("WOBOT_AI").Collection("fileVersions")),
 		UserSessionsCollection: (*mongo.Collection)(db.Database("WOBOT_AI").Collection("userSessions")),
+		UploadCollection:       (*mongo.Collection)(db.Database("WOBOT_AI").Collection("uploads")),
+		BlobCollection:         (*mongo.Collection)(db.Database("WOBOT_AI").Collection("blobs")),
+		LoginAttemptCollection: (*mongo.Collection)(db.Database("WOBOT_AI").Collection("loginAttempts")),
+		FolderCollection:       (*mongo.Collection)(db.Database("WOBOT_AI").Collection("folders")),
+		ShareCollection:        (*mongo.Collection)(db.Database("WOBOT_AI").Collection("shares")),
+		Timeouts:               timeouts,
+	}
+}
+
+// notFound reports a missing document as models.ErrNotFound, so callers don't depend on
+// the MongoDB driver.
+func notFound(err error) error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return models.ErrNotFound
 	}
+	return err
 }
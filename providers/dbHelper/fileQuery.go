@@ -0,0 +1,150 @@
+package dbHelper
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// File fields the listing can be sorted on.
+var fileSortFields = map[string]string{
+	models.FileSortByName: "filename",
+	models.FileSortBySize: "size",
+	models.FileSortByDate: "uploaded_at",
+}
+
+// ListFiles returns one page of the user's files matching the query, ordered by the
+// sort field and then by ID.
+func (dh *DBHelper) ListFiles(ctx context.Context, query models.FileQuery) (models.FilePage, error) {
+	utils.LogInfo("ListFiles", "listing files for user", fmt.Sprintf("UserID: %s, Query: %+v", query.UserID, query), nil)
+
+	page := models.FilePage{Files: []models.File{}}
+
+	sortField, ok := fileSortFields[query.SortBy]
+	if !ok {
+		return page, fmt.Errorf("unknown sort field %q", query.SortBy)
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = models.DefaultFilePageSize
+	}
+
+	filter := fileQueryFilter(query)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Batch)
+	defer cancel()
+
+	total, err := dh.FileCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		utils.LogError("ListFiles", "error counting files", fmt.Sprintf("UserID: %s", query.UserID), err)
+		return page, err
+	}
+	page.Total = total
+
+	if query.Cursor != "" {
+		after, err := utils.DecodeFileCursor(query)
+		if err != nil {
+			return page, err
+		}
+		filter["$or"] = fileCursorFilter(query, sortField, after)
+	}
+
+	direction := 1
+	if query.Descending {
+		direction = -1
+	}
+
+	// Fetch one extra file to find out whether there is another page.
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: sortField, Value: direction}, {Key: "id", Value: direction}}).
+		SetLimit(limit + 1)
+
+	cursor, err := dh.FileCollection.Find(ctx, filter, findOptions)
+	if err != nil {
+		utils.LogError("ListFiles", "error fetching files from database", fmt.Sprintf("UserID: %s", query.UserID), err)
+		return page, err
+	}
+	defer cursor.Close(ctx)
+
+	if err = cursor.All(ctx, &page.Files); err != nil {
+		utils.LogError("ListFiles", "error decoding file cursor", fmt.Sprintf("UserID: %s", query.UserID), err)
+		return page, err
+	}
+
+	if int64(len(page.Files)) > limit {
+		page.Files = page.Files[:limit]
+		page.NextCursor = utils.EncodeFileCursor(query, page.Files[limit-1])
+	}
+
+	utils.LogInfo("ListFiles", fmt.Sprintf("retrieved %d of %d files", len(page.Files), page.Total), fmt.Sprintf("UserID: %s", query.UserID), nil)
+	return page, nil
+}
+
+// fileCursorFilter matches the files that come after the cursor in the listing's order.
+// Files sharing the cursor's sort value are ordered by ID, so none is skipped or repeated.
+func fileCursorFilter(query models.FileQuery, sortField string, after utils.FileCursor) bson.A {
+	comparison := "$gt"
+	if query.Descending {
+		comparison = "$lt"
+	}
+
+	var value interface{} = after.Number
+	if query.SortBy == models.FileSortByName {
+		value = after.Filename
+	}
+
+	return bson.A{
+		bson.M{sortField: bson.M{comparison: value}},
+		bson.M{sortField: value, "id": bson.M{comparison: after.ID}},
+	}
+}
+
+func fileQueryFilter(query models.FileQuery) bson.M {
+	filter := bson.M{"user_id": query.UserID}
+
+	if query.FolderID != nil {
+		filter["folder_id"] = folderIDFilter(*query.FolderID)
+	}
+
+	if query.NamePrefix != "" {
+		filter["filename"] = bson.M{"$regex": "^" + regexp.QuoteMeta(query.NamePrefix)}
+	}
+
+	// "image/*" matches every image type.
+	if strings.HasSuffix(query.ContentType, "/*") {
+		filter["content_type"] = bson.M{"$regex": "^" + regexp.QuoteMeta(strings.TrimSuffix(query.ContentType, "*"))}
+	} else if query.ContentType != "" {
+		filter["content_type"] = query.ContentType
+	}
+
+	size := bson.M{}
+	if query.MinSize != nil {
+		size["$gte"] = *query.MinSize
+	}
+	if query.MaxSize != nil {
+		size["$lte"] = *query.MaxSize
+	}
+	if len(size) > 0 {
+		filter["size"] = size
+	}
+
+	uploadedAt := bson.M{}
+	if query.UploadedAfter != nil {
+		uploadedAt["$gte"] = *query.UploadedAfter
+	}
+	if query.UploadedBefore != nil {
+		uploadedAt["$lt"] = *query.UploadedBefore
+	}
+	if len(uploadedAt) > 0 {
+		filter["uploaded_at"] = uploadedAt
+	}
+
+	return filter
+}
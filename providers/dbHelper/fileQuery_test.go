@@ -0,0 +1,178 @@
+package dbHelper
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestFileQueryFilter(t *testing.T) {
+	root, folder := "", "folder-id"
+	size, after, before := int64(10), int64(1700000000), int64(1800000000)
+
+	tests := []struct {
+		name  string
+		query models.FileQuery
+		want  bson.M
+	}{
+		{name: "user only", query: models.FileQuery{UserID: "ada-id"}, want: bson.M{"user_id": "ada-id"}},
+		{name: "top level", query: models.FileQuery{UserID: "ada-id", FolderID: &root}, want: bson.M{"user_id": "ada-id", "folder_id": bson.M{"$in": bson.A{"", nil}}}},
+		{name: "folder", query: models.FileQuery{UserID: "ada-id", FolderID: &folder}, want: bson.M{"user_id": "ada-id", "folder_id": "folder-id"}},
+		{name: "prefix is not a pattern", query: models.FileQuery{UserID: "ada-id", NamePrefix: "a.b*"}, want: bson.M{"user_id": "ada-id", "filename": bson.M{"$regex": `^a\.b\*`}}},
+		{name: "content type", query: models.FileQuery{UserID: "ada-id", ContentType: "image/png"}, want: bson.M{"user_id": "ada-id", "content_type": "image/png"}},
+		{name: "content type family", query: models.FileQuery{UserID: "ada-id", ContentType: "image/*"}, want: bson.M{"user_id": "ada-id", "content_type": bson.M{"$regex": `^image/`}}},
+		{name: "minimum size", query: models.FileQuery{UserID: "ada-id", MinSize: &size}, want: bson.M{"user_id": "ada-id", "size": bson.M{"$gte": size}}},
+		{name: "size range", query: models.FileQuery{UserID: "ada-id", MinSize: &size, MaxSize: &size}, want: bson.M{"user_id": "ada-id", "size": bson.M{"$gte": size, "$lte": size}}},
+		{name: "upload window", query: models.FileQuery{UserID: "ada-id", UploadedAfter: &after, UploadedBefore: &before}, want: bson.M{"user_id": "ada-id", "uploaded_at": bson.M{"$gte": after, "$lt": before}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fileQueryFilter(tt.query); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("fileQueryFilter(%+v) = %v; want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestFileCursorFilter pages through files, several of them sharing a sort value, by
+// applying fileCursorFilter the way MongoDB would.
+func TestFileCursorFilter(t *testing.T) {
+	files := []models.File{
+		{ID: "e", Filename: "same.txt", Size: 5, UploadedAt: 100},
+		{ID: "a", Filename: "same.txt", Size: 5, UploadedAt: 100},
+		{ID: "c", Filename: "same.txt", Size: 5, UploadedAt: 100},
+		{ID: "b", Filename: "other.txt", Size: 1, UploadedAt: 200},
+		{ID: "d", Filename: "zebra.txt", Size: 9, UploadedAt: 50},
+	}
+
+	tests := []struct {
+		sortBy     string
+		descending bool
+		want       string
+	}{
+		{sortBy: models.FileSortByName, want: "b,a,c,e,d"},
+		{sortBy: models.FileSortByName, descending: true, want: "d,e,c,a,b"},
+		{sortBy: models.FileSortBySize, want: "b,a,c,e,d"},
+		{sortBy: models.FileSortBySize, descending: true, want: "d,e,c,a,b"},
+		{sortBy: models.FileSortByDate, want: "d,a,c,e,b"},
+		{sortBy: models.FileSortByDate, descending: true, want: "b,e,c,a,d"},
+	}
+
+	for _, tt := range tests {
+		name := tt.sortBy
+		if tt.descending {
+			name += " descending"
+		}
+		t.Run(name, func(t *testing.T) {
+			query := models.FileQuery{SortBy: tt.sortBy, Descending: tt.descending}
+			sortField := fileSortFields[tt.sortBy]
+
+			// Pages of two, so a page boundary falls between files with the same value.
+			var listed []string
+			remaining := sortedFiles(files, sortField, tt.descending)
+			for len(remaining) > 0 {
+				end := 2
+				if end > len(remaining) {
+					end = len(remaining)
+				}
+				for _, file := range remaining[:end] {
+					listed = append(listed, file.ID)
+				}
+				if end == len(remaining) {
+					break
+				}
+
+				query.Cursor = utils.EncodeFileCursor(query, remaining[end-1])
+				after, err := utils.DecodeFileCursor(query)
+				if err != nil {
+					t.Fatalf("DecodeFileCursor: %v", err)
+				}
+				filter := fileCursorFilter(query, sortField, after)
+
+				var next []models.File
+				for _, file := range files {
+					if matchesAny(filter, fileDocument(file)) {
+						next = append(next, file)
+					}
+				}
+				remaining = sortedFiles(next, sortField, tt.descending)
+			}
+
+			if got := strings.Join(listed, ","); got != tt.want {
+				t.Fatalf("listed %s; want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func fileDocument(file models.File) bson.M {
+	return bson.M{"id": file.ID, "filename": file.Filename, "size": file.Size, "uploaded_at": file.UploadedAt}
+}
+
+// sortedFiles orders files the way ListFiles asks MongoDB to: by the field, then by ID.
+func sortedFiles(files []models.File, sortField string, descending bool) []models.File {
+	sorted := append([]models.File(nil), files...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := fileDocument(sorted[i]), fileDocument(sorted[j])
+		c := compareValues(a[sortField], b[sortField])
+		if c == 0 {
+			c = compareValues(a["id"], b["id"])
+		}
+		if descending {
+			return c > 0
+		}
+		return c < 0
+	})
+	return sorted
+}
+
+// matchesAny evaluates an $or of the equality, $gt and $lt conditions fileCursorFilter uses.
+func matchesAny(branches bson.A, doc bson.M) bool {
+	for _, branch := range branches {
+		if matchesAll(branch.(bson.M), doc) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAll(conditions bson.M, doc bson.M) bool {
+	for field, condition := range conditions {
+		operators, ok := condition.(bson.M)
+		if !ok {
+			operators = bson.M{"$eq": condition}
+		}
+		for operator, value := range operators {
+			c := compareValues(doc[field], value)
+			switch {
+			case operator == "$eq" && c != 0,
+				operator == "$gt" && c <= 0,
+				operator == "$lt" && c >= 0:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func compareValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(a, b.(string))
+	case int64:
+		switch b := b.(int64); {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	}
+	panic("unexpected value type")
+}
@@ -0,0 +1,192 @@
+package dbHelper
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (dh *DBHelper) CreateFolder(ctx context.Context, folder models.Folder) error {
+	utils.LogInfo("CreateFolder", "creating folder", fmt.Sprintf("UserID: %s, Name: %s, ParentID: %s", folder.UserID, folder.Name, folder.ParentID), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	_, err := dh.FolderCollection.InsertOne(ctx, folder)
+	if err != nil {
+		utils.LogError("CreateFolder", "error inserting folder", folder, err)
+	}
+	return err
+}
+
+func (dh *DBHelper) GetFolder(ctx context.Context, userID, folderID string) (*models.Folder, error) {
+	utils.LogInfo("GetFolder", "fetching folder", fmt.Sprintf("UserID: %s, FolderID: %s", userID, folderID), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	var folder models.Folder
+	err := dh.FolderCollection.FindOne(ctx, bson.M{"id": folderID, "user_id": userID}).Decode(&folder)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			utils.LogError("GetFolder", "error decoding folder", fmt.Sprintf("UserID: %s, FolderID: %s", userID, folderID), err)
+		}
+		return nil, notFound(err)
+	}
+
+	return &folder, nil
+}
+
+// GetFolderByName returns the folder with the given name directly inside the parent.
+func (dh *DBHelper) GetFolderByName(ctx context.Context, userID, parentID, name string) (*models.Folder, error) {
+	utils.LogInfo("GetFolderByName", "searching for folder by name", fmt.Sprintf("UserID: %s, ParentID: %s, Name: %s", userID, parentID, name), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	var folder models.Folder
+	err := dh.FolderCollection.FindOne(ctx, bson.M{"user_id": userID, "parent_id": parentID, "name": name}).Decode(&folder)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			utils.LogError("GetFolderByName", "error decoding folder", fmt.Sprintf("UserID: %s, ParentID: %s, Name: %s", userID, parentID, name), err)
+		}
+		return nil, notFound(err)
+	}
+
+	return &folder, nil
+}
+
+// GetFolders returns every folder of the user, sorted by name.
+func (dh *DBHelper) GetFolders(ctx context.Context, userID string) ([]models.Folder, error) {
+	utils.LogInfo("GetFolders", "fetching folders for user", fmt.Sprintf("UserID: %s", userID), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Batch)
+	defer cancel()
+
+	cursor, err := dh.FolderCollection.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		utils.LogError("GetFolders", "error fetching folders", fmt.Sprintf("UserID: %s", userID), err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	folders := []models.Folder{}
+	if err = cursor.All(ctx, &folders); err != nil {
+		utils.LogError("GetFolders", "error decoding folders", fmt.Sprintf("UserID: %s", userID), err)
+		return nil, err
+	}
+
+	return folders, nil
+}
+
+// UpdateFolder saves the folder's name and parent. Nothing else needs to change on a
+// move, since files only refer to their own folder.
+func (dh *DBHelper) UpdateFolder(ctx context.Context, folder models.Folder) error {
+	utils.LogInfo("UpdateFolder", "updating folder", fmt.Sprintf("UserID: %s, FolderID: %s", folder.UserID, folder.ID), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{
+		"name":       folder.Name,
+		"parent_id":  folder.ParentID,
+		"updated_at": folder.UpdatedAt,
+	}}
+
+	result, err := dh.FolderCollection.UpdateOne(ctx, bson.M{"id": folder.ID, "user_id": folder.UserID}, update)
+	if err != nil {
+		utils.LogError("UpdateFolder", "error updating folder", folder, err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return models.ErrNotFound
+	}
+
+	return nil
+}
+
+// DeleteFolders removes the folders. Their files must have been deleted already.
+func (dh *DBHelper) DeleteFolders(ctx context.Context, userID string, folderIDs []string) error {
+	utils.LogInfo("DeleteFolders", "deleting folders", fmt.Sprintf("UserID: %s, Folders: %d", userID, len(folderIDs)), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Batch)
+	defer cancel()
+
+	_, err := dh.FolderCollection.DeleteMany(ctx, bson.M{"user_id": userID, "id": bson.M{"$in": folderIDs}})
+	if err != nil {
+		utils.LogError("DeleteFolders", "error deleting folders", fmt.Sprintf("UserID: %s", userID), err)
+		return err
+	}
+
+	return nil
+}
+
+// GetFilesInFolders returns the user's files directly inside any of the folders,
+// sorted by name. "" stands for the top level.
+func (dh *DBHelper) GetFilesInFolders(ctx context.Context, userID string, folderIDs []string) ([]models.File, error) {
+	utils.LogInfo("GetFilesInFolders", "fetching files in folders", fmt.Sprintf("UserID: %s, Folders: %d", userID, len(folderIDs)), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Batch)
+	defer cancel()
+
+	folders := bson.A{}
+	for _, folderID := range folderIDs {
+		folders = append(folders, folderID)
+		if folderID == "" {
+			// Files stored before folders existed have no folder_id.
+			folders = append(folders, nil)
+		}
+	}
+
+	cursor, err := dh.FileCollection.Find(ctx, bson.M{"user_id": userID, "folder_id": bson.M{"$in": folders}},
+		options.Find().SetSort(bson.D{{Key: "filename", Value: 1}}))
+	if err != nil {
+		utils.LogError("GetFilesInFolders", "error fetching files", fmt.Sprintf("UserID: %s", userID), err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	files := []models.File{}
+	if err = cursor.All(ctx, &files); err != nil {
+		utils.LogError("GetFilesInFolders", "error decoding files", fmt.Sprintf("UserID: %s", userID), err)
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// UpdateFileLocation renames and/or moves a file. Only metadata changes; the content
+// stays where it is in storage.
+func (dh *DBHelper) UpdateFileLocation(ctx context.Context, userID, fileID, folderID, filename string) error {
+	utils.LogInfo("UpdateFileLocation", "moving file", fmt.Sprintf("UserID: %s, FileID: %s, FolderID: %s, FileName: %s", userID, fileID, folderID, filename), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"folder_id": folderID, "filename": filename}}
+
+	result, err := dh.FileCollection.UpdateOne(ctx, bson.M{"id": fileID, "user_id": userID}, update)
+	if err != nil {
+		utils.LogError("UpdateFileLocation", "error updating file", fmt.Sprintf("UserID: %s, FileID: %s", userID, fileID), err)
+		return fileNameErr(filename, err)
+	}
+	if result.MatchedCount == 0 {
+		return models.ErrNotFound
+	}
+
+	return nil
+}
+
+// folderIDFilter matches files in the folder. Files stored before folders existed have
+// no folder_id and count as being at the top level.
+func folderIDFilter(folderID string) interface{} {
+	if folderID == "" {
+		return bson.M{"$in": bson.A{"", nil}}
+	}
+	return folderID
+}
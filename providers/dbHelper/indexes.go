@@ -0,0 +1,151 @@
+package dbHelper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureIndexes creates the indexes the queries rely on, including the unique index that
+// keeps usernames unique and the TTL index that deletes expired sessions. Existing
+// indexes are left alone, so it is safe to run on every start. The returned error wraps
+// ErrRequiredIndex when the users, sessions or blobs indexes could not be created.
+func (dh *DBHelper) EnsureIndexes(ctx context.Context) error {
+	utils.LogInfo("EnsureIndexes", "creating database indexes", "", nil)
+
+	indexes := dh.indexModels()
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Scan)
+	defer cancel()
+
+	var errs []error
+
+	// Sessions created before the TTL index have no expiresAt and would never be deleted.
+	// They expire when the later of their access and refresh tokens does.
+	backfill := mongo.Pipeline{{{Key: "$set", Value: bson.M{"expiresAt": bson.M{"$toDate": bson.M{"$multiply": bson.A{bson.M{"$max": bson.A{"$endTime", "$refreshExpiresAt"}}, 1000}}}}}}}
+	result, err := dh.UserSessionsCollection.UpdateMany(ctx, bson.M{"expiresAt": bson.M{"$exists": false}}, backfill)
+	switch {
+	case err != nil:
+		utils.LogError("EnsureIndexes", "error setting the expiry of existing sessions", dh.UserSessionsCollection.Name(), err)
+		errs = append(errs, fmt.Errorf("%s: %w", dh.UserSessionsCollection.Name(), err))
+	case result.ModifiedCount > 0:
+		utils.LogInfo("EnsureIndexes", fmt.Sprintf("expiry set on %d existing sessions", result.ModifiedCount), dh.UserSessionsCollection.Name(), nil)
+	}
+
+	// The index on file names was not unique at first. It is dropped so it can be built
+	// again as a unique one.
+	if err := dh.dropNonUniqueIndex(ctx, dh.FileCollection, fileNameIndex); err != nil {
+		utils.LogError("EnsureIndexes", "error dropping the non-unique file name index", dh.FileCollection.Name(), err)
+		errs = append(errs, fmt.Errorf("%s: %w", dh.FileCollection.Name(), err))
+	}
+
+	// A collection whose indexes can't be built, such as users with duplicate usernames
+	// from before the unique index, doesn't stop the other collections getting theirs.
+	for collection, indexModels := range indexes {
+		names, err := collection.Indexes().CreateMany(ctx, indexModels)
+		if err != nil {
+			utils.LogError("EnsureIndexes", "error creating indexes", collection.Name(), err)
+			err = fmt.Errorf("%s: %w", collection.Name(), err)
+			if dh.indexesRequired(collection) {
+				err = fmt.Errorf("%w: %w", models.ErrRequiredIndex, err)
+			}
+			errs = append(errs, err)
+			continue
+		}
+		utils.LogInfo("EnsureIndexes", fmt.Sprintf("indexes ready: %v", names), collection.Name(), nil)
+	}
+
+	return errors.Join(errs...)
+}
+
+// fileNameIndex keeps the names of the files in a folder unique.
+const fileNameIndex = "user_id_1_folder_id_1_filename_1"
+
+// dropNonUniqueIndex drops the named index if it exists without the unique constraint.
+func (dh *DBHelper) dropNonUniqueIndex(ctx context.Context, collection *mongo.Collection, name string) error {
+	cursor, err := collection.Indexes().List(ctx)
+	if err != nil {
+		return err
+	}
+
+	var indexes []bson.M
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return err
+	}
+
+	for _, index := range indexes {
+		if index["name"] == name && index["unique"] != true {
+			utils.LogInfo("EnsureIndexes", "dropping non-unique index to rebuild it unique", fmt.Sprintf("Collection: %s, Index: %s", collection.Name(), name), nil)
+			_, err := collection.Indexes().DropOne(ctx, name)
+			return err
+		}
+	}
+	return nil
+}
+
+// indexesRequired reports whether the collection's indexes are needed for correctness
+// rather than speed. CreateUser relies on the unique username index to refuse taken
+// usernames, sessions on theirs to keep tokens unique, and AcquireBlob on the unique
+// hash to keep a single reference count per blob across server instances.
+func (dh *DBHelper) indexesRequired(collection *mongo.Collection) bool {
+	return collection == dh.UserCollection || collection == dh.UserSessionsCollection || collection == dh.BlobCollection
+}
+
+// indexModels lists the indexes EnsureIndexes creates, by collection.
+func (dh *DBHelper) indexModels() map[*mongo.Collection][]mongo.IndexModel {
+	return map[*mongo.Collection][]mongo.IndexModel{
+		dh.UserCollection: {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "username", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		dh.UserSessionsCollection: {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "token", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "endTime", Value: 1}}},
+			{Keys: bson.D{{Key: "refreshTokenHash", Value: 1}}},
+			{Keys: bson.D{{Key: "familyId", Value: 1}}},
+			{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(models.ExpiredSessionRetention.Seconds()))},
+		},
+		dh.FileCollection: {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "filename", Value: 1}, {Key: "id", Value: 1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "size", Value: 1}, {Key: "id", Value: 1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "uploaded_at", Value: 1}, {Key: "id", Value: 1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "content_type", Value: 1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "hash", Value: 1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "folder_id", Value: 1}, {Key: "filename", Value: 1}}, Options: options.Index().SetName(fileNameIndex).SetUnique(true)},
+		},
+		dh.ShareCollection: {
+			{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "file_id", Value: 1}}},
+		},
+		dh.FolderCollection: {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "parent_id", Value: 1}, {Key: "name", Value: 1}}},
+		},
+		// Blobs and login attempts are upserted by these keys, as the SQL primary keys.
+		dh.BlobCollection: {
+			{Keys: bson.D{{Key: "hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		dh.LoginAttemptCollection: {
+			{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		dh.UploadCollection: {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "expires_at", Value: 1}}},
+		},
+		dh.FileVersionCollection: {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "file_id", Value: 1}, {Key: "version", Value: 1}}},
+			{Keys: bson.D{{Key: "hash", Value: 1}}},
+		},
+	}
+}
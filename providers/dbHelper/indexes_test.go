@@ -0,0 +1,131 @@
+package dbHelper
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/file_upload/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// newTestHelper returns a helper on a client that is never connected; it is only good
+// for looking at collections, not for queries.
+func newTestHelper(t *testing.T) *DBHelper {
+	t.Helper()
+
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://localhost:1"))
+	if err != nil {
+		t.Fatalf("mongo.Connect: %v", err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+
+	return NewDBHelperProvider(client, models.DBTimeouts{}).(*DBHelper)
+}
+
+func TestIndexModels(t *testing.T) {
+	dh := newTestHelper(t)
+	indexes := dh.indexModels()
+
+	find := func(collection *mongo.Collection, keys bson.D) *mongo.IndexModel {
+		for _, index := range indexes[collection] {
+			if indexKeys, ok := index.Keys.(bson.D); ok && equalKeys(indexKeys, keys) {
+				return &index
+			}
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name        string
+		collection  *mongo.Collection
+		keys        bson.D
+		wantUnique  bool
+		wantExpires int32
+		wantNeeded  bool
+	}{
+		{name: "user ids", collection: dh.UserCollection, keys: bson.D{{Key: "id", Value: 1}}, wantUnique: true, wantNeeded: true},
+		{name: "usernames", collection: dh.UserCollection, keys: bson.D{{Key: "username", Value: 1}}, wantUnique: true, wantNeeded: true},
+		{name: "session ids", collection: dh.UserSessionsCollection, keys: bson.D{{Key: "id", Value: 1}}, wantUnique: true, wantNeeded: true},
+		{name: "session tokens", collection: dh.UserSessionsCollection, keys: bson.D{{Key: "token", Value: 1}}, wantUnique: true, wantNeeded: true},
+		{name: "session expiry", collection: dh.UserSessionsCollection, keys: bson.D{{Key: "expiresAt", Value: 1}}, wantExpires: int32(models.ExpiredSessionRetention.Seconds()), wantNeeded: true},
+		{name: "blob hashes", collection: dh.BlobCollection, keys: bson.D{{Key: "hash", Value: 1}}, wantUnique: true, wantNeeded: true},
+		{name: "login attempt keys", collection: dh.LoginAttemptCollection, keys: bson.D{{Key: "key", Value: 1}}, wantUnique: true},
+		{name: "login attempt expiry", collection: dh.LoginAttemptCollection, keys: bson.D{{Key: "expiresAt", Value: 1}}},
+		{name: "upload ids", collection: dh.UploadCollection, keys: bson.D{{Key: "id", Value: 1}}, wantUnique: true},
+		{name: "open uploads by user", collection: dh.UploadCollection, keys: bson.D{{Key: "user_id", Value: 1}, {Key: "expires_at", Value: 1}}},
+		{name: "share tokens", collection: dh.ShareCollection, keys: bson.D{{Key: "token_hash", Value: 1}}, wantUnique: true},
+		{name: "file names", collection: dh.FileCollection, keys: bson.D{{Key: "user_id", Value: 1}, {Key: "folder_id", Value: 1}, {Key: "filename", Value: 1}}, wantUnique: true},
+		{name: "version ids", collection: dh.FileVersionCollection, keys: bson.D{{Key: "id", Value: 1}}, wantUnique: true},
+		{name: "versions by blob", collection: dh.FileVersionCollection, keys: bson.D{{Key: "hash", Value: 1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index := find(tt.collection, tt.keys)
+			if index == nil {
+				t.Fatalf("no index on %v in %s", tt.keys, tt.collection.Name())
+			}
+
+			var unique bool
+			var expires int32
+			if index.Options != nil {
+				if index.Options.Unique != nil {
+					unique = *index.Options.Unique
+				}
+				if index.Options.ExpireAfterSeconds != nil {
+					expires = *index.Options.ExpireAfterSeconds
+				}
+			}
+			if unique != tt.wantUnique {
+				t.Errorf("unique = %v; want %v", unique, tt.wantUnique)
+			}
+			if expires != tt.wantExpires {
+				t.Errorf("expire after = %ds; want %ds", expires, tt.wantExpires)
+			}
+			if needed := dh.indexesRequired(tt.collection); needed != tt.wantNeeded {
+				t.Errorf("indexesRequired(%s) = %v; want %v", tt.collection.Name(), needed, tt.wantNeeded)
+			}
+		})
+	}
+}
+
+func equalKeys(a, b bson.D) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key || a[i].Value != b[i].Value {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCreateUserErr(t *testing.T) {
+	other := errors.New("connection reset")
+
+	tests := []struct {
+		name      string
+		err       error
+		wantTaken bool
+	}{
+		{name: "duplicate key", err: mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000, Message: "E11000 duplicate key error"}}}, wantTaken: true},
+		{name: "other write error", err: mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 121, Message: "document failed validation"}}}},
+		{name: "other error", err: other},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := createUserErr("ada", tt.err)
+			if taken := errors.Is(err, models.ErrUsernameTaken); taken != tt.wantTaken {
+				t.Fatalf("createUserErr(%v) = %v; want ErrUsernameTaken %v", tt.err, err, tt.wantTaken)
+			}
+			if !tt.wantTaken && err.Error() != tt.err.Error() {
+				t.Errorf("createUserErr(%v) = %v; want the error unchanged", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package dbHelper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetLoginAttempt returns the failed login record for the key, or an empty one if there is none.
+func (dh *DBHelper) GetLoginAttempt(ctx context.Context, key string) (models.LoginAttempt, error) {
+
+	attempt := models.LoginAttempt{Key: key}
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	err := dh.LoginAttemptCollection.FindOne(ctx, bson.M{"key": key}).Decode(&attempt)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		utils.LogError("GetLoginAttempt", "error fetching login attempts", fmt.Sprintf("Key: %s", key), err)
+		return attempt, err
+	}
+
+	return attempt, nil
+}
+
+// RecordLoginFailure counts a failed login for the key and returns the updated record.
+// Failures are forgotten, and the count starts again at one, when neither the last
+// failure nor the end of the last lockout is after resetBefore. The TTL index deletes
+// the record once that holds, as at is then as far behind as resetBefore is now.
+func (dh *DBHelper) RecordLoginFailure(ctx context.Context, key string, at, resetBefore int64) (models.LoginAttempt, error) {
+	utils.LogInfo("RecordLoginFailure", "recording failed login", fmt.Sprintf("Key: %s", key), nil)
+
+	var attempt models.LoginAttempt
+
+	// An update pipeline, so the reset and the increment happen in one atomic step.
+	update := mongo.Pipeline{bson.D{{Key: "$set", Value: bson.M{
+		"key": key,
+		"failures": bson.M{"$cond": bson.A{
+			bson.M{"$lt": bson.A{
+				bson.M{"$max": bson.A{bson.M{"$ifNull": bson.A{"$lastFailureAt", 0}}, bson.M{"$ifNull": bson.A{"$lockedUntil", 0}}}},
+				resetBefore,
+			}},
+			1,
+			bson.M{"$add": bson.A{"$failures", 1}},
+		}},
+		"lastFailureAt": at,
+		"expiresAt": bson.M{"$toDate": bson.M{"$multiply": bson.A{
+			bson.M{"$add": bson.A{bson.M{"$max": bson.A{at, bson.M{"$ifNull": bson.A{"$lockedUntil", 0}}}}, at - resetBefore}},
+			1000,
+		}}},
+	}}}}
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	err := dh.LoginAttemptCollection.FindOneAndUpdate(ctx, bson.M{"key": key}, update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&attempt)
+	if err != nil {
+		utils.LogError("RecordLoginFailure", "error recording failed login", fmt.Sprintf("Key: %s", key), err)
+		return attempt, err
+	}
+
+	return attempt, nil
+}
+
+// LockLogin refuses logins for the key until lockedUntil, keeping the record at least
+// until keepUntil.
+func (dh *DBHelper) LockLogin(ctx context.Context, key string, lockedUntil, keepUntil int64) error {
+	utils.LogWarning("LockLogin", "locking logins after repeated failures", fmt.Sprintf("Key: %s, LockedUntil: %d", key, lockedUntil))
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	_, err := dh.LoginAttemptCollection.UpdateOne(ctx, bson.M{"key": key}, bson.M{"$max": bson.M{"lockedUntil": lockedUntil, "expiresAt": time.Unix(keepUntil, 0)}})
+	if err != nil {
+		utils.LogError("LockLogin", "error locking logins", fmt.Sprintf("Key: %s", key), err)
+		return err
+	}
+
+	return nil
+}
+
+// ClearLoginAttempts forgets the failed logins for the key and lifts any lockout.
+func (dh *DBHelper) ClearLoginAttempts(ctx context.Context, key string) error {
+	utils.LogInfo("ClearLoginAttempts", "clearing failed logins", fmt.Sprintf("Key: %s", key), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	_, err := dh.LoginAttemptCollection.DeleteOne(ctx, bson.M{"key": key})
+	if err != nil {
+		utils.LogError("ClearLoginAttempts", "error clearing failed logins", fmt.Sprintf("Key: %s", key), err)
+		return err
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package dbHelper
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -14,32 +15,34 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func (dh *DBHelper) GetUserByUsername(username string) (models.User, error) {
+func (dh *DBHelper) GetUserByUsername(ctx context.Context, username string) (models.User, error) {
+	utils.LogInfo("GetUserByUsername", "fetching user by username", fmt.Sprintf("Username: %s", username), nil)
 
-	var users models.User
-
-	// filters
+	var user models.User
 	filter := bson.M{"username": username}
 
-	result := dh.UserCollection.FindOne(context.TODO(), filter)
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
 
-	err := result.Decode(&users)
+	err := dh.UserCollection.FindOne(ctx, filter).Decode(&user)
 	if err != nil {
-		logrus.Errorf("GetUserByUsername, error decoding the database results : %v ", err)
-		return users, err
+		if err != mongo.ErrNoDocuments {
+			utils.LogError("GetUserByUsername", "error decoding the user from the database", fmt.Sprintf("Username: %s", username), err)
+		}
+		return user, notFound(err)
 	}
 
-	return users, nil
+	return user, nil
 }
 
-func (dbHelper *DBHelper) ReadUserSessions(userID string, activeSessions bool) ([]models.UserSession, error) {
+func (dbHelper *DBHelper) ReadUserSessions(ctx context.Context, userID string, activeSessions bool) ([]models.UserSession, error) {
 
 	utils.LogInfo("ReadUserSessions", "reading all the User sessions with the specified UserID", fmt.Sprintf("UserID: %s, Active Sessions Switch: %v", userID, activeSessions), nil)
 
 	var userSessionsData []models.UserSession
 	var filter primitive.M
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, dbHelper.Timeouts.Query)
 	defer cancel()
 
 	// here bson.M act as a map.
@@ -47,8 +50,12 @@ func (dbHelper *DBHelper) ReadUserSessions(userID string, activeSessions bool) (
 
 	logrus.Infof("Mongo Filter 1: %v", filter)
 
+	// A session whose access token expired is still alive while its refresh token can be used.
 	if activeSessions {
-		filter["endTime"] = bson.M{"$gt": time.Now().Unix()}
+		filter["$or"] = bson.A{
+			bson.M{"endTime": bson.M{"$gt": time.Now().Unix()}},
+			bson.M{"refreshUsed": false, "refreshExpiresAt": bson.M{"$gt": time.Now().Unix()}},
+		}
 	}
 
 	logrus.Infof("Mongo Filter 2: %v", filter)
@@ -74,7 +81,7 @@ func (dbHelper *DBHelper) ReadUserSessions(userID string, activeSessions bool) (
 	return userSessionsData, nil
 }
 
-func (dbHelper *DBHelper) ReadUserSessionBySessionID(sessionID string) (models.UserSession, error) {
+func (dbHelper *DBHelper) ReadUserSessionBySessionID(ctx context.Context, sessionID string) (models.UserSession, error) {
 
 	utils.LogInfo("ReadUserSessionBySessionID", "reading the User session with the specified ID", fmt.Sprintf("SessionID: %s", sessionID), nil)
 
@@ -82,7 +89,7 @@ func (dbHelper *DBHelper) ReadUserSessionBySessionID(sessionID string) (models.U
 
 	filter := bson.M{"id": sessionID}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, dbHelper.Timeouts.Query)
 	defer cancel()
 
 	err := dbHelper.UserSessionsCollection.FindOne(ctx, &filter).Decode(&userSession)
@@ -99,20 +106,21 @@ func (dbHelper *DBHelper) ReadUserSessionBySessionID(sessionID string) (models.U
 	return userSession, nil
 }
 
-func (dbHelper *DBHelper) EndUserSession(sessionID string) error {
+func (dbHelper *DBHelper) EndUserSession(ctx context.Context, sessionID string) error {
 
 	utils.LogInfo("EndUserSession", "ending the User session with the specified ID", fmt.Sprintf("SessionID: %s", sessionID), nil)
 
-	userSessionData, err := dbHelper.ReadUserSessionBySessionID(sessionID)
+	userSessionData, err := dbHelper.ReadUserSessionBySessionID(ctx, sessionID)
 	if err != nil {
 		utils.LogError("EndUserSession", "error fetching user session data", fmt.Sprintf("SessionID: %s", sessionID), err)
 		return err
 	}
 
+	// Ending a session also retires its refresh token.
 	filter := bson.M{"id": sessionID}
-	update := bson.M{"$set": bson.M{"endTime": time.Now().Unix()}}
+	update := bson.M{"$set": bson.M{"endTime": time.Now().Unix(), "refreshExpiresAt": time.Now().Unix()}}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, dbHelper.Timeouts.Query)
 	defer cancel()
 
 	result, err := dbHelper.UserSessionsCollection.UpdateOne(ctx, filter, update)
@@ -129,145 +137,261 @@ func (dbHelper *DBHelper) EndUserSession(sessionID string) error {
 	return nil
 }
 
-func (dbHelper *DBHelper) CreateUserSession(userID string) (models.UserSession, error) {
+// CreateUserSession starts a new session family for the user. Other sessions of the
+// user are left running, so every device keeps its own session.
+func (dbHelper *DBHelper) CreateUserSession(ctx context.Context, userID string, client models.SessionClient) (models.UserSession, error) {
 
 	utils.LogInfo("CreateUserSession", "create a new User session based on the data provided", fmt.Sprintf("UserID: %s", userID), nil)
 
-	var newSession models.UserSession
-
-	userSessions, err := dbHelper.ReadUserSessions(userID, true)
+	newSession, err := dbHelper.insertUserSession(ctx, userID, uuid.New().String(), time.Now().Unix(), client)
 	if err != nil {
-		utils.LogError("CreateUserSession", "error fetching user's sessions data from the database", userID, err)
+		utils.LogError("CreateUserSession", "error inserting user's new session into the database", fmt.Sprintf("UserID: %s", userID), err)
 		return newSession, err
 	}
+	utils.LogInfo("CreateUserSession", "successfully created a new user session", fmt.Sprintf("New Session ID: %s", newSession.ID), nil)
 
-	// end all the sessions.
-	if len(userSessions) > 0 {
+	return newSession, nil
+}
 
-		fmt.Println("Hello I am here")
-		for _, userSession := range userSessions {
+// sessionDocument is a session as stored in MongoDB. ExpiresAt is a date for the TTL
+// index to delete the session by, as the session's own times are Unix seconds.
+type sessionDocument struct {
+	models.UserSession `bson:",inline"`
+	ExpiresAt          time.Time `bson:"expiresAt"`
+}
 
-			err = dbHelper.EndUserSession(userSession.ID)
-			if err != nil {
-				utils.LogError("CreateUserSession", "error closing user's previous sessions in the database", fmt.Sprintf("User ID: %s, Old User Session ID: %s", userID, userSession.ID), err)
-				return newSession, err
-			}
-		}
+// insertUserSession stores a new session in the given family, with fresh access and refresh tokens.
+func (dbHelper *DBHelper) insertUserSession(ctx context.Context, userID, familyID string, startTime int64, client models.SessionClient) (models.UserSession, error) {
 
-		utils.LogInfo("CreateUserSession", "successfully end all the session", "", "")
+	var newSession models.UserSession
 
+	refreshToken, err := utils.GenerateSecureToken()
+	if err != nil {
+		return newSession, err
 	}
 
 	newSession.ID = uuid.New().String()
 	newSession.UserID = userID
-	newSession.StartTime = time.Now().Unix()
-	newSession.EndTime = time.Now().Add(1 * time.Hour).Unix()
+	newSession.FamilyID = familyID
+	newSession.StartTime = startTime
+	newSession.EndTime = time.Now().Add(models.AccessTokenTTL).Unix()
 	newSession.Token = uuid.New().String()
+	newSession.RefreshTokenHash = utils.HashToken(refreshToken)
+	newSession.RefreshExpiresAt = time.Now().Add(models.RefreshTokenTTL).Unix()
+	newSession.UserAgent = client.UserAgent
+	newSession.IPAddress = client.IPAddress
+	newSession.LastSeenAt = time.Now().Unix()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, dbHelper.Timeouts.Query)
 	defer cancel()
 
-	result, err := dbHelper.UserSessionsCollection.InsertOne(ctx, newSession)
+	_, err = dbHelper.UserSessionsCollection.InsertOne(ctx, sessionDocument{UserSession: newSession, ExpiresAt: time.Unix(newSession.RefreshExpiresAt, 0)})
 	if err != nil {
-		utils.LogError("CreateUserSession", "error inserting user's new session into the database", fmt.Sprintf("UserID: %s", userID), err)
 		return newSession, err
 	}
-	utils.LogInfo("CreateUserSession", "successfully created a new user session", fmt.Sprintf("New Session ID: %s", newSession.ID), result.InsertedID)
 
+	newSession.RefreshToken = refreshToken
 	return newSession, nil
 }
 
-func (dh *DBHelper) CreateUser(user models.User) error {
+// RotateRefreshToken exchanges a refresh token for a new session in the same family.
+// The old session ends and its refresh token can't be used again. Presenting a token
+// that was already exchanged revokes the whole family and returns ErrRefreshTokenReused,
+// together with the session of the reused token so the caller knows whose family it was.
+func (dbHelper *DBHelper) RotateRefreshToken(ctx context.Context, refreshToken string, client models.SessionClient) (models.UserSession, error) {
 
-	filter := bson.M{"username": user.Username}
+	utils.LogInfo("RotateRefreshToken", "exchanging refresh token for a new session", "", nil)
 
-	var existingUser models.User
-	err := dh.UserCollection.FindOne(context.TODO(), filter).Decode(&existingUser)
-	if err == nil {
-		logrus.Warnf("CreateUser: user with username '%s' already exists", user.Username)
-		return fmt.Errorf("user with username '%s' already exists", user.Username)
-	}
-	if err != mongo.ErrNoDocuments {
-		logrus.Errorf("CreateUser: error checking for existing user: %v", err)
-		return err
-	}
-	_, err = dh.UserCollection.InsertOne(context.TODO(), user)
-	if err != nil {
-		logrus.Errorf("CreateUser, error inserting user data in mongo database users collection : %v ", err)
-		return err
-	}
-	return nil
-}
+	var oldSession models.UserSession
 
-func (dbHelper *DBHelper) IsUserSessionTokenActive(tokenString string) (bool, error) {
+	now := time.Now().Unix()
+	tokenHash := utils.HashToken(refreshToken)
+
+	ctx, cancel := context.WithTimeout(ctx, dbHelper.Timeouts.Query)
+	defer cancel()
 
-	utils.LogInfo("IsUserSessionTokenActive", "checking if user token is valid in the database", fmt.Sprintf("Token: %s", tokenString), nil)
+	filter := bson.M{"refreshTokenHash": tokenHash, "refreshUsed": false, "refreshExpiresAt": bson.M{"$gt": now}}
+	update := bson.M{"$set": bson.M{"refreshUsed": true, "endTime": now}}
 
-	sessionData, err := dbHelper.ReadUserSessionBySessionToken(tokenString)
+	err := dbHelper.UserSessionsCollection.FindOneAndUpdate(ctx, filter, update).Decode(&oldSession)
 	if err != nil {
-		utils.LogError("IsUserSessionTokenActive", "error reading user session data from the database, session is assumed to be inactive", fmt.Sprintf("Token: %s", tokenString), err)
-		return false, nil
+		if err != mongo.ErrNoDocuments {
+			utils.LogError("RotateRefreshToken", "error marking refresh token as used", "", err)
+			return oldSession, err
+		}
+
+		// The token is unknown, expired, revoked or was already used. Only the last one is an attack.
+		err = dbHelper.UserSessionsCollection.FindOne(ctx, bson.M{"refreshTokenHash": tokenHash}).Decode(&oldSession)
+		if err == nil && oldSession.RefreshUsed {
+			utils.LogWarning("RotateRefreshToken", "refresh token reused, revoking session family", fmt.Sprintf("UserID: %s, FamilyID: %s", oldSession.UserID, oldSession.FamilyID))
+			if err := dbHelper.RevokeSessionFamily(ctx, oldSession.FamilyID); err != nil {
+				return models.UserSession{}, err
+			}
+			return oldSession, models.ErrRefreshTokenReused
+		}
+		if err != nil && err != mongo.ErrNoDocuments {
+			utils.LogError("RotateRefreshToken", "error reading refresh token session", "", err)
+			return models.UserSession{}, err
+		}
+
+		return models.UserSession{}, models.ErrInvalidRefreshToken
 	}
 
-	if sessionData.EndTime < time.Now().Unix() {
-		utils.LogInfo("IsUserSessionTokenActive", "token has been expired", fmt.Sprintf("Token: %s", tokenString), nil)
-		return false, nil
+	// Sessions created before refresh tokens existed start their own family.
+	familyID := oldSession.FamilyID
+	if familyID == "" {
+		familyID = oldSession.ID
 	}
 
-	utils.LogInfo("IsUserSessionTokenActive", "token data found valid for an active session", fmt.Sprintf("Token: %s", tokenString), nil)
+	newSession, err := dbHelper.insertUserSession(ctx, oldSession.UserID, familyID, oldSession.StartTime, client)
+	if err != nil {
+		utils.LogError("RotateRefreshToken", "error inserting rotated session into the database", fmt.Sprintf("UserID: %s", oldSession.UserID), err)
+		return newSession, err
+	}
+	utils.LogInfo("RotateRefreshToken", "refresh token rotated", fmt.Sprintf("Old Session ID: %s, New Session ID: %s", oldSession.ID, newSession.ID), nil)
 
-	return true, nil
+	return newSession, nil
 }
 
-func (dbHelper *DBHelper) UpdateUserSession(sessionID string) error {
+// RevokeSessionFamily ends every session of the family and invalidates their refresh tokens.
+func (dbHelper *DBHelper) RevokeSessionFamily(ctx context.Context, familyID string) error {
 
-	utils.LogInfo("UpdateUserSession", "updating the User session with the specified session ID", fmt.Sprintf("SessionID: %s", sessionID), nil)
+	utils.LogInfo("RevokeSessionFamily", "revoking all the sessions of the family", fmt.Sprintf("FamilyID: %s", familyID), nil)
+
+	if familyID == "" {
+		return errors.New("RevokeSessionFamily: empty family ID")
+	}
+
+	now := time.Now().Unix()
+	filter := bson.M{"familyId": familyID}
+	update := bson.M{"$min": bson.M{"endTime": now, "refreshExpiresAt": now}}
 
-	userSessionData, err := dbHelper.ReadUserSessionBySessionID(sessionID)
+	ctx, cancel := context.WithTimeout(ctx, dbHelper.Timeouts.Query)
+	defer cancel()
+
+	result, err := dbHelper.UserSessionsCollection.UpdateMany(ctx, filter, update)
 	if err != nil {
-		utils.LogError("UpdateUserSession", "error fetching user session data", fmt.Sprintf("SessionID: %s", sessionID), err)
+		utils.LogError("RevokeSessionFamily", "error revoking session family in the database", fmt.Sprintf("FamilyID: %s", familyID), err)
 		return err
 	}
+	utils.LogInfo("RevokeSessionFamily", fmt.Sprintf("session family revoked. Matched Count: %d, Modified Count: %d", result.MatchedCount, result.ModifiedCount), fmt.Sprintf("FamilyID: %s", familyID), nil)
 
-	userSessionData.EndTime = time.Now().Add(1 * time.Hour).Unix()
+	return nil
+}
 
-	filter := bson.M{"id": sessionID}
-	update := bson.M{"$set": userSessionData}
+// CreateUser adds the user, or returns ErrUsernameTaken if the username is in use. The
+// unique index on username settles concurrent registrations of the same name.
+func (dh *DBHelper) CreateUser(ctx context.Context, user models.User) error {
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
 	defer cancel()
 
-	result, err := dbHelper.UserSessionsCollection.UpdateOne(ctx, filter, update)
+	_, err := dh.UserCollection.InsertOne(ctx, user)
 	if err != nil {
-		utils.LogError("UpdateUserSession", "error updating user session in the database", fmt.Sprintf("SessionID: %s", sessionID), err)
+		err = createUserErr(user.Username, err)
+		if errors.Is(err, models.ErrUsernameTaken) {
+			logrus.Warnf("CreateUser: user with username '%s' already exists", user.Username)
+			return err
+		}
+		logrus.Errorf("CreateUser, error inserting user data in mongo database users collection : %v ", err)
 		return err
 	}
-	utils.LogInfo("UpdateUserSession", fmt.Sprintf("successfully updated user session in the database. Matched Count: %d, Modified Count: %d, Upserted Count: %d", result.MatchedCount, result.ModifiedCount, result.UpsertedCount), fmt.Sprintf("SessionID: %s", sessionID), userSessionData)
-
 	return nil
 }
 
-func (dbHelper *DBHelper) IsUserSessionActive(sessionID string) (bool, error) {
+// fileNameErr maps a duplicate key error from storing a file to ErrNameTaken; only the
+// index on file names is unique among those that can be hit.
+func fileNameErr(filename string, err error) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w: %s", models.ErrNameTaken, filename)
+	}
+	return err
+}
+
+// createUserErr maps a duplicate key error from inserting a user to ErrUsernameTaken.
+func createUserErr(username string, err error) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w: %s", models.ErrUsernameTaken, username)
+	}
+	return err
+}
+
+// GetSessionWithUser reads the session with the token together with its user in one
+// round trip. models.ErrNotFound is returned when either is missing.
+func (dbHelper *DBHelper) GetSessionWithUser(ctx context.Context, tokenString string) (models.SessionWithUser, error) {
+
+	utils.LogInfo("GetSessionWithUser", "reading the user session and its user with the specified token", "", nil)
 
-	utils.LogInfo("IsUserSessionActive", "checking if user is marked online in the database", fmt.Sprintf("SessionID: %s", sessionID), nil)
+	var sessionWithUser models.SessionWithUser
 
-	sessionData, err := dbHelper.ReadUserSessionBySessionID(sessionID)
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"token": tokenString}}},
+		{{Key: "$limit", Value: 1}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": bson.M{"session": "$$ROOT"}}}},
+		{{Key: "$lookup", Value: bson.M{"from": dbHelper.UserCollection.Name(), "localField": "session.userId", "foreignField": "id", "as": "user"}}},
+		{{Key: "$unwind", Value: "$user"}},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dbHelper.Timeouts.Query)
+	defer cancel()
+
+	cursor, err := dbHelper.UserSessionsCollection.Aggregate(ctx, pipeline)
 	if err != nil {
-		utils.LogError("IsUserSessionActive", "error reading user session data from the database, session is assumed to be inactive", fmt.Sprintf("SessionID: %s", sessionID), err)
-		return false, nil
+		utils.LogError("GetSessionWithUser", "error reading user session from the database", "", err)
+		return sessionWithUser, err
 	}
+	defer cursor.Close(ctx)
 
-	if sessionData.EndTime <= time.Now().Unix() {
-		utils.LogInfo("IsUserSessionActive", "session has been terminated", fmt.Sprintf("SessionID: %s", sessionID), nil)
-		return false, nil
+	if !cursor.Next(ctx) {
+		if err := cursor.Err(); err != nil {
+			utils.LogError("GetSessionWithUser", "error reading user session from the database", "", err)
+			return sessionWithUser, err
+		}
+		return sessionWithUser, models.ErrNotFound
 	}
 
-	utils.LogInfo("IsUserSessionActive", "active session data found for the session ID, session is active", fmt.Sprintf("SessionID: %s", sessionID), nil)
+	if err := cursor.Decode(&sessionWithUser); err != nil {
+		utils.LogError("GetSessionWithUser", "error decoding user session from the database", "", err)
+		return sessionWithUser, err
+	}
 
-	return true, nil
+	return sessionWithUser, nil
 }
 
-func (dbHelper *DBHelper) ReadUserSessionBySessionToken(tokenString string) (models.UserSession, error) {
+// UpdateUserSession extends a running session by another access token lifetime with a
+// single write. Ended sessions are left alone and reported as not found.
+func (dbHelper *DBHelper) UpdateUserSession(ctx context.Context, sessionID string) error {
+
+	utils.LogInfo("UpdateUserSession", "updating the User session with the specified session ID", fmt.Sprintf("SessionID: %s", sessionID), nil)
+
+	now := time.Now()
+
+	// Only extend sessions that are still running, and only touch endTime so a concurrent
+	// logout or refresh isn't overwritten. A session used for longer than its refresh
+	// token lives pushes its expiry along, so the TTL index doesn't delete it while in use.
+	filter := bson.M{"id": sessionID, "endTime": bson.M{"$gt": now.Unix()}}
+	update := bson.M{
+		"$set": bson.M{"endTime": now.Add(models.AccessTokenTTL).Unix(), "lastSeenAt": now.Unix()},
+		"$max": bson.M{"expiresAt": now.Add(models.AccessTokenTTL)},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dbHelper.Timeouts.Query)
+	defer cancel()
+
+	result, err := dbHelper.UserSessionsCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		utils.LogError("UpdateUserSession", "error updating user session in the database", fmt.Sprintf("SessionID: %s", sessionID), err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return models.ErrNotFound
+	}
+	utils.LogInfo("UpdateUserSession", fmt.Sprintf("successfully updated user session in the database. Matched Count: %d, Modified Count: %d", result.MatchedCount, result.ModifiedCount), fmt.Sprintf("SessionID: %s", sessionID), nil)
+
+	return nil
+}
+
+func (dbHelper *DBHelper) ReadUserSessionBySessionToken(ctx context.Context, tokenString string) (models.UserSession, error) {
 
 	utils.LogInfo("ReadUserSessionBySessionToken", "reading the User session with the specified token", fmt.Sprintf("Token: %s", tokenString), nil)
 
@@ -275,45 +399,45 @@ func (dbHelper *DBHelper) ReadUserSessionBySessionToken(tokenString string) (mod
 
 	filter := bson.M{"token": tokenString}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, dbHelper.Timeouts.Query)
 	defer cancel()
 
 	err := dbHelper.UserSessionsCollection.FindOne(ctx, &filter).Decode(&userSession)
 	if err != nil {
 		utils.LogError("ReadUserSessionBySessionToken", "error decoding user session data from the database", fmt.Sprintf("Token: %s", tokenString), err)
-		return userSession, err
+		return userSession, notFound(err)
 	}
 	utils.LogInfo("ReadUserSessionBySessionToken", "user session fetched successfully", fmt.Sprintf("Token: %s", tokenString), nil)
 
 	return userSession, nil
 }
 
-func (dh *DBHelper) GetUserByID(userID string) (models.User, error) {
+func (dh *DBHelper) GetUserByID(ctx context.Context, userID string) (models.User, error) {
 	utils.LogInfo("GetUserByID", "fetching user by ID", fmt.Sprintf("UserID: %s", userID), nil)
 
 	var user models.User
 	filter := bson.M{"id": userID}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
 	defer cancel()
 
 	err := dh.UserCollection.FindOne(ctx, filter).Decode(&user)
 	if err != nil {
 		utils.LogError("GetUserByID", "error decoding the user from the database", fmt.Sprintf("UserID: %s", userID), err)
-		return user, err
+		return user, notFound(err)
 	}
 
 	utils.LogInfo("GetUserByID", "user fetched successfully", fmt.Sprintf("UserID: %s", userID), nil)
 	return user, nil
 }
 
-func (dh *DBHelper) UpdateStorageData(userID string, storage int64) error {
+func (dh *DBHelper) UpdateStorageData(ctx context.Context, userID string, storage int64) error {
 	utils.LogInfo("UpdateStorageData", "updating used storage", fmt.Sprintf("UserID: %s, NewStorage: %d", userID, storage), nil)
 
 	filter := bson.M{"id": userID}
 	update := bson.M{"$set": bson.M{"used_storage": storage}}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
 	defer cancel()
 
 	result, err := dh.UserCollection.UpdateOne(ctx, filter, update)
@@ -326,55 +450,191 @@ func (dh *DBHelper) UpdateStorageData(userID string, storage int64) error {
 	return nil
 }
 
-func (dh *DBHelper) InsertFileMetadata(file models.File) error {
+// InsertFileMetadata records a new file together with its first version. ErrNameTaken is
+// returned when the folder already has a file with the name.
+func (dh *DBHelper) InsertFileMetadata(ctx context.Context, file models.File, version models.FileVersion) error {
 	utils.LogInfo("InsertFileMetadata", "inserting file metadata", fmt.Sprintf("UserID: %s, FileName: %s", file.UserID, file.Filename), nil)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Batch)
 	defer cancel()
 
-	_, err := dh.FileCollection.InsertOne(ctx, file)
+	_, err := dh.FileVersionCollection.InsertOne(ctx, version)
+	if err != nil {
+		utils.LogError("InsertFileMetadata", "error inserting file version", fmt.Sprintf("UserID: %s, FileName: %s", file.UserID, file.Filename), err)
+		return err
+	}
+
+	_, err = dh.FileCollection.InsertOne(ctx, file)
 	if err != nil {
 		utils.LogError("InsertFileMetadata", "error inserting file metadata", fmt.Sprintf("UserID: %s, FileName: %s", file.UserID, file.Filename), err)
+		if _, cleanupErr := dh.FileVersionCollection.DeleteOne(ctx, bson.M{"id": version.ID}); cleanupErr != nil {
+			utils.LogError("InsertFileMetadata", "error removing orphaned file version", version, cleanupErr)
+		}
 	}
-	return err
+	return fileNameErr(file.Filename, err)
 }
 
-func (dh *DBHelper) GetFileByHash(userID, hash string) (*models.File, error) {
+func (dh *DBHelper) GetFileByHash(ctx context.Context, userID, hash string) (*models.File, error) {
 	utils.LogInfo("GetFileByHash", "searching for file by hash", fmt.Sprintf("UserID: %s, Hash: %s", userID, hash), nil)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Batch)
 	defer cancel()
 
 	var file models.File
 	err := dh.FileCollection.FindOne(ctx, bson.M{"user_id": userID, "hash": hash}).Decode(&file)
 	if err != nil {
 		utils.LogError("GetFileByHash", "file not found or error decoding", fmt.Sprintf("UserID: %s, Hash: %s", userID, hash), err)
-		return nil, err
+		return nil, notFound(err)
 	}
 
 	utils.LogInfo("GetFileByHash", "file retrieved successfully", fmt.Sprintf("UserID: %s, FileName: %s", userID, file.Filename), nil)
 	return &file, nil
 }
 
-func (dh *DBHelper) GetFilesByUser(userID string) ([]models.File, error) {
-	utils.LogInfo("GetFilesByUser", "fetching all files for user", fmt.Sprintf("UserID: %s", userID), nil)
+func (dh *DBHelper) GetFileByID(ctx context.Context, userID, fileID string) (*models.File, error) {
+	utils.LogInfo("GetFileByID", "fetching file by ID", fmt.Sprintf("UserID: %s, FileID: %s", userID, fileID), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Batch)
+	defer cancel()
+
+	var file models.File
+	err := dh.FileCollection.FindOne(ctx, bson.M{"id": fileID, "user_id": userID}).Decode(&file)
+	if err != nil {
+		utils.LogError("GetFileByID", "file not found or error decoding", fmt.Sprintf("UserID: %s, FileID: %s", userID, fileID), err)
+		return nil, notFound(err)
+	}
+
+	utils.LogInfo("GetFileByID", "file retrieved successfully", fmt.Sprintf("UserID: %s, FileName: %s", userID, file.Filename), nil)
+	return &file, nil
+}
+
+// DeleteFile removes the file and all of its versions, and gives their combined size
+// and the file back to the user's limits. If the quota update fails the metadata is put back, so the
+// two never drift apart. The deleted versions are returned so their content can be released.
+// The file's share links are revoked with it.
+func (dh *DBHelper) DeleteFile(ctx context.Context, userID, fileID string) (*models.File, []models.FileVersion, error) {
+	utils.LogInfo("DeleteFile", "deleting file metadata", fmt.Sprintf("UserID: %s, FileID: %s", userID, fileID), nil)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// The steps below are not a transaction. Once the file is gone they have to run to the
+	// end, or be rolled back, even if the client disconnects, so only the timeout applies.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), dh.Timeouts.Batch)
 	defer cancel()
 
-	cursor, err := dh.FileCollection.Find(ctx, bson.M{"user_id": userID})
+	var file models.File
+	err := dh.FileCollection.FindOneAndDelete(ctx, bson.M{"id": fileID, "user_id": userID}).Decode(&file)
 	if err != nil {
-		utils.LogError("GetFilesByUser", "error fetching files from database", fmt.Sprintf("UserID: %s", userID), err)
-		return nil, err
+		utils.LogError("DeleteFile", "file not found or error deleting", fmt.Sprintf("UserID: %s, FileID: %s", userID, fileID), err)
+		return nil, nil, notFound(err)
+	}
+
+	versions, err := dh.GetFileVersions(ctx, userID, fileID)
+	if err != nil {
+		utils.LogError("DeleteFile", "error reading file versions, restoring file metadata", fmt.Sprintf("UserID: %s, FileID: %s", userID, fileID), err)
+		dh.restoreFile(file, nil)
+		return nil, nil, err
+	}
+
+	_, err = dh.FileVersionCollection.DeleteMany(ctx, bson.M{"file_id": fileID, "user_id": userID})
+	if err != nil {
+		// Some of the versions may be gone already; restore all of them with the file.
+		utils.LogError("DeleteFile", "error deleting file versions, restoring file metadata", fmt.Sprintf("UserID: %s, FileID: %s", userID, fileID), err)
+		dh.restoreFile(file, versions)
+		return nil, nil, err
+	}
+
+	var freed int64
+	for _, version := range versions {
+		freed += version.Size
 	}
-	defer cursor.Close(ctx)
 
-	var files []models.File
-	if err = cursor.All(ctx, &files); err != nil {
-		utils.LogError("GetFilesByUser", "error decoding file cursor", fmt.Sprintf("UserID: %s", userID), err)
-		return nil, err
+	err = dh.ReleaseStorage(ctx, userID, freed, 1)
+	if err != nil {
+		utils.LogError("DeleteFile", "error releasing user storage, restoring file metadata", fmt.Sprintf("UserID: %s, FileID: %s", userID, fileID), err)
+		dh.restoreFile(file, versions)
+		return nil, nil, err
+	}
+
+	dh.deleteFileShares(ctx, userID, fileID)
+
+	utils.LogInfo("DeleteFile", "file metadata deleted and storage released", fmt.Sprintf("UserID: %s, FileID: %s, Freed: %d", userID, fileID, freed), nil)
+	return &file, versions, nil
+}
+
+// restoreFile puts back metadata removed by a delete that could not complete. It gets a
+// context of its own, as the delete may have failed because its deadline passed.
+// Versions that were never deleted are already there and are skipped.
+func (dh *DBHelper) restoreFile(file models.File, versions []models.FileVersion) {
+	ctx, cancel := context.WithTimeout(context.Background(), dh.Timeouts.Batch)
+	defer cancel()
+
+	if _, err := dh.FileCollection.InsertOne(ctx, file); err != nil {
+		utils.LogError("restoreFile", "error restoring file metadata", file, err)
+	}
+
+	for _, version := range versions {
+		if _, err := dh.FileVersionCollection.InsertOne(ctx, version); err != nil && !mongo.IsDuplicateKeyError(err) {
+			utils.LogError("restoreFile", "error restoring file version", version, err)
+		}
+	}
+}
+
+// ReserveStorage atomically adds size to the user's used storage and files to their file
+// count, but only while both stay within the user's limits. ErrInsufficientStorage or
+// ErrFileLimitReached is returned when they would not.
+func (dh *DBHelper) ReserveStorage(ctx context.Context, userID string, size, files int64) error {
+	utils.LogInfo("ReserveStorage", "reserving user storage", fmt.Sprintf("UserID: %s, Size: %d, Files: %d", userID, size, files), nil)
+
+	withinQuota := bson.M{"$lte": bson.A{bson.M{"$add": bson.A{"$used_storage", size}}, "$quota"}}
+	withinFileLimit := bson.M{"$or": bson.A{
+		bson.M{"$lte": bson.A{bson.M{"$ifNull": bson.A{"$max_files", 0}}, 0}},
+		bson.M{"$lte": bson.A{bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$file_count", 0}}, files}}, "$max_files"}},
+	}}
+
+	filter := bson.M{
+		"id":    userID,
+		"$expr": bson.M{"$and": bson.A{withinQuota, withinFileLimit}},
+	}
+	update := bson.M{"$inc": bson.M{"used_storage": size, "file_count": files}}
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	result, err := dh.UserCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		utils.LogError("ReserveStorage", "error reserving user storage in the database", fmt.Sprintf("UserID: %s, Size: %d", userID, size), err)
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		utils.LogWarning("ReserveStorage", "limits exceeded, storage not reserved", fmt.Sprintf("UserID: %s, Size: %d, Files: %d", userID, size, files))
+
+		user, err := dh.GetUserByID(ctx, userID)
+		if err == nil && files > 0 && user.MaxFiles > 0 && user.FileCount+files > user.MaxFiles {
+			return models.ErrFileLimitReached
+		}
+		return models.ErrInsufficientStorage
 	}
 
-	utils.LogInfo("GetFilesByUser", fmt.Sprintf("retrieved %d files", len(files)), fmt.Sprintf("UserID: %s", userID), nil)
-	return files, nil
+	utils.LogInfo("ReserveStorage", "storage reserved", fmt.Sprintf("UserID: %s, Size: %d", userID, size), nil)
+	return nil
+}
+
+// ReleaseStorage gives back storage and files taken by ReserveStorage.
+func (dh *DBHelper) ReleaseStorage(ctx context.Context, userID string, size, files int64) error {
+	utils.LogInfo("ReleaseStorage", "releasing user storage", fmt.Sprintf("UserID: %s, Size: %d, Files: %d", userID, size, files), nil)
+
+	filter := bson.M{"id": userID}
+	update := bson.M{"$inc": bson.M{"used_storage": -size, "file_count": -files}}
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	_, err := dh.UserCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		utils.LogError("ReleaseStorage", "error releasing user storage in the database", fmt.Sprintf("UserID: %s, Size: %d", userID, size), err)
+		return err
+	}
+
+	utils.LogInfo("ReleaseStorage", "storage released", fmt.Sprintf("UserID: %s, Size: %d", userID, size), nil)
+	return nil
 }
@@ -0,0 +1,194 @@
+package dbHelper
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetAllFiles returns the files of every user.
+func (dh *DBHelper) GetAllFiles(ctx context.Context) ([]models.File, error) {
+	utils.LogInfo("GetAllFiles", "fetching the files of all users", "", nil)
+
+	files := []models.File{}
+	if err := dh.findAll(ctx, dh.FileCollection, &files); err != nil {
+		utils.LogError("GetAllFiles", "error fetching files", "", err)
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// GetAllFileVersions returns the file versions of every user.
+func (dh *DBHelper) GetAllFileVersions(ctx context.Context) ([]models.FileVersion, error) {
+	utils.LogInfo("GetAllFileVersions", "fetching the file versions of all users", "", nil)
+
+	versions := []models.FileVersion{}
+	if err := dh.findAll(ctx, dh.FileVersionCollection, &versions); err != nil {
+		utils.LogError("GetAllFileVersions", "error fetching file versions", "", err)
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// GetBlobs returns every blob record.
+func (dh *DBHelper) GetBlobs(ctx context.Context) ([]models.Blob, error) {
+	utils.LogInfo("GetBlobs", "fetching all blob records", "", nil)
+
+	blobs := []models.Blob{}
+	if err := dh.findAll(ctx, dh.BlobCollection, &blobs); err != nil {
+		utils.LogError("GetBlobs", "error fetching blobs", "", err)
+		return nil, err
+	}
+
+	return blobs, nil
+}
+
+// DeleteFileVersion removes a single version record. The file itself is left as it is.
+func (dh *DBHelper) DeleteFileVersion(ctx context.Context, versionID string) error {
+	utils.LogInfo("DeleteFileVersion", "deleting file version", fmt.Sprintf("VersionID: %s", versionID), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	_, err := dh.FileVersionCollection.DeleteOne(ctx, bson.M{"id": versionID})
+	if err != nil {
+		utils.LogError("DeleteFileVersion", "error deleting file version", fmt.Sprintf("VersionID: %s", versionID), err)
+		return err
+	}
+
+	return nil
+}
+
+// SetCurrentFileVersion makes the version the file's current one, even if it is older.
+func (dh *DBHelper) SetCurrentFileVersion(ctx context.Context, version models.FileVersion) error {
+	utils.LogInfo("SetCurrentFileVersion", "setting current file version", fmt.Sprintf("FileID: %s, Version: %d", version.FileID, version.Version), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{
+		"version":      version.Version,
+		"size":         version.Size,
+		"path":         version.Path,
+		"hash":         version.Hash,
+		"content_type": version.ContentType,
+		"uploaded_at":  version.UploadedAt,
+	}}
+
+	result, err := dh.FileCollection.UpdateOne(ctx, bson.M{"id": version.FileID, "user_id": version.UserID}, update)
+	if err != nil {
+		utils.LogError("SetCurrentFileVersion", "error updating file", version, err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return models.ErrNotFound
+	}
+
+	return nil
+}
+
+// SetUserUsage moves the user's used storage and file count from the recorded to the
+// actual values of the correction, by the difference, and only while they are still the
+// recorded ones. Otherwise ErrConcurrentUpdate is returned, so usage reserved or released
+// since it was read is never lost.
+func (dh *DBHelper) SetUserUsage(ctx context.Context, correction models.UsageCorrection) error {
+	utils.LogInfo("SetUserUsage", "correcting user usage", fmt.Sprintf("UserID: %s, UsedStorage: %d -> %d, FileCount: %d -> %d", correction.UserID, correction.RecordedStorage, correction.ActualStorage, correction.RecordedFiles, correction.ActualFiles), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	// Users from before file counts were kept have no file_count, which counts as 0.
+	filter := bson.M{
+		"id":           correction.UserID,
+		"used_storage": correction.RecordedStorage,
+		"$expr":        bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{"$file_count", 0}}, correction.RecordedFiles}},
+	}
+	update := bson.M{"$inc": bson.M{
+		"used_storage": correction.ActualStorage - correction.RecordedStorage,
+		"file_count":   correction.ActualFiles - correction.RecordedFiles,
+	}}
+
+	result, err := dh.UserCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		utils.LogError("SetUserUsage", "error correcting user usage", fmt.Sprintf("UserID: %s", correction.UserID), err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		if _, err := dh.GetUserByID(ctx, correction.UserID); err != nil {
+			return err
+		}
+		return models.ErrConcurrentUpdate
+	}
+
+	return nil
+}
+
+// SetBlobRefCount overwrites the blob's reference count, creating its record if needed,
+// but only while the stored count is still recorded (0 for no record). Otherwise
+// ErrConcurrentUpdate is returned. A count of zero or less removes the record.
+func (dh *DBHelper) SetBlobRefCount(ctx context.Context, blob models.Blob, recorded int64) error {
+	utils.LogInfo("SetBlobRefCount", "setting blob reference count", fmt.Sprintf("Hash: %s, RefCount: %d, Recorded: %d", blob.Hash, blob.RefCount, recorded), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	var changed bool
+	switch {
+	case recorded <= 0 && blob.RefCount <= 0:
+		return nil
+	case recorded <= 0:
+		update := bson.M{"$setOnInsert": bson.M{
+			"ref_count":  blob.RefCount,
+			"size":       blob.Size,
+			"path":       blob.Path,
+			"created_at": blob.CreatedAt,
+		}}
+		result, err := dh.BlobCollection.UpdateOne(ctx, bson.M{"hash": blob.Hash}, update, options.Update().SetUpsert(true))
+		if err != nil {
+			utils.LogError("SetBlobRefCount", "error creating blob", fmt.Sprintf("Hash: %s", blob.Hash), err)
+			return err
+		}
+		changed = result.MatchedCount > 0
+	case blob.RefCount <= 0:
+		result, err := dh.BlobCollection.DeleteOne(ctx, bson.M{"hash": blob.Hash, "ref_count": recorded})
+		if err != nil {
+			utils.LogError("SetBlobRefCount", "error deleting blob", fmt.Sprintf("Hash: %s", blob.Hash), err)
+			return err
+		}
+		changed = result.DeletedCount == 0
+	default:
+		result, err := dh.BlobCollection.UpdateOne(ctx, bson.M{"hash": blob.Hash, "ref_count": recorded}, bson.M{"$set": bson.M{"ref_count": blob.RefCount}})
+		if err != nil {
+			utils.LogError("SetBlobRefCount", "error updating blob", fmt.Sprintf("Hash: %s", blob.Hash), err)
+			return err
+		}
+		changed = result.MatchedCount == 0
+	}
+
+	if changed {
+		return models.ErrConcurrentUpdate
+	}
+	return nil
+}
+
+// findAll decodes every document of the collection into results.
+func (dh *DBHelper) findAll(ctx context.Context, collection *mongo.Collection, results interface{}) error {
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Scan)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	return cursor.All(ctx, results)
+}
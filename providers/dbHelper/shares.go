@@ -0,0 +1,133 @@
+package dbHelper
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (dh *DBHelper) CreateShare(ctx context.Context, share models.Share) error {
+	utils.LogInfo("CreateShare", "creating share link", fmt.Sprintf("UserID: %s, FileID: %s", share.UserID, share.FileID), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	_, err := dh.ShareCollection.InsertOne(ctx, share)
+	if err != nil {
+		utils.LogError("CreateShare", "error inserting share link", fmt.Sprintf("UserID: %s, FileID: %s", share.UserID, share.FileID), err)
+	}
+	return err
+}
+
+// GetShareByToken looks a share link up by the hash of its token.
+func (dh *DBHelper) GetShareByToken(ctx context.Context, tokenHash string) (*models.Share, error) {
+	utils.LogInfo("GetShareByToken", "fetching share link", "", nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	var share models.Share
+	err := dh.ShareCollection.FindOne(ctx, bson.M{"token_hash": tokenHash}).Decode(&share)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			utils.LogError("GetShareByToken", "error decoding share link", "", err)
+		}
+		return nil, notFound(err)
+	}
+
+	return &share, nil
+}
+
+// GetSharesByUser returns the user's share links, newest first.
+func (dh *DBHelper) GetSharesByUser(ctx context.Context, userID string) ([]models.Share, error) {
+	utils.LogInfo("GetSharesByUser", "fetching share links for user", fmt.Sprintf("UserID: %s", userID), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Batch)
+	defer cancel()
+
+	cursor, err := dh.ShareCollection.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		utils.LogError("GetSharesByUser", "error fetching share links", fmt.Sprintf("UserID: %s", userID), err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	shares := []models.Share{}
+	if err = cursor.All(ctx, &shares); err != nil {
+		utils.LogError("GetSharesByUser", "error decoding share links", fmt.Sprintf("UserID: %s", userID), err)
+		return nil, err
+	}
+
+	return shares, nil
+}
+
+// DeleteShare revokes one of the user's share links.
+func (dh *DBHelper) DeleteShare(ctx context.Context, userID, shareID string) error {
+	utils.LogInfo("DeleteShare", "revoking share link", fmt.Sprintf("UserID: %s, ShareID: %s", userID, shareID), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	result, err := dh.ShareCollection.DeleteOne(ctx, bson.M{"id": shareID, "user_id": userID})
+	if err != nil {
+		utils.LogError("DeleteShare", "error deleting share link", fmt.Sprintf("UserID: %s, ShareID: %s", userID, shareID), err)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return models.ErrNotFound
+	}
+
+	return nil
+}
+
+// ClaimShareDownload counts a download against the share link, but only while it has
+// not expired and is within its download limit. ErrShareExpired or ErrShareExhausted
+// is returned when it is not.
+func (dh *DBHelper) ClaimShareDownload(ctx context.Context, shareID string, now int64) (*models.Share, error) {
+	utils.LogInfo("ClaimShareDownload", "counting share link download", fmt.Sprintf("ShareID: %s", shareID), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	filter := bson.M{
+		"id":  shareID,
+		"$or": bson.A{bson.M{"expires_at": 0}, bson.M{"expires_at": bson.M{"$gt": now}}},
+		"$expr": bson.M{"$or": bson.A{
+			bson.M{"$lte": bson.A{"$max_downloads", 0}},
+			bson.M{"$lt": bson.A{"$downloads", "$max_downloads"}},
+		}},
+	}
+
+	var share models.Share
+	err := dh.ShareCollection.FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{"downloads": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&share)
+	if err == nil {
+		return &share, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		utils.LogError("ClaimShareDownload", "error counting share link download", fmt.Sprintf("ShareID: %s", shareID), err)
+		return nil, err
+	}
+
+	// Find out why the link can't be used.
+	err = dh.ShareCollection.FindOne(ctx, bson.M{"id": shareID}).Decode(&share)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if share.ExpiresAt > 0 && share.ExpiresAt <= now {
+		return nil, models.ErrShareExpired
+	}
+	return nil, models.ErrShareExhausted
+}
+
+// deleteFileShares revokes the share links of a deleted file.
+func (dh *DBHelper) deleteFileShares(ctx context.Context, userID, fileID string) {
+	if _, err := dh.ShareCollection.DeleteMany(ctx, bson.M{"user_id": userID, "file_id": fileID}); err != nil {
+		utils.LogError("deleteFileShares", "error deleting share links of deleted file", fmt.Sprintf("UserID: %s, FileID: %s", userID, fileID), err)
+	}
+}
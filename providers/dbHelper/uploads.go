@@ -0,0 +1,109 @@
+package dbHelper
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func (dh *DBHelper) CreateUpload(ctx context.Context, upload models.Upload) error {
+	utils.LogInfo("CreateUpload", "creating resumable upload session", fmt.Sprintf("UserID: %s, UploadID: %s", upload.UserID, upload.ID), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	_, err := dh.UploadCollection.InsertOne(ctx, upload)
+	if err != nil {
+		utils.LogError("CreateUpload", "error inserting upload session", fmt.Sprintf("UserID: %s, UploadID: %s", upload.UserID, upload.ID), err)
+	}
+	return err
+}
+
+func (dh *DBHelper) GetUploadByID(ctx context.Context, userID, uploadID string) (*models.Upload, error) {
+	utils.LogInfo("GetUploadByID", "fetching upload session", fmt.Sprintf("UserID: %s, UploadID: %s", userID, uploadID), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	var upload models.Upload
+	err := dh.UploadCollection.FindOne(ctx, bson.M{"id": uploadID, "user_id": userID}).Decode(&upload)
+	if err != nil {
+		utils.LogError("GetUploadByID", "upload session not found or error decoding", fmt.Sprintf("UserID: %s, UploadID: %s", userID, uploadID), err)
+		return nil, notFound(err)
+	}
+
+	return &upload, nil
+}
+
+// UpdateUploadOffset moves the upload forward, but only if nobody else moved it since
+// fromOffset was read. ErrUploadOffsetChanged is returned otherwise. The upload expires
+// UploadTTL from now.
+func (dh *DBHelper) UpdateUploadOffset(ctx context.Context, uploadID string, fromOffset, toOffset int64, hashState []byte) error {
+	utils.LogInfo("UpdateUploadOffset", "updating upload offset", fmt.Sprintf("UploadID: %s, From: %d, To: %d", uploadID, fromOffset, toOffset), nil)
+
+	filter := bson.M{"id": uploadID, "offset": fromOffset}
+	update := bson.M{"$set": bson.M{
+		"offset":     toOffset,
+		"hash_state": hashState,
+		"updated_at": time.Now().Unix(),
+		"expires_at": time.Now().Add(models.UploadTTL).Unix(),
+	}}
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	result, err := dh.UploadCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		utils.LogError("UpdateUploadOffset", "error updating upload offset", fmt.Sprintf("UploadID: %s", uploadID), err)
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		utils.LogWarning("UpdateUploadOffset", "upload offset changed concurrently", fmt.Sprintf("UploadID: %s, From: %d", uploadID, fromOffset))
+		return models.ErrUploadOffsetChanged
+	}
+
+	return nil
+}
+
+func (dh *DBHelper) DeleteUpload(ctx context.Context, uploadID string) error {
+	utils.LogInfo("DeleteUpload", "deleting upload session", fmt.Sprintf("UploadID: %s", uploadID), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	_, err := dh.UploadCollection.DeleteOne(ctx, bson.M{"id": uploadID})
+	if err != nil {
+		utils.LogError("DeleteUpload", "error deleting upload session", fmt.Sprintf("UploadID: %s", uploadID), err)
+	}
+	return err
+}
+
+// CountOpenUploads counts the uploads of the user that have not expired at the given time.
+func (dh *DBHelper) CountOpenUploads(ctx context.Context, userID string, at int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	count, err := dh.UploadCollection.CountDocuments(ctx, bson.M{"user_id": userID, "expires_at": bson.M{"$gt": at}})
+	if err != nil {
+		utils.LogError("CountOpenUploads", "error counting upload sessions", fmt.Sprintf("UserID: %s", userID), err)
+	}
+	return count, err
+}
+
+// GetUploads returns every upload session, expired or not.
+func (dh *DBHelper) GetUploads(ctx context.Context) ([]models.Upload, error) {
+	utils.LogInfo("GetUploads", "fetching all upload sessions", "", nil)
+
+	uploads := []models.Upload{}
+	if err := dh.findAll(ctx, dh.UploadCollection, &uploads); err != nil {
+		utils.LogError("GetUploads", "error fetching upload sessions", "", err)
+		return nil, err
+	}
+
+	return uploads, nil
+}
@@ -0,0 +1,167 @@
+package dbHelper
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetUsers returns every registered user, oldest first.
+func (dh *DBHelper) GetUsers(ctx context.Context) ([]models.User, error) {
+	utils.LogInfo("GetUsers", "fetching all users", "", nil)
+
+	users := []models.User{}
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Batch)
+	defer cancel()
+
+	cursor, err := dh.UserCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		utils.LogError("GetUsers", "error fetching users from the database", "", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &users); err != nil {
+		utils.LogError("GetUsers", "error decoding users", "", err)
+		return nil, err
+	}
+
+	utils.LogInfo("GetUsers", fmt.Sprintf("fetched %d users", len(users)), "", nil)
+	return users, nil
+}
+
+// UpdateUserQuota sets the user's storage quota in bytes.
+func (dh *DBHelper) UpdateUserQuota(ctx context.Context, userID string, quota int64) error {
+	utils.LogInfo("UpdateUserQuota", "updating user quota", fmt.Sprintf("UserID: %s, Quota: %d", userID, quota), nil)
+
+	return dh.updateUser(ctx, "UpdateUserQuota", userID, bson.M{"quota": quota})
+}
+
+// SetUserDisabled disables or re-enables the user's account.
+func (dh *DBHelper) SetUserDisabled(ctx context.Context, userID string, disabled bool) error {
+	utils.LogInfo("SetUserDisabled", "updating user account state", fmt.Sprintf("UserID: %s, Disabled: %v", userID, disabled), nil)
+
+	return dh.updateUser(ctx, "SetUserDisabled", userID, bson.M{"disabled": disabled})
+}
+
+// SetUserPlan puts the user on the plan, replacing their limits with the plan's.
+func (dh *DBHelper) SetUserPlan(ctx context.Context, userID string, plan models.Plan) error {
+	utils.LogInfo("SetUserPlan", "updating user plan", fmt.Sprintf("UserID: %s, Plan: %s", userID, plan.Name), nil)
+
+	return dh.updateUser(ctx, "SetUserPlan", userID, bson.M{
+		"plan":          plan.Name,
+		"quota":         plan.Quota,
+		"max_file_size": plan.MaxFileSize,
+		"max_files":     plan.MaxFiles,
+	})
+}
+
+// MigrateUserPlans puts users registered before plans existed on the plan and counts
+// their files. Their quota is left as it was. It is safe to run on every start.
+func (dh *DBHelper) MigrateUserPlans(ctx context.Context, plan models.Plan) error {
+	utils.LogInfo("MigrateUserPlans", "assigning a plan to users without one", fmt.Sprintf("Plan: %s", plan.Name), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Scan)
+	defer cancel()
+
+	cursor, err := dh.UserCollection.Find(ctx, bson.M{"plan": bson.M{"$exists": false}})
+	if err != nil {
+		utils.LogError("MigrateUserPlans", "error fetching users without a plan", "", err)
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var users []models.User
+	if err = cursor.All(ctx, &users); err != nil {
+		utils.LogError("MigrateUserPlans", "error decoding users without a plan", "", err)
+		return err
+	}
+
+	for _, user := range users {
+		fileCount, err := dh.FileCollection.CountDocuments(ctx, bson.M{"user_id": user.ID})
+		if err != nil {
+			utils.LogError("MigrateUserPlans", "error counting user files", user.ID, err)
+			return err
+		}
+
+		_, err = dh.UserCollection.UpdateOne(ctx, bson.M{"id": user.ID}, bson.M{"$set": bson.M{
+			"plan":          plan.Name,
+			"max_file_size": plan.MaxFileSize,
+			"max_files":     plan.MaxFiles,
+			"file_count":    fileCount,
+		}})
+		if err != nil {
+			utils.LogError("MigrateUserPlans", "error updating user plan", user.ID, err)
+			return err
+		}
+	}
+
+	utils.LogInfo("MigrateUserPlans", fmt.Sprintf("migrated %d users", len(users)), "", nil)
+	return nil
+}
+
+// SetUserRoleByUsername gives the user with the username the role.
+func (dh *DBHelper) SetUserRoleByUsername(ctx context.Context, username, role string) error {
+	utils.LogInfo("SetUserRoleByUsername", "updating user role", fmt.Sprintf("Username: %s, Role: %s", username, role), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	result, err := dh.UserCollection.UpdateOne(ctx, bson.M{"username": username}, bson.M{"$set": bson.M{"role": role}})
+	if err != nil {
+		utils.LogError("SetUserRoleByUsername", "error updating user role in the database", fmt.Sprintf("Username: %s", username), err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return models.ErrNotFound
+	}
+
+	return nil
+}
+
+// EndAllUserSessions ends every session of the user and invalidates their refresh tokens.
+func (dbHelper *DBHelper) EndAllUserSessions(ctx context.Context, userID string) error {
+
+	utils.LogInfo("EndAllUserSessions", "ending all the sessions of the user", fmt.Sprintf("UserID: %s", userID), nil)
+
+	now := time.Now().Unix()
+	filter := bson.M{"userId": userID}
+	update := bson.M{"$min": bson.M{"endTime": now, "refreshExpiresAt": now}}
+
+	ctx, cancel := context.WithTimeout(ctx, dbHelper.Timeouts.Query)
+	defer cancel()
+
+	result, err := dbHelper.UserSessionsCollection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		utils.LogError("EndAllUserSessions", "error ending user sessions in the database", fmt.Sprintf("UserID: %s", userID), err)
+		return err
+	}
+	utils.LogInfo("EndAllUserSessions", fmt.Sprintf("user sessions ended. Matched Count: %d, Modified Count: %d", result.MatchedCount, result.ModifiedCount), fmt.Sprintf("UserID: %s", userID), nil)
+
+	return nil
+}
+
+// updateUser sets fields on the user, returning models.ErrNotFound when there is no such user.
+func (dh *DBHelper) updateUser(ctx context.Context, source, userID string, fields bson.M) error {
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Query)
+	defer cancel()
+
+	result, err := dh.UserCollection.UpdateOne(ctx, bson.M{"id": userID}, bson.M{"$set": fields})
+	if err != nil {
+		utils.LogError(source, "error updating user in the database", fmt.Sprintf("UserID: %s", userID), err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return models.ErrNotFound
+	}
+
+	utils.LogInfo(source, fmt.Sprintf("user updated. Matched: %d, Modified: %d", result.MatchedCount, result.ModifiedCount), fmt.Sprintf("UserID: %s", userID), nil)
+	return nil
+}
@@ -0,0 +1,221 @@
+package dbHelper
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetFileByName returns the file with the given name directly inside the folder.
+func (dh *DBHelper) GetFileByName(ctx context.Context, userID, folderID, filename string) (*models.File, error) {
+	utils.LogInfo("GetFileByName", "searching for file by name", fmt.Sprintf("UserID: %s, FolderID: %s, FileName: %s", userID, folderID, filename), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Batch)
+	defer cancel()
+
+	var file models.File
+	err := dh.FileCollection.FindOne(ctx, bson.M{"user_id": userID, "folder_id": folderIDFilter(folderID), "filename": filename}).Decode(&file)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			utils.LogError("GetFileByName", "error decoding file", fmt.Sprintf("UserID: %s, FileName: %s", userID, filename), err)
+		}
+		return nil, notFound(err)
+	}
+
+	return &file, nil
+}
+
+// AddFileVersion appends a version to an existing file and makes it the current one.
+// The version number is allocated here and returned on the version.
+func (dh *DBHelper) AddFileVersion(ctx context.Context, version models.FileVersion) (models.FileVersion, error) {
+	utils.LogInfo("AddFileVersion", "adding file version", fmt.Sprintf("UserID: %s, FileID: %s", version.UserID, version.FileID), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Batch)
+	defer cancel()
+
+	var file models.File
+	err := dh.FileCollection.FindOneAndUpdate(ctx,
+		bson.M{"id": version.FileID, "user_id": version.UserID},
+		bson.M{"$inc": bson.M{"last_version": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&file)
+	if err != nil {
+		utils.LogError("AddFileVersion", "file not found or error allocating version", fmt.Sprintf("UserID: %s, FileID: %s", version.UserID, version.FileID), err)
+		return version, notFound(err)
+	}
+
+	version.Version = file.LastVersion
+
+	_, err = dh.FileVersionCollection.InsertOne(ctx, version)
+	if err != nil {
+		utils.LogError("AddFileVersion", "error inserting file version", version, err)
+		return version, err
+	}
+
+	// Only move forward, in case a newer version was added concurrently.
+	filter := bson.M{"id": version.FileID, "version": bson.M{"$lt": version.Version}}
+	update := bson.M{"$set": bson.M{
+		"version":      version.Version,
+		"size":         version.Size,
+		"path":         version.Path,
+		"hash":         version.Hash,
+		"content_type": version.ContentType,
+		"uploaded_at":  version.UploadedAt,
+	}}
+
+	_, err = dh.FileCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		utils.LogError("AddFileVersion", "error making version current, removing it", version, err)
+		if _, cleanupErr := dh.FileVersionCollection.DeleteOne(ctx, bson.M{"id": version.ID}); cleanupErr != nil {
+			utils.LogError("AddFileVersion", "error removing file version", version, cleanupErr)
+		}
+		return version, err
+	}
+
+	utils.LogInfo("AddFileVersion", "file version added", fmt.Sprintf("FileID: %s, Version: %d", version.FileID, version.Version), nil)
+	return version, nil
+}
+
+// GetFileVersions returns every version of the file, newest first.
+func (dh *DBHelper) GetFileVersions(ctx context.Context, userID, fileID string) ([]models.FileVersion, error) {
+	utils.LogInfo("GetFileVersions", "fetching file versions", fmt.Sprintf("UserID: %s, FileID: %s", userID, fileID), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Batch)
+	defer cancel()
+
+	cursor, err := dh.FileVersionCollection.Find(ctx, bson.M{"file_id": fileID, "user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "version", Value: -1}}))
+	if err != nil {
+		utils.LogError("GetFileVersions", "error fetching file versions", fmt.Sprintf("UserID: %s, FileID: %s", userID, fileID), err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	versions := []models.FileVersion{}
+	if err = cursor.All(ctx, &versions); err != nil {
+		utils.LogError("GetFileVersions", "error decoding file versions", fmt.Sprintf("UserID: %s, FileID: %s", userID, fileID), err)
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+func (dh *DBHelper) GetFileVersion(ctx context.Context, userID, fileID string, version int64) (*models.FileVersion, error) {
+	utils.LogInfo("GetFileVersion", "fetching file version", fmt.Sprintf("UserID: %s, FileID: %s, Version: %d", userID, fileID, version), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Batch)
+	defer cancel()
+
+	var fileVersion models.FileVersion
+	err := dh.FileVersionCollection.FindOne(ctx, bson.M{"file_id": fileID, "user_id": userID, "version": version}).Decode(&fileVersion)
+	if err != nil {
+		utils.LogError("GetFileVersion", "file version not found or error decoding", fmt.Sprintf("UserID: %s, FileID: %s, Version: %d", userID, fileID, version), err)
+		return nil, notFound(err)
+	}
+
+	return &fileVersion, nil
+}
+
+// MigrateLegacyFiles gives every file uploaded before versioning existed a version
+// record for its content, as version 1. It is safe to run on every start.
+func (dh *DBHelper) MigrateLegacyFiles(ctx context.Context) error {
+	utils.LogInfo("MigrateLegacyFiles", "creating version records for files without any", "", nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Scan)
+	defer cancel()
+
+	filter := bson.M{"$or": bson.A{bson.M{"last_version": bson.M{"$exists": false}}, bson.M{"last_version": 0}}}
+
+	cursor, err := dh.FileCollection.Find(ctx, filter)
+	if err != nil {
+		utils.LogError("MigrateLegacyFiles", "error fetching files without versions", "", err)
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var files []models.File
+	if err = cursor.All(ctx, &files); err != nil {
+		utils.LogError("MigrateLegacyFiles", "error decoding files without versions", "", err)
+		return err
+	}
+
+	for _, file := range files {
+		version := models.FileVersion{
+			ID:          uuid.NewString(),
+			FileID:      file.ID,
+			UserID:      file.UserID,
+			Version:     1,
+			Size:        file.Size,
+			Path:        file.Path,
+			Hash:        file.Hash,
+			ContentType: file.ContentType,
+			UploadedAt:  file.UploadedAt,
+		}
+
+		_, err = dh.FileVersionCollection.InsertOne(ctx, version)
+		if err != nil {
+			utils.LogError("MigrateLegacyFiles", "error inserting file version", version, err)
+			return err
+		}
+
+		_, err = dh.FileCollection.UpdateOne(ctx, bson.M{"id": file.ID}, bson.M{"$set": bson.M{"version": 1, "last_version": 1}})
+		if err != nil {
+			utils.LogError("MigrateLegacyFiles", "error updating file version", file, err)
+			return err
+		}
+	}
+
+	utils.LogInfo("MigrateLegacyFiles", fmt.Sprintf("migrated %d files", len(files)), "", nil)
+	return nil
+}
+
+// MigrateContentTypes gives files and versions stored before content types were
+// recorded a type guessed from their file name. It is safe to run on every start.
+func (dh *DBHelper) MigrateContentTypes(ctx context.Context) error {
+	utils.LogInfo("MigrateContentTypes", "setting content types of files without one", "", nil)
+
+	ctx, cancel := context.WithTimeout(ctx, dh.Timeouts.Scan)
+	defer cancel()
+
+	cursor, err := dh.FileCollection.Find(ctx, bson.M{"content_type": bson.M{"$exists": false}})
+	if err != nil {
+		utils.LogError("MigrateContentTypes", "error fetching files without a content type", "", err)
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var files []models.File
+	if err = cursor.All(ctx, &files); err != nil {
+		utils.LogError("MigrateContentTypes", "error decoding files without a content type", "", err)
+		return err
+	}
+
+	for _, file := range files {
+		contentType := utils.ContentTypeByName(file.Filename)
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		_, err = dh.FileCollection.UpdateOne(ctx, bson.M{"id": file.ID}, bson.M{"$set": bson.M{"content_type": contentType}})
+		if err != nil {
+			utils.LogError("MigrateContentTypes", "error updating file content type", file, err)
+			return err
+		}
+
+		_, err = dh.FileVersionCollection.UpdateMany(ctx,
+			bson.M{"file_id": file.ID, "content_type": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"content_type": contentType}})
+		if err != nil {
+			utils.LogError("MigrateContentTypes", "error updating file version content types", file, err)
+			return err
+		}
+	}
+
+	utils.LogInfo("MigrateContentTypes", fmt.Sprintf("migrated %d files", len(files)), "", nil)
+	return nil
+}
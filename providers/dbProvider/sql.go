@@ -0,0 +1,54 @@
+package dbProvider
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	// Register the "postgres" and "sqlite" drivers with database/sql.
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// ConnectSQL opens the database for the driver, "sqlite" or "postgres", and waits for it
+// to answer. Like ConnectDB it retries up to maxAttempts times before giving up.
+func ConnectSQL(driver, dsn string, maxAttempts int) (*sql.DB, error) {
+
+	if dsn == "" {
+		return nil, errors.New("no sql.dsn configured")
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s database: %w", driver, err)
+	}
+
+	// SQLite allows a single writer at a time, so everything goes through one connection
+	// instead of failing with "database is locked". This also keeps a ":memory:"
+	// database alive, as every connection would otherwise get its own.
+	if driver == "sqlite" {
+		db.SetMaxOpenConns(1)
+	}
+
+	for i := 0; i < maxAttempts; i++ {
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = db.PingContext(ctx)
+		cancel()
+		if err != nil {
+			logrus.Errorf("dbProvider.go: ConnectSQL: Failed to reach the %s database: %v", driver, err)
+			time.Sleep(2 * time.Second)
+			continue
+		}
+
+		logrus.Infof("dbProvider.go: ConnectSQL: Connected to the %s database successfully.", driver)
+		return db, nil
+	}
+
+	db.Close()
+	return nil, fmt.Errorf("connecting to %s database: %w", driver, err)
+}
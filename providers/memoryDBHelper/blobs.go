@@ -0,0 +1,122 @@
+package memoryDBHelper
+
+import (
+	"context"
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+)
+
+// AcquireBlob adds a reference to the blob, creating its record on first use.
+// It reports whether the record was newly created.
+func (mh *MemoryDBHelper) AcquireBlob(ctx context.Context, blob models.Blob) (bool, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	if stored := mh.findBlob(blob.Hash); stored != nil {
+		stored.RefCount++
+		return false, nil
+	}
+
+	blob.RefCount = 1
+	mh.blobs = append(mh.blobs, blob)
+	return true, nil
+}
+
+func (mh *MemoryDBHelper) GetBlob(ctx context.Context, hash string) (*models.Blob, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	if stored := mh.findBlob(hash); stored != nil {
+		blob := *stored
+		return &blob, nil
+	}
+	return nil, models.ErrNotFound
+}
+
+// GetBlobs returns every blob record.
+func (mh *MemoryDBHelper) GetBlobs(ctx context.Context) ([]models.Blob, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	return append([]models.Blob{}, mh.blobs...), nil
+}
+
+// ReleaseBlob drops a reference to the blob and returns it with the remaining count.
+// The record is removed once the count reaches zero; deleting the content is up to the caller.
+func (mh *MemoryDBHelper) ReleaseBlob(ctx context.Context, hash string) (*models.Blob, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	stored := mh.findBlob(hash)
+	if stored == nil {
+		return nil, models.ErrNotFound
+	}
+
+	stored.RefCount--
+	blob := *stored
+	if blob.RefCount <= 0 {
+		mh.removeBlob(hash)
+	}
+	return &blob, nil
+}
+
+// CountBlobVersions returns how many file versions keep their content in the blob.
+func (mh *MemoryDBHelper) CountBlobVersions(ctx context.Context, hash string) (int64, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	var count int64
+	for _, version := range mh.fileVersions {
+		if version.Hash == hash && utils.StorageKey(version.Path) == utils.BlobKey(hash) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// SetBlobRefCount overwrites the blob's reference count, creating its record if needed,
+// but only while the stored count is still recorded (0 for no record). Otherwise
+// ErrConcurrentUpdate is returned. A count of zero or less removes the record.
+func (mh *MemoryDBHelper) SetBlobRefCount(ctx context.Context, blob models.Blob, recorded int64) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	var current int64
+	if stored := mh.findBlob(blob.Hash); stored != nil {
+		current = stored.RefCount
+	}
+	if current != recorded {
+		return models.ErrConcurrentUpdate
+	}
+
+	if blob.RefCount <= 0 {
+		mh.removeBlob(blob.Hash)
+		return nil
+	}
+
+	if stored := mh.findBlob(blob.Hash); stored != nil {
+		stored.RefCount = blob.RefCount
+		return nil
+	}
+	mh.blobs = append(mh.blobs, blob)
+	return nil
+}
+
+// findBlob returns the stored blob to update in place; the caller holds the lock.
+func (mh *MemoryDBHelper) findBlob(hash string) *models.Blob {
+	for i := range mh.blobs {
+		if mh.blobs[i].Hash == hash {
+			return &mh.blobs[i]
+		}
+	}
+	return nil
+}
+
+func (mh *MemoryDBHelper) removeBlob(hash string) {
+	for i := range mh.blobs {
+		if mh.blobs[i].Hash == hash {
+			mh.blobs = append(mh.blobs[:i], mh.blobs[i+1:]...)
+			return
+		}
+	}
+}
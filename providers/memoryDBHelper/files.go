@@ -0,0 +1,403 @@
+package memoryDBHelper
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+	"github.com/google/uuid"
+)
+
+func (mh *MemoryDBHelper) InsertFileMetadata(ctx context.Context, file models.File, version models.FileVersion) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	if mh.fileNameTaken(file.UserID, file.ID, file.FolderID, file.Filename) {
+		return fmt.Errorf("%w: %s", models.ErrNameTaken, file.Filename)
+	}
+	mh.fileVersions = append(mh.fileVersions, version)
+	mh.files = append(mh.files, file)
+	return nil
+}
+
+func (mh *MemoryDBHelper) GetFileByHash(ctx context.Context, userID, hash string) (*models.File, error) {
+	return mh.findFile(func(file models.File) bool {
+		return file.UserID == userID && file.Hash == hash
+	})
+}
+
+func (mh *MemoryDBHelper) GetFileByID(ctx context.Context, userID, fileID string) (*models.File, error) {
+	return mh.findFile(func(file models.File) bool {
+		return file.UserID == userID && file.ID == fileID
+	})
+}
+
+// GetFileByName returns the file with the given name directly inside the folder.
+func (mh *MemoryDBHelper) GetFileByName(ctx context.Context, userID, folderID, filename string) (*models.File, error) {
+	return mh.findFile(func(file models.File) bool {
+		return file.UserID == userID && file.FolderID == folderID && file.Filename == filename
+	})
+}
+
+// GetAllFiles returns the files of every user.
+func (mh *MemoryDBHelper) GetAllFiles(ctx context.Context) ([]models.File, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	return append([]models.File{}, mh.files...), nil
+}
+
+// DeleteFile removes the file and all of its versions, and gives their combined size
+// and the file back to the user's limits. The deleted versions are returned, newest
+// first, so their content can be released. The file's share links are revoked with it.
+func (mh *MemoryDBHelper) DeleteFile(ctx context.Context, userID, fileID string) (*models.File, []models.FileVersion, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	index := -1
+	for i, file := range mh.files {
+		if file.UserID == userID && file.ID == fileID {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, nil, models.ErrNotFound
+	}
+
+	file := mh.files[index]
+	mh.files = append(mh.files[:index], mh.files[index+1:]...)
+
+	versions := mh.fileVersionsOf(userID, fileID)
+
+	var freed int64
+	remaining := mh.fileVersions[:0]
+	for _, version := range mh.fileVersions {
+		if version.FileID == fileID && version.UserID == userID {
+			freed += version.Size
+			continue
+		}
+		remaining = append(remaining, version)
+	}
+	mh.fileVersions = remaining
+
+	if user := mh.findUser(userID); user != nil {
+		user.UsedStorage -= freed
+		user.FileCount--
+	}
+
+	shares := mh.shares[:0]
+	for _, share := range mh.shares {
+		if share.UserID != userID || share.FileID != fileID {
+			shares = append(shares, share)
+		}
+	}
+	mh.shares = shares
+
+	return &file, versions, nil
+}
+
+// ListFiles returns one page of the user's files matching the query, ordered by the
+// sort field and then by ID.
+func (mh *MemoryDBHelper) ListFiles(ctx context.Context, query models.FileQuery) (models.FilePage, error) {
+	page := models.FilePage{Files: []models.File{}}
+
+	less, ok := fileSortLess[query.SortBy]
+	if !ok {
+		return page, fmt.Errorf("unknown sort field %q", query.SortBy)
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = models.DefaultFilePageSize
+	}
+
+	var after *models.File
+	if query.Cursor != "" {
+		cursor, err := utils.DecodeFileCursor(query)
+		if err != nil {
+			return page, err
+		}
+		after = &models.File{ID: cursor.ID, Filename: cursor.Filename, Size: cursor.Number, UploadedAt: cursor.Number}
+	}
+
+	mh.mu.Lock()
+	var files []models.File
+	for _, file := range mh.files {
+		if matchesFileQuery(file, query) {
+			files = append(files, file)
+		}
+	}
+	mh.mu.Unlock()
+
+	page.Total = int64(len(files))
+
+	// ordered reports whether a comes before b in the listing.
+	ordered := func(a, b models.File) bool {
+		if query.Descending {
+			a, b = b, a
+		}
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return a.ID < b.ID
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return ordered(files[i], files[j])
+	})
+
+	for _, file := range files {
+		if after != nil && !ordered(*after, file) {
+			continue
+		}
+		page.Files = append(page.Files, file)
+		if int64(len(page.Files)) > limit {
+			break
+		}
+	}
+
+	if int64(len(page.Files)) > limit {
+		page.Files = page.Files[:limit]
+		page.NextCursor = utils.EncodeFileCursor(query, page.Files[limit-1])
+	}
+
+	return page, nil
+}
+
+// File fields the listing can be sorted on.
+var fileSortLess = map[string]func(a, b models.File) bool{
+	models.FileSortByName: func(a, b models.File) bool { return a.Filename < b.Filename },
+	models.FileSortBySize: func(a, b models.File) bool { return a.Size < b.Size },
+	models.FileSortByDate: func(a, b models.File) bool { return a.UploadedAt < b.UploadedAt },
+}
+
+func matchesFileQuery(file models.File, query models.FileQuery) bool {
+	if file.UserID != query.UserID {
+		return false
+	}
+	if query.FolderID != nil && file.FolderID != *query.FolderID {
+		return false
+	}
+	if !strings.HasPrefix(file.Filename, query.NamePrefix) {
+		return false
+	}
+
+	// "image/*" matches every image type.
+	if strings.HasSuffix(query.ContentType, "/*") {
+		if !strings.HasPrefix(file.ContentType, strings.TrimSuffix(query.ContentType, "*")) {
+			return false
+		}
+	} else if query.ContentType != "" && file.ContentType != query.ContentType {
+		return false
+	}
+
+	if query.MinSize != nil && file.Size < *query.MinSize {
+		return false
+	}
+	if query.MaxSize != nil && file.Size > *query.MaxSize {
+		return false
+	}
+	if query.UploadedAfter != nil && file.UploadedAt < *query.UploadedAfter {
+		return false
+	}
+	if query.UploadedBefore != nil && file.UploadedAt >= *query.UploadedBefore {
+		return false
+	}
+
+	return true
+}
+
+// AddFileVersion appends a version to an existing file and makes it the current one.
+// The version number is allocated here and returned on the version.
+func (mh *MemoryDBHelper) AddFileVersion(ctx context.Context, version models.FileVersion) (models.FileVersion, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	file := mh.findStoredFile(version.UserID, version.FileID)
+	if file == nil {
+		return version, models.ErrNotFound
+	}
+
+	file.LastVersion++
+	version.Version = file.LastVersion
+	mh.fileVersions = append(mh.fileVersions, version)
+
+	if file.Version < version.Version {
+		setCurrentVersion(file, version)
+	}
+	return version, nil
+}
+
+// GetFileVersions returns every version of the file, newest first.
+func (mh *MemoryDBHelper) GetFileVersions(ctx context.Context, userID, fileID string) ([]models.FileVersion, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	return mh.fileVersionsOf(userID, fileID), nil
+}
+
+func (mh *MemoryDBHelper) GetFileVersion(ctx context.Context, userID, fileID string, version int64) (*models.FileVersion, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	for _, fileVersion := range mh.fileVersions {
+		if fileVersion.UserID == userID && fileVersion.FileID == fileID && fileVersion.Version == version {
+			return &fileVersion, nil
+		}
+	}
+	return nil, models.ErrNotFound
+}
+
+// GetAllFileVersions returns the file versions of every user.
+func (mh *MemoryDBHelper) GetAllFileVersions(ctx context.Context) ([]models.FileVersion, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	return append([]models.FileVersion{}, mh.fileVersions...), nil
+}
+
+// DeleteFileVersion removes a single version record. The file itself is left as it is.
+func (mh *MemoryDBHelper) DeleteFileVersion(ctx context.Context, versionID string) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	for i, version := range mh.fileVersions {
+		if version.ID == versionID {
+			mh.fileVersions = append(mh.fileVersions[:i], mh.fileVersions[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// SetCurrentFileVersion makes the version the file's current one, even if it is older.
+func (mh *MemoryDBHelper) SetCurrentFileVersion(ctx context.Context, version models.FileVersion) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	file := mh.findStoredFile(version.UserID, version.FileID)
+	if file == nil {
+		return models.ErrNotFound
+	}
+	setCurrentVersion(file, version)
+	return nil
+}
+
+// MigrateLegacyFiles gives every file without versions a version record for its
+// content, as version 1.
+func (mh *MemoryDBHelper) MigrateLegacyFiles(ctx context.Context) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	for i := range mh.files {
+		file := &mh.files[i]
+		if file.LastVersion != 0 {
+			continue
+		}
+
+		mh.fileVersions = append(mh.fileVersions, models.FileVersion{
+			ID:          uuid.NewString(),
+			FileID:      file.ID,
+			UserID:      file.UserID,
+			Version:     1,
+			Size:        file.Size,
+			Path:        file.Path,
+			Hash:        file.Hash,
+			ContentType: file.ContentType,
+			UploadedAt:  file.UploadedAt,
+		})
+		file.Version = 1
+		file.LastVersion = 1
+	}
+	return nil
+}
+
+// MigrateContentTypes gives files and versions without a content type one guessed from
+// their file name.
+func (mh *MemoryDBHelper) MigrateContentTypes(ctx context.Context) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	for i := range mh.files {
+		file := &mh.files[i]
+		if file.ContentType != "" {
+			continue
+		}
+
+		file.ContentType = utils.ContentTypeByName(file.Filename)
+		if file.ContentType == "" {
+			file.ContentType = "application/octet-stream"
+		}
+
+		for j := range mh.fileVersions {
+			if mh.fileVersions[j].FileID == file.ID && mh.fileVersions[j].ContentType == "" {
+				mh.fileVersions[j].ContentType = file.ContentType
+			}
+		}
+	}
+	return nil
+}
+
+func (mh *MemoryDBHelper) findFile(match func(file models.File) bool) (*models.File, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	for _, file := range mh.files {
+		if match(file) {
+			return &file, nil
+		}
+	}
+	return nil, models.ErrNotFound
+}
+
+// findStoredFile returns the stored file to update in place; the caller holds the lock.
+func (mh *MemoryDBHelper) findStoredFile(userID, fileID string) *models.File {
+	for i := range mh.files {
+		if mh.files[i].UserID == userID && mh.files[i].ID == fileID {
+			return &mh.files[i]
+		}
+	}
+	return nil
+}
+
+// fileVersionsOf returns the file's versions, newest first; the caller holds the lock.
+func (mh *MemoryDBHelper) fileVersionsOf(userID, fileID string) []models.FileVersion {
+	versions := []models.FileVersion{}
+	for _, version := range mh.fileVersions {
+		if version.UserID == userID && version.FileID == fileID {
+			versions = append(versions, version)
+		}
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version > versions[j].Version
+	})
+	return versions
+}
+
+func setCurrentVersion(file *models.File, version models.FileVersion) {
+	file.Version = version.Version
+	file.Size = version.Size
+	file.Path = version.Path
+	file.Hash = version.Hash
+	file.ContentType = version.ContentType
+	file.UploadedAt = version.UploadedAt
+}
+
+// fileNameTaken reports whether another file than fileID has the name in the folder, as
+// the unique index of the other databases would. mh.mu must be held.
+func (mh *MemoryDBHelper) fileNameTaken(userID, fileID, folderID, filename string) bool {
+	for _, file := range mh.files {
+		if file.UserID == userID && file.ID != fileID && file.FolderID == folderID && file.Filename == filename {
+			return true
+		}
+	}
+	return false
+}
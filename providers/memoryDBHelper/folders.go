@@ -0,0 +1,138 @@
+package memoryDBHelper
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/file_upload/models"
+)
+
+func (mh *MemoryDBHelper) CreateFolder(ctx context.Context, folder models.Folder) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	mh.folders = append(mh.folders, folder)
+	return nil
+}
+
+func (mh *MemoryDBHelper) GetFolder(ctx context.Context, userID, folderID string) (*models.Folder, error) {
+	return mh.findFolder(func(folder models.Folder) bool {
+		return folder.UserID == userID && folder.ID == folderID
+	})
+}
+
+// GetFolderByName returns the folder with the given name directly inside the parent.
+func (mh *MemoryDBHelper) GetFolderByName(ctx context.Context, userID, parentID, name string) (*models.Folder, error) {
+	return mh.findFolder(func(folder models.Folder) bool {
+		return folder.UserID == userID && folder.ParentID == parentID && folder.Name == name
+	})
+}
+
+// GetFolders returns every folder of the user, sorted by name.
+func (mh *MemoryDBHelper) GetFolders(ctx context.Context, userID string) ([]models.Folder, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	folders := []models.Folder{}
+	for _, folder := range mh.folders {
+		if folder.UserID == userID {
+			folders = append(folders, folder)
+		}
+	}
+
+	sort.SliceStable(folders, func(i, j int) bool {
+		return folders[i].Name < folders[j].Name
+	})
+	return folders, nil
+}
+
+// UpdateFolder saves the folder's name and parent.
+func (mh *MemoryDBHelper) UpdateFolder(ctx context.Context, folder models.Folder) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	for i := range mh.folders {
+		stored := &mh.folders[i]
+		if stored.UserID == folder.UserID && stored.ID == folder.ID {
+			stored.Name = folder.Name
+			stored.ParentID = folder.ParentID
+			stored.UpdatedAt = folder.UpdatedAt
+			return nil
+		}
+	}
+	return models.ErrNotFound
+}
+
+// DeleteFolders removes the folders. Their files must have been deleted already.
+func (mh *MemoryDBHelper) DeleteFolders(ctx context.Context, userID string, folderIDs []string) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	deleted := map[string]bool{}
+	for _, folderID := range folderIDs {
+		deleted[folderID] = true
+	}
+
+	folders := mh.folders[:0]
+	for _, folder := range mh.folders {
+		if folder.UserID != userID || !deleted[folder.ID] {
+			folders = append(folders, folder)
+		}
+	}
+	mh.folders = folders
+	return nil
+}
+
+// GetFilesInFolders returns the user's files directly inside any of the folders,
+// sorted by name. "" stands for the top level.
+func (mh *MemoryDBHelper) GetFilesInFolders(ctx context.Context, userID string, folderIDs []string) ([]models.File, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	inFolders := map[string]bool{}
+	for _, folderID := range folderIDs {
+		inFolders[folderID] = true
+	}
+
+	files := []models.File{}
+	for _, file := range mh.files {
+		if file.UserID == userID && inFolders[file.FolderID] {
+			files = append(files, file)
+		}
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].Filename < files[j].Filename
+	})
+	return files, nil
+}
+
+// UpdateFileLocation renames and/or moves a file.
+func (mh *MemoryDBHelper) UpdateFileLocation(ctx context.Context, userID, fileID, folderID, filename string) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	file := mh.findStoredFile(userID, fileID)
+	if file == nil {
+		return models.ErrNotFound
+	}
+	if mh.fileNameTaken(userID, fileID, folderID, filename) {
+		return fmt.Errorf("%w: %s", models.ErrNameTaken, filename)
+	}
+	file.FolderID = folderID
+	file.Filename = filename
+	return nil
+}
+
+func (mh *MemoryDBHelper) findFolder(match func(folder models.Folder) bool) (*models.Folder, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	for _, folder := range mh.folders {
+		if match(folder) {
+			return &folder, nil
+		}
+	}
+	return nil, models.ErrNotFound
+}
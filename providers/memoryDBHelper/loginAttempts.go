@@ -0,0 +1,81 @@
+package memoryDBHelper
+
+import (
+	"context"
+	"github.com/file_upload/models"
+)
+
+// GetLoginAttempt returns the failed login record for the key, or an empty one if there is none.
+func (mh *MemoryDBHelper) GetLoginAttempt(ctx context.Context, key string) (models.LoginAttempt, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	if attempt := mh.findLoginAttempt(key); attempt != nil {
+		return *attempt, nil
+	}
+	return models.LoginAttempt{Key: key}, nil
+}
+
+// RecordLoginFailure counts a failed login for the key and returns the updated record.
+// Failures are forgotten, and the count starts again at one, when neither the last
+// failure nor the end of the last lockout is after resetBefore. Records of every key
+// for which that holds are dropped, so they don't pile up.
+func (mh *MemoryDBHelper) RecordLoginFailure(ctx context.Context, key string, at, resetBefore int64) (models.LoginAttempt, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	current := mh.loginAttempts[:0]
+	for _, attempt := range mh.loginAttempts {
+		if max(attempt.LastFailureAt, attempt.LockedUntil) >= resetBefore {
+			current = append(current, attempt)
+		}
+	}
+	mh.loginAttempts = current
+
+	attempt := mh.findLoginAttempt(key)
+	if attempt == nil {
+		mh.loginAttempts = append(mh.loginAttempts, models.LoginAttempt{Key: key})
+		attempt = &mh.loginAttempts[len(mh.loginAttempts)-1]
+	}
+
+	attempt.Failures++
+	attempt.LastFailureAt = at
+
+	return *attempt, nil
+}
+
+// LockLogin refuses logins for the key until lockedUntil. The record is dropped by
+// RecordLoginFailure once it is stale, so keepUntil is not needed.
+func (mh *MemoryDBHelper) LockLogin(ctx context.Context, key string, lockedUntil, keepUntil int64) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	if attempt := mh.findLoginAttempt(key); attempt != nil && attempt.LockedUntil < lockedUntil {
+		attempt.LockedUntil = lockedUntil
+	}
+	return nil
+}
+
+// ClearLoginAttempts forgets the failed logins for the key and lifts any lockout.
+func (mh *MemoryDBHelper) ClearLoginAttempts(ctx context.Context, key string) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	for i := range mh.loginAttempts {
+		if mh.loginAttempts[i].Key == key {
+			mh.loginAttempts = append(mh.loginAttempts[:i], mh.loginAttempts[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// findLoginAttempt returns the stored record to update in place; the caller holds the lock.
+func (mh *MemoryDBHelper) findLoginAttempt(key string) *models.LoginAttempt {
+	for i := range mh.loginAttempts {
+		if mh.loginAttempts[i].Key == key {
+			return &mh.loginAttempts[i]
+		}
+	}
+	return nil
+}
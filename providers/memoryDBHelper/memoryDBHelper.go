@@ -0,0 +1,38 @@
+package memoryDBHelper
+
+import (
+	"context"
+	"sync"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/providers"
+)
+
+// MemoryDBHelper keeps everything in memory, for tests and local development without
+// MongoDB. It follows the semantics of the MongoDB helper, including its not-found
+// errors (models.ErrNotFound), so handlers behave the same on either. Records are
+// kept in insertion order, and every method works on copies so callers can't change
+// stored records behind its back. Nothing it does waits, so the contexts passed to it
+// are not used.
+type MemoryDBHelper struct {
+	mu sync.Mutex
+
+	users         []models.User
+	sessions      []models.UserSession
+	files         []models.File
+	fileVersions  []models.FileVersion
+	uploads       []models.Upload
+	blobs         []models.Blob
+	loginAttempts []models.LoginAttempt
+	folders       []models.Folder
+	shares        []models.Share
+}
+
+func NewMemoryDBHelperProvider() providers.DBHelperProvider {
+	return &MemoryDBHelper{}
+}
+
+// EnsureIndexes has nothing to do; lookups scan the records.
+func (mh *MemoryDBHelper) EnsureIndexes(ctx context.Context) error {
+	return nil
+}
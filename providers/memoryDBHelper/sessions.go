@@ -0,0 +1,239 @@
+package memoryDBHelper
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+	"github.com/google/uuid"
+)
+
+// CreateUserSession starts a new session family for the user. Other sessions of the
+// user are left running, so every device keeps its own session.
+func (mh *MemoryDBHelper) CreateUserSession(ctx context.Context, userID string, client models.SessionClient) (models.UserSession, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	return mh.insertUserSession(userID, uuid.New().String(), time.Now().Unix(), client)
+}
+
+// insertUserSession stores a new session in the given family, with fresh access and
+// refresh tokens. The caller holds the lock.
+func (mh *MemoryDBHelper) insertUserSession(userID, familyID string, startTime int64, client models.SessionClient) (models.UserSession, error) {
+
+	refreshToken, err := utils.GenerateSecureToken()
+	if err != nil {
+		return models.UserSession{}, err
+	}
+
+	newSession := models.UserSession{
+		ID:               uuid.New().String(),
+		UserID:           userID,
+		FamilyID:         familyID,
+		StartTime:        startTime,
+		EndTime:          time.Now().Add(models.AccessTokenTTL).Unix(),
+		Token:            uuid.New().String(),
+		RefreshTokenHash: utils.HashToken(refreshToken),
+		RefreshExpiresAt: time.Now().Add(models.RefreshTokenTTL).Unix(),
+		UserAgent:        client.UserAgent,
+		IPAddress:        client.IPAddress,
+		LastSeenAt:       time.Now().Unix(),
+	}
+	mh.sessions = append(mh.sessions, newSession)
+
+	newSession.RefreshToken = refreshToken
+	return newSession, nil
+}
+
+// GetSessionWithUser returns the session with the token together with its user.
+func (mh *MemoryDBHelper) GetSessionWithUser(ctx context.Context, tokenString string) (models.SessionWithUser, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	for _, session := range mh.sessions {
+		if session.Token != tokenString {
+			continue
+		}
+		user := mh.findUser(session.UserID)
+		if user == nil {
+			break
+		}
+		return models.SessionWithUser{Session: session, User: *user}, nil
+	}
+	return models.SessionWithUser{}, models.ErrNotFound
+}
+
+// UpdateUserSession extends a running session by another access token lifetime. Ended
+// sessions are left alone and reported as not found.
+func (mh *MemoryDBHelper) UpdateUserSession(ctx context.Context, sessionID string) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	now := time.Now().Unix()
+	for i := range mh.sessions {
+		session := &mh.sessions[i]
+		if session.ID == sessionID && session.EndTime > now {
+			session.EndTime = time.Now().Add(models.AccessTokenTTL).Unix()
+			session.LastSeenAt = now
+			return nil
+		}
+	}
+	return models.ErrNotFound
+}
+
+func (mh *MemoryDBHelper) ReadUserSessionBySessionToken(ctx context.Context, tokenString string) (models.UserSession, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	for _, session := range mh.sessions {
+		if session.Token == tokenString {
+			return session, nil
+		}
+	}
+	return models.UserSession{}, models.ErrNotFound
+}
+
+// ReadUserSessionBySessionID returns the session, or an empty one without an error
+// when there is none.
+func (mh *MemoryDBHelper) ReadUserSessionBySessionID(ctx context.Context, sessionID string) (models.UserSession, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	for _, session := range mh.sessions {
+		if session.ID == sessionID {
+			return session, nil
+		}
+	}
+	return models.UserSession{}, nil
+}
+
+// ReadUserSessions returns the user's sessions. With activeSessions set only the
+// sessions still alive are returned: those whose access token is valid, or whose
+// refresh token can still be used.
+func (mh *MemoryDBHelper) ReadUserSessions(ctx context.Context, userID string, activeSessions bool) ([]models.UserSession, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	now := time.Now().Unix()
+
+	var sessions []models.UserSession
+	for _, session := range mh.sessions {
+		if session.UserID != userID {
+			continue
+		}
+		if activeSessions && session.EndTime <= now && (session.RefreshUsed || session.RefreshExpiresAt <= now) {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// EndUserSession ends the session and retires its refresh token.
+func (mh *MemoryDBHelper) EndUserSession(ctx context.Context, sessionID string) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	now := time.Now().Unix()
+	for i := range mh.sessions {
+		if mh.sessions[i].ID == sessionID {
+			mh.sessions[i].EndTime = now
+			mh.sessions[i].RefreshExpiresAt = now
+		}
+	}
+	return nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new session in the same family.
+// The old session ends and its refresh token can't be used again. Presenting a token
+// that was already exchanged revokes the whole family and returns ErrRefreshTokenReused,
+// together with the session of the reused token so the caller knows whose family it was.
+func (mh *MemoryDBHelper) RotateRefreshToken(ctx context.Context, refreshToken string, client models.SessionClient) (models.UserSession, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	now := time.Now().Unix()
+	tokenHash := utils.HashToken(refreshToken)
+
+	for i := range mh.sessions {
+		oldSession := &mh.sessions[i]
+		if oldSession.RefreshTokenHash != tokenHash {
+			continue
+		}
+
+		// Only a token that was already used is an attack; unknown, expired and revoked
+		// tokens are just invalid.
+		if oldSession.RefreshUsed {
+			familyID := oldSession.FamilyID
+			if familyID == "" {
+				return models.UserSession{}, errors.New("RevokeSessionFamily: empty family ID")
+			}
+			mh.revokeSessions(func(session models.UserSession) bool {
+				return session.FamilyID == familyID
+			})
+			return *oldSession, models.ErrRefreshTokenReused
+		}
+		if oldSession.RefreshExpiresAt <= now {
+			break
+		}
+
+		oldSession.RefreshUsed = true
+		oldSession.EndTime = now
+
+		// Sessions created before refresh tokens existed start their own family.
+		familyID := oldSession.FamilyID
+		if familyID == "" {
+			familyID = oldSession.ID
+		}
+
+		return mh.insertUserSession(oldSession.UserID, familyID, oldSession.StartTime, client)
+	}
+
+	return models.UserSession{}, models.ErrInvalidRefreshToken
+}
+
+// RevokeSessionFamily ends every session of the family and invalidates their refresh tokens.
+func (mh *MemoryDBHelper) RevokeSessionFamily(ctx context.Context, familyID string) error {
+	if familyID == "" {
+		return errors.New("RevokeSessionFamily: empty family ID")
+	}
+
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	mh.revokeSessions(func(session models.UserSession) bool {
+		return session.FamilyID == familyID
+	})
+	return nil
+}
+
+// EndAllUserSessions ends every session of the user and invalidates their refresh tokens.
+func (mh *MemoryDBHelper) EndAllUserSessions(ctx context.Context, userID string) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	mh.revokeSessions(func(session models.UserSession) bool {
+		return session.UserID == userID
+	})
+	return nil
+}
+
+// revokeSessions ends the matching sessions now, unless they already ended earlier.
+// The caller holds the lock.
+func (mh *MemoryDBHelper) revokeSessions(match func(session models.UserSession) bool) {
+	now := time.Now().Unix()
+	for i := range mh.sessions {
+		session := &mh.sessions[i]
+		if !match(*session) {
+			continue
+		}
+		if session.EndTime > now {
+			session.EndTime = now
+		}
+		if session.RefreshExpiresAt > now {
+			session.RefreshExpiresAt = now
+		}
+	}
+}
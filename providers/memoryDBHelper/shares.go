@@ -0,0 +1,89 @@
+package memoryDBHelper
+
+import (
+	"context"
+	"sort"
+
+	"github.com/file_upload/models"
+)
+
+func (mh *MemoryDBHelper) CreateShare(ctx context.Context, share models.Share) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	share.Token = ""
+	mh.shares = append(mh.shares, share)
+	return nil
+}
+
+// GetShareByToken looks a share link up by the hash of its token.
+func (mh *MemoryDBHelper) GetShareByToken(ctx context.Context, tokenHash string) (*models.Share, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	for _, share := range mh.shares {
+		if share.TokenHash == tokenHash {
+			return &share, nil
+		}
+	}
+	return nil, models.ErrNotFound
+}
+
+// GetSharesByUser returns the user's share links, newest first.
+func (mh *MemoryDBHelper) GetSharesByUser(ctx context.Context, userID string) ([]models.Share, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	shares := []models.Share{}
+	for _, share := range mh.shares {
+		if share.UserID == userID {
+			shares = append(shares, share)
+		}
+	}
+
+	sort.SliceStable(shares, func(i, j int) bool {
+		return shares[i].CreatedAt > shares[j].CreatedAt
+	})
+	return shares, nil
+}
+
+// DeleteShare revokes one of the user's share links.
+func (mh *MemoryDBHelper) DeleteShare(ctx context.Context, userID, shareID string) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	for i, share := range mh.shares {
+		if share.UserID == userID && share.ID == shareID {
+			mh.shares = append(mh.shares[:i], mh.shares[i+1:]...)
+			return nil
+		}
+	}
+	return models.ErrNotFound
+}
+
+// ClaimShareDownload counts a download against the share link, but only while it has
+// not expired and is within its download limit. ErrShareExpired or ErrShareExhausted
+// is returned when it is not.
+func (mh *MemoryDBHelper) ClaimShareDownload(ctx context.Context, shareID string, now int64) (*models.Share, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	for i := range mh.shares {
+		share := &mh.shares[i]
+		if share.ID != shareID {
+			continue
+		}
+
+		if share.ExpiresAt > 0 && share.ExpiresAt <= now {
+			return nil, models.ErrShareExpired
+		}
+		if share.MaxDownloads > 0 && share.Downloads >= share.MaxDownloads {
+			return nil, models.ErrShareExhausted
+		}
+
+		share.Downloads++
+		claimed := *share
+		return &claimed, nil
+	}
+	return nil, models.ErrNotFound
+}
@@ -0,0 +1,90 @@
+package memoryDBHelper
+
+import (
+	"context"
+	"time"
+
+	"github.com/file_upload/models"
+)
+
+func (mh *MemoryDBHelper) CreateUpload(ctx context.Context, upload models.Upload) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	upload.HashState = append([]byte(nil), upload.HashState...)
+	mh.uploads = append(mh.uploads, upload)
+	return nil
+}
+
+func (mh *MemoryDBHelper) GetUploadByID(ctx context.Context, userID, uploadID string) (*models.Upload, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	for _, upload := range mh.uploads {
+		if upload.UserID == userID && upload.ID == uploadID {
+			upload.HashState = append([]byte(nil), upload.HashState...)
+			return &upload, nil
+		}
+	}
+	return nil, models.ErrNotFound
+}
+
+// UpdateUploadOffset moves the upload forward, but only if nobody else moved it since
+// fromOffset was read. ErrUploadOffsetChanged is returned otherwise. The upload expires
+// UploadTTL from now.
+func (mh *MemoryDBHelper) UpdateUploadOffset(ctx context.Context, uploadID string, fromOffset, toOffset int64, hashState []byte) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	for i := range mh.uploads {
+		upload := &mh.uploads[i]
+		if upload.ID == uploadID && upload.Offset == fromOffset {
+			upload.Offset = toOffset
+			upload.HashState = append([]byte(nil), hashState...)
+			upload.UpdatedAt = time.Now().Unix()
+			upload.ExpiresAt = time.Now().Add(models.UploadTTL).Unix()
+			return nil
+		}
+	}
+	return models.ErrUploadOffsetChanged
+}
+
+func (mh *MemoryDBHelper) DeleteUpload(ctx context.Context, uploadID string) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	for i, upload := range mh.uploads {
+		if upload.ID == uploadID {
+			mh.uploads = append(mh.uploads[:i], mh.uploads[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// CountOpenUploads counts the uploads of the user that have not expired at the given time.
+func (mh *MemoryDBHelper) CountOpenUploads(ctx context.Context, userID string, at int64) (int64, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	var count int64
+	for _, upload := range mh.uploads {
+		if upload.UserID == userID && upload.ExpiresAt > at {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// GetUploads returns every upload session, expired or not.
+func (mh *MemoryDBHelper) GetUploads(ctx context.Context) ([]models.Upload, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	uploads := make([]models.Upload, 0, len(mh.uploads))
+	for _, upload := range mh.uploads {
+		upload.HashState = append([]byte(nil), upload.HashState...)
+		uploads = append(uploads, upload)
+	}
+	return uploads, nil
+}
@@ -0,0 +1,209 @@
+package memoryDBHelper
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/file_upload/models"
+)
+
+func (mh *MemoryDBHelper) GetUserByUsername(ctx context.Context, username string) (models.User, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	for _, user := range mh.users {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+	return models.User{}, models.ErrNotFound
+}
+
+func (mh *MemoryDBHelper) GetUserByID(ctx context.Context, userID string) (models.User, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	if user := mh.findUser(userID); user != nil {
+		return *user, nil
+	}
+	return models.User{}, models.ErrNotFound
+}
+
+// CreateUser adds the user, or returns ErrUsernameTaken if the username is in use.
+func (mh *MemoryDBHelper) CreateUser(ctx context.Context, user models.User) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	for _, existingUser := range mh.users {
+		if existingUser.Username == user.Username {
+			return fmt.Errorf("%w: %s", models.ErrUsernameTaken, user.Username)
+		}
+	}
+
+	mh.users = append(mh.users, user)
+	return nil
+}
+
+// GetUsers returns every registered user, oldest first.
+func (mh *MemoryDBHelper) GetUsers(ctx context.Context) ([]models.User, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	users := append([]models.User{}, mh.users...)
+	sort.SliceStable(users, func(i, j int) bool {
+		return users[i].CreatedAt < users[j].CreatedAt
+	})
+	return users, nil
+}
+
+func (mh *MemoryDBHelper) UpdateStorageData(ctx context.Context, userID string, storage int64) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	if user := mh.findUser(userID); user != nil {
+		user.UsedStorage = storage
+	}
+	return nil
+}
+
+// ReserveStorage adds size to the user's used storage and files to their file count, but
+// only while both stay within the user's limits. ErrInsufficientStorage or
+// ErrFileLimitReached is returned when they would not.
+func (mh *MemoryDBHelper) ReserveStorage(ctx context.Context, userID string, size, files int64) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	user := mh.findUser(userID)
+	if user == nil {
+		return models.ErrInsufficientStorage
+	}
+	if files > 0 && user.MaxFiles > 0 && user.FileCount+files > user.MaxFiles {
+		return models.ErrFileLimitReached
+	}
+	if user.UsedStorage+size > user.Quota {
+		return models.ErrInsufficientStorage
+	}
+
+	user.UsedStorage += size
+	user.FileCount += files
+	return nil
+}
+
+// ReleaseStorage gives back storage and files taken by ReserveStorage.
+func (mh *MemoryDBHelper) ReleaseStorage(ctx context.Context, userID string, size, files int64) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	if user := mh.findUser(userID); user != nil {
+		user.UsedStorage -= size
+		user.FileCount -= files
+	}
+	return nil
+}
+
+func (mh *MemoryDBHelper) UpdateUserQuota(ctx context.Context, userID string, quota int64) error {
+	return mh.updateUser(userID, func(user *models.User) {
+		user.Quota = quota
+	})
+}
+
+func (mh *MemoryDBHelper) SetUserDisabled(ctx context.Context, userID string, disabled bool) error {
+	return mh.updateUser(userID, func(user *models.User) {
+		user.Disabled = disabled
+	})
+}
+
+// SetUserPlan puts the user on the plan, replacing their limits with the plan's.
+func (mh *MemoryDBHelper) SetUserPlan(ctx context.Context, userID string, plan models.Plan) error {
+	return mh.updateUser(userID, func(user *models.User) {
+		user.Plan = plan.Name
+		user.Quota = plan.Quota
+		user.MaxFileSize = plan.MaxFileSize
+		user.MaxFiles = plan.MaxFiles
+	})
+}
+
+// SetUserUsage moves the user's used storage and file count from the recorded to the
+// actual values of the correction, by the difference, and only while they are still the
+// recorded ones. Otherwise ErrConcurrentUpdate is returned, so usage reserved or released
+// since it was read is never lost.
+func (mh *MemoryDBHelper) SetUserUsage(ctx context.Context, correction models.UsageCorrection) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	user := mh.findUser(correction.UserID)
+	if user == nil {
+		return models.ErrNotFound
+	}
+	if user.UsedStorage != correction.RecordedStorage || user.FileCount != correction.RecordedFiles {
+		return models.ErrConcurrentUpdate
+	}
+
+	user.UsedStorage += correction.ActualStorage - correction.RecordedStorage
+	user.FileCount += correction.ActualFiles - correction.RecordedFiles
+	return nil
+}
+
+// MigrateUserPlans puts users without a plan on the plan and counts their files.
+// Their quota is left as it was.
+func (mh *MemoryDBHelper) MigrateUserPlans(ctx context.Context, plan models.Plan) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	for i := range mh.users {
+		user := &mh.users[i]
+		if user.Plan != "" {
+			continue
+		}
+
+		var fileCount int64
+		for _, file := range mh.files {
+			if file.UserID == user.ID {
+				fileCount++
+			}
+		}
+
+		user.Plan = plan.Name
+		user.MaxFileSize = plan.MaxFileSize
+		user.MaxFiles = plan.MaxFiles
+		user.FileCount = fileCount
+	}
+	return nil
+}
+
+func (mh *MemoryDBHelper) SetUserRoleByUsername(ctx context.Context, username, role string) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	for i := range mh.users {
+		if mh.users[i].Username == username {
+			mh.users[i].Role = role
+			return nil
+		}
+	}
+	return models.ErrNotFound
+}
+
+// updateUser changes the user, returning models.ErrNotFound when there is no such user.
+func (mh *MemoryDBHelper) updateUser(userID string, update func(user *models.User)) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	user := mh.findUser(userID)
+	if user == nil {
+		return models.ErrNotFound
+	}
+	update(user)
+	return nil
+}
+
+// findUser returns the stored user to update in place; the caller holds the lock.
+func (mh *MemoryDBHelper) findUser(userID string) *models.User {
+	for i := range mh.users {
+		if mh.users[i].ID == userID {
+			return &mh.users[i]
+		}
+	}
+	return nil
+}
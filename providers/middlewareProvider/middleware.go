@@ -6,16 +6,16 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/file_upload/models"
-	"github.com/file_upload/providers"
 	"github.com/file_upload/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
-	"github.com/sirupsen/logrus"
 )
 
-// AuthenticationMiddleware checks each request token increase the expiration time
+// AuthenticationMiddleware checks the token of each request against its session and user,
+// and keeps the session alive while it is used.
 func (authMiddleware Middleware) AuthMiddleware() gin.HandlerFunc {
 
 	return func(c *gin.Context) {
@@ -38,7 +38,7 @@ func (authMiddleware Middleware) AuthMiddleware() gin.HandlerFunc {
 		}
 		token = tokenParts[1]
 
-		claims, err := GetClaimsFromToken(token)
+		claims, err := authMiddleware.AuthProvider.GetClaimsFromToken(token)
 		if err != nil {
 			utils.LogError("AuthenticationMiddleware", "fetch claims from auth token", "", err)
 			utils.RespondClientErr(c, err, http.StatusUnauthorized, "invalid token", "invalid token")
@@ -46,66 +46,33 @@ func (authMiddleware Middleware) AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		sessionID, isClaimsVerified, err := getUserDataFromClaims(authMiddleware.DBHelper, claims)
+		sessionWithUser, err := authMiddleware.sessionFromClaims(c.Request.Context(), claims)
 		if err != nil {
-			utils.LogError("AuthenticationMiddleware", "fetch user data from claim", "", err)
+			utils.LogError("AuthenticationMiddleware", "fetch user session from claims", "", err)
 			utils.RespondClientErr(c, err, http.StatusUnauthorized, "invalid token", "invalid token")
 			c.Abort()
 			return
 		}
+		userData := sessionWithUser.User
 
-		// rejecting request because token and session are expired.
-		if !isClaimsVerified {
-			utils.LogError("AuthenticationMiddleware", "verifying claim of the token", "Claim verification", err)
-			utils.RespondClientErr(c, errors.New("invalid token"), http.StatusUnauthorized, "invalid token", "invalid token")
-			c.Abort()
-			return
-		}
-
-		sessionActive, err := authMiddleware.DBHelper.IsUserSessionActive(sessionID)
-		if err != nil {
-			utils.LogError("AuthenticationMiddleware", "error verifying validity of the user session", "", err)
-			utils.RespondClientErr(c, errors.New("invalid token"), http.StatusUnauthorized, "invalid token", "invalid token")
-			c.Abort()
-			return
-		}
-
-		// reject req is session is terminated.
-		if !sessionActive {
-			err := errors.New("user session is not active")
-			utils.LogError("AuthenticationMiddleware", "user session is not active", "", err)
-			utils.RespondClientErr(c, errors.New("invalid token"), http.StatusUnauthorized, "invalid token", "invalid token")
-			c.Abort()
-			return
-		}
-
-		// Now increase the time.
-		err = authMiddleware.DBHelper.UpdateUserSession(sessionID)
-		if err != nil {
-			utils.LogError("AuthenticationMiddleware", "Updating session", "Update user session", err)
-			utils.RespondClientErr(c, err, http.StatusUnauthorized, "UpdateSession: error updating sessions ", "UpdateSession error updating sessions")
-			c.Abort()
-			return
-		}
-
-		// Extract data values from the claims.
-		// data := claims["data"].(map[string]interface{})
-		issuer := claims["iss"].(string)
-
-		userData, err := authMiddleware.DBHelper.GetUserByID(issuer)
-		if err != nil {
-			utils.LogError("AuthenticationMiddleware", "finding user by ID extracted from the claims", "", err)
-			utils.RespondClientErr(c, err, http.StatusUnauthorized, "UpdateSession: error getting user Details", "error getting user details")
+		if userData.Disabled {
+			utils.RespondClientErr(c, errors.New("account disabled"), http.StatusForbidden, "account disabled", "account disabled")
 			c.Abort()
 			return
 		}
 
 		// Construct the user context data.
-		userContextData.ID = issuer
+		userContextData.ID = userData.ID
+		userContextData.SessionID = sessionWithUser.Session.ID
 		userContextData.Name = userData.Name
 		userContextData.Username = userData.Username
 		userContextData.Quota = userData.Quota
 		userContextData.UsedStorage = userData.UsedStorage
+		userContextData.Role = userRole(userData)
+		userContextData.Plan = userData.Plan
+		userContextData.MaxFileSize = userData.MaxFileSize
+		userContextData.MaxFiles = userData.MaxFiles
+		userContextData.FileCount = userData.FileCount
 
 		// setting the value in the context.
 		ctxWithUser := context.WithValue(c.Request.Context(), models.UserContextKey, &userContextData)
@@ -114,48 +81,83 @@ func (authMiddleware Middleware) AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
-func GetClaimsFromToken(tokenString string) (jwt.MapClaims, error) {
+// sessionFromClaims returns the running session named by the token claims together with
+// its user, in one lookup that is skipped when the session was authenticated moments
+// ago. The session is extended once it gets close to expiring.
+func (authMiddleware Middleware) sessionFromClaims(ctx context.Context, claims jwt.MapClaims) (models.SessionWithUser, error) {
 
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	data, _ := claims["data"].(map[string]interface{})
+	token, _ := data["token"].(string)
+	issuer, _ := claims["iss"].(string)
+	if token == "" {
+		return models.SessionWithUser{}, errors.New("token does not name a session")
+	}
+
+	now := time.Now()
+	sessionWithUser, cached := authMiddleware.sessions.get(token, now)
+	if !cached {
+		var err error
+		sessionWithUser, err = authMiddleware.DBHelper.GetSessionWithUser(ctx, token)
+		if err != nil {
+			return models.SessionWithUser{}, fmt.Errorf("error fetching user session from database: %w", err)
 		}
-		return models.JwtSigningSecretKey, nil
-	})
-	if err != nil {
-		return jwt.MapClaims{}, err
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		return claims, nil
+	session := sessionWithUser.Session
+	if session.EndTime <= now.Unix() {
+		authMiddleware.sessions.forgetToken(token)
+		return models.SessionWithUser{}, errors.New("user session is not active")
+	}
+	if session.UserID != issuer {
+		return models.SessionWithUser{}, errors.New("token issuer does not own the session")
+	}
+
+	// Extending the session is a write, so it only happens once per SessionExtendThreshold.
+	if time.Unix(session.EndTime, 0).Sub(now) < models.SessionExtendThreshold {
+		if err := authMiddleware.DBHelper.UpdateUserSession(ctx, session.ID); err != nil {
+			// The session ended since it was read, by a logout or refresh on another instance.
+			if errors.Is(err, models.ErrNotFound) {
+				authMiddleware.sessions.forgetToken(token)
+				return models.SessionWithUser{}, errors.New("user session is not active")
+			}
+			return models.SessionWithUser{}, fmt.Errorf("error extending user session: %w", err)
+		}
+		sessionWithUser.Session.EndTime = now.Add(models.AccessTokenTTL).Unix()
+		sessionWithUser.Session.LastSeenAt = now.Unix()
+		cached = false
 	}
-	return jwt.MapClaims{}, err
+
+	if !cached {
+		authMiddleware.sessions.put(token, sessionWithUser, now)
+	}
+	return sessionWithUser, nil
 }
 
-func getUserDataFromClaims(dbHelper providers.DBHelperProvider, claims jwt.MapClaims) (string, bool, error) {
+// RequireRole only lets requests through from users with one of the roles. It must run
+// after AuthMiddleware.
+func (authMiddleware Middleware) RequireRole(roles ...string) gin.HandlerFunc {
 
-	fmt.Println("claims -  ", claims)
-	data := claims["data"].(map[string]interface{})
-	token := data["token"].(string)
+	return func(c *gin.Context) {
 
-	IsUserSessionTokenActive, err := dbHelper.IsUserSessionTokenActive(token)
-	if err != nil {
-		utils.LogError("getUserDataFromClaims", "error fetching user session data from database", "", err)
-		logrus.Error("GetUserDataFromClaims: error fetching user session data from database ", err)
-		return "", false, errors.New(fmt.Sprintln("GetUserDataFromClaims: error fetching user Data from database  & \n", err))
-	}
+		userContext := authMiddleware.UserFromContext(c.Request.Context())
 
-	if IsUserSessionTokenActive {
-		sessionData, err := dbHelper.ReadUserSessionBySessionToken(token)
-		if err != nil {
-			utils.LogError("getUserDataFromClaims", "error fetching user session data from database", "", err)
-			logrus.Error("GetUserDataFromClaims: error fetching user session data from database ", err)
-			return "", false, errors.New(fmt.Sprintln("GetUserDataFromClaims: error fetching user Data from database  & \n", err))
+		for _, role := range roles {
+			if userContext.Role == role {
+				return
+			}
 		}
-		return sessionData.ID, true, nil
+
+		utils.RespondClientErr(c, errors.New("insufficient role"), http.StatusForbidden, "forbidden", "forbidden")
+		c.Abort()
 	}
+}
 
-	return "", false, errors.New(fmt.Sprintln("invalid session id or session is expired", err))
+// userRole treats users registered before roles existed as ordinary users.
+func userRole(user models.User) string {
+	if user.Role == "" {
+		return models.RoleUser
+	}
+	return user.Role
 }
 
 // Extract the user context data from the user context attached to the request.
@@ -0,0 +1,298 @@
+package middlewareProvider
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/file_upload/config"
+	"github.com/file_upload/models"
+	"github.com/file_upload/providers"
+	"github.com/file_upload/providers/authProvider"
+	"github.com/file_upload/providers/memoryDBHelper"
+	"github.com/file_upload/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	utils.Logging = zap.NewNop()
+
+	dbHelper := memoryDBHelper.NewMemoryDBHelperProvider()
+	auth, err := authProvider.NewAuthProvider(config.JWTConfig{}, "test-secret")
+	if err != nil {
+		t.Fatalf("NewAuthProvider: %v", err)
+	}
+	otherAuth, err := authProvider.NewAuthProvider(config.JWTConfig{}, "other-secret")
+	if err != nil {
+		t.Fatalf("NewAuthProvider: %v", err)
+	}
+	middleware := NewMiddleware(dbHelper, auth, 0)
+
+	router := gin.New()
+	protected := router.Group("/", middleware.AuthMiddleware())
+	protected.GET("/me", func(c *gin.Context) {
+		c.JSON(http.StatusOK, middleware.UserFromContext(c.Request.Context()))
+	})
+	protected.GET("/admin", middleware.RequireRole(models.RoleAdmin), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	// login creates the user with a session and returns an access token for it.
+	login := func(user models.User) (string, models.UserSession) {
+		t.Helper()
+		if err := dbHelper.CreateUser(context.Background(), user); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		session, err := dbHelper.CreateUserSession(context.Background(), user.ID, models.SessionClient{})
+		if err != nil {
+			t.Fatalf("CreateUserSession: %v", err)
+		}
+		token, err := auth.GenerateJWT(user, session.Token)
+		if err != nil {
+			t.Fatalf("GenerateJWT: %v", err)
+		}
+		return token, session
+	}
+
+	ada := models.User{ID: "ada-id", Username: "ada", Plan: "free", Quota: 100, UsedStorage: 10}
+	adaToken, _ := login(ada)
+
+	loggedOutToken, loggedOutSession := login(models.User{ID: "logged-out-id", Username: "loggedout"})
+	if err := dbHelper.EndUserSession(context.Background(), loggedOutSession.ID); err != nil {
+		t.Fatalf("EndUserSession: %v", err)
+	}
+
+	disabledToken, _ := login(models.User{ID: "disabled-id", Username: "disabled"})
+	if err := dbHelper.SetUserDisabled(context.Background(), "disabled-id", true); err != nil {
+		t.Fatalf("SetUserDisabled: %v", err)
+	}
+
+	adminToken, _ := login(models.User{ID: "admin-id", Username: "admin", Role: models.RoleAdmin})
+
+	forgedToken, err := otherAuth.GenerateJWT(ada, "forged-session-token")
+	if err != nil {
+		t.Fatalf("GenerateJWT: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		wantStatus    int
+	}{
+		{name: "valid", path: "/me", authorization: "Bearer " + adaToken, wantStatus: http.StatusOK},
+		{name: "scheme is case insensitive", path: "/me", authorization: "bearer " + adaToken, wantStatus: http.StatusOK},
+		{name: "missing header", path: "/me", wantStatus: http.StatusUnauthorized},
+		{name: "not bearer", path: "/me", authorization: "Basic " + adaToken, wantStatus: http.StatusUnauthorized},
+		{name: "malformed token", path: "/me", authorization: "Bearer not-a-jwt", wantStatus: http.StatusUnauthorized},
+		{name: "signed with another key", path: "/me", authorization: "Bearer " + forgedToken, wantStatus: http.StatusUnauthorized},
+		{name: "session ended", path: "/me", authorization: "Bearer " + loggedOutToken, wantStatus: http.StatusUnauthorized},
+		{name: "account disabled", path: "/me", authorization: "Bearer " + disabledToken, wantStatus: http.StatusForbidden},
+		{name: "role missing", path: "/admin", authorization: "Bearer " + adaToken, wantStatus: http.StatusForbidden},
+		{name: "role present", path: "/admin", authorization: "Bearer " + adminToken, wantStatus: http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.authorization != "" {
+				request.Header.Set("Authorization", tt.authorization)
+			}
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d, body %s", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+		})
+	}
+
+	t.Run("user context", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/me", nil)
+		request.Header.Set("Authorization", "Bearer "+adaToken)
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		var userContext models.UserContext
+		if err := json.Unmarshal(recorder.Body.Bytes(), &userContext); err != nil {
+			t.Fatalf("decode user context: %v", err)
+		}
+
+		if userContext.ID != ada.ID || userContext.Username != ada.Username || userContext.Role != models.RoleUser ||
+			userContext.Plan != ada.Plan || userContext.Quota != ada.Quota || userContext.UsedStorage != ada.UsedStorage {
+			t.Fatalf("user context = %+v; want the details of %+v", userContext, ada)
+		}
+	})
+}
+
+// countingDBHelper counts session lookups and makes each of them take latency, like a
+// round trip to a database server would. afterLookup, when set, runs once a lookup has
+// read the session, to change things while the result is on its way back.
+type countingDBHelper struct {
+	providers.DBHelperProvider
+	latency     time.Duration
+	lookups     atomic.Int64
+	afterLookup func(sessionWithUser *models.SessionWithUser)
+}
+
+func (ch *countingDBHelper) GetSessionWithUser(ctx context.Context, tokenString string) (models.SessionWithUser, error) {
+	ch.lookups.Add(1)
+	time.Sleep(ch.latency)
+	sessionWithUser, err := ch.DBHelperProvider.GetSessionWithUser(ctx, tokenString)
+	if err == nil && ch.afterLookup != nil {
+		ch.afterLookup(&sessionWithUser)
+	}
+	return sessionWithUser, err
+}
+
+// newCountingRouter serves GET /me behind the auth middleware and returns an access
+// token of a logged in user.
+func newCountingRouter(tb testing.TB, cacheTTL, latency time.Duration) (*gin.Engine, providers.MiddlewareProvider, *countingDBHelper, models.UserSession, string) {
+	tb.Helper()
+	gin.SetMode(gin.TestMode)
+	utils.Logging = zap.NewNop()
+
+	dbHelper := &countingDBHelper{DBHelperProvider: memoryDBHelper.NewMemoryDBHelperProvider(), latency: latency}
+	auth, err := authProvider.NewAuthProvider(config.JWTConfig{}, "test-secret")
+	if err != nil {
+		tb.Fatalf("NewAuthProvider: %v", err)
+	}
+	middleware := NewMiddleware(dbHelper, auth, cacheTTL)
+
+	router := gin.New()
+	router.GET("/me", middleware.AuthMiddleware(), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	user := models.User{ID: "ada-id", Username: "ada"}
+	if err := dbHelper.CreateUser(context.Background(), user); err != nil {
+		tb.Fatalf("CreateUser: %v", err)
+	}
+	session, err := dbHelper.CreateUserSession(context.Background(), user.ID, models.SessionClient{})
+	if err != nil {
+		tb.Fatalf("CreateUserSession: %v", err)
+	}
+	token, err := auth.GenerateJWT(user, session.Token)
+	if err != nil {
+		tb.Fatalf("GenerateJWT: %v", err)
+	}
+
+	return router, middleware, dbHelper, session, token
+}
+
+func getMe(router *gin.Engine, token string) int {
+	request := httptest.NewRequest(http.MethodGet, "/me", nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder.Code
+}
+
+func TestAuthMiddlewareCache(t *testing.T) {
+	router, middleware, dbHelper, session, token := newCountingRouter(t, time.Minute, 0)
+
+	for i := 0; i < 3; i++ {
+		if code := getMe(router, token); code != http.StatusNoContent {
+			t.Fatalf("status = %d; want %d", code, http.StatusNoContent)
+		}
+	}
+	if lookups := dbHelper.lookups.Load(); lookups != 1 {
+		t.Fatalf("session lookups = %d; want 1 for repeated requests", lookups)
+	}
+
+	if err := dbHelper.SetUserDisabled(context.Background(), session.UserID, true); err != nil {
+		t.Fatalf("SetUserDisabled: %v", err)
+	}
+	middleware.InvalidateUser(session.UserID)
+	if code := getMe(router, token); code != http.StatusForbidden {
+		t.Fatalf("status after disabling = %d; want %d", code, http.StatusForbidden)
+	}
+
+	if err := dbHelper.SetUserDisabled(context.Background(), session.UserID, false); err != nil {
+		t.Fatalf("SetUserDisabled: %v", err)
+	}
+	middleware.InvalidateUser(session.UserID)
+	if code := getMe(router, token); code != http.StatusNoContent {
+		t.Fatalf("status after enabling = %d; want %d", code, http.StatusNoContent)
+	}
+
+	if err := dbHelper.EndUserSession(context.Background(), session.ID); err != nil {
+		t.Fatalf("EndUserSession: %v", err)
+	}
+	middleware.InvalidateSession(session.ID)
+	if code := getMe(router, token); code != http.StatusUnauthorized {
+		t.Fatalf("status after logout = %d; want %d", code, http.StatusUnauthorized)
+	}
+}
+
+// TestAuthMiddlewareCacheInvalidatedDuringLookup disables the user while their session
+// is being read, so the lookup returns the user as they were.
+func TestAuthMiddlewareCacheInvalidatedDuringLookup(t *testing.T) {
+	router, middleware, dbHelper, session, token := newCountingRouter(t, time.Minute, 0)
+
+	dbHelper.afterLookup = func(*models.SessionWithUser) {
+		dbHelper.afterLookup = nil
+		if err := dbHelper.SetUserDisabled(context.Background(), session.UserID, true); err != nil {
+			t.Fatalf("SetUserDisabled: %v", err)
+		}
+		middleware.InvalidateUser(session.UserID)
+	}
+	if code := getMe(router, token); code != http.StatusNoContent {
+		t.Fatalf("status = %d; want %d for the user read before disabling", code, http.StatusNoContent)
+	}
+
+	if code := getMe(router, token); code != http.StatusForbidden {
+		t.Fatalf("status after disabling = %d; want %d", code, http.StatusForbidden)
+	}
+	if lookups := dbHelper.lookups.Load(); lookups != 2 {
+		t.Fatalf("session lookups = %d; want 2, the stale session must not be cached", lookups)
+	}
+}
+
+// TestAuthMiddlewareExtendEndedSession ends a session while it is read close to expiring,
+// so extending it finds nothing to extend.
+func TestAuthMiddlewareExtendEndedSession(t *testing.T) {
+	router, _, dbHelper, session, token := newCountingRouter(t, time.Minute, 0)
+
+	dbHelper.afterLookup = func(sessionWithUser *models.SessionWithUser) {
+		if err := dbHelper.EndUserSession(context.Background(), session.ID); err != nil {
+			t.Fatalf("EndUserSession: %v", err)
+		}
+		sessionWithUser.Session.EndTime = time.Now().Add(time.Minute).Unix()
+	}
+	if code := getMe(router, token); code != http.StatusUnauthorized {
+		t.Fatalf("status = %d; want %d", code, http.StatusUnauthorized)
+	}
+}
+
+// BenchmarkAuthMiddleware authenticates requests against a database with a millisecond
+// of latency, with and without the session cache.
+func BenchmarkAuthMiddleware(b *testing.B) {
+	for _, bench := range []struct {
+		name     string
+		cacheTTL time.Duration
+	}{
+		{name: "uncached", cacheTTL: 0},
+		{name: "cached", cacheTTL: models.DefaultAuthCacheTTL},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			router, _, dbHelper, _, token := newCountingRouter(b, bench.cacheTTL, time.Millisecond)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if code := getMe(router, token); code != http.StatusNoContent {
+					b.Fatalf("status = %d; want %d", code, http.StatusNoContent)
+				}
+			}
+			b.ReportMetric(float64(dbHelper.lookups.Load())/float64(b.N), "queries/op")
+		})
+	}
+}
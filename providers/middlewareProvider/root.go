@@ -1,15 +1,34 @@
 package middlewareProvider
 
 import (
+	"time"
+
 	providers "github.com/file_upload/providers"
 )
 
 type Middleware struct {
-	DBHelper providers.DBHelperProvider
+	DBHelper     providers.DBHelperProvider
+	AuthProvider providers.AuthProvider
+
+	sessions *sessionCache
 }
 
-func NewMiddleware(dbHelper providers.DBHelperProvider) providers.MiddlewareProvider {
+// NewMiddleware returns the middleware. Authenticated sessions are cached for cacheTTL;
+// a cacheTTL of 0 reads every session from the database.
+func NewMiddleware(dbHelper providers.DBHelperProvider, authProvider providers.AuthProvider, cacheTTL time.Duration) providers.MiddlewareProvider {
 	return &Middleware{
-		DBHelper: dbHelper,
+		DBHelper:     dbHelper,
+		AuthProvider: authProvider,
+		sessions:     newSessionCache(cacheTTL),
 	}
 }
+
+// InvalidateSession drops the cached authentication of the session.
+func (authMiddleware Middleware) InvalidateSession(sessionID string) {
+	authMiddleware.sessions.forgetSession(sessionID)
+}
+
+// InvalidateUser drops the cached authentication of every session of the user.
+func (authMiddleware Middleware) InvalidateUser(userID string) {
+	authMiddleware.sessions.forgetUser(userID)
+}
@@ -0,0 +1,131 @@
+package middlewareProvider
+
+import (
+	"sync"
+	"time"
+
+	"github.com/file_upload/models"
+)
+
+// sessionCache remembers recently authenticated sessions with their user for a short
+// time, so most requests are authenticated without reaching the database. Entries are
+// keyed by session token. A cache with a TTL of 0 keeps nothing.
+//
+// Invalidating a session or user is remembered for a TTL, so a lookup that was already
+// running when the session or user changed doesn't put the stale result back.
+type sessionCache struct {
+	mu            sync.Mutex
+	ttl           time.Duration
+	entries       map[string]sessionCacheEntry
+	invalidations map[string]time.Time
+	nextSweep     time.Time
+}
+
+type sessionCacheEntry struct {
+	sessionWithUser models.SessionWithUser
+	expiresAt       time.Time
+}
+
+func newSessionCache(ttl time.Duration) *sessionCache {
+	return &sessionCache{
+		ttl:           ttl,
+		entries:       map[string]sessionCacheEntry{},
+		invalidations: map[string]time.Time{},
+	}
+}
+
+func (sc *sessionCache) get(token string, now time.Time) (models.SessionWithUser, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	entry, ok := sc.entries[token]
+	if !ok || !now.Before(entry.expiresAt) {
+		return models.SessionWithUser{}, false
+	}
+	return entry.sessionWithUser, true
+}
+
+// put caches the session read at readAt. It is not cached when its session or user was
+// invalidated since, or when the read took longer than invalidations are remembered.
+func (sc *sessionCache) put(token string, sessionWithUser models.SessionWithUser, readAt time.Time) {
+	if sc.ttl <= 0 {
+		return
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(readAt) >= sc.ttl {
+		return
+	}
+
+	// Drop expired entries and invalidations now and then, so tokens that are never seen
+	// again don't pile up.
+	if now.After(sc.nextSweep) {
+		for key, entry := range sc.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(sc.entries, key)
+			}
+		}
+		for key, at := range sc.invalidations {
+			if now.Sub(at) >= sc.ttl {
+				delete(sc.invalidations, key)
+			}
+		}
+		sc.nextSweep = now.Add(sc.ttl)
+	}
+
+	for _, key := range []string{sessionKey(sessionWithUser.Session.ID), userKey(sessionWithUser.Session.UserID)} {
+		if at, ok := sc.invalidations[key]; ok && !at.Before(readAt) {
+			return
+		}
+	}
+
+	sc.entries[token] = sessionCacheEntry{sessionWithUser: sessionWithUser, expiresAt: readAt.Add(sc.ttl)}
+}
+
+// forgetSession drops the entry of the session and keeps lookups already running from
+// caching it again.
+func (sc *sessionCache) forgetSession(sessionID string) {
+	sc.forget(sessionKey(sessionID), func(sessionWithUser models.SessionWithUser) bool {
+		return sessionWithUser.Session.ID == sessionID
+	})
+}
+
+// forgetUser drops the entries of every session of the user and keeps lookups already
+// running from caching them again.
+func (sc *sessionCache) forgetUser(userID string) {
+	sc.forget(userKey(userID), func(sessionWithUser models.SessionWithUser) bool {
+		return sessionWithUser.Session.UserID == userID
+	})
+}
+
+func (sc *sessionCache) forget(key string, match func(sessionWithUser models.SessionWithUser) bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.ttl > 0 {
+		sc.invalidations[key] = time.Now()
+	}
+	for token, entry := range sc.entries {
+		if match(entry.sessionWithUser) {
+			delete(sc.entries, token)
+		}
+	}
+}
+
+func (sc *sessionCache) forgetToken(token string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	delete(sc.entries, token)
+}
+
+func sessionKey(sessionID string) string {
+	return "session:" + sessionID
+}
+
+func userKey(userID string) string {
+	return "user:" + userID
+}
@@ -2,32 +2,143 @@ package providers
 
 import (
 	"context"
+	"io"
 
 	"github.com/file_upload/models"
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// DBHelperProvider stores everything but file content. Every method takes the caller's
+// context, so a request that goes away cancels its queries; implementations bound each
+// call with the timeout of its class in models.DBTimeouts.
 type DBHelperProvider interface {
-	GetUserByUsername(string) (models.User, error)
-	CreateUserSession(string) (models.UserSession, error)
-	CreateUser(models.User) error
-	GetUserByID(userID string) (models.User, error)
-	UpdateStorageData(string, int64) error
-
-	IsUserSessionActive(sessionID string) (bool, error)
-	UpdateUserSession(sessionID string) error
-	IsUserSessionTokenActive(tokenString string) (bool, error)
-	ReadUserSessionBySessionToken(tokenString string) (models.UserSession, error)
-
-	InsertFileMetadata(models.File) error
-	GetFileByHash(string, string) (*models.File, error)
-	GetFilesByUser(string) ([]models.File, error)
+	GetUserByUsername(ctx context.Context, username string) (models.User, error)
+	CreateUserSession(ctx context.Context, userID string, client models.SessionClient) (models.UserSession, error)
+	CreateUser(ctx context.Context, user models.User) error
+	GetUserByID(ctx context.Context, userID string) (models.User, error)
+	UpdateStorageData(ctx context.Context, userID string, storage int64) error
+	ReserveStorage(ctx context.Context, userID string, size, files int64) error
+	ReleaseStorage(ctx context.Context, userID string, size, files int64) error
+
+	GetSessionWithUser(ctx context.Context, tokenString string) (models.SessionWithUser, error)
+	UpdateUserSession(ctx context.Context, sessionID string) error
+	ReadUserSessionBySessionToken(ctx context.Context, tokenString string) (models.UserSession, error)
+	ReadUserSessionBySessionID(ctx context.Context, sessionID string) (models.UserSession, error)
+	ReadUserSessions(ctx context.Context, userID string, activeSessions bool) ([]models.UserSession, error)
+	EndUserSession(ctx context.Context, sessionID string) error
+	RotateRefreshToken(ctx context.Context, refreshToken string, client models.SessionClient) (models.UserSession, error)
+	RevokeSessionFamily(ctx context.Context, familyID string) error
+	EndAllUserSessions(ctx context.Context, userID string) error
+
+	// Login protection.
+	GetLoginAttempt(ctx context.Context, key string) (models.LoginAttempt, error)
+	RecordLoginFailure(ctx context.Context, key string, at, resetBefore int64) (models.LoginAttempt, error)
+	LockLogin(ctx context.Context, key string, lockedUntil, keepUntil int64) error
+	ClearLoginAttempts(ctx context.Context, key string) error
+
+	// Administration.
+	GetUsers(ctx context.Context) ([]models.User, error)
+	UpdateUserQuota(ctx context.Context, userID string, quota int64) error
+	SetUserDisabled(ctx context.Context, userID string, disabled bool) error
+	SetUserRoleByUsername(ctx context.Context, username, role string) error
+	SetUserPlan(ctx context.Context, userID string, plan models.Plan) error
+	MigrateUserPlans(ctx context.Context, plan models.Plan) error
+
+	InsertFileMetadata(ctx context.Context, file models.File, version models.FileVersion) error
+	GetFileByHash(ctx context.Context, userID, hash string) (*models.File, error)
+	ListFiles(ctx context.Context, query models.FileQuery) (models.FilePage, error)
+	GetFileByID(ctx context.Context, userID, fileID string) (*models.File, error)
+	GetFileByName(ctx context.Context, userID, folderID, filename string) (*models.File, error)
+	DeleteFile(ctx context.Context, userID, fileID string) (*models.File, []models.FileVersion, error)
+
+	// Folders. A folder or parent ID of "" is the top level.
+	CreateFolder(ctx context.Context, folder models.Folder) error
+	GetFolder(ctx context.Context, userID, folderID string) (*models.Folder, error)
+	GetFolderByName(ctx context.Context, userID, parentID, name string) (*models.Folder, error)
+	GetFolders(ctx context.Context, userID string) ([]models.Folder, error)
+	UpdateFolder(ctx context.Context, folder models.Folder) error
+	DeleteFolders(ctx context.Context, userID string, folderIDs []string) error
+	GetFilesInFolders(ctx context.Context, userID string, folderIDs []string) ([]models.File, error)
+	UpdateFileLocation(ctx context.Context, userID, fileID, folderID, filename string) error
+
+	// Share links.
+	CreateShare(ctx context.Context, share models.Share) error
+	GetShareByToken(ctx context.Context, tokenHash string) (*models.Share, error)
+	GetSharesByUser(ctx context.Context, userID string) ([]models.Share, error)
+	DeleteShare(ctx context.Context, userID, shareID string) error
+	ClaimShareDownload(ctx context.Context, shareID string, now int64) (*models.Share, error)
+
+	AddFileVersion(ctx context.Context, version models.FileVersion) (models.FileVersion, error)
+	GetFileVersions(ctx context.Context, userID, fileID string) ([]models.FileVersion, error)
+	GetFileVersion(ctx context.Context, userID, fileID string, version int64) (*models.FileVersion, error)
+	MigrateLegacyFiles(ctx context.Context) error
+	MigrateContentTypes(ctx context.Context) error
+	EnsureIndexes(ctx context.Context) error
+
+	CreateUpload(ctx context.Context, upload models.Upload) error
+	GetUploadByID(ctx context.Context, userID, uploadID string) (*models.Upload, error)
+	UpdateUploadOffset(ctx context.Context, uploadID string, fromOffset, toOffset int64, hashState []byte) error
+	DeleteUpload(ctx context.Context, uploadID string) error
+	CountOpenUploads(ctx context.Context, userID string, at int64) (int64, error)
+	GetUploads(ctx context.Context) ([]models.Upload, error)
+
+	AcquireBlob(ctx context.Context, blob models.Blob) (bool, error)
+	ReleaseBlob(ctx context.Context, hash string) (*models.Blob, error)
+	GetBlob(ctx context.Context, hash string) (*models.Blob, error)
+	CountBlobVersions(ctx context.Context, hash string) (int64, error)
+
+	// Reconciliation.
+	GetAllFiles(ctx context.Context) ([]models.File, error)
+	GetAllFileVersions(ctx context.Context) ([]models.FileVersion, error)
+	GetBlobs(ctx context.Context) ([]models.Blob, error)
+	DeleteFileVersion(ctx context.Context, versionID string) error
+	SetCurrentFileVersion(ctx context.Context, version models.FileVersion) error
+	SetUserUsage(ctx context.Context, correction models.UsageCorrection) error
+	SetBlobRefCount(ctx context.Context, blob models.Blob, recorded int64) error
+}
+
+type AuthProvider interface {
+
+	// sign an access token for the user's session with the active key.
+	GenerateJWT(user models.User, sessionToken string) (string, error)
+
+	// verify the token with the key named by its kid and return its claims.
+	GetClaimsFromToken(tokenString string) (jwt.MapClaims, error)
+
+	// public keys for other services to verify tokens with.
+	JWKS() models.JWKS
 }
 
 type MiddlewareProvider interface {
 	AuthMiddleware() gin.HandlerFunc
 	UserFromContext(ctx context.Context) *models.UserContext
+	RequireRole(roles ...string) gin.HandlerFunc
+
+	// InvalidateSession and InvalidateUser drop cached authentication, so that ending a
+	// session or changing a user takes effect on the next request.
+	InvalidateSession(sessionID string)
+	InvalidateUser(userID string)
+}
+
+// StorageProvider keeps file content. Keys are slash separated, e.g. "blobs/ab/cd/<hash>".
+type StorageProvider interface {
+
+	// store the content of the reader under the key, replacing anything already there.
+	Put(key string, r io.Reader, size int64) error
+
+	// open the object for reading; ErrObjectNotFound if there is none.
+	Get(key string) (io.ReadSeekCloser, error)
+
+	// remove the object. Removing a missing object is not an error.
+	Delete(key string) error
+
+	// size and modification time of the object; ErrObjectNotFound if there is none.
+	Stat(key string) (models.StorageObjectInfo, error)
+
+	// every object whose key starts with the prefix.
+	List(prefix string) ([]models.StorageObjectInfo, error)
 }
 
 type MongoClientProvider interface {
@@ -0,0 +1,165 @@
+package sqlDBHelper
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+)
+
+const blobColumns = `hash, size, path, ref_count, created_at`
+
+func scanBlob(row scanner) (models.Blob, error) {
+	var blob models.Blob
+	err := row.Scan(&blob.Hash, &blob.Size, &blob.Path, &blob.RefCount, &blob.CreatedAt)
+	return blob, err
+}
+
+// AcquireBlob adds a reference to the blob, creating its record on first use.
+// It reports whether the record was newly created.
+func (sh *SQLDBHelper) AcquireBlob(ctx context.Context, blob models.Blob) (bool, error) {
+	utils.LogInfo("AcquireBlob", "adding blob reference", fmt.Sprintf("Hash: %s", blob.Hash), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	result, err := sh.exec(ctx, sh.DB, `INSERT INTO blobs (`+blobColumns+`) VALUES (?, ?, ?, 1, ?) ON CONFLICT (hash) DO NOTHING`,
+		blob.Hash, blob.Size, blob.Path, blob.CreatedAt)
+	if err != nil {
+		utils.LogError("AcquireBlob", "error creating blob record", fmt.Sprintf("Hash: %s", blob.Hash), err)
+		return false, err
+	}
+
+	if created, err := result.RowsAffected(); err != nil || created == 1 {
+		return created == 1, err
+	}
+
+	_, err = sh.exec(ctx, sh.DB, `UPDATE blobs SET ref_count = ref_count + 1 WHERE hash = ?`, blob.Hash)
+	if err != nil {
+		utils.LogError("AcquireBlob", "error adding blob reference", fmt.Sprintf("Hash: %s", blob.Hash), err)
+		return false, err
+	}
+
+	return false, nil
+}
+
+// GetBlob returns the blob record for the hash.
+func (sh *SQLDBHelper) GetBlob(ctx context.Context, hash string) (*models.Blob, error) {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	blob, err := scanBlob(sh.queryRow(ctx, sh.DB, `SELECT `+blobColumns+` FROM blobs WHERE hash = ?`, hash))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &blob, nil
+}
+
+// GetBlobs returns every blob record.
+func (sh *SQLDBHelper) GetBlobs(ctx context.Context) ([]models.Blob, error) {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Scan)
+	defer cancel()
+
+	rows, err := sh.query(ctx, sh.DB, `SELECT `+blobColumns+` FROM blobs`)
+	if err != nil {
+		utils.LogError("GetBlobs", "error fetching blobs", "", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	blobs := []models.Blob{}
+	for rows.Next() {
+		blob, err := scanBlob(rows)
+		if err != nil {
+			utils.LogError("GetBlobs", "error reading blob", "", err)
+			return nil, err
+		}
+		blobs = append(blobs, blob)
+	}
+	return blobs, rows.Err()
+}
+
+// ReleaseBlob drops a reference to the blob and returns it with the remaining count.
+// The record is removed once the count reaches zero; deleting the content is up to the caller.
+func (sh *SQLDBHelper) ReleaseBlob(ctx context.Context, hash string) (*models.Blob, error) {
+	utils.LogInfo("ReleaseBlob", "dropping blob reference", fmt.Sprintf("Hash: %s", hash), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	var blob models.Blob
+	err := sh.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		blob, err = scanBlob(sh.queryRow(ctx, tx, `UPDATE blobs SET ref_count = ref_count - 1 WHERE hash = ? RETURNING `+blobColumns, hash))
+		if err != nil {
+			return notFound(err)
+		}
+
+		if blob.RefCount <= 0 {
+			_, err = sh.exec(ctx, tx, `DELETE FROM blobs WHERE hash = ?`, hash)
+		}
+		return err
+	})
+	if err != nil {
+		utils.LogError("ReleaseBlob", "blob not found or error dropping reference", fmt.Sprintf("Hash: %s", hash), err)
+		return nil, err
+	}
+
+	return &blob, nil
+}
+
+// CountBlobVersions returns how many file versions keep their content in the blob.
+func (sh *SQLDBHelper) CountBlobVersions(ctx context.Context, hash string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	blobKey := utils.BlobKey(hash)
+
+	var count int64
+	err := sh.queryRow(ctx, sh.DB, `SELECT COUNT(*) FROM file_versions WHERE hash = ? AND path IN (?, ?)`,
+		hash, blobKey, models.DefaultDirectory+"/"+blobKey).Scan(&count)
+	if err != nil {
+		utils.LogError("CountBlobVersions", "error counting versions using blob", fmt.Sprintf("Hash: %s", hash), err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// SetBlobRefCount overwrites the blob's reference count, creating its record if needed,
+// but only while the stored count is still recorded (0 for no record). Otherwise
+// ErrConcurrentUpdate is returned. A count of zero or less removes the record.
+func (sh *SQLDBHelper) SetBlobRefCount(ctx context.Context, blob models.Blob, recorded int64) error {
+	utils.LogInfo("SetBlobRefCount", "setting blob reference count", fmt.Sprintf("Hash: %s, RefCount: %d, Recorded: %d", blob.Hash, blob.RefCount, recorded), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	var result sql.Result
+	var err error
+	switch {
+	case recorded <= 0 && blob.RefCount <= 0:
+		return nil
+	case recorded <= 0:
+		result, err = sh.exec(ctx, sh.DB, `INSERT INTO blobs (`+blobColumns+`) VALUES (?, ?, ?, ?, ?) ON CONFLICT (hash) DO NOTHING`,
+			blob.Hash, blob.Size, blob.Path, blob.RefCount, blob.CreatedAt)
+	case blob.RefCount <= 0:
+		result, err = sh.exec(ctx, sh.DB, `DELETE FROM blobs WHERE hash = ? AND ref_count = ?`, blob.Hash, recorded)
+	default:
+		result, err = sh.exec(ctx, sh.DB, `UPDATE blobs SET ref_count = ? WHERE hash = ? AND ref_count = ?`, blob.RefCount, blob.Hash, recorded)
+	}
+	if err != nil {
+		utils.LogError("SetBlobRefCount", "error setting blob reference count", fmt.Sprintf("Hash: %s", blob.Hash), err)
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return models.ErrConcurrentUpdate
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package sqlDBHelper
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+)
+
+// File columns the listing can be sorted on.
+var fileSortColumns = map[string]string{
+	models.FileSortByName: "filename",
+	models.FileSortBySize: "size",
+	models.FileSortByDate: "uploaded_at",
+}
+
+// ListFiles returns one page of the user's files matching the query, ordered by the
+// sort column and then by ID.
+func (sh *SQLDBHelper) ListFiles(ctx context.Context, query models.FileQuery) (models.FilePage, error) {
+	utils.LogInfo("ListFiles", "listing files for user", fmt.Sprintf("UserID: %s, Query: %+v", query.UserID, query), nil)
+
+	page := models.FilePage{Files: []models.File{}}
+
+	sortColumn, ok := fileSortColumns[query.SortBy]
+	if !ok {
+		return page, fmt.Errorf("unknown sort column %q", query.SortBy)
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = models.DefaultFilePageSize
+	}
+
+	where, args := fileQueryConditions(query)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Batch)
+	defer cancel()
+
+	err := sh.queryRow(ctx, sh.DB, `SELECT COUNT(*) FROM files WHERE `+where, args...).Scan(&page.Total)
+	if err != nil {
+		utils.LogError("ListFiles", "error counting files", fmt.Sprintf("UserID: %s", query.UserID), err)
+		return page, err
+	}
+
+	comparison, direction := ">", "ASC"
+	if query.Descending {
+		comparison, direction = "<", "DESC"
+	}
+
+	if query.Cursor != "" {
+		after, err := utils.DecodeFileCursor(query)
+		if err != nil {
+			return page, err
+		}
+
+		var value interface{} = after.Number
+		if query.SortBy == models.FileSortByName {
+			value = after.Filename
+		}
+
+		where += fmt.Sprintf(` AND (%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))`, sortColumn, comparison)
+		args = append(args, value, value, after.ID)
+	}
+
+	// Fetch one extra file to find out whether there is another page.
+	page.Files, err = sh.queryFiles(ctx, sh.DB, fmt.Sprintf(`SELECT %s FROM files WHERE %s ORDER BY %s %s, id %s LIMIT ?`,
+		fileColumns, where, sortColumn, direction, direction), append(args, limit+1)...)
+	if err != nil {
+		return page, err
+	}
+
+	if int64(len(page.Files)) > limit {
+		page.Files = page.Files[:limit]
+		page.NextCursor = utils.EncodeFileCursor(query, page.Files[limit-1])
+	}
+
+	return page, nil
+}
+
+// fileQueryConditions returns the WHERE clause of the query's filters with its arguments.
+// Prefixes are compared with substr rather than LIKE, which ignores case on SQLite and
+// treats "%" and "_" in the prefix as wildcards.
+func fileQueryConditions(query models.FileQuery) (string, []interface{}) {
+	conditions := []string{`user_id = ?`}
+	args := []interface{}{query.UserID}
+
+	if query.FolderID != nil {
+		conditions = append(conditions, `folder_id = ?`)
+		args = append(args, *query.FolderID)
+	}
+
+	if query.NamePrefix != "" {
+		conditions = append(conditions, `substr(filename, 1, ?) = ?`)
+		args = append(args, utf8.RuneCountInString(query.NamePrefix), query.NamePrefix)
+	}
+
+	// "image/*" matches every image type.
+	if strings.HasSuffix(query.ContentType, "/*") {
+		prefix := strings.TrimSuffix(query.ContentType, "*")
+		conditions = append(conditions, `substr(content_type, 1, ?) = ?`)
+		args = append(args, utf8.RuneCountInString(prefix), prefix)
+	} else if query.ContentType != "" {
+		conditions = append(conditions, `content_type = ?`)
+		args = append(args, query.ContentType)
+	}
+
+	if query.MinSize != nil {
+		conditions = append(conditions, `size >= ?`)
+		args = append(args, *query.MinSize)
+	}
+	if query.MaxSize != nil {
+		conditions = append(conditions, `size <= ?`)
+		args = append(args, *query.MaxSize)
+	}
+	if query.UploadedAfter != nil {
+		conditions = append(conditions, `uploaded_at >= ?`)
+		args = append(args, *query.UploadedAfter)
+	}
+	if query.UploadedBefore != nil {
+		conditions = append(conditions, `uploaded_at < ?`)
+		args = append(args, *query.UploadedBefore)
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
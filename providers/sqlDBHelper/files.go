@@ -0,0 +1,370 @@
+package sqlDBHelper
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+	"github.com/google/uuid"
+)
+
+const fileColumns = `id, user_id, filename, folder_id, size, path, hash, content_type, uploaded_at, version, last_version`
+
+const fileVersionColumns = `id, file_id, user_id, version, size, path, hash, content_type, uploaded_at`
+
+func scanFile(row scanner) (models.File, error) {
+	var file models.File
+	err := row.Scan(&file.ID, &file.UserID, &file.Filename, &file.FolderID, &file.Size, &file.Path, &file.Hash,
+		&file.ContentType, &file.UploadedAt, &file.Version, &file.LastVersion)
+	return file, err
+}
+
+func scanFileVersion(row scanner) (models.FileVersion, error) {
+	var version models.FileVersion
+	err := row.Scan(&version.ID, &version.FileID, &version.UserID, &version.Version, &version.Size, &version.Path,
+		&version.Hash, &version.ContentType, &version.UploadedAt)
+	return version, err
+}
+
+// InsertFileMetadata records a new file together with its first version.
+// InsertFileMetadata records a new file together with its first version. ErrNameTaken is
+// returned when the folder already has a file with the name.
+func (sh *SQLDBHelper) InsertFileMetadata(ctx context.Context, file models.File, version models.FileVersion) error {
+	utils.LogInfo("InsertFileMetadata", "inserting file metadata", fmt.Sprintf("UserID: %s, FileName: %s", file.UserID, file.Filename), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Batch)
+	defer cancel()
+
+	err := sh.withTx(ctx, func(tx *sql.Tx) error {
+		if err := sh.insertFileVersion(ctx, tx, version); err != nil {
+			return err
+		}
+		_, err := sh.exec(ctx, tx, `INSERT INTO files (`+fileColumns+`) VALUES (`+placeholders(11)+`)`,
+			file.ID, file.UserID, file.Filename, file.FolderID, file.Size, file.Path, file.Hash, file.ContentType,
+			file.UploadedAt, file.Version, file.LastVersion)
+		return err
+	})
+	if err != nil {
+		if sh.fileNameTaken(ctx, file.UserID, file.ID, file.FolderID, file.Filename) {
+			return fmt.Errorf("%w: %s", models.ErrNameTaken, file.Filename)
+		}
+		utils.LogError("InsertFileMetadata", "error inserting file metadata", fmt.Sprintf("UserID: %s, FileName: %s", file.UserID, file.Filename), err)
+	}
+	return err
+}
+
+// fileNameTaken reports whether another file than fileID has the name in the folder,
+// which is why the unique index refused a write; the error itself differs per driver.
+func (sh *SQLDBHelper) fileNameTaken(ctx context.Context, userID, fileID, folderID, filename string) bool {
+	existing, err := sh.GetFileByName(ctx, userID, folderID, filename)
+	return err == nil && existing.ID != fileID
+}
+
+func (sh *SQLDBHelper) insertFileVersion(ctx context.Context, q querier, version models.FileVersion) error {
+	_, err := sh.exec(ctx, q, `INSERT INTO file_versions (`+fileVersionColumns+`) VALUES (`+placeholders(9)+`)`,
+		version.ID, version.FileID, version.UserID, version.Version, version.Size, version.Path, version.Hash,
+		version.ContentType, version.UploadedAt)
+	return err
+}
+
+func (sh *SQLDBHelper) GetFileByHash(ctx context.Context, userID, hash string) (*models.File, error) {
+	return sh.getFile(ctx, "GetFileByHash", `user_id = ? AND hash = ?`, userID, hash)
+}
+
+func (sh *SQLDBHelper) GetFileByID(ctx context.Context, userID, fileID string) (*models.File, error) {
+	return sh.getFile(ctx, "GetFileByID", `user_id = ? AND id = ?`, userID, fileID)
+}
+
+// GetFileByName returns the file with the given name directly inside the folder.
+func (sh *SQLDBHelper) GetFileByName(ctx context.Context, userID, folderID, filename string) (*models.File, error) {
+	return sh.getFile(ctx, "GetFileByName", `user_id = ? AND folder_id = ? AND filename = ?`, userID, folderID, filename)
+}
+
+// getFile returns the first file matching the condition, or models.ErrNotFound.
+func (sh *SQLDBHelper) getFile(ctx context.Context, source, where string, args ...interface{}) (*models.File, error) {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Batch)
+	defer cancel()
+
+	file, err := scanFile(sh.queryRow(ctx, sh.DB, `SELECT `+fileColumns+` FROM files WHERE `+where+` LIMIT 1`, args...))
+	if err != nil {
+		err = notFound(err)
+		utils.LogError(source, "file not found or error reading it", fmt.Sprintf("Match: %s %v", where, args), err)
+		return nil, err
+	}
+	return &file, nil
+}
+
+// GetAllFiles returns the files of every user.
+func (sh *SQLDBHelper) GetAllFiles(ctx context.Context) ([]models.File, error) {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Scan)
+	defer cancel()
+
+	return sh.queryFiles(ctx, sh.DB, `SELECT `+fileColumns+` FROM files`)
+}
+
+func (sh *SQLDBHelper) queryFiles(ctx context.Context, q querier, query string, args ...interface{}) ([]models.File, error) {
+	rows, err := sh.query(ctx, q, query, args...)
+	if err != nil {
+		utils.LogError("queryFiles", "error fetching files", query, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []models.File{}
+	for rows.Next() {
+		file, err := scanFile(rows)
+		if err != nil {
+			utils.LogError("queryFiles", "error reading file", query, err)
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// DeleteFile removes the file and all of its versions, and gives their combined size
+// and the file back to the user's limits, all in one transaction. The deleted versions
+// are returned, newest first, so their content can be released. The file's share links
+// are revoked with it.
+func (sh *SQLDBHelper) DeleteFile(ctx context.Context, userID, fileID string) (*models.File, []models.FileVersion, error) {
+	utils.LogInfo("DeleteFile", "deleting file metadata", fmt.Sprintf("UserID: %s, FileID: %s", userID, fileID), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Batch)
+	defer cancel()
+
+	var file models.File
+	var versions []models.FileVersion
+
+	err := sh.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		file, err = scanFile(sh.queryRow(ctx, tx, `DELETE FROM files WHERE id = ? AND user_id = ? RETURNING `+fileColumns, fileID, userID))
+		if err != nil {
+			return notFound(err)
+		}
+
+		versions, err = sh.queryFileVersions(ctx, tx, `SELECT `+fileVersionColumns+` FROM file_versions
+			WHERE file_id = ? AND user_id = ? ORDER BY version DESC`, fileID, userID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := sh.exec(ctx, tx, `DELETE FROM file_versions WHERE file_id = ? AND user_id = ?`, fileID, userID); err != nil {
+			return err
+		}
+
+		var freed int64
+		for _, version := range versions {
+			freed += version.Size
+		}
+
+		_, err = sh.exec(ctx, tx, `UPDATE users SET used_storage = used_storage - ?, file_count = file_count - 1 WHERE id = ?`, freed, userID)
+		if err != nil {
+			return err
+		}
+
+		_, err = sh.exec(ctx, tx, `DELETE FROM shares WHERE user_id = ? AND file_id = ?`, userID, fileID)
+		return err
+	})
+	if err != nil {
+		utils.LogError("DeleteFile", "file not found or error deleting it", fmt.Sprintf("UserID: %s, FileID: %s", userID, fileID), err)
+		return nil, nil, err
+	}
+
+	return &file, versions, nil
+}
+
+// AddFileVersion appends a version to an existing file and makes it the current one.
+// The version number is allocated here and returned on the version.
+func (sh *SQLDBHelper) AddFileVersion(ctx context.Context, version models.FileVersion) (models.FileVersion, error) {
+	utils.LogInfo("AddFileVersion", "adding file version", fmt.Sprintf("UserID: %s, FileID: %s", version.UserID, version.FileID), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Batch)
+	defer cancel()
+
+	err := sh.withTx(ctx, func(tx *sql.Tx) error {
+		err := sh.queryRow(ctx, tx, `UPDATE files SET last_version = last_version + 1 WHERE id = ? AND user_id = ? RETURNING last_version`,
+			version.FileID, version.UserID).Scan(&version.Version)
+		if err != nil {
+			return notFound(err)
+		}
+
+		if err := sh.insertFileVersion(ctx, tx, version); err != nil {
+			return err
+		}
+
+		// Only move forward, in case a newer version was added concurrently.
+		_, err = sh.exec(ctx, tx, `UPDATE files SET version = ?, size = ?, path = ?, hash = ?, content_type = ?, uploaded_at = ?
+			WHERE id = ? AND version < ?`,
+			version.Version, version.Size, version.Path, version.Hash, version.ContentType, version.UploadedAt,
+			version.FileID, version.Version)
+		return err
+	})
+	if err != nil {
+		utils.LogError("AddFileVersion", "file not found or error adding version", fmt.Sprintf("UserID: %s, FileID: %s", version.UserID, version.FileID), err)
+	}
+	return version, err
+}
+
+// GetFileVersions returns every version of the file, newest first.
+func (sh *SQLDBHelper) GetFileVersions(ctx context.Context, userID, fileID string) ([]models.FileVersion, error) {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Batch)
+	defer cancel()
+
+	return sh.queryFileVersions(ctx, sh.DB, `SELECT `+fileVersionColumns+` FROM file_versions
+		WHERE file_id = ? AND user_id = ? ORDER BY version DESC`, fileID, userID)
+}
+
+func (sh *SQLDBHelper) GetFileVersion(ctx context.Context, userID, fileID string, version int64) (*models.FileVersion, error) {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Batch)
+	defer cancel()
+
+	fileVersion, err := scanFileVersion(sh.queryRow(ctx, sh.DB, `SELECT `+fileVersionColumns+` FROM file_versions
+		WHERE file_id = ? AND user_id = ? AND version = ?`, fileID, userID, version))
+	if err != nil {
+		err = notFound(err)
+		utils.LogError("GetFileVersion", "file version not found or error reading it", fmt.Sprintf("UserID: %s, FileID: %s, Version: %d", userID, fileID, version), err)
+		return nil, err
+	}
+	return &fileVersion, nil
+}
+
+// GetAllFileVersions returns the file versions of every user.
+func (sh *SQLDBHelper) GetAllFileVersions(ctx context.Context) ([]models.FileVersion, error) {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Scan)
+	defer cancel()
+
+	return sh.queryFileVersions(ctx, sh.DB, `SELECT `+fileVersionColumns+` FROM file_versions`)
+}
+
+func (sh *SQLDBHelper) queryFileVersions(ctx context.Context, q querier, query string, args ...interface{}) ([]models.FileVersion, error) {
+	rows, err := sh.query(ctx, q, query, args...)
+	if err != nil {
+		utils.LogError("queryFileVersions", "error fetching file versions", query, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []models.FileVersion{}
+	for rows.Next() {
+		version, err := scanFileVersion(rows)
+		if err != nil {
+			utils.LogError("queryFileVersions", "error reading file version", query, err)
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+// DeleteFileVersion removes a single version record. The file itself is left as it is.
+func (sh *SQLDBHelper) DeleteFileVersion(ctx context.Context, versionID string) error {
+	utils.LogInfo("DeleteFileVersion", "deleting file version", fmt.Sprintf("VersionID: %s", versionID), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	_, err := sh.exec(ctx, sh.DB, `DELETE FROM file_versions WHERE id = ?`, versionID)
+	if err != nil {
+		utils.LogError("DeleteFileVersion", "error deleting file version", fmt.Sprintf("VersionID: %s", versionID), err)
+	}
+	return err
+}
+
+// SetCurrentFileVersion makes the version the file's current one, even if it is older.
+func (sh *SQLDBHelper) SetCurrentFileVersion(ctx context.Context, version models.FileVersion) error {
+	utils.LogInfo("SetCurrentFileVersion", "setting current file version", fmt.Sprintf("FileID: %s, Version: %d", version.FileID, version.Version), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	result, err := sh.exec(ctx, sh.DB, `UPDATE files SET version = ?, size = ?, path = ?, hash = ?, content_type = ?, uploaded_at = ?
+		WHERE id = ? AND user_id = ?`,
+		version.Version, version.Size, version.Path, version.Hash, version.ContentType, version.UploadedAt,
+		version.FileID, version.UserID)
+	if err != nil {
+		utils.LogError("SetCurrentFileVersion", "error setting current file version", version, err)
+		return err
+	}
+	return affectedOne(result)
+}
+
+// MigrateLegacyFiles gives every file without versions a version record for its
+// content, as version 1. It is safe to run on every start.
+func (sh *SQLDBHelper) MigrateLegacyFiles(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Scan)
+	defer cancel()
+
+	files, err := sh.queryFiles(ctx, sh.DB, `SELECT `+fileColumns+` FROM files WHERE last_version = 0`)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		version := models.FileVersion{
+			ID:          uuid.NewString(),
+			FileID:      file.ID,
+			UserID:      file.UserID,
+			Version:     1,
+			Size:        file.Size,
+			Path:        file.Path,
+			Hash:        file.Hash,
+			ContentType: file.ContentType,
+			UploadedAt:  file.UploadedAt,
+		}
+
+		err := sh.withTx(ctx, func(tx *sql.Tx) error {
+			if err := sh.insertFileVersion(ctx, tx, version); err != nil {
+				return err
+			}
+			_, err := sh.exec(ctx, tx, `UPDATE files SET version = 1, last_version = 1 WHERE id = ?`, file.ID)
+			return err
+		})
+		if err != nil {
+			utils.LogError("MigrateLegacyFiles", "error creating file version", version, err)
+			return err
+		}
+	}
+
+	if len(files) > 0 {
+		utils.LogInfo("MigrateLegacyFiles", fmt.Sprintf("migrated %d files", len(files)), "", nil)
+	}
+	return nil
+}
+
+// MigrateContentTypes gives files and versions stored without a content type one
+// guessed from their file name. It is safe to run on every start.
+func (sh *SQLDBHelper) MigrateContentTypes(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Scan)
+	defer cancel()
+
+	files, err := sh.queryFiles(ctx, sh.DB, `SELECT `+fileColumns+` FROM files WHERE content_type = ''`)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		contentType := utils.ContentTypeByName(file.Filename)
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		err := sh.withTx(ctx, func(tx *sql.Tx) error {
+			if _, err := sh.exec(ctx, tx, `UPDATE files SET content_type = ? WHERE id = ?`, contentType, file.ID); err != nil {
+				return err
+			}
+			_, err := sh.exec(ctx, tx, `UPDATE file_versions SET content_type = ? WHERE file_id = ? AND content_type = ''`, contentType, file.ID)
+			return err
+		})
+		if err != nil {
+			utils.LogError("MigrateContentTypes", "error updating file content type", file, err)
+			return err
+		}
+	}
+
+	if len(files) > 0 {
+		utils.LogInfo("MigrateContentTypes", fmt.Sprintf("migrated %d files", len(files)), "", nil)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package sqlDBHelper
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+)
+
+const folderColumns = `id, user_id, name, parent_id, created_at, updated_at`
+
+func scanFolder(row scanner) (models.Folder, error) {
+	var folder models.Folder
+	err := row.Scan(&folder.ID, &folder.UserID, &folder.Name, &folder.ParentID, &folder.CreatedAt, &folder.UpdatedAt)
+	return folder, err
+}
+
+func (sh *SQLDBHelper) CreateFolder(ctx context.Context, folder models.Folder) error {
+	utils.LogInfo("CreateFolder", "creating folder", fmt.Sprintf("UserID: %s, FolderID: %s, ParentID: %s", folder.UserID, folder.ID, folder.ParentID), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	_, err := sh.exec(ctx, sh.DB, `INSERT INTO folders (`+folderColumns+`) VALUES (`+placeholders(6)+`)`,
+		folder.ID, folder.UserID, folder.Name, folder.ParentID, folder.CreatedAt, folder.UpdatedAt)
+	if err != nil {
+		utils.LogError("CreateFolder", "error inserting folder", folder, err)
+	}
+	return err
+}
+
+func (sh *SQLDBHelper) GetFolder(ctx context.Context, userID, folderID string) (*models.Folder, error) {
+	return sh.getFolder(ctx, `user_id = ? AND id = ?`, userID, folderID)
+}
+
+// GetFolderByName returns the folder with the given name directly inside the parent.
+func (sh *SQLDBHelper) GetFolderByName(ctx context.Context, userID, parentID, name string) (*models.Folder, error) {
+	return sh.getFolder(ctx, `user_id = ? AND parent_id = ? AND name = ?`, userID, parentID, name)
+}
+
+func (sh *SQLDBHelper) getFolder(ctx context.Context, where string, args ...interface{}) (*models.Folder, error) {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	folder, err := scanFolder(sh.queryRow(ctx, sh.DB, `SELECT `+folderColumns+` FROM folders WHERE `+where+` LIMIT 1`, args...))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &folder, nil
+}
+
+// GetFolders returns every folder of the user, sorted by name.
+func (sh *SQLDBHelper) GetFolders(ctx context.Context, userID string) ([]models.Folder, error) {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Batch)
+	defer cancel()
+
+	rows, err := sh.query(ctx, sh.DB, `SELECT `+folderColumns+` FROM folders WHERE user_id = ? ORDER BY name, id`, userID)
+	if err != nil {
+		utils.LogError("GetFolders", "error fetching folders", fmt.Sprintf("UserID: %s", userID), err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	folders := []models.Folder{}
+	for rows.Next() {
+		folder, err := scanFolder(rows)
+		if err != nil {
+			utils.LogError("GetFolders", "error reading folder", fmt.Sprintf("UserID: %s", userID), err)
+			return nil, err
+		}
+		folders = append(folders, folder)
+	}
+	return folders, rows.Err()
+}
+
+// UpdateFolder saves the folder's name and parent.
+func (sh *SQLDBHelper) UpdateFolder(ctx context.Context, folder models.Folder) error {
+	utils.LogInfo("UpdateFolder", "updating folder", fmt.Sprintf("UserID: %s, FolderID: %s", folder.UserID, folder.ID), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	result, err := sh.exec(ctx, sh.DB, `UPDATE folders SET name = ?, parent_id = ?, updated_at = ? WHERE id = ? AND user_id = ?`,
+		folder.Name, folder.ParentID, folder.UpdatedAt, folder.ID, folder.UserID)
+	if err != nil {
+		utils.LogError("UpdateFolder", "error updating folder", folder, err)
+		return err
+	}
+	return affectedOne(result)
+}
+
+// DeleteFolders removes the folders. Their files must have been deleted already.
+func (sh *SQLDBHelper) DeleteFolders(ctx context.Context, userID string, folderIDs []string) error {
+	if len(folderIDs) == 0 {
+		return nil
+	}
+
+	utils.LogInfo("DeleteFolders", "deleting folders", fmt.Sprintf("UserID: %s, Folders: %d", userID, len(folderIDs)), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Batch)
+	defer cancel()
+
+	_, err := sh.exec(ctx, sh.DB, `DELETE FROM folders WHERE user_id = ? AND id IN (`+placeholders(len(folderIDs))+`)`,
+		append([]interface{}{userID}, stringArgs(folderIDs)...)...)
+	if err != nil {
+		utils.LogError("DeleteFolders", "error deleting folders", fmt.Sprintf("UserID: %s", userID), err)
+	}
+	return err
+}
+
+// GetFilesInFolders returns the user's files directly inside any of the folders,
+// sorted by name. "" stands for the top level.
+func (sh *SQLDBHelper) GetFilesInFolders(ctx context.Context, userID string, folderIDs []string) ([]models.File, error) {
+	if len(folderIDs) == 0 {
+		return []models.File{}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Batch)
+	defer cancel()
+
+	return sh.queryFiles(ctx, sh.DB, `SELECT `+fileColumns+` FROM files
+		WHERE user_id = ? AND folder_id IN (`+placeholders(len(folderIDs))+`) ORDER BY filename, id`,
+		append([]interface{}{userID}, stringArgs(folderIDs)...)...)
+}
+
+// UpdateFileLocation renames and/or moves a file.
+func (sh *SQLDBHelper) UpdateFileLocation(ctx context.Context, userID, fileID, folderID, filename string) error {
+	utils.LogInfo("UpdateFileLocation", "moving file", fmt.Sprintf("UserID: %s, FileID: %s, FolderID: %s, FileName: %s", userID, fileID, folderID, filename), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	result, err := sh.exec(ctx, sh.DB, `UPDATE files SET folder_id = ?, filename = ? WHERE id = ? AND user_id = ?`,
+		folderID, filename, fileID, userID)
+	if err != nil {
+		if sh.fileNameTaken(ctx, userID, fileID, folderID, filename) {
+			return fmt.Errorf("%w: %s", models.ErrNameTaken, filename)
+		}
+		utils.LogError("UpdateFileLocation", "error moving file", fmt.Sprintf("UserID: %s, FileID: %s", userID, fileID), err)
+		return err
+	}
+	return affectedOne(result)
+}
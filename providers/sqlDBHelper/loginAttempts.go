@@ -0,0 +1,94 @@
+package sqlDBHelper
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+)
+
+const loginAttemptColumns = `attempt_key, failures, last_failure_at, locked_until`
+
+func scanLoginAttempt(row scanner) (models.LoginAttempt, error) {
+	var attempt models.LoginAttempt
+	err := row.Scan(&attempt.Key, &attempt.Failures, &attempt.LastFailureAt, &attempt.LockedUntil)
+	return attempt, err
+}
+
+// GetLoginAttempt returns the failed login record for the key, or an empty one if there is none.
+func (sh *SQLDBHelper) GetLoginAttempt(ctx context.Context, key string) (models.LoginAttempt, error) {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	attempt, err := scanLoginAttempt(sh.queryRow(ctx, sh.DB, `SELECT `+loginAttemptColumns+` FROM login_attempts WHERE attempt_key = ?`, key))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.LoginAttempt{Key: key}, nil
+	}
+	if err != nil {
+		utils.LogError("GetLoginAttempt", "error reading failed logins", key, err)
+		return models.LoginAttempt{Key: key}, err
+	}
+	return attempt, nil
+}
+
+// RecordLoginFailure counts a failed login for the key and returns the updated record.
+// Failures are forgotten, and the count starts again at one, when neither the last
+// failure nor the end of the last lockout is after resetBefore. Records of every key
+// for which that holds are deleted, so they don't pile up.
+func (sh *SQLDBHelper) RecordLoginFailure(ctx context.Context, key string, at, resetBefore int64) (models.LoginAttempt, error) {
+	utils.LogInfo("RecordLoginFailure", "recording failed login", key, nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	if _, err := sh.exec(ctx, sh.DB, `DELETE FROM login_attempts WHERE last_failure_at < ? AND locked_until < ?`, resetBefore, resetBefore); err != nil {
+		utils.LogError("RecordLoginFailure", "error deleting stale failed logins", fmt.Sprintf("Key: %s", key), err)
+		return models.LoginAttempt{Key: key}, err
+	}
+
+	attempt, err := scanLoginAttempt(sh.queryRow(ctx, sh.DB, `INSERT INTO login_attempts (`+loginAttemptColumns+`) VALUES (?, 1, ?, 0)
+		ON CONFLICT (attempt_key) DO UPDATE SET
+			failures = CASE
+				WHEN login_attempts.last_failure_at < ? AND login_attempts.locked_until < ? THEN 1
+				ELSE login_attempts.failures + 1
+			END,
+			last_failure_at = excluded.last_failure_at
+		RETURNING `+loginAttemptColumns,
+		key, at, resetBefore, resetBefore))
+	if err != nil {
+		utils.LogError("RecordLoginFailure", "error recording failed login", fmt.Sprintf("Key: %s", key), err)
+		return models.LoginAttempt{Key: key}, err
+	}
+	return attempt, nil
+}
+
+// LockLogin refuses logins for the key until lockedUntil. The record is deleted by
+// RecordLoginFailure once it is stale, so keepUntil is not needed.
+func (sh *SQLDBHelper) LockLogin(ctx context.Context, key string, lockedUntil, keepUntil int64) error {
+	utils.LogInfo("LockLogin", "locking logins", fmt.Sprintf("Key: %s, LockedUntil: %d", key, lockedUntil), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	_, err := sh.exec(ctx, sh.DB, `UPDATE login_attempts SET locked_until = ? WHERE attempt_key = ? AND locked_until < ?`,
+		lockedUntil, key, lockedUntil)
+	if err != nil {
+		utils.LogError("LockLogin", "error locking logins", fmt.Sprintf("Key: %s", key), err)
+	}
+	return err
+}
+
+// ClearLoginAttempts forgets the failed logins for the key and lifts any lockout.
+func (sh *SQLDBHelper) ClearLoginAttempts(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	_, err := sh.exec(ctx, sh.DB, `DELETE FROM login_attempts WHERE attempt_key = ?`, key)
+	if err != nil {
+		utils.LogError("ClearLoginAttempts", "error clearing failed logins", fmt.Sprintf("Key: %s", key), err)
+	}
+	return err
+}
@@ -0,0 +1,240 @@
+package sqlDBHelper
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/file_upload/utils"
+)
+
+// migration is one step of the schema. Applied migrations are recorded in
+// schema_migrations and never run again, so a released migration must not be changed;
+// add a new one instead. {bytes} in a statement stands for the binary column type.
+type migration struct {
+	version    int64
+	name       string
+	statements []string
+}
+
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "users and sessions",
+		statements: []string{
+			`CREATE TABLE users (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL DEFAULT '',
+				password TEXT NOT NULL DEFAULT '',
+				username TEXT NOT NULL UNIQUE,
+				used_storage BIGINT NOT NULL DEFAULT 0,
+				quota BIGINT NOT NULL DEFAULT 0,
+				created_at BIGINT NOT NULL DEFAULT 0,
+				role TEXT NOT NULL DEFAULT '',
+				disabled BOOLEAN NOT NULL DEFAULT FALSE,
+				plan TEXT NOT NULL DEFAULT '',
+				max_file_size BIGINT NOT NULL DEFAULT 0,
+				max_files BIGINT NOT NULL DEFAULT 0,
+				file_count BIGINT NOT NULL DEFAULT 0
+			)`,
+			`CREATE TABLE user_sessions (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL,
+				family_id TEXT NOT NULL DEFAULT '',
+				start_time BIGINT NOT NULL DEFAULT 0,
+				end_time BIGINT NOT NULL DEFAULT 0,
+				token TEXT NOT NULL UNIQUE,
+				refresh_token_hash TEXT NOT NULL DEFAULT '',
+				refresh_expires_at BIGINT NOT NULL DEFAULT 0,
+				refresh_used BOOLEAN NOT NULL DEFAULT FALSE,
+				user_agent TEXT NOT NULL DEFAULT '',
+				ip_address TEXT NOT NULL DEFAULT '',
+				last_seen_at BIGINT NOT NULL DEFAULT 0
+			)`,
+			`CREATE INDEX user_sessions_user_id ON user_sessions (user_id)`,
+			`CREATE INDEX user_sessions_family_id ON user_sessions (family_id)`,
+			`CREATE INDEX user_sessions_refresh_token_hash ON user_sessions (refresh_token_hash)`,
+		},
+	},
+	{
+		version: 2,
+		name:    "files, versions, uploads and blobs",
+		statements: []string{
+			`CREATE TABLE files (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL,
+				filename TEXT NOT NULL,
+				folder_id TEXT NOT NULL DEFAULT '',
+				size BIGINT NOT NULL DEFAULT 0,
+				path TEXT NOT NULL DEFAULT '',
+				hash TEXT NOT NULL DEFAULT '',
+				content_type TEXT NOT NULL DEFAULT '',
+				uploaded_at BIGINT NOT NULL DEFAULT 0,
+				version BIGINT NOT NULL DEFAULT 0,
+				last_version BIGINT NOT NULL DEFAULT 0
+			)`,
+			`CREATE INDEX files_user_folder_filename ON files (user_id, folder_id, filename)`,
+			`CREATE INDEX files_user_hash ON files (user_id, hash)`,
+			`CREATE INDEX files_user_size ON files (user_id, size, id)`,
+			`CREATE INDEX files_user_uploaded_at ON files (user_id, uploaded_at, id)`,
+			`CREATE TABLE file_versions (
+				id TEXT PRIMARY KEY,
+				file_id TEXT NOT NULL,
+				user_id TEXT NOT NULL,
+				version BIGINT NOT NULL,
+				size BIGINT NOT NULL DEFAULT 0,
+				path TEXT NOT NULL DEFAULT '',
+				hash TEXT NOT NULL DEFAULT '',
+				content_type TEXT NOT NULL DEFAULT '',
+				uploaded_at BIGINT NOT NULL DEFAULT 0,
+				UNIQUE (file_id, version)
+			)`,
+			`CREATE TABLE uploads (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL,
+				filename TEXT NOT NULL,
+				folder_id TEXT NOT NULL DEFAULT '',
+				size BIGINT NOT NULL DEFAULT 0,
+				"offset" BIGINT NOT NULL DEFAULT 0,
+				temp_path TEXT NOT NULL DEFAULT '',
+				hash_state {bytes},
+				created_at BIGINT NOT NULL DEFAULT 0,
+				updated_at BIGINT NOT NULL DEFAULT 0
+			)`,
+			`CREATE TABLE blobs (
+				hash TEXT PRIMARY KEY,
+				size BIGINT NOT NULL DEFAULT 0,
+				path TEXT NOT NULL DEFAULT '',
+				ref_count BIGINT NOT NULL DEFAULT 0,
+				created_at BIGINT NOT NULL DEFAULT 0
+			)`,
+		},
+	},
+	{
+		version: 3,
+		name:    "login attempts, folders and share links",
+		statements: []string{
+			`CREATE TABLE login_attempts (
+				attempt_key TEXT PRIMARY KEY,
+				failures BIGINT NOT NULL DEFAULT 0,
+				last_failure_at BIGINT NOT NULL DEFAULT 0,
+				locked_until BIGINT NOT NULL DEFAULT 0
+			)`,
+			`CREATE TABLE folders (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL,
+				name TEXT NOT NULL,
+				parent_id TEXT NOT NULL DEFAULT '',
+				created_at BIGINT NOT NULL DEFAULT 0,
+				updated_at BIGINT NOT NULL DEFAULT 0
+			)`,
+			`CREATE INDEX folders_user_parent_name ON folders (user_id, parent_id, name)`,
+			`CREATE TABLE shares (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL,
+				file_id TEXT NOT NULL,
+				token_hash TEXT NOT NULL UNIQUE,
+				password_hash TEXT NOT NULL DEFAULT '',
+				expires_at BIGINT NOT NULL DEFAULT 0,
+				max_downloads BIGINT NOT NULL DEFAULT 0,
+				downloads BIGINT NOT NULL DEFAULT 0,
+				created_at BIGINT NOT NULL DEFAULT 0
+			)`,
+			`CREATE INDEX shares_user_created_at ON shares (user_id, created_at)`,
+			`CREATE INDEX shares_user_file ON shares (user_id, file_id)`,
+		},
+	},
+	{
+		version: 4,
+		name:    "file versions by blob",
+		statements: []string{
+			`CREATE INDEX file_versions_hash ON file_versions (hash)`,
+		},
+	},
+	{
+		version: 5,
+		name:    "login attempts by last failure",
+		statements: []string{
+			`CREATE INDEX login_attempts_last_failure_at ON login_attempts (last_failure_at)`,
+		},
+	},
+	{
+		version: 6,
+		name:    "upload expiry",
+		statements: []string{
+			`ALTER TABLE uploads ADD COLUMN expires_at BIGINT NOT NULL DEFAULT 0`,
+			// A day after the last chunk, the UploadTTL of the time.
+			`UPDATE uploads SET expires_at = updated_at + 86400`,
+			`CREATE INDEX uploads_user_expires_at ON uploads (user_id, expires_at)`,
+		},
+	},
+	{
+		version: 7,
+		name:    "unique file names",
+		statements: []string{
+			`DROP INDEX files_user_folder_filename`,
+			`CREATE UNIQUE INDEX files_user_folder_filename ON files (user_id, folder_id, filename)`,
+		},
+	},
+}
+
+// Migrate applies the migrations the database is missing, each in its own transaction.
+func (sh *SQLDBHelper) Migrate(ctx context.Context) error {
+	utils.LogInfo("Migrate", "bringing the database schema up to date", fmt.Sprintf("Driver: %s", sh.Driver), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Scan)
+	defer cancel()
+
+	_, err := sh.DB.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at BIGINT NOT NULL
+	)`)
+	if err != nil {
+		utils.LogError("Migrate", "error creating the migrations table", "", err)
+		return err
+	}
+
+	var current int64
+	err = sh.DB.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		utils.LogError("Migrate", "error reading the schema version", "", err)
+		return err
+	}
+
+	for _, step := range migrations {
+		if step.version <= current {
+			continue
+		}
+
+		err := sh.withTx(ctx, func(tx *sql.Tx) error {
+			for _, statement := range step.statements {
+				if _, err := tx.ExecContext(ctx, sh.schemaStatement(statement)); err != nil {
+					return err
+				}
+			}
+			_, err := sh.exec(ctx, tx, `INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+				step.version, step.name, time.Now().Unix())
+			return err
+		})
+		if err != nil {
+			utils.LogError("Migrate", "error applying migration", fmt.Sprintf("Version: %d, Name: %s", step.version, step.name), err)
+			return fmt.Errorf("migration %d (%s): %w", step.version, step.name, err)
+		}
+
+		utils.LogInfo("Migrate", "migration applied", fmt.Sprintf("Version: %d, Name: %s", step.version, step.name), nil)
+	}
+
+	return nil
+}
+
+// schemaStatement fills in the column types that differ between the databases.
+func (sh *SQLDBHelper) schemaStatement(statement string) string {
+	bytesType := "BLOB"
+	if sh.Driver == "postgres" {
+		bytesType = "BYTEA"
+	}
+	return strings.ReplaceAll(statement, "{bytes}", bytesType)
+}
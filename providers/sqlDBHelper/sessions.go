@@ -0,0 +1,282 @@
+package sqlDBHelper
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+	"github.com/google/uuid"
+)
+
+const sessionColumns = `id, user_id, family_id, start_time, end_time, token, refresh_token_hash, refresh_expires_at,
+	refresh_used, user_agent, ip_address, last_seen_at`
+
+func scanSession(row scanner) (models.UserSession, error) {
+	var session models.UserSession
+	err := row.Scan(sessionFields(&session)...)
+	return session, err
+}
+
+// sessionFields lists the fields of the session in the order of sessionColumns.
+func sessionFields(session *models.UserSession) []interface{} {
+	return []interface{}{&session.ID, &session.UserID, &session.FamilyID, &session.StartTime, &session.EndTime, &session.Token,
+		&session.RefreshTokenHash, &session.RefreshExpiresAt, &session.RefreshUsed, &session.UserAgent, &session.IPAddress,
+		&session.LastSeenAt}
+}
+
+// CreateUserSession starts a new session family for the user. Other sessions of the
+// user are left running, so every device keeps its own session.
+func (sh *SQLDBHelper) CreateUserSession(ctx context.Context, userID string, client models.SessionClient) (models.UserSession, error) {
+	utils.LogInfo("CreateUserSession", "creating user session", fmt.Sprintf("UserID: %s", userID), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	newSession, err := sh.insertUserSession(ctx, sh.DB, userID, uuid.New().String(), time.Now().Unix(), client)
+	if err != nil {
+		utils.LogError("CreateUserSession", "error inserting user session", fmt.Sprintf("UserID: %s", userID), err)
+	}
+	return newSession, err
+}
+
+// insertUserSession stores a new session in the given family, with fresh access and refresh tokens.
+func (sh *SQLDBHelper) insertUserSession(ctx context.Context, q querier, userID, familyID string, startTime int64, client models.SessionClient) (models.UserSession, error) {
+
+	refreshToken, err := utils.GenerateSecureToken()
+	if err != nil {
+		return models.UserSession{}, err
+	}
+
+	newSession := models.UserSession{
+		ID:               uuid.New().String(),
+		UserID:           userID,
+		FamilyID:         familyID,
+		StartTime:        startTime,
+		EndTime:          time.Now().Add(models.AccessTokenTTL).Unix(),
+		Token:            uuid.New().String(),
+		RefreshTokenHash: utils.HashToken(refreshToken),
+		RefreshExpiresAt: time.Now().Add(models.RefreshTokenTTL).Unix(),
+		UserAgent:        client.UserAgent,
+		IPAddress:        client.IPAddress,
+		LastSeenAt:       time.Now().Unix(),
+	}
+
+	_, err = sh.exec(ctx, q, `INSERT INTO user_sessions (`+sessionColumns+`) VALUES (`+placeholders(12)+`)`,
+		newSession.ID, newSession.UserID, newSession.FamilyID, newSession.StartTime, newSession.EndTime, newSession.Token,
+		newSession.RefreshTokenHash, newSession.RefreshExpiresAt, newSession.RefreshUsed, newSession.UserAgent,
+		newSession.IPAddress, newSession.LastSeenAt)
+	if err != nil {
+		return models.UserSession{}, err
+	}
+
+	newSession.RefreshToken = refreshToken
+	return newSession, nil
+}
+
+// GetSessionWithUser reads the session with the token together with its user in one query.
+func (sh *SQLDBHelper) GetSessionWithUser(ctx context.Context, tokenString string) (models.SessionWithUser, error) {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	var sessionWithUser models.SessionWithUser
+	row := sh.queryRow(ctx, sh.DB, `SELECT `+qualify("s", sessionColumns)+`, `+qualify("u", userColumns)+`
+		FROM user_sessions s JOIN users u ON u.id = s.user_id WHERE s.token = ?`, tokenString)
+	err := row.Scan(append(sessionFields(&sessionWithUser.Session), userFields(&sessionWithUser.User)...)...)
+	if err != nil {
+		return models.SessionWithUser{}, notFound(err)
+	}
+	return sessionWithUser, nil
+}
+
+// UpdateUserSession extends a running session by another access token lifetime. Ended
+// sessions are left alone and reported as not found.
+func (sh *SQLDBHelper) UpdateUserSession(ctx context.Context, sessionID string) error {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	now := time.Now().Unix()
+	result, err := sh.exec(ctx, sh.DB, `UPDATE user_sessions SET end_time = ?, last_seen_at = ? WHERE id = ? AND end_time > ?`,
+		time.Now().Add(models.AccessTokenTTL).Unix(), now, sessionID, now)
+	if err != nil {
+		utils.LogError("UpdateUserSession", "error extending user session", fmt.Sprintf("SessionID: %s", sessionID), err)
+		return err
+	}
+	return affectedOne(result)
+}
+
+func (sh *SQLDBHelper) ReadUserSessionBySessionToken(ctx context.Context, tokenString string) (models.UserSession, error) {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	session, err := scanSession(sh.queryRow(ctx, sh.DB, `SELECT `+sessionColumns+` FROM user_sessions WHERE token = ?`, tokenString))
+	if err != nil {
+		return session, notFound(err)
+	}
+	return session, nil
+}
+
+// ReadUserSessionBySessionID returns the session, or an empty one without an error
+// when there is none.
+func (sh *SQLDBHelper) ReadUserSessionBySessionID(ctx context.Context, sessionID string) (models.UserSession, error) {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	session, err := scanSession(sh.queryRow(ctx, sh.DB, `SELECT `+sessionColumns+` FROM user_sessions WHERE id = ?`, sessionID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.UserSession{}, nil
+	}
+	if err != nil {
+		utils.LogError("ReadUserSessionBySessionID", "error reading user session", fmt.Sprintf("SessionID: %s", sessionID), err)
+		return session, err
+	}
+	return session, nil
+}
+
+// ReadUserSessions returns the user's sessions. With activeSessions set only the
+// sessions still alive are returned: those whose access token is valid, or whose
+// refresh token can still be used.
+func (sh *SQLDBHelper) ReadUserSessions(ctx context.Context, userID string, activeSessions bool) ([]models.UserSession, error) {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	query := `SELECT ` + sessionColumns + ` FROM user_sessions WHERE user_id = ?`
+	args := []interface{}{userID}
+
+	if activeSessions {
+		now := time.Now().Unix()
+		query += ` AND (end_time > ? OR (refresh_used = ? AND refresh_expires_at > ?))`
+		args = append(args, now, false, now)
+	}
+
+	rows, err := sh.query(ctx, sh.DB, query+` ORDER BY start_time, id`, args...)
+	if err != nil {
+		utils.LogError("ReadUserSessions", "error reading user sessions", fmt.Sprintf("UserID: %s, Active Sessions Switch: %v", userID, activeSessions), err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.UserSession
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			utils.LogError("ReadUserSessions", "error reading user session", fmt.Sprintf("UserID: %s", userID), err)
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// EndUserSession ends the session and retires its refresh token.
+func (sh *SQLDBHelper) EndUserSession(ctx context.Context, sessionID string) error {
+	utils.LogInfo("EndUserSession", "ending user session", fmt.Sprintf("SessionID: %s", sessionID), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	now := time.Now().Unix()
+	_, err := sh.exec(ctx, sh.DB, `UPDATE user_sessions SET end_time = ?, refresh_expires_at = ? WHERE id = ?`, now, now, sessionID)
+	if err != nil {
+		utils.LogError("EndUserSession", "error ending user session", fmt.Sprintf("SessionID: %s", sessionID), err)
+	}
+	return err
+}
+
+// RotateRefreshToken exchanges a refresh token for a new session in the same family.
+// The old session ends and its refresh token can't be used again. Presenting a token
+// that was already exchanged revokes the whole family and returns ErrRefreshTokenReused,
+// together with the session of the reused token so the caller knows whose family it was.
+func (sh *SQLDBHelper) RotateRefreshToken(ctx context.Context, refreshToken string, client models.SessionClient) (models.UserSession, error) {
+	utils.LogInfo("RotateRefreshToken", "exchanging refresh token for a new session", "", nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	now := time.Now().Unix()
+	tokenHash := utils.HashToken(refreshToken)
+
+	var newSession models.UserSession
+	var reused models.UserSession
+
+	err := sh.withTx(ctx, func(tx *sql.Tx) error {
+		oldSession, err := scanSession(sh.queryRow(ctx, tx, `UPDATE user_sessions SET refresh_used = ?, end_time = ?
+			WHERE refresh_token_hash = ? AND refresh_used = ? AND refresh_expires_at > ?
+			RETURNING `+sessionColumns,
+			true, now, tokenHash, false, now))
+		if errors.Is(err, sql.ErrNoRows) {
+			// The token is unknown, expired, revoked or was already used. Only the last one is an attack.
+			reused, err = scanSession(sh.queryRow(ctx, tx, `SELECT `+sessionColumns+` FROM user_sessions WHERE refresh_token_hash = ?`, tokenHash))
+			if err == nil && reused.RefreshUsed {
+				return models.ErrRefreshTokenReused
+			}
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+			return models.ErrInvalidRefreshToken
+		}
+		if err != nil {
+			return err
+		}
+
+		// Sessions created before refresh tokens existed start their own family.
+		familyID := oldSession.FamilyID
+		if familyID == "" {
+			familyID = oldSession.ID
+		}
+
+		newSession, err = sh.insertUserSession(ctx, tx, oldSession.UserID, familyID, oldSession.StartTime, client)
+		return err
+	})
+
+	switch {
+	case errors.Is(err, models.ErrRefreshTokenReused):
+		utils.LogWarning("RotateRefreshToken", "refresh token reused, revoking session family", fmt.Sprintf("UserID: %s, FamilyID: %s", reused.UserID, reused.FamilyID))
+		if err := sh.RevokeSessionFamily(ctx, reused.FamilyID); err != nil {
+			return models.UserSession{}, err
+		}
+		return reused, models.ErrRefreshTokenReused
+	case errors.Is(err, models.ErrInvalidRefreshToken):
+		return models.UserSession{}, err
+	case err != nil:
+		utils.LogError("RotateRefreshToken", "error rotating refresh token", "", err)
+		return models.UserSession{}, err
+	}
+
+	return newSession, nil
+}
+
+// RevokeSessionFamily ends every session of the family and invalidates their refresh tokens.
+func (sh *SQLDBHelper) RevokeSessionFamily(ctx context.Context, familyID string) error {
+	if familyID == "" {
+		return errors.New("RevokeSessionFamily: empty family ID")
+	}
+	return sh.revokeSessions(ctx, "RevokeSessionFamily", `family_id = ?`, familyID)
+}
+
+// EndAllUserSessions ends every session of the user and invalidates their refresh tokens.
+func (sh *SQLDBHelper) EndAllUserSessions(ctx context.Context, userID string) error {
+	return sh.revokeSessions(ctx, "EndAllUserSessions", `user_id = ?`, userID)
+}
+
+// revokeSessions ends the matching sessions now, unless they already ended earlier.
+func (sh *SQLDBHelper) revokeSessions(ctx context.Context, source, where string, arg interface{}) error {
+	utils.LogInfo(source, "revoking sessions", fmt.Sprintf("Match: %s %v", where, arg), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	now := time.Now().Unix()
+	_, err := sh.exec(ctx, sh.DB, `UPDATE user_sessions SET
+		end_time = CASE WHEN end_time > ? THEN ? ELSE end_time END,
+		refresh_expires_at = CASE WHEN refresh_expires_at > ? THEN ? ELSE refresh_expires_at END
+		WHERE `+where,
+		now, now, now, now, arg)
+	if err != nil {
+		utils.LogError(source, "error revoking sessions", fmt.Sprintf("Match: %s %v", where, arg), err)
+	}
+	return err
+}
@@ -0,0 +1,116 @@
+package sqlDBHelper
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+)
+
+const shareColumns = `id, user_id, file_id, token_hash, password_hash, expires_at, max_downloads, downloads, created_at`
+
+func scanShare(row scanner) (models.Share, error) {
+	var share models.Share
+	err := row.Scan(&share.ID, &share.UserID, &share.FileID, &share.TokenHash, &share.PasswordHash, &share.ExpiresAt,
+		&share.MaxDownloads, &share.Downloads, &share.CreatedAt)
+	return share, err
+}
+
+func (sh *SQLDBHelper) CreateShare(ctx context.Context, share models.Share) error {
+	utils.LogInfo("CreateShare", "creating share link", fmt.Sprintf("UserID: %s, FileID: %s, ShareID: %s", share.UserID, share.FileID, share.ID), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	_, err := sh.exec(ctx, sh.DB, `INSERT INTO shares (`+shareColumns+`) VALUES (`+placeholders(9)+`)`,
+		share.ID, share.UserID, share.FileID, share.TokenHash, share.PasswordHash, share.ExpiresAt,
+		share.MaxDownloads, share.Downloads, share.CreatedAt)
+	if err != nil {
+		utils.LogError("CreateShare", "error inserting share link", fmt.Sprintf("UserID: %s, ShareID: %s", share.UserID, share.ID), err)
+	}
+	return err
+}
+
+// GetShareByToken looks a share link up by the hash of its token.
+func (sh *SQLDBHelper) GetShareByToken(ctx context.Context, tokenHash string) (*models.Share, error) {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	share, err := scanShare(sh.queryRow(ctx, sh.DB, `SELECT `+shareColumns+` FROM shares WHERE token_hash = ?`, tokenHash))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &share, nil
+}
+
+// GetSharesByUser returns the user's share links, newest first.
+func (sh *SQLDBHelper) GetSharesByUser(ctx context.Context, userID string) ([]models.Share, error) {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Batch)
+	defer cancel()
+
+	rows, err := sh.query(ctx, sh.DB, `SELECT `+shareColumns+` FROM shares WHERE user_id = ? ORDER BY created_at DESC, id`, userID)
+	if err != nil {
+		utils.LogError("GetSharesByUser", "error fetching share links", fmt.Sprintf("UserID: %s", userID), err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []models.Share{}
+	for rows.Next() {
+		share, err := scanShare(rows)
+		if err != nil {
+			utils.LogError("GetSharesByUser", "error reading share link", fmt.Sprintf("UserID: %s", userID), err)
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+// DeleteShare revokes one of the user's share links.
+func (sh *SQLDBHelper) DeleteShare(ctx context.Context, userID, shareID string) error {
+	utils.LogInfo("DeleteShare", "revoking share link", fmt.Sprintf("UserID: %s, ShareID: %s", userID, shareID), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	result, err := sh.exec(ctx, sh.DB, `DELETE FROM shares WHERE id = ? AND user_id = ?`, shareID, userID)
+	if err != nil {
+		utils.LogError("DeleteShare", "error revoking share link", fmt.Sprintf("UserID: %s, ShareID: %s", userID, shareID), err)
+		return err
+	}
+	return affectedOne(result)
+}
+
+// ClaimShareDownload atomically counts a download against the share link, but only
+// while it has not expired and is within its download limit. ErrShareExpired or
+// ErrShareExhausted is returned when it is not.
+func (sh *SQLDBHelper) ClaimShareDownload(ctx context.Context, shareID string, now int64) (*models.Share, error) {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	share, err := scanShare(sh.queryRow(ctx, sh.DB, `UPDATE shares SET downloads = downloads + 1
+		WHERE id = ? AND (expires_at = 0 OR expires_at > ?) AND (max_downloads = 0 OR downloads < max_downloads)
+		RETURNING `+shareColumns,
+		shareID, now))
+	if err == nil {
+		return &share, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		utils.LogError("ClaimShareDownload", "error counting share link download", fmt.Sprintf("ShareID: %s", shareID), err)
+		return nil, err
+	}
+
+	// Find out why the link can't be used.
+	share, err = scanShare(sh.queryRow(ctx, sh.DB, `SELECT `+shareColumns+` FROM shares WHERE id = ?`, shareID))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if share.ExpiresAt > 0 && share.ExpiresAt <= now {
+		return nil, models.ErrShareExpired
+	}
+	return nil, models.ErrShareExhausted
+}
@@ -0,0 +1,149 @@
+package sqlDBHelper
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/providers"
+	"github.com/file_upload/utils"
+)
+
+// SQLDBHelper stores everything in a SQL database through database/sql, for deployments
+// without MongoDB. The same queries run on SQLite and Postgres: they are written with
+// "?" placeholders, rewritten for Postgres, and avoid functions only one of them has.
+// Like the in-memory helper it reports missing records as models.ErrNotFound, so
+// handlers behave the same on every database.
+type SQLDBHelper struct {
+	DB       *sql.DB
+	Driver   string
+	Timeouts models.DBTimeouts
+}
+
+// NewSQLDBHelperProvider brings the schema of the database up to date and returns the
+// helper for it. driver is "sqlite" or "postgres".
+func NewSQLDBHelperProvider(db *sql.DB, driver string, timeouts models.DBTimeouts) (providers.DBHelperProvider, error) {
+	if driver != "sqlite" && driver != "postgres" {
+		return nil, fmt.Errorf("unsupported SQL driver %q", driver)
+	}
+
+	sh := &SQLDBHelper{DB: db, Driver: driver, Timeouts: timeouts}
+	if err := sh.Migrate(context.Background()); err != nil {
+		return nil, err
+	}
+	return sh, nil
+}
+
+// EnsureIndexes has nothing to do; the indexes are created by the migrations.
+func (sh *SQLDBHelper) EnsureIndexes(ctx context.Context) error {
+	return nil
+}
+
+// querier runs statements either directly on the database or inside a transaction.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// scanner is a *sql.Row or *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// rebind rewrites the "?" placeholders of a query into the "$1" style Postgres expects.
+// SQLite understands "?" as it is.
+func (sh *SQLDBHelper) rebind(query string) string {
+	if sh.Driver != "postgres" {
+		return query
+	}
+
+	var rebound strings.Builder
+	argument := 0
+	for _, char := range query {
+		if char != '?' {
+			rebound.WriteRune(char)
+			continue
+		}
+		argument++
+		rebound.WriteString("$" + strconv.Itoa(argument))
+	}
+	return rebound.String()
+}
+
+func (sh *SQLDBHelper) exec(ctx context.Context, q querier, query string, args ...interface{}) (sql.Result, error) {
+	return q.ExecContext(ctx, sh.rebind(query), args...)
+}
+
+func (sh *SQLDBHelper) query(ctx context.Context, q querier, query string, args ...interface{}) (*sql.Rows, error) {
+	return q.QueryContext(ctx, sh.rebind(query), args...)
+}
+
+func (sh *SQLDBHelper) queryRow(ctx context.Context, q querier, query string, args ...interface{}) *sql.Row {
+	return q.QueryRowContext(ctx, sh.rebind(query), args...)
+}
+
+// withTx runs fn in a transaction, committing it when fn succeeds and rolling it back
+// otherwise.
+func (sh *SQLDBHelper) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := sh.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			utils.LogError("withTx", "error rolling back transaction", "", rollbackErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// notFound turns sql.ErrNoRows into the models.ErrNotFound handlers check for.
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.ErrNotFound
+	}
+	return err
+}
+
+// affectedOne returns models.ErrNotFound when the statement changed no rows.
+func affectedOne(result sql.Result) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+// placeholders returns "?, ?, ..." for n arguments.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// qualify prefixes each of the comma separated columns with the table alias, for joins.
+func qualify(alias, columns string) string {
+	fields := strings.Split(columns, ",")
+	for i, field := range fields {
+		fields[i] = alias + "." + strings.TrimSpace(field)
+	}
+	return strings.Join(fields, ", ")
+}
+
+// stringArgs passes a list of strings as query arguments.
+func stringArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+	return args
+}
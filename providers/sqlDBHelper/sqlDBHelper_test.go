@@ -0,0 +1,622 @@
+package sqlDBHelper
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/providers/dbProvider"
+	"github.com/file_upload/utils"
+	"go.uber.org/zap"
+)
+
+var testTimeouts = models.DBTimeouts{Query: 5 * time.Second, Batch: 10 * time.Second, Scan: 60 * time.Second}
+
+// postgresDSNEnv names the environment variable holding the DSN of a PostgreSQL database
+// to run the tests against instead of SQLite, for example
+// postgres://postgres@localhost:5432/file_upload_test?sslmode=disable.
+const postgresDSNEnv = "FILE_UPLOAD_TEST_POSTGRES_DSN"
+
+// testDatabase returns the driver and DSN of an empty database for the test: a SQLite
+// file, or a schema of its own in the PostgreSQL database named by postgresDSNEnv.
+func testDatabase(t *testing.T) (string, string) {
+	t.Helper()
+	utils.Logging = zap.NewNop()
+
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		return "sqlite", filepath.Join(t.TempDir(), "file_upload.db")
+	}
+
+	admin, err := dbProvider.ConnectSQL("postgres", dsn, 1)
+	if err != nil {
+		t.Fatalf("ConnectSQL: %v", err)
+	}
+
+	suffix := make([]byte, 8)
+	rand.Read(suffix)
+	schema := "test_" + hex.EncodeToString(suffix)
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		admin.Close()
+		t.Fatalf("create schema %s: %v", schema, err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Errorf("drop schema %s: %v", schema, err)
+		}
+		admin.Close()
+	})
+
+	// lib/pq sends parameters it doesn't know, such as search_path, to the server.
+	if strings.Contains(dsn, "://") {
+		dsnURL, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatalf("parse %s: %v", postgresDSNEnv, err)
+		}
+		query := dsnURL.Query()
+		query.Set("search_path", schema)
+		dsnURL.RawQuery = query.Encode()
+		return "postgres", dsnURL.String()
+	}
+	return "postgres", dsn + " search_path=" + schema
+}
+
+// newTestHelper returns a helper on a fresh database, see testDatabase.
+func newTestHelper(t *testing.T) *SQLDBHelper {
+	t.Helper()
+	driver, dsn := testDatabase(t)
+
+	db, err := dbProvider.ConnectSQL(driver, dsn, 1)
+	if err != nil {
+		t.Fatalf("ConnectSQL: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	helper, err := NewSQLDBHelperProvider(db, driver, testTimeouts)
+	if err != nil {
+		t.Fatalf("NewSQLDBHelperProvider: %v", err)
+	}
+	return helper.(*SQLDBHelper)
+}
+
+func createTestUser(t *testing.T, sh *SQLDBHelper, user models.User) {
+	t.Helper()
+	if err := sh.CreateUser(context.Background(), user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		driver string
+		query  string
+		want   string
+	}{
+		{driver: "sqlite", query: "SELECT 1 WHERE a = ? AND b = ?", want: "SELECT 1 WHERE a = ? AND b = ?"},
+		{driver: "postgres", query: "SELECT 1 WHERE a = ? AND b = ?", want: "SELECT 1 WHERE a = $1 AND b = $2"},
+		{driver: "postgres", query: "SELECT 1", want: "SELECT 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.driver+" "+tt.query, func(t *testing.T) {
+			sh := &SQLDBHelper{Driver: tt.driver}
+			if got := sh.rebind(tt.query); got != tt.want {
+				t.Fatalf("rebind(%q) = %q; want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrateIsRepeatable(t *testing.T) {
+	driver, dsn := testDatabase(t)
+
+	for i := 0; i < 2; i++ {
+		db, err := dbProvider.ConnectSQL(driver, dsn, 1)
+		if err != nil {
+			t.Fatalf("ConnectSQL: %v", err)
+		}
+
+		helper, err := NewSQLDBHelperProvider(db, driver, testTimeouts)
+		if err != nil {
+			t.Fatalf("open %d: NewSQLDBHelperProvider: %v", i+1, err)
+		}
+
+		if i == 0 {
+			createTestUser(t, helper.(*SQLDBHelper), models.User{ID: "ada-id", Username: "ada"})
+		} else if _, err := helper.GetUserByUsername(context.Background(), "ada"); err != nil {
+			t.Fatalf("user lost after reopening: %v", err)
+		}
+		db.Close()
+	}
+}
+
+func TestCreateUser(t *testing.T) {
+	sh := newTestHelper(t)
+	createTestUser(t, sh, models.User{ID: "ada-id", Username: "ada", Disabled: true})
+
+	err := sh.CreateUser(context.Background(), models.User{ID: "other-id", Username: "ada"})
+	if !errors.Is(err, models.ErrUsernameTaken) {
+		t.Fatalf("CreateUser with a taken username = %v; want ErrUsernameTaken", err)
+	}
+
+	user, err := sh.GetUserByID(context.Background(), "ada-id")
+	if err != nil || !user.Disabled {
+		t.Fatalf("GetUserByID = %+v, %v; want the disabled user", user, err)
+	}
+
+	if _, err := sh.GetUserByID(context.Background(), "missing"); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("GetUserByID of an unknown user = %v; want models.ErrNotFound", err)
+	}
+	if err := sh.SetUserDisabled(context.Background(), "missing", true); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("SetUserDisabled of an unknown user = %v; want models.ErrNotFound", err)
+	}
+}
+
+func TestReserveStorage(t *testing.T) {
+	sh := newTestHelper(t)
+	createTestUser(t, sh, models.User{ID: "ada-id", Username: "ada", Quota: 100, MaxFiles: 2})
+
+	tests := []struct {
+		name    string
+		size    int64
+		files   int64
+		wantErr error
+	}{
+		{name: "fits", size: 60, files: 1},
+		{name: "over quota", size: 41, files: 1, wantErr: models.ErrInsufficientStorage},
+		{name: "new version", size: 20},
+		{name: "last file", size: 20, files: 1},
+		{name: "over file limit", size: 0, files: 1, wantErr: models.ErrFileLimitReached},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := sh.ReserveStorage(context.Background(), "ada-id", tt.size, tt.files); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReserveStorage(%d, %d) = %v; want %v", tt.size, tt.files, err, tt.wantErr)
+			}
+		})
+	}
+
+	user, _ := sh.GetUserByID(context.Background(), "ada-id")
+	if user.UsedStorage != 100 || user.FileCount != 2 {
+		t.Fatalf("used storage = %d, files = %d; want 100 and 2", user.UsedStorage, user.FileCount)
+	}
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	sh := newTestHelper(t)
+
+	session, err := sh.CreateUserSession(context.Background(), "ada-id", models.SessionClient{UserAgent: "test"})
+	if err != nil {
+		t.Fatalf("CreateUserSession: %v", err)
+	}
+
+	rotated, err := sh.RotateRefreshToken(context.Background(), session.RefreshToken, models.SessionClient{})
+	if err != nil {
+		t.Fatalf("RotateRefreshToken: %v", err)
+	}
+	if rotated.FamilyID != session.FamilyID || rotated.RefreshToken == session.RefreshToken {
+		t.Fatalf("rotated session = %+v; want a new token in family %s", rotated, session.FamilyID)
+	}
+
+	if old, _ := sh.ReadUserSessionBySessionID(context.Background(), session.ID); old.EndTime > time.Now().Unix() {
+		t.Fatal("old session still active after rotation")
+	}
+
+	if _, err := sh.RotateRefreshToken(context.Background(), "unknown", models.SessionClient{}); !errors.Is(err, models.ErrInvalidRefreshToken) {
+		t.Fatalf("RotateRefreshToken of an unknown token = %v; want ErrInvalidRefreshToken", err)
+	}
+
+	reused, err := sh.RotateRefreshToken(context.Background(), session.RefreshToken, models.SessionClient{})
+	if !errors.Is(err, models.ErrRefreshTokenReused) || reused.FamilyID != session.FamilyID {
+		t.Fatalf("RotateRefreshToken of a used token = %+v, %v; want ErrRefreshTokenReused for family %s", reused, err, session.FamilyID)
+	}
+
+	revoked, err := sh.ReadUserSessionBySessionToken(context.Background(), rotated.Token)
+	if err != nil || revoked.EndTime > time.Now().Unix() || revoked.RefreshExpiresAt > time.Now().Unix() {
+		t.Fatalf("rotated session = %+v, %v; want it ended with its family", revoked, err)
+	}
+	if _, err := sh.RotateRefreshToken(context.Background(), rotated.RefreshToken, models.SessionClient{}); !errors.Is(err, models.ErrInvalidRefreshToken) {
+		t.Fatalf("RotateRefreshToken in a revoked family = %v; want ErrInvalidRefreshToken", err)
+	}
+}
+
+func TestGetSessionWithUser(t *testing.T) {
+	sh := newTestHelper(t)
+	createTestUser(t, sh, models.User{ID: "ada-id", Username: "ada", Quota: 100})
+
+	session, err := sh.CreateUserSession(context.Background(), "ada-id", models.SessionClient{UserAgent: "test"})
+	if err != nil {
+		t.Fatalf("CreateUserSession: %v", err)
+	}
+
+	got, err := sh.GetSessionWithUser(context.Background(), session.Token)
+	if err != nil {
+		t.Fatalf("GetSessionWithUser: %v", err)
+	}
+	if got.Session.ID != session.ID || got.Session.UserAgent != "test" || got.User.Username != "ada" || got.User.Quota != 100 {
+		t.Fatalf("GetSessionWithUser = %+v; want session %s of ada", got, session.ID)
+	}
+
+	if _, err := sh.GetSessionWithUser(context.Background(), "unknown"); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("GetSessionWithUser of an unknown token = %v; want ErrNotFound", err)
+	}
+
+	if err := sh.UpdateUserSession(context.Background(), session.ID); err != nil {
+		t.Fatalf("UpdateUserSession: %v", err)
+	}
+	if err := sh.EndUserSession(context.Background(), session.ID); err != nil {
+		t.Fatalf("EndUserSession: %v", err)
+	}
+	if err := sh.UpdateUserSession(context.Background(), session.ID); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("UpdateUserSession of an ended session = %v; want ErrNotFound", err)
+	}
+}
+
+func TestListFiles(t *testing.T) {
+	sh := newTestHelper(t)
+
+	for i, name := range []string{"b.txt", "a.txt", "a_b.png", "A.txt", "c.txt"} {
+		file := models.File{ID: name + "-id", UserID: "ada-id", Filename: name, Size: int64(10 - i), ContentType: "text/plain", Version: 1, LastVersion: 1}
+		if name == "a_b.png" {
+			file.ContentType = "image/png"
+		}
+		if err := sh.InsertFileMetadata(context.Background(), file, models.FileVersion{ID: name + "-v1", FileID: file.ID, UserID: "ada-id", Version: 1}); err != nil {
+			t.Fatalf("InsertFileMetadata: %v", err)
+		}
+	}
+
+	query := models.FileQuery{UserID: "ada-id", SortBy: models.FileSortByName, Limit: 2}
+
+	var names []string
+	for {
+		page, err := sh.ListFiles(context.Background(), query)
+		if err != nil {
+			t.Fatalf("ListFiles: %v", err)
+		}
+		if page.Total != 5 {
+			t.Fatalf("total = %d; want 5", page.Total)
+		}
+		for _, file := range page.Files {
+			names = append(names, file.Filename)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+
+	want := []string{"A.txt", "a.txt", "a_b.png", "b.txt", "c.txt"}
+	if len(names) != len(want) {
+		t.Fatalf("listed %v; want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("listed %v; want %v", names, want)
+		}
+	}
+
+	filters := []struct {
+		name      string
+		query     models.FileQuery
+		wantTotal int64
+	}{
+		{name: "prefix is case sensitive", query: models.FileQuery{NamePrefix: "a"}, wantTotal: 2},
+		{name: "underscore is not a wildcard", query: models.FileQuery{NamePrefix: "a_"}, wantTotal: 1},
+		{name: "content type family", query: models.FileQuery{ContentType: "image/*"}, wantTotal: 1},
+		{name: "size range", query: models.FileQuery{MinSize: int64Ptr(7), MaxSize: int64Ptr(9)}, wantTotal: 3},
+	}
+
+	for _, tt := range filters {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.query.UserID = "ada-id"
+			tt.query.SortBy = models.FileSortBySize
+			page, err := sh.ListFiles(context.Background(), tt.query)
+			if err != nil || page.Total != tt.wantTotal || int64(len(page.Files)) != tt.wantTotal {
+				t.Fatalf("ListFiles = %d files of %d, %v; want %d", len(page.Files), page.Total, err, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestFileVersionsAndDelete(t *testing.T) {
+	sh := newTestHelper(t)
+	createTestUser(t, sh, models.User{ID: "ada-id", Username: "ada", Quota: 100, UsedStorage: 30, FileCount: 1})
+
+	file := models.File{ID: "file-id", UserID: "ada-id", Filename: "a.txt", Size: 10, Hash: "h1", Version: 1, LastVersion: 1}
+	if err := sh.InsertFileMetadata(context.Background(), file, models.FileVersion{ID: "v1", FileID: file.ID, UserID: "ada-id", Version: 1, Size: 10, Hash: "h1"}); err != nil {
+		t.Fatalf("InsertFileMetadata: %v", err)
+	}
+
+	version, err := sh.AddFileVersion(context.Background(), models.FileVersion{ID: "v2", FileID: file.ID, UserID: "ada-id", Size: 20, Hash: "h2"})
+	if err != nil || version.Version != 2 {
+		t.Fatalf("AddFileVersion = %+v, %v; want version 2", version, err)
+	}
+
+	current, err := sh.GetFileByID(context.Background(), "ada-id", file.ID)
+	if err != nil || current.Hash != "h2" || current.Size != 20 {
+		t.Fatalf("GetFileByID = %+v, %v; want version 2 current", current, err)
+	}
+
+	if _, err := sh.AddFileVersion(context.Background(), models.FileVersion{ID: "v3", FileID: "missing", UserID: "ada-id"}); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("AddFileVersion of an unknown file = %v; want models.ErrNotFound", err)
+	}
+
+	if err := sh.CreateShare(context.Background(), models.Share{ID: "share-id", UserID: "ada-id", FileID: file.ID, TokenHash: "token-hash"}); err != nil {
+		t.Fatalf("CreateShare: %v", err)
+	}
+
+	deleted, versions, err := sh.DeleteFile(context.Background(), "ada-id", file.ID)
+	if err != nil || deleted.ID != file.ID || len(versions) != 2 || versions[0].Version != 2 {
+		t.Fatalf("DeleteFile = %+v, %+v, %v; want the file and both versions, newest first", deleted, versions, err)
+	}
+
+	user, _ := sh.GetUserByID(context.Background(), "ada-id")
+	if user.UsedStorage != 0 || user.FileCount != 0 {
+		t.Fatalf("used storage = %d, files = %d; want 0 and 0", user.UsedStorage, user.FileCount)
+	}
+	if _, err := sh.GetShareByToken(context.Background(), "token-hash"); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("share of a deleted file = %v; want models.ErrNotFound", err)
+	}
+	if _, _, err := sh.DeleteFile(context.Background(), "ada-id", file.ID); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("DeleteFile of a deleted file = %v; want models.ErrNotFound", err)
+	}
+}
+
+func TestBlobReferences(t *testing.T) {
+	sh := newTestHelper(t)
+	blob := models.Blob{Hash: "h1", Size: 10, Path: "blobs/h1"}
+
+	for i, wantCreated := range []bool{true, false} {
+		created, err := sh.AcquireBlob(context.Background(), blob)
+		if err != nil || created != wantCreated {
+			t.Fatalf("AcquireBlob %d = %v, %v; want %v", i+1, created, err, wantCreated)
+		}
+	}
+
+	for _, wantRefs := range []int64{1, 0} {
+		released, err := sh.ReleaseBlob(context.Background(), blob.Hash)
+		if err != nil || released.RefCount != wantRefs {
+			t.Fatalf("ReleaseBlob = %+v, %v; want %d references left", released, err, wantRefs)
+		}
+	}
+
+	if _, err := sh.GetBlob(context.Background(), blob.Hash); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("GetBlob after the last release = %v; want models.ErrNotFound", err)
+	}
+}
+
+func TestSetBlobRefCount(t *testing.T) {
+	sh := newTestHelper(t)
+	blob := models.Blob{Hash: "h1", Size: 10, Path: "blobs/h1"}
+
+	steps := []struct {
+		name     string
+		refCount int64
+		recorded int64
+		wantErr  error
+		wantRefs int64
+	}{
+		{name: "create", refCount: 2, recorded: 0, wantRefs: 2},
+		{name: "create again", refCount: 3, recorded: 0, wantErr: models.ErrConcurrentUpdate, wantRefs: 2},
+		{name: "stale count", refCount: 3, recorded: 1, wantErr: models.ErrConcurrentUpdate, wantRefs: 2},
+		{name: "update", refCount: 1, recorded: 2, wantRefs: 1},
+		{name: "stale delete", refCount: 0, recorded: 2, wantErr: models.ErrConcurrentUpdate, wantRefs: 1},
+		{name: "delete", refCount: 0, recorded: 1},
+	}
+
+	for _, step := range steps {
+		blob.RefCount = step.refCount
+		if err := sh.SetBlobRefCount(context.Background(), blob, step.recorded); !errors.Is(err, step.wantErr) {
+			t.Fatalf("%s: SetBlobRefCount = %v; want %v", step.name, err, step.wantErr)
+		}
+
+		var refs int64
+		if stored, err := sh.GetBlob(context.Background(), blob.Hash); err == nil {
+			refs = stored.RefCount
+		}
+		if refs != step.wantRefs {
+			t.Fatalf("%s: ref count = %d; want %d", step.name, refs, step.wantRefs)
+		}
+	}
+}
+
+func TestSetUserUsage(t *testing.T) {
+	sh := newTestHelper(t)
+	createTestUser(t, sh, models.User{ID: "ada-id", Username: "ada", Quota: 100, UsedStorage: 30, FileCount: 3})
+
+	// A reservation made after usage was read must survive the correction.
+	if err := sh.ReserveStorage(context.Background(), "ada-id", 5, 1); err != nil {
+		t.Fatalf("ReserveStorage: %v", err)
+	}
+	stale := models.UsageCorrection{UserID: "ada-id", RecordedStorage: 30, ActualStorage: 20, RecordedFiles: 3, ActualFiles: 2}
+	if err := sh.SetUserUsage(context.Background(), stale); !errors.Is(err, models.ErrConcurrentUpdate) {
+		t.Fatalf("SetUserUsage from stale usage = %v; want ErrConcurrentUpdate", err)
+	}
+
+	current := models.UsageCorrection{UserID: "ada-id", RecordedStorage: 35, ActualStorage: 25, RecordedFiles: 4, ActualFiles: 3}
+	if err := sh.SetUserUsage(context.Background(), current); err != nil {
+		t.Fatalf("SetUserUsage: %v", err)
+	}
+	user, _ := sh.GetUserByID(context.Background(), "ada-id")
+	if user.UsedStorage != 25 || user.FileCount != 3 {
+		t.Fatalf("used storage = %d, files = %d; want 25 and 3", user.UsedStorage, user.FileCount)
+	}
+
+	if err := sh.SetUserUsage(context.Background(), models.UsageCorrection{UserID: "missing"}); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("SetUserUsage of an unknown user = %v; want models.ErrNotFound", err)
+	}
+}
+
+func TestCountBlobVersions(t *testing.T) {
+	sh := newTestHelper(t)
+	createTestUser(t, sh, models.User{ID: "ada-id", Username: "ada", Quota: 100})
+
+	hash := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	blobKey := utils.BlobKey(hash)
+
+	file := models.File{ID: "file-id", UserID: "ada-id", Filename: "a.txt", Path: blobKey, Hash: hash, Version: 1, LastVersion: 1}
+	if err := sh.InsertFileMetadata(context.Background(), file, models.FileVersion{ID: "v1", FileID: file.ID, UserID: "ada-id", Version: 1, Path: blobKey, Hash: hash}); err != nil {
+		t.Fatalf("InsertFileMetadata: %v", err)
+	}
+	for _, version := range []models.FileVersion{
+		{ID: "v2", FileID: file.ID, UserID: "ada-id", Path: models.DefaultDirectory + "/" + blobKey, Hash: hash},
+		{ID: "v3", FileID: file.ID, UserID: "ada-id", Path: "ada-id/a.txt", Hash: hash},
+	} {
+		if _, err := sh.AddFileVersion(context.Background(), version); err != nil {
+			t.Fatalf("AddFileVersion: %v", err)
+		}
+	}
+
+	count, err := sh.CountBlobVersions(context.Background(), hash)
+	if err != nil || count != 2 {
+		t.Fatalf("CountBlobVersions = %d, %v; want 2, not counting the version stored outside the blob", count, err)
+	}
+}
+
+func TestRecordLoginFailure(t *testing.T) {
+	sh := newTestHelper(t)
+
+	for _, wantFailures := range []int64{1, 2} {
+		attempt, err := sh.RecordLoginFailure(context.Background(), "user:ada", 100, 50)
+		if err != nil || attempt.Failures != wantFailures {
+			t.Fatalf("RecordLoginFailure = %+v, %v; want %d failures", attempt, err, wantFailures)
+		}
+	}
+
+	if err := sh.LockLogin(context.Background(), "user:ada", 200, 300); err != nil {
+		t.Fatalf("LockLogin: %v", err)
+	}
+
+	// Still counting while the lockout is recent.
+	if attempt, _ := sh.RecordLoginFailure(context.Background(), "user:ada", 300, 150); attempt.Failures != 3 || attempt.LockedUntil != 200 {
+		t.Fatalf("failure after lockout = %+v; want 3 failures, locked until 200", attempt)
+	}
+
+	// Forgotten once both the last failure and the lockout are old.
+	if attempt, _ := sh.RecordLoginFailure(context.Background(), "user:ada", 1000, 500); attempt.Failures != 1 {
+		t.Fatalf("failure after reset = %+v; want 1 failure", attempt)
+	}
+
+	if err := sh.ClearLoginAttempts(context.Background(), "user:ada"); err != nil {
+		t.Fatalf("ClearLoginAttempts: %v", err)
+	}
+	if attempt, err := sh.GetLoginAttempt(context.Background(), "user:ada"); err != nil || attempt.Failures != 0 {
+		t.Fatalf("GetLoginAttempt after clearing = %+v, %v; want no failures", attempt, err)
+	}
+
+	// Records whose failures are forgotten are deleted by the next failure of any key.
+	sh.RecordLoginFailure(context.Background(), "user:old", 100, 50)
+	sh.RecordLoginFailure(context.Background(), "user:new", 1000, 500)
+	var count int
+	if err := sh.queryRow(context.Background(), sh.DB, `SELECT COUNT(*) FROM login_attempts`).Scan(&count); err != nil || count != 1 {
+		t.Fatalf("login attempt records = %d, %v; want only the new one", count, err)
+	}
+}
+
+func TestClaimShareDownload(t *testing.T) {
+	sh := newTestHelper(t)
+
+	shares := []models.Share{
+		{ID: "limited", TokenHash: "t1", MaxDownloads: 1},
+		{ID: "expired", TokenHash: "t2", ExpiresAt: 100},
+	}
+	for _, share := range shares {
+		if err := sh.CreateShare(context.Background(), share); err != nil {
+			t.Fatalf("CreateShare: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		shareID string
+		wantErr error
+	}{
+		{name: "first download", shareID: "limited"},
+		{name: "limit reached", shareID: "limited", wantErr: models.ErrShareExhausted},
+		{name: "expired", shareID: "expired", wantErr: models.ErrShareExpired},
+		{name: "unknown", shareID: "missing", wantErr: models.ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := sh.ClaimShareDownload(context.Background(), tt.shareID, 200); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ClaimShareDownload(%s) = %v; want %v", tt.shareID, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func int64Ptr(value int64) *int64 {
+	return &value
+}
+
+func TestOpenUploads(t *testing.T) {
+	sh := newTestHelper(t)
+	now := time.Now().Unix()
+
+	for _, upload := range []models.Upload{
+		{ID: "open-id", UserID: "ada-id", Filename: "open.txt", Size: 10, ExpiresAt: now + 60},
+		{ID: "expired-id", UserID: "ada-id", Filename: "expired.txt", Size: 10, ExpiresAt: now - 60},
+		{ID: "other-id", UserID: "bob-id", Filename: "other.txt", Size: 10, ExpiresAt: now + 60},
+	} {
+		if err := sh.CreateUpload(context.Background(), upload); err != nil {
+			t.Fatalf("CreateUpload: %v", err)
+		}
+	}
+
+	if count, err := sh.CountOpenUploads(context.Background(), "ada-id", now); err != nil || count != 1 {
+		t.Fatalf("CountOpenUploads = %d, %v; want 1", count, err)
+	}
+
+	// Receiving data moves the expiry along.
+	if err := sh.UpdateUploadOffset(context.Background(), "expired-id", 0, 5, nil); err != nil {
+		t.Fatalf("UpdateUploadOffset: %v", err)
+	}
+	if count, err := sh.CountOpenUploads(context.Background(), "ada-id", now); err != nil || count != 2 {
+		t.Fatalf("CountOpenUploads after a chunk = %d, %v; want 2", count, err)
+	}
+
+	uploads, err := sh.GetUploads(context.Background())
+	if err != nil || len(uploads) != 3 {
+		t.Fatalf("GetUploads = %d uploads, %v; want 3", len(uploads), err)
+	}
+}
+
+func TestUniqueFileNames(t *testing.T) {
+	sh := newTestHelper(t)
+	createTestUser(t, sh, models.User{ID: "ada-id", Username: "ada", Quota: 100})
+
+	insert := func(id, folderID, filename string) error {
+		file := models.File{ID: id, UserID: "ada-id", Filename: filename, FolderID: folderID, Version: 1, LastVersion: 1}
+		return sh.InsertFileMetadata(context.Background(), file, models.FileVersion{ID: id + "-v1", FileID: id, UserID: "ada-id", Version: 1})
+	}
+
+	if err := insert("a-id", "", "a.txt"); err != nil {
+		t.Fatalf("InsertFileMetadata: %v", err)
+	}
+	if err := insert("b-id", "folder-id", "a.txt"); err != nil {
+		t.Fatalf("InsertFileMetadata of the name in another folder: %v", err)
+	}
+	if err := insert("c-id", "", "a.txt"); !errors.Is(err, models.ErrNameTaken) {
+		t.Fatalf("InsertFileMetadata of a taken name = %v; want ErrNameTaken", err)
+	}
+	if versions, err := sh.GetFileVersions(context.Background(), "ada-id", "c-id"); err != nil || len(versions) != 0 {
+		t.Fatalf("GetFileVersions of the refused file = %+v, %v; want none, rolled back with it", versions, err)
+	}
+
+	if err := sh.UpdateFileLocation(context.Background(), "ada-id", "b-id", "", "a.txt"); !errors.Is(err, models.ErrNameTaken) {
+		t.Fatalf("UpdateFileLocation onto a taken name = %v; want ErrNameTaken", err)
+	}
+	if err := sh.UpdateFileLocation(context.Background(), "ada-id", "a-id", "", "a.txt"); err != nil {
+		t.Fatalf("UpdateFileLocation onto its own name: %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package sqlDBHelper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+)
+
+const uploadColumns = `id, user_id, filename, folder_id, size, "offset", temp_path, hash_state, created_at, updated_at, expires_at`
+
+func scanUpload(row scanner) (models.Upload, error) {
+	var upload models.Upload
+	err := row.Scan(&upload.ID, &upload.UserID, &upload.Filename, &upload.FolderID, &upload.Size, &upload.Offset, &upload.TempPath,
+		&upload.HashState, &upload.CreatedAt, &upload.UpdatedAt, &upload.ExpiresAt)
+	return upload, err
+}
+
+func (sh *SQLDBHelper) CreateUpload(ctx context.Context, upload models.Upload) error {
+	utils.LogInfo("CreateUpload", "creating resumable upload session", fmt.Sprintf("UserID: %s, UploadID: %s", upload.UserID, upload.ID), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	_, err := sh.exec(ctx, sh.DB, `INSERT INTO uploads (`+uploadColumns+`) VALUES (`+placeholders(11)+`)`,
+		upload.ID, upload.UserID, upload.Filename, upload.FolderID, upload.Size, upload.Offset, upload.TempPath,
+		upload.HashState, upload.CreatedAt, upload.UpdatedAt, upload.ExpiresAt)
+	if err != nil {
+		utils.LogError("CreateUpload", "error inserting upload session", fmt.Sprintf("UserID: %s, UploadID: %s", upload.UserID, upload.ID), err)
+	}
+	return err
+}
+
+func (sh *SQLDBHelper) GetUploadByID(ctx context.Context, userID, uploadID string) (*models.Upload, error) {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	upload, err := scanUpload(sh.queryRow(ctx, sh.DB, `SELECT `+uploadColumns+` FROM uploads WHERE id = ? AND user_id = ?`, uploadID, userID))
+	if err != nil {
+		err = notFound(err)
+		utils.LogError("GetUploadByID", "upload session not found or error reading it", fmt.Sprintf("UserID: %s, UploadID: %s", userID, uploadID), err)
+		return nil, err
+	}
+
+	return &upload, nil
+}
+
+// UpdateUploadOffset moves the upload forward, but only if nobody else moved it since
+// fromOffset was read. ErrUploadOffsetChanged is returned otherwise. The upload expires
+// UploadTTL from now.
+func (sh *SQLDBHelper) UpdateUploadOffset(ctx context.Context, uploadID string, fromOffset, toOffset int64, hashState []byte) error {
+	utils.LogInfo("UpdateUploadOffset", "updating upload offset", fmt.Sprintf("UploadID: %s, From: %d, To: %d", uploadID, fromOffset, toOffset), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	result, err := sh.exec(ctx, sh.DB, `UPDATE uploads SET "offset" = ?, hash_state = ?, updated_at = ?, expires_at = ? WHERE id = ? AND "offset" = ?`,
+		toOffset, hashState, time.Now().Unix(), time.Now().Add(models.UploadTTL).Unix(), uploadID, fromOffset)
+	if err != nil {
+		utils.LogError("UpdateUploadOffset", "error updating upload offset", fmt.Sprintf("UploadID: %s", uploadID), err)
+		return err
+	}
+
+	if err := affectedOne(result); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			utils.LogWarning("UpdateUploadOffset", "upload offset changed concurrently", fmt.Sprintf("UploadID: %s, From: %d", uploadID, fromOffset))
+			return models.ErrUploadOffsetChanged
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (sh *SQLDBHelper) DeleteUpload(ctx context.Context, uploadID string) error {
+	utils.LogInfo("DeleteUpload", "deleting upload session", fmt.Sprintf("UploadID: %s", uploadID), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	_, err := sh.exec(ctx, sh.DB, `DELETE FROM uploads WHERE id = ?`, uploadID)
+	if err != nil {
+		utils.LogError("DeleteUpload", "error deleting upload session", fmt.Sprintf("UploadID: %s", uploadID), err)
+	}
+	return err
+}
+
+// CountOpenUploads counts the uploads of the user that have not expired at the given time.
+func (sh *SQLDBHelper) CountOpenUploads(ctx context.Context, userID string, at int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	var count int64
+	err := sh.queryRow(ctx, sh.DB, `SELECT COUNT(*) FROM uploads WHERE user_id = ? AND expires_at > ?`, userID, at).Scan(&count)
+	if err != nil {
+		utils.LogError("CountOpenUploads", "error counting upload sessions", fmt.Sprintf("UserID: %s", userID), err)
+	}
+	return count, err
+}
+
+// GetUploads returns every upload session, expired or not.
+func (sh *SQLDBHelper) GetUploads(ctx context.Context) ([]models.Upload, error) {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Scan)
+	defer cancel()
+
+	rows, err := sh.query(ctx, sh.DB, `SELECT `+uploadColumns+` FROM uploads`)
+	if err != nil {
+		utils.LogError("GetUploads", "error fetching upload sessions", "", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	uploads := []models.Upload{}
+	for rows.Next() {
+		upload, err := scanUpload(rows)
+		if err != nil {
+			utils.LogError("GetUploads", "error reading upload session", "", err)
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
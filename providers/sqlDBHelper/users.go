@@ -0,0 +1,251 @@
+package sqlDBHelper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+)
+
+const userColumns = `id, name, password, username, used_storage, quota, created_at, role, disabled, plan, max_file_size, max_files, file_count`
+
+func scanUser(row scanner) (models.User, error) {
+	var user models.User
+	err := row.Scan(userFields(&user)...)
+	return user, err
+}
+
+// userFields lists the fields of the user in the order of userColumns.
+func userFields(user *models.User) []interface{} {
+	return []interface{}{&user.ID, &user.Name, &user.Password, &user.Username, &user.UsedStorage, &user.Quota, &user.CreatedAt,
+		&user.Role, &user.Disabled, &user.Plan, &user.MaxFileSize, &user.MaxFiles, &user.FileCount}
+}
+
+func (sh *SQLDBHelper) GetUserByUsername(ctx context.Context, username string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	user, err := scanUser(sh.queryRow(ctx, sh.DB, `SELECT `+userColumns+` FROM users WHERE username = ?`, username))
+	if err != nil {
+		return user, notFound(err)
+	}
+	return user, nil
+}
+
+func (sh *SQLDBHelper) GetUserByID(ctx context.Context, userID string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	user, err := scanUser(sh.queryRow(ctx, sh.DB, `SELECT `+userColumns+` FROM users WHERE id = ?`, userID))
+	if err != nil {
+		err = notFound(err)
+		utils.LogError("GetUserByID", "user not found or error reading it", fmt.Sprintf("UserID: %s", userID), err)
+		return user, err
+	}
+	return user, nil
+}
+
+// CreateUser adds the user, or returns ErrUsernameTaken if the username is in use.
+func (sh *SQLDBHelper) CreateUser(ctx context.Context, user models.User) error {
+	utils.LogInfo("CreateUser", "creating user", fmt.Sprintf("UserID: %s, Username: %s", user.ID, user.Username), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	_, err := sh.exec(ctx, sh.DB, `INSERT INTO users (`+userColumns+`) VALUES (`+placeholders(13)+`)`,
+		user.ID, user.Name, user.Password, user.Username, user.UsedStorage, user.Quota, user.CreatedAt,
+		user.Role, user.Disabled, user.Plan, user.MaxFileSize, user.MaxFiles, user.FileCount)
+	if err != nil {
+		// The unique index refused the username; the error itself differs per driver.
+		if _, lookupErr := sh.GetUserByUsername(ctx, user.Username); lookupErr == nil {
+			return fmt.Errorf("%w: %s", models.ErrUsernameTaken, user.Username)
+		}
+		utils.LogError("CreateUser", "error inserting user", fmt.Sprintf("Username: %s", user.Username), err)
+		return err
+	}
+	return nil
+}
+
+// GetUsers returns every registered user, oldest first.
+func (sh *SQLDBHelper) GetUsers(ctx context.Context) ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Batch)
+	defer cancel()
+
+	rows, err := sh.query(ctx, sh.DB, `SELECT `+userColumns+` FROM users ORDER BY created_at, id`)
+	if err != nil {
+		utils.LogError("GetUsers", "error fetching users", "", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			utils.LogError("GetUsers", "error reading user", "", err)
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (sh *SQLDBHelper) UpdateStorageData(ctx context.Context, userID string, storage int64) error {
+	utils.LogInfo("UpdateStorageData", "updating used storage", fmt.Sprintf("UserID: %s, NewStorage: %d", userID, storage), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	_, err := sh.exec(ctx, sh.DB, `UPDATE users SET used_storage = ? WHERE id = ?`, storage, userID)
+	if err != nil {
+		utils.LogError("UpdateStorageData", "error updating user storage", fmt.Sprintf("UserID: %s", userID), err)
+	}
+	return err
+}
+
+// ReserveStorage atomically adds size to the user's used storage and files to their file
+// count, but only while both stay within the user's limits. ErrInsufficientStorage or
+// ErrFileLimitReached is returned when they would not.
+func (sh *SQLDBHelper) ReserveStorage(ctx context.Context, userID string, size, files int64) error {
+	utils.LogInfo("ReserveStorage", "reserving user storage", fmt.Sprintf("UserID: %s, Size: %d, Files: %d", userID, size, files), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	result, err := sh.exec(ctx, sh.DB, `UPDATE users SET used_storage = used_storage + ?, file_count = file_count + ?
+		WHERE id = ? AND used_storage + ? <= quota AND (max_files <= 0 OR file_count + ? <= max_files)`,
+		size, files, userID, size, files)
+	if err != nil {
+		utils.LogError("ReserveStorage", "error reserving user storage", fmt.Sprintf("UserID: %s, Size: %d", userID, size), err)
+		return err
+	}
+
+	if err := affectedOne(result); err != nil {
+		if !errors.Is(err, models.ErrNotFound) {
+			return err
+		}
+		utils.LogWarning("ReserveStorage", "limits exceeded, storage not reserved", fmt.Sprintf("UserID: %s, Size: %d, Files: %d", userID, size, files))
+
+		user, err := sh.GetUserByID(ctx, userID)
+		if err == nil && files > 0 && user.MaxFiles > 0 && user.FileCount+files > user.MaxFiles {
+			return models.ErrFileLimitReached
+		}
+		return models.ErrInsufficientStorage
+	}
+
+	return nil
+}
+
+// ReleaseStorage gives back storage and files taken by ReserveStorage.
+func (sh *SQLDBHelper) ReleaseStorage(ctx context.Context, userID string, size, files int64) error {
+	utils.LogInfo("ReleaseStorage", "releasing user storage", fmt.Sprintf("UserID: %s, Size: %d, Files: %d", userID, size, files), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	_, err := sh.exec(ctx, sh.DB, `UPDATE users SET used_storage = used_storage - ?, file_count = file_count - ? WHERE id = ?`,
+		size, files, userID)
+	if err != nil {
+		utils.LogError("ReleaseStorage", "error releasing user storage", fmt.Sprintf("UserID: %s, Size: %d", userID, size), err)
+	}
+	return err
+}
+
+func (sh *SQLDBHelper) UpdateUserQuota(ctx context.Context, userID string, quota int64) error {
+	return sh.updateUser(ctx, "UpdateUserQuota", userID, `quota = ?`, quota)
+}
+
+func (sh *SQLDBHelper) SetUserDisabled(ctx context.Context, userID string, disabled bool) error {
+	return sh.updateUser(ctx, "SetUserDisabled", userID, `disabled = ?`, disabled)
+}
+
+// SetUserPlan puts the user on the plan, replacing their limits with the plan's.
+func (sh *SQLDBHelper) SetUserPlan(ctx context.Context, userID string, plan models.Plan) error {
+	return sh.updateUser(ctx, "SetUserPlan", userID, `plan = ?, quota = ?, max_file_size = ?, max_files = ?`,
+		plan.Name, plan.Quota, plan.MaxFileSize, plan.MaxFiles)
+}
+
+// SetUserUsage moves the user's used storage and file count from the recorded to the
+// actual values of the correction, by the difference, and only while they are still the
+// recorded ones. Otherwise ErrConcurrentUpdate is returned, so usage reserved or released
+// since it was read is never lost.
+func (sh *SQLDBHelper) SetUserUsage(ctx context.Context, correction models.UsageCorrection) error {
+	utils.LogInfo("SetUserUsage", "correcting user usage", fmt.Sprintf("UserID: %s, UsedStorage: %d -> %d, FileCount: %d -> %d", correction.UserID, correction.RecordedStorage, correction.ActualStorage, correction.RecordedFiles, correction.ActualFiles), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	result, err := sh.exec(ctx, sh.DB, `UPDATE users SET used_storage = used_storage + ?, file_count = file_count + ?
+		WHERE id = ? AND used_storage = ? AND file_count = ?`,
+		correction.ActualStorage-correction.RecordedStorage, correction.ActualFiles-correction.RecordedFiles,
+		correction.UserID, correction.RecordedStorage, correction.RecordedFiles)
+	if err != nil {
+		utils.LogError("SetUserUsage", "error correcting user usage", fmt.Sprintf("UserID: %s", correction.UserID), err)
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		if _, err := sh.GetUserByID(ctx, correction.UserID); err != nil {
+			return err
+		}
+		return models.ErrConcurrentUpdate
+	}
+	return nil
+}
+
+// MigrateUserPlans puts users without a plan on the plan and counts their files.
+// Their quota is left as it was.
+func (sh *SQLDBHelper) MigrateUserPlans(ctx context.Context, plan models.Plan) error {
+	utils.LogInfo("MigrateUserPlans", "putting users without a plan on the default plan", fmt.Sprintf("Plan: %s", plan.Name), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Scan)
+	defer cancel()
+
+	result, err := sh.exec(ctx, sh.DB, `UPDATE users SET plan = ?, max_file_size = ?, max_files = ?,
+		file_count = (SELECT COUNT(*) FROM files WHERE files.user_id = users.id)
+		WHERE plan = ''`,
+		plan.Name, plan.MaxFileSize, plan.MaxFiles)
+	if err != nil {
+		utils.LogError("MigrateUserPlans", "error migrating users to the plan", fmt.Sprintf("Plan: %s", plan.Name), err)
+		return err
+	}
+
+	migrated, _ := result.RowsAffected()
+	utils.LogInfo("MigrateUserPlans", fmt.Sprintf("migrated %d users", migrated), fmt.Sprintf("Plan: %s", plan.Name), nil)
+	return nil
+}
+
+func (sh *SQLDBHelper) SetUserRoleByUsername(ctx context.Context, username, role string) error {
+	utils.LogInfo("SetUserRoleByUsername", "setting user role", fmt.Sprintf("Username: %s, Role: %s", username, role), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	result, err := sh.exec(ctx, sh.DB, `UPDATE users SET role = ? WHERE username = ?`, role, username)
+	if err != nil {
+		utils.LogError("SetUserRoleByUsername", "error setting user role", fmt.Sprintf("Username: %s", username), err)
+		return err
+	}
+	return affectedOne(result)
+}
+
+// updateUser sets columns on the user, returning models.ErrNotFound when there is no such user.
+func (sh *SQLDBHelper) updateUser(ctx context.Context, source, userID, set string, args ...interface{}) error {
+	utils.LogInfo(source, "updating user", fmt.Sprintf("UserID: %s", userID), nil)
+
+	ctx, cancel := context.WithTimeout(ctx, sh.Timeouts.Query)
+	defer cancel()
+
+	result, err := sh.exec(ctx, sh.DB, `UPDATE users SET `+set+` WHERE id = ?`, append(args, userID)...)
+	if err != nil {
+		utils.LogError(source, "error updating user", fmt.Sprintf("UserID: %s", userID), err)
+		return err
+	}
+	return affectedOne(result)
+}
@@ -0,0 +1,172 @@
+package storageProvider
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/providers"
+	"github.com/file_upload/utils"
+)
+
+// localStorage keeps objects as files below a root directory on the API host.
+type localStorage struct {
+	root string
+}
+
+func NewLocalStorage(root string) providers.StorageProvider {
+	return &localStorage{
+		root: root,
+	}
+}
+
+func (ls *localStorage) Put(key string, r io.Reader, size int64) error {
+	objectPath, err := ls.objectPath(key)
+	if err != nil {
+		return err
+	}
+
+	if err := utils.CreateDirIfNotExist(filepath.Dir(objectPath)); err != nil {
+		return err
+	}
+
+	// Write next to the target and rename, so readers never see a half written object.
+	tempFile, err := os.CreateTemp(filepath.Dir(objectPath), ".put-*")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tempFile, r)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempFile.Name(), objectPath)
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		utils.LogError("localStorage.Put", "error writing object", key, err)
+		return err
+	}
+
+	return nil
+}
+
+func (ls *localStorage) Get(key string) (io.ReadSeekCloser, error) {
+	objectPath, err := ls.objectPath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(objectPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, models.ErrObjectNotFound
+		}
+		utils.LogError("localStorage.Get", "error opening object", key, err)
+		return nil, err
+	}
+
+	return file, nil
+}
+
+func (ls *localStorage) Delete(key string) error {
+	objectPath, err := ls.objectPath(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(objectPath); err != nil && !os.IsNotExist(err) {
+		utils.LogError("localStorage.Delete", "error removing object", key, err)
+		return err
+	}
+
+	return nil
+}
+
+func (ls *localStorage) Stat(key string) (models.StorageObjectInfo, error) {
+	objectPath, err := ls.objectPath(key)
+	if err != nil {
+		return models.StorageObjectInfo{}, err
+	}
+
+	info, err := os.Stat(objectPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return models.StorageObjectInfo{}, models.ErrObjectNotFound
+		}
+		return models.StorageObjectInfo{}, err
+	}
+
+	return models.StorageObjectInfo{
+		Key:     key,
+		Size:    info.Size(),
+		ModTime: info.ModTime().Unix(),
+	}, nil
+}
+
+func (ls *localStorage) List(prefix string) ([]models.StorageObjectInfo, error) {
+	var objects []models.StorageObjectInfo
+
+	// Only walk the directory part of the prefix; the rest is matched per key.
+	walkRoot := ls.root
+	if dir := path.Dir(prefix); dir != "." {
+		walkRoot = filepath.Join(ls.root, filepath.FromSlash(dir))
+	}
+
+	err := filepath.WalkDir(walkRoot, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		// Skip staging files such as in-progress uploads and puts.
+		if strings.HasPrefix(entry.Name(), ".") && filePath != walkRoot {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(ls.root, filePath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(relativePath)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		objects = append(objects, models.StorageObjectInfo{
+			Key:     key,
+			Size:    info.Size(),
+			ModTime: info.ModTime().Unix(),
+		})
+		return nil
+	})
+	if err != nil {
+		utils.LogError("localStorage.List", "error listing objects", prefix, err)
+		return nil, err
+	}
+
+	return objects, nil
+}
+
+// objectPath maps a key to its file below the root, refusing keys that would escape it.
+func (ls *localStorage) objectPath(key string) (string, error) {
+	return utils.SafeJoin(ls.root, key)
+}
@@ -0,0 +1,442 @@
+package storageProvider
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/file_upload/config"
+	"github.com/file_upload/models"
+	"github.com/file_upload/providers"
+	"github.com/file_upload/utils"
+)
+
+const (
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	s3TimeFormat      = "20060102T150405Z"
+	s3DateFormat      = "20060102"
+
+	// Objects can be large and are streamed, so a request as a whole has no deadline.
+	// Connecting and the start of S3's answer do, so a hung server can't block forever.
+	s3DialTimeout     = 10 * time.Second
+	s3ResponseTimeout = 30 * time.Second
+)
+
+// s3Storage keeps objects in a bucket of AWS S3 or any S3-compatible server. Requests
+// are signed with AWS Signature Version 4, so no SDK is needed.
+type s3Storage struct {
+	endpoint     *url.URL
+	region       string
+	bucket       string
+	accessKey    string
+	secretKey    string
+	usePathStyle bool
+	httpClient   *http.Client
+}
+
+func NewS3Storage(s3Config config.S3Config) (providers.StorageProvider, error) {
+	endpoint, err := url.Parse(s3Config.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %v", err)
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", s3Config.Endpoint)
+	}
+	if s3Config.Bucket == "" {
+		return nil, errors.New("s3 bucket is not configured")
+	}
+
+	region := s3Config.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return &s3Storage{
+		endpoint:     endpoint,
+		region:       region,
+		bucket:       s3Config.Bucket,
+		accessKey:    s3Config.AccessKey,
+		secretKey:    s3Config.SecretKey,
+		usePathStyle: s3Config.UsePathStyle,
+		httpClient:   newS3HTTPClient(s3ResponseTimeout),
+	}, nil
+}
+
+// newS3HTTPClient returns a client that gives up on a server that doesn't start
+// answering within responseTimeout.
+func newS3HTTPClient(responseTimeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: s3DialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = s3DialTimeout
+	transport.ResponseHeaderTimeout = responseTimeout
+
+	return &http.Client{Transport: transport}
+}
+
+func (s3 *s3Storage) Put(key string, r io.Reader, size int64) error {
+	req, err := s3.newRequest(http.MethodPut, key, nil, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+
+	resp, err := s3.do(req)
+	if err != nil {
+		utils.LogError("s3Storage.Put", "error uploading object", key, err)
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+func (s3 *s3Storage) Get(key string) (io.ReadSeekCloser, error) {
+	info, err := s3.Stat(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3Object{storage: s3, key: key, size: info.Size}, nil
+}
+
+func (s3 *s3Storage) Delete(key string) error {
+	req, err := s3.newRequest(http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s3.do(req)
+	if err != nil {
+		if errors.Is(err, models.ErrObjectNotFound) {
+			return nil
+		}
+		utils.LogError("s3Storage.Delete", "error deleting object", key, err)
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+func (s3 *s3Storage) Stat(key string) (models.StorageObjectInfo, error) {
+	req, err := s3.newRequest(http.MethodHead, key, nil, nil)
+	if err != nil {
+		return models.StorageObjectInfo{}, err
+	}
+
+	resp, err := s3.do(req)
+	if err != nil {
+		return models.StorageObjectInfo{}, err
+	}
+	resp.Body.Close()
+
+	info := models.StorageObjectInfo{
+		Key:  key,
+		Size: resp.ContentLength,
+	}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modTime.Unix()
+	}
+
+	return info, nil
+}
+
+type s3ListBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s3 *s3Storage) List(prefix string) ([]models.StorageObjectInfo, error) {
+	var objects []models.StorageObjectInfo
+
+	continuationToken := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+
+		req, err := s3.newRequest(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := s3.do(req)
+		if err != nil {
+			utils.LogError("s3Storage.List", "error listing objects", prefix, err)
+			return nil, err
+		}
+
+		var result s3ListBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			utils.LogError("s3Storage.List", "error decoding object listing", prefix, err)
+			return nil, err
+		}
+
+		for _, content := range result.Contents {
+			objects = append(objects, models.StorageObjectInfo{
+				Key:     content.Key,
+				Size:    content.Size,
+				ModTime: content.LastModified.Unix(),
+			})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+// newRequest builds a request for the object key, or for the bucket itself when key is empty.
+func (s3 *s3Storage) newRequest(method, key string, query url.Values, body io.Reader) (*http.Request, error) {
+	if key != "" {
+		if _, err := utils.SafeJoin("", key); err != nil {
+			return nil, err
+		}
+	}
+
+	requestURL := *s3.endpoint
+	if s3.usePathStyle {
+		requestURL.Path = strings.TrimSuffix(requestURL.Path, "/") + "/" + s3.bucket + "/" + key
+	} else {
+		requestURL.Host = s3.bucket + "." + requestURL.Host
+		requestURL.Path = strings.TrimSuffix(requestURL.Path, "/") + "/" + key
+	}
+	requestURL.RawPath = s3EscapePath(requestURL.Path)
+	requestURL.RawQuery = s3CanonicalQuery(query)
+
+	return http.NewRequest(method, requestURL.String(), body)
+}
+
+// do signs and sends the request. Any non-2xx answer is turned into an error, and a
+// 404 into ErrObjectNotFound.
+func (s3 *s3Storage) do(req *http.Request) (*http.Response, error) {
+	s3.sign(req, time.Now().UTC())
+
+	resp, err := s3.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, models.ErrObjectNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	return resp, nil
+}
+
+// sign adds the AWS Signature Version 4 Authorization header. The payload is left
+// unsigned so bodies can be streamed without being hashed first.
+func (s3 *s3Storage) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format(s3TimeFormat)
+	shortDate := now.Format(s3DateFormat)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if req.Header.Get("X-Amz-Content-Sha256") == "" {
+		req.Header.Set("X-Amz-Content-Sha256", s3UnsignedPayload)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lowerName := strings.ToLower(name)
+		if strings.HasPrefix(lowerName, "x-amz-") || lowerName == "range" || lowerName == "content-type" {
+			headers[lowerName] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	headerNames := make([]string, 0, len(headers))
+	for name := range headers {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range headerNames {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	scope := shortDate + "/" + s3.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(sha256Sum([]byte(canonicalRequest))),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+s3.secretKey), shortDate)
+	signingKey = hmacSHA256(signingKey, s3.region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3.accessKey, scope, signedHeaders, signature))
+}
+
+// s3Object reads an object lazily with ranged GETs, so it can be seeked like a file
+// and served with http.ServeContent.
+type s3Object struct {
+	storage *s3Storage
+	key     string
+	size    int64
+	offset  int64
+	body    io.ReadCloser
+}
+
+func (obj *s3Object) Read(p []byte) (int, error) {
+	if obj.offset >= obj.size {
+		return 0, io.EOF
+	}
+
+	if obj.body == nil {
+		req, err := obj.storage.newRequest(http.MethodGet, obj.key, nil, nil)
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(obj.offset, 10)+"-")
+
+		resp, err := obj.storage.do(req)
+		if err != nil {
+			return 0, err
+		}
+		obj.body = resp.Body
+	}
+
+	n, err := obj.body.Read(p)
+	obj.offset += int64(n)
+	return n, err
+}
+
+func (obj *s3Object) Seek(offset int64, whence int) (int64, error) {
+	var newOffset int64
+	switch whence {
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekCurrent:
+		newOffset = obj.offset + offset
+	case io.SeekEnd:
+		newOffset = obj.size + offset
+	default:
+		return 0, errors.New("s3Object.Seek: invalid whence")
+	}
+	if newOffset < 0 {
+		return 0, errors.New("s3Object.Seek: negative position")
+	}
+
+	if newOffset != obj.offset && obj.body != nil {
+		obj.body.Close()
+		obj.body = nil
+	}
+	obj.offset = newOffset
+
+	return newOffset, nil
+}
+
+func (obj *s3Object) Close() error {
+	if obj.body == nil {
+		return nil
+	}
+	err := obj.body.Close()
+	obj.body = nil
+	return err
+}
+
+// s3EscapePath URI-encodes every byte of the path except unreserved characters and '/'.
+func s3EscapePath(path string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if isUnreserved(c) || c == '/' {
+			escaped.WriteByte(c)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+	return escaped.String()
+}
+
+// s3CanonicalQuery encodes the query the way Signature Version 4 expects: sorted by key,
+// with every byte except unreserved characters escaped.
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, s3EscapeQueryComponent(key)+"="+s3EscapeQueryComponent(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func s3EscapeQueryComponent(component string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(component); i++ {
+		c := component[i]
+		if isUnreserved(c) {
+			escaped.WriteByte(c)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+	return escaped.String()
+}
+
+func isUnreserved(c byte) bool {
+	return 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '_' || c == '.' || c == '~'
+}
+
+func sha256Sum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
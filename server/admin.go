@@ -0,0 +1,269 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// adminListUsers returns every user with their storage usage.
+func (srv *Server) adminListUsers(c *gin.Context) {
+
+	users, err := srv.DBHelper.GetUsers(c.Request.Context())
+	if err != nil {
+		utils.LogError("adminListUsers", "error fetching users", "", err)
+		utils.RespondGenericServerErr(c, err, "could not retrieve users")
+		return
+	}
+
+	userList := make([]map[string]interface{}, 0, len(users))
+	for _, user := range users {
+		userList = append(userList, adminUserView(user))
+	}
+
+	utils.EncodeJSONBody(c, http.StatusOK, map[string]interface{}{
+		"users": userList,
+	})
+}
+
+// adminGetUser returns one user with their usage and active sessions.
+func (srv *Server) adminGetUser(c *gin.Context) {
+	userID := c.Param("id")
+
+	user, ok := srv.adminFetchUser(c, userID)
+	if !ok {
+		return
+	}
+
+	sessions, err := srv.DBHelper.ReadUserSessions(c.Request.Context(), userID, true)
+	if err != nil {
+		utils.LogError("adminGetUser", "error reading user sessions", userID, err)
+		utils.RespondGenericServerErr(c, err, "could not retrieve user sessions")
+		return
+	}
+
+	loginAttempt, err := srv.DBHelper.GetLoginAttempt(c.Request.Context(), accountLoginKey(user.Username))
+	if err != nil {
+		utils.LogError("adminGetUser", "error reading failed logins", userID, err)
+		utils.RespondGenericServerErr(c, err, "could not retrieve failed logins")
+		return
+	}
+
+	userView := adminUserView(user)
+	userView["active_sessions"] = len(sessions)
+	userView["failed_logins"] = loginAttempt.Failures
+	userView["locked_until"] = loginAttempt.LockedUntil
+
+	utils.EncodeJSONBody(c, http.StatusOK, userView)
+}
+
+// adminUpdateQuota overrides a user's storage quota until they are next put on a plan.
+// A quota below what the user already stores is allowed; it only stops further uploads.
+func (srv *Server) adminUpdateQuota(c *gin.Context) {
+	userID := c.Param("id")
+
+	var request models.UpdateQuotaRequest
+
+	err := utils.DecodeJSONBody(c, &request)
+	if err != nil {
+		utils.RespondRequestBodyErr(c, err)
+		return
+	}
+
+	if err := srv.DBHelper.UpdateUserQuota(c.Request.Context(), userID, *request.Quota); err != nil {
+		srv.respondAdminUpdateErr(c, "adminUpdateQuota", userID, err)
+		return
+	}
+	srv.MiddlewareProvider.InvalidateUser(userID)
+
+	utils.EncodeJSONBody(c, http.StatusOK, map[string]interface{}{
+		"message": "quota updated",
+		"quota":   *request.Quota,
+	})
+}
+
+// adminAssignPlan puts a user on a plan, replacing their limits with the plan's.
+func (srv *Server) adminAssignPlan(c *gin.Context) {
+	userID := c.Param("id")
+
+	var request models.AssignPlanRequest
+
+	err := utils.DecodeJSONBody(c, &request)
+	if err != nil {
+		utils.RespondRequestBodyErr(c, err)
+		return
+	}
+
+	plan, ok := srv.plan(request.Plan)
+	if !ok {
+		utils.RespondFieldErrs(c, fmt.Errorf("unknown plan %q", request.Plan), "unknown plan", models.FieldError{Field: "plan", Message: "is not a configured plan"})
+		return
+	}
+
+	if err := srv.DBHelper.SetUserPlan(c.Request.Context(), userID, plan); err != nil {
+		srv.respondAdminUpdateErr(c, "adminAssignPlan", userID, err)
+		return
+	}
+	srv.MiddlewareProvider.InvalidateUser(userID)
+
+	utils.EncodeJSONBody(c, http.StatusOK, map[string]interface{}{
+		"message": "plan assigned",
+		"plan":    plan,
+	})
+}
+
+// adminDisableUser stops the user from logging in and ends all their sessions.
+func (srv *Server) adminDisableUser(c *gin.Context) {
+	userContext := srv.MiddlewareProvider.UserFromContext(c.Request.Context())
+	userID := c.Param("id")
+
+	if userID == userContext.ID {
+		utils.RespondClientErr(c, errors.New("cannot disable own account"), http.StatusBadRequest, "you cannot disable your own account")
+		return
+	}
+
+	if err := srv.DBHelper.SetUserDisabled(c.Request.Context(), userID, true); err != nil {
+		srv.respondAdminUpdateErr(c, "adminDisableUser", userID, err)
+		return
+	}
+	srv.MiddlewareProvider.InvalidateUser(userID)
+
+	if err := srv.DBHelper.EndAllUserSessions(c.Request.Context(), userID); err != nil {
+		utils.LogError("adminDisableUser", "error ending sessions of disabled user", userID, err)
+		utils.RespondGenericServerErr(c, err, "account disabled, but its sessions could not be ended")
+		return
+	}
+
+	utils.EncodeJSONBody(c, http.StatusOK, map[string]interface{}{
+		"message": "account disabled",
+	})
+}
+
+// adminEnableUser lets a disabled user log in again.
+func (srv *Server) adminEnableUser(c *gin.Context) {
+	userID := c.Param("id")
+
+	if err := srv.DBHelper.SetUserDisabled(c.Request.Context(), userID, false); err != nil {
+		srv.respondAdminUpdateErr(c, "adminEnableUser", userID, err)
+		return
+	}
+	srv.MiddlewareProvider.InvalidateUser(userID)
+
+	utils.EncodeJSONBody(c, http.StatusOK, map[string]interface{}{
+		"message": "account enabled",
+	})
+}
+
+// adminUnlockUser lifts a login lockout on the user's account and forgets their failed logins.
+func (srv *Server) adminUnlockUser(c *gin.Context) {
+	userID := c.Param("id")
+
+	user, ok := srv.adminFetchUser(c, userID)
+	if !ok {
+		return
+	}
+
+	if err := srv.DBHelper.ClearLoginAttempts(c.Request.Context(), accountLoginKey(user.Username)); err != nil {
+		utils.LogError("adminUnlockUser", "error clearing failed logins", userID, err)
+		utils.RespondGenericServerErr(c, err, "could not unlock account")
+		return
+	}
+
+	utils.EncodeJSONBody(c, http.StatusOK, map[string]interface{}{
+		"message": "account unlocked",
+	})
+}
+
+// adminUnlockIP lifts a login lockout on a client IP address.
+func (srv *Server) adminUnlockIP(c *gin.Context) {
+	ip := net.ParseIP(c.Param("ip"))
+	if ip == nil {
+		utils.RespondClientErr(c, errors.New("invalid ip address"), http.StatusBadRequest, "invalid ip address")
+		return
+	}
+
+	if err := srv.DBHelper.ClearLoginAttempts(c.Request.Context(), ipLoginKey(c.Param("ip"))); err != nil {
+		utils.LogError("adminUnlockIP", "error clearing failed logins", ip.String(), err)
+		utils.RespondGenericServerErr(c, err, "could not unlock ip address")
+		return
+	}
+
+	utils.EncodeJSONBody(c, http.StatusOK, map[string]interface{}{
+		"message": "ip address unlocked",
+	})
+}
+
+// adminEndUserSessions logs the user out on every device.
+func (srv *Server) adminEndUserSessions(c *gin.Context) {
+	userID := c.Param("id")
+
+	if _, ok := srv.adminFetchUser(c, userID); !ok {
+		return
+	}
+
+	if err := srv.DBHelper.EndAllUserSessions(c.Request.Context(), userID); err != nil {
+		utils.LogError("adminEndUserSessions", "error ending user sessions", userID, err)
+		utils.RespondGenericServerErr(c, err, "could not end sessions")
+		return
+	}
+	srv.MiddlewareProvider.InvalidateUser(userID)
+
+	utils.EncodeJSONBody(c, http.StatusOK, map[string]interface{}{
+		"message": "sessions ended",
+	})
+}
+
+// adminFetchUser loads the user, responding with an error when that fails.
+func (srv *Server) adminFetchUser(c *gin.Context, userID string) (models.User, bool) {
+
+	user, err := srv.DBHelper.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			utils.RespondClientErr(c, err, http.StatusNotFound, "user not found")
+			return user, false
+		}
+		utils.LogError("adminFetchUser", "error fetching user", userID, err)
+		utils.RespondGenericServerErr(c, err, "could not retrieve user")
+		return user, false
+	}
+
+	return user, true
+}
+
+func (srv *Server) respondAdminUpdateErr(c *gin.Context, source, userID string, err error) {
+	if errors.Is(err, models.ErrNotFound) {
+		utils.RespondClientErr(c, err, http.StatusNotFound, "user not found")
+		return
+	}
+	utils.LogError(source, "error updating user", userID, err)
+	utils.RespondGenericServerErr(c, err, "could not update user")
+}
+
+// adminUserView is the user as shown to admins, without the password hash.
+func adminUserView(user models.User) map[string]interface{} {
+	role := user.Role
+	if role == "" {
+		role = models.RoleUser
+	}
+
+	return map[string]interface{}{
+		"id":            user.ID,
+		"name":          user.Name,
+		"username":      user.Username,
+		"role":          role,
+		"disabled":      user.Disabled,
+		"createdAt":     user.CreatedAt,
+		"plan":          user.Plan,
+		"quota":         user.Quota,
+		"used_storage":  user.UsedStorage,
+		"remaining":     user.Quota - user.UsedStorage,
+		"max_file_size": user.MaxFileSize,
+		"max_files":     user.MaxFiles,
+		"files":         user.FileCount,
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+)
+
+// putBlob copies a received temp file into the content-addressed store and takes a
+// reference on it. When the content is already stored it is not copied again.
+func (srv *Server) putBlob(ctx context.Context, tempPath, fileHash string, size int64) (string, error) {
+	blobKey := utils.BlobKey(fileHash)
+	if blobKey == "" {
+		return "", models.ErrInvalidStorageKey
+	}
+
+	unlock := srv.blobLocks.Lock(fileHash)
+	defer unlock()
+
+	placed := false
+	if _, err := srv.Storage.Stat(blobKey); errors.Is(err, models.ErrObjectNotFound) {
+		tempFile, err := os.Open(tempPath)
+		if err != nil {
+			return "", err
+		}
+		// Stop copying when the request is cancelled; Put removes what it wrote.
+		err = srv.Storage.Put(blobKey, utils.ContextReader(ctx, tempFile), size)
+		tempFile.Close()
+		if err != nil {
+			return "", err
+		}
+		placed = true
+	} else if err != nil {
+		return "", err
+	}
+
+	_, err := srv.DBHelper.AcquireBlob(ctx, models.Blob{
+		Hash:      fileHash,
+		Size:      size,
+		Path:      blobKey,
+		CreatedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		if placed {
+			srv.Storage.Delete(blobKey)
+		}
+		return "", err
+	}
+
+	return blobKey, nil
+}
+
+// acquireBlob takes another reference on content that is already stored, e.g. when an
+// old version is restored. Files stored before blobs existed are not reference counted.
+func (srv *Server) acquireBlob(ctx context.Context, path, fileHash string, size int64) error {
+	if utils.StorageKey(path) != utils.BlobKey(fileHash) {
+		return nil
+	}
+
+	unlock := srv.blobLocks.Lock(fileHash)
+	defer unlock()
+
+	_, err := srv.DBHelper.AcquireBlob(ctx, models.Blob{
+		Hash:      fileHash,
+		Size:      size,
+		Path:      utils.BlobKey(fileHash),
+		CreatedAt: time.Now().Unix(),
+	})
+	return err
+}
+
+// restoreBlobRefCount checks that no version uses a blob whose last reference was just
+// dropped. If some still do, the count is put back to their number and it reports true,
+// so the content is kept. The caller holds the blob's lock.
+func (srv *Server) restoreBlobRefCount(ctx context.Context, path, fileHash string, size int64) bool {
+	inUse, err := srv.DBHelper.CountBlobVersions(ctx, fileHash)
+	if err != nil {
+		utils.LogError("releaseBlob", "error counting versions using blob, keeping its content", path, err)
+		return true
+	}
+	if inUse == 0 {
+		return false
+	}
+
+	utils.LogWarning("releaseBlob", fmt.Sprintf("blob reference count was too low, %d versions still use it", inUse), path)
+	err = srv.DBHelper.SetBlobRefCount(ctx, models.Blob{
+		Hash:      fileHash,
+		Size:      size,
+		Path:      utils.BlobKey(fileHash),
+		RefCount:  inUse,
+		CreatedAt: time.Now().Unix(),
+	}, 0)
+	if err != nil {
+		utils.LogError("releaseBlob", "error restoring blob reference count", path, err)
+	}
+	return true
+}
+
+// releaseBlob drops a reference on the blob and deletes the content once nothing
+// points at it any more. Files stored before blobs existed are removed directly. It
+// runs to the end even when ctx is cancelled, as it undoes or finishes other work.
+func (srv *Server) releaseBlob(ctx context.Context, path, fileHash string) {
+	ctx = context.WithoutCancel(ctx)
+	if utils.StorageKey(path) == utils.BlobKey(fileHash) {
+		unlock := srv.blobLocks.Lock(fileHash)
+		defer unlock()
+
+		blob, err := srv.DBHelper.ReleaseBlob(ctx, fileHash)
+		if err != nil && !errors.Is(err, models.ErrNotFound) {
+			utils.LogError("releaseBlob", "error dropping blob reference", path, err)
+			return
+		}
+
+		if blob != nil && blob.RefCount > 0 {
+			return
+		}
+
+		// A reference count that is too low must not cost a version its content.
+		var size int64
+		if blob != nil {
+			size = blob.Size
+		}
+		if inUse := srv.restoreBlobRefCount(ctx, path, fileHash, size); inUse {
+			return
+		}
+	}
+
+	if err := srv.Storage.Delete(utils.StorageKey(path)); err != nil {
+		utils.LogError("releaseBlob", "error removing file from storage", path, err)
+	}
+}
@@ -0,0 +1,437 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// createFolder adds a folder at the top level or inside another folder.
+func (srv *Server) createFolder(c *gin.Context) {
+	userContext := srv.MiddlewareProvider.UserFromContext(c.Request.Context())
+
+	var request models.CreateFolderRequest
+
+	err := utils.DecodeJSONBody(c, &request)
+	if err != nil {
+		utils.RespondRequestBodyErr(c, err)
+		return
+	}
+
+	name, err := utils.SanitizeFilename(request.Name)
+	if err != nil {
+		utils.RespondFieldErrs(c, err, "invalid folder name", utils.NewFieldError("name", err, models.ErrInvalidFilename))
+		return
+	}
+
+	// Serialise changes to the user's folder tree, so names stay unique and moves can't form a cycle.
+	unlock := srv.folderLocks.Lock(userContext.ID)
+	defer unlock()
+
+	parentID, ok := srv.resolveFolder(c, userContext.ID, request.ParentID, "parent_id", "createFolder")
+	if !ok {
+		return
+	}
+
+	if !srv.checkFolderNameFree(c, userContext.ID, parentID, name, "", "createFolder") {
+		return
+	}
+
+	folder := models.Folder{
+		ID:        uuid.NewString(),
+		UserID:    userContext.ID,
+		Name:      name,
+		ParentID:  parentID,
+		CreatedAt: time.Now().Unix(),
+		UpdatedAt: time.Now().Unix(),
+	}
+
+	if err := srv.DBHelper.CreateFolder(c.Request.Context(), folder); err != nil {
+		utils.LogError("createFolder", "error creating folder", folder, err)
+		utils.RespondGenericServerErr(c, err, "could not create folder")
+		return
+	}
+
+	utils.EncodeJSONBody(c, http.StatusCreated, folder)
+}
+
+// getFolder lists the folders and files directly inside a folder, or with ?recursive=true
+// everything below it. "root" names the top level.
+func (srv *Server) getFolder(c *gin.Context) {
+	userContext := srv.MiddlewareProvider.UserFromContext(c.Request.Context())
+	recursive := c.Query("recursive") == "true"
+
+	folders, err := srv.DBHelper.GetFolders(c.Request.Context(), userContext.ID)
+	if err != nil {
+		utils.LogError("getFolder", "error fetching folders", userContext.ID, err)
+		utils.RespondGenericServerErr(c, err, "could not retrieve folders")
+		return
+	}
+
+	var folder *models.Folder
+	folderID := rootToEmpty(c.Param("id"))
+	if folderID != "" {
+		folder = findFolder(folders, folderID)
+		if folder == nil {
+			utils.RespondClientErr(c, models.ErrFolderNotFound, http.StatusNotFound, "folder not found")
+			return
+		}
+	}
+
+	subfolders := []models.Folder{}
+	if recursive {
+		subfolders = descendantFolders(folders, folderID)
+	} else {
+		for _, child := range folders {
+			if child.ParentID == folderID {
+				subfolders = append(subfolders, child)
+			}
+		}
+	}
+
+	folderIDs := []string{folderID}
+	if recursive {
+		for _, child := range subfolders {
+			folderIDs = append(folderIDs, child.ID)
+		}
+	}
+
+	files, err := srv.DBHelper.GetFilesInFolders(c.Request.Context(), userContext.ID, folderIDs)
+	if err != nil {
+		utils.LogError("getFolder", "error fetching files in folder", folderID, err)
+		utils.RespondGenericServerErr(c, err, "could not retrieve folder contents")
+		return
+	}
+
+	utils.EncodeJSONBody(c, http.StatusOK, map[string]interface{}{
+		"folder":    folder,
+		"recursive": recursive,
+		"folders":   subfolders,
+		"files":     files,
+	})
+}
+
+// updateFolder renames a folder and/or moves it under another parent. Only the folder's
+// own record changes; everything inside it moves along.
+func (srv *Server) updateFolder(c *gin.Context) {
+	userContext := srv.MiddlewareProvider.UserFromContext(c.Request.Context())
+
+	var request models.UpdateFolderRequest
+
+	err := utils.DecodeJSONBody(c, &request)
+	if err != nil {
+		utils.RespondRequestBodyErr(c, err)
+		return
+	}
+
+	unlock := srv.folderLocks.Lock(userContext.ID)
+	defer unlock()
+
+	folders, err := srv.DBHelper.GetFolders(c.Request.Context(), userContext.ID)
+	if err != nil {
+		utils.LogError("updateFolder", "error fetching folders", userContext.ID, err)
+		utils.RespondGenericServerErr(c, err, "could not retrieve folders")
+		return
+	}
+
+	folder := findFolder(folders, c.Param("id"))
+	if folder == nil {
+		utils.RespondClientErr(c, models.ErrFolderNotFound, http.StatusNotFound, "folder not found")
+		return
+	}
+
+	if request.Name != nil {
+		folder.Name, err = utils.SanitizeFilename(*request.Name)
+		if err != nil {
+			utils.RespondFieldErrs(c, err, "invalid folder name", utils.NewFieldError("name", err, models.ErrInvalidFilename))
+			return
+		}
+	}
+
+	if request.ParentID != nil {
+		parentID := rootToEmpty(*request.ParentID)
+		if parentID != "" && findFolder(folders, parentID) == nil {
+			utils.RespondFieldErrs(c, models.ErrFolderNotFound, "folder not found", models.FieldError{Field: "parent_id", Message: "is not an existing folder"})
+			return
+		}
+
+		// The new parent may not be the folder itself or anything below it. A missing
+		// ancestor ends the chain; the folder can't be above it.
+		for ancestor := parentID; ancestor != ""; {
+			if ancestor == folder.ID {
+				utils.RespondFieldErrs(c, models.ErrFolderCycle, "invalid move", models.FieldError{Field: "parent_id", Message: "cannot be the folder itself or one of its subfolders"})
+				return
+			}
+
+			parent := findFolder(folders, ancestor)
+			if parent == nil {
+				break
+			}
+			ancestor = parent.ParentID
+		}
+		folder.ParentID = parentID
+	}
+
+	if !srv.checkFolderNameFree(c, userContext.ID, folder.ParentID, folder.Name, folder.ID, "updateFolder") {
+		return
+	}
+
+	folder.UpdatedAt = time.Now().Unix()
+	if err := srv.DBHelper.UpdateFolder(c.Request.Context(), *folder); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			utils.RespondClientErr(c, models.ErrFolderNotFound, http.StatusNotFound, "folder not found")
+			return
+		}
+		utils.LogError("updateFolder", "error updating folder", folder, err)
+		utils.RespondGenericServerErr(c, err, "could not update folder")
+		return
+	}
+
+	utils.EncodeJSONBody(c, http.StatusOK, folder)
+}
+
+// deleteFolder deletes a folder with everything below it, giving all the freed storage back.
+func (srv *Server) deleteFolder(c *gin.Context) {
+	userContext := srv.MiddlewareProvider.UserFromContext(c.Request.Context())
+
+	unlock := srv.folderLocks.Lock(userContext.ID)
+	defer unlock()
+
+	folders, err := srv.DBHelper.GetFolders(c.Request.Context(), userContext.ID)
+	if err != nil {
+		utils.LogError("deleteFolder", "error fetching folders", userContext.ID, err)
+		utils.RespondGenericServerErr(c, err, "could not retrieve folders")
+		return
+	}
+
+	folder := findFolder(folders, c.Param("id"))
+	if folder == nil {
+		utils.RespondClientErr(c, models.ErrFolderNotFound, http.StatusNotFound, "folder not found")
+		return
+	}
+
+	folderIDs := []string{folder.ID}
+	for _, child := range descendantFolders(folders, folder.ID) {
+		folderIDs = append(folderIDs, child.ID)
+	}
+
+	files, err := srv.DBHelper.GetFilesInFolders(c.Request.Context(), userContext.ID, folderIDs)
+	if err != nil {
+		utils.LogError("deleteFolder", "error fetching files in folder", folder.ID, err)
+		utils.RespondGenericServerErr(c, err, "could not retrieve folder contents")
+		return
+	}
+
+	// Files go first, the same way deleteFile removes them. The folders are only removed
+	// once they are empty, so a failure never leaves files without a folder.
+	var freed, deletedFiles int64
+	for _, file := range files {
+		_, versions, err := srv.DBHelper.DeleteFile(c.Request.Context(), userContext.ID, file.ID)
+		if err != nil {
+			if errors.Is(err, models.ErrNotFound) {
+				continue
+			}
+			utils.LogError("deleteFolder", "error deleting file metadata", file.ID, err)
+			utils.RespondGenericServerErr(c, err, "could not delete folder, "+strconv.FormatInt(deletedFiles, 10)+" files were deleted")
+			return
+		}
+
+		for _, version := range versions {
+			srv.releaseBlob(c.Request.Context(), version.Path, version.Hash)
+			freed += version.Size
+		}
+		deletedFiles++
+	}
+
+	if err := srv.DBHelper.DeleteFolders(c.Request.Context(), userContext.ID, folderIDs); err != nil {
+		utils.LogError("deleteFolder", "error deleting folders", folder.ID, err)
+		utils.RespondGenericServerErr(c, err, "could not delete folder")
+		return
+	}
+
+	utils.EncodeJSONBody(c, http.StatusOK, map[string]interface{}{
+		"message": "folder deleted successfully",
+		"name":    folder.Name,
+		"folders": len(folderIDs),
+		"files":   deletedFiles,
+		"freed":   freed,
+	})
+}
+
+// updateFile renames a file and/or moves it into another folder. Its content is not touched.
+func (srv *Server) updateFile(c *gin.Context) {
+	userContext := srv.MiddlewareProvider.UserFromContext(c.Request.Context())
+
+	var request models.UpdateFileRequest
+
+	err := utils.DecodeJSONBody(c, &request)
+	if err != nil {
+		utils.RespondRequestBodyErr(c, err)
+		return
+	}
+
+	fileData, ok := srv.getFile(c, userContext.ID, "updateFile")
+	if !ok {
+		return
+	}
+
+	filename := fileData.Filename
+	if request.Filename != nil {
+		filename, err = utils.SanitizeFilename(*request.Filename)
+		if err != nil {
+			utils.RespondFieldErrs(c, err, "invalid filename", utils.NewFieldError("filename", err, models.ErrInvalidFilename))
+			return
+		}
+	}
+
+	folderID := fileData.FolderID
+	if request.FolderID != nil {
+		folderID = rootToEmpty(*request.FolderID)
+	}
+
+	// Taken under the same lock as uploads, so an upload can't claim the name meanwhile.
+	unlock := srv.fileLocks.Lock(fileLockKey(userContext.ID, folderID, filename))
+	defer unlock()
+
+	// The folder tree stays still until the file is moved, so the target folder can't be
+	// deleted in between. Like uploads, the file lock is taken first.
+	unlockFolders := srv.folderLocks.Lock(userContext.ID)
+	defer unlockFolders()
+
+	if request.FolderID != nil {
+		if _, ok := srv.resolveFolder(c, userContext.ID, folderID, "folder_id", "updateFile"); !ok {
+			return
+		}
+	}
+
+	existingFile, err := srv.DBHelper.GetFileByName(c.Request.Context(), userContext.ID, folderID, filename)
+	if err != nil && !errors.Is(err, models.ErrNotFound) {
+		utils.LogError("updateFile", "error searching for file by name", filename, err)
+		utils.RespondGenericServerErr(c, err, "could not update file")
+		return
+	}
+	if existingFile != nil && existingFile.ID != fileData.ID {
+		utils.RespondClientErr(c, models.ErrNameTaken, http.StatusConflict, "a file with this name already exists in the folder")
+		return
+	}
+
+	if err := srv.DBHelper.UpdateFileLocation(c.Request.Context(), userContext.ID, fileData.ID, folderID, filename); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			utils.RespondClientErr(c, err, http.StatusNotFound, "file not found")
+			return
+		}
+		// Another server instance took the name since it was checked.
+		if errors.Is(err, models.ErrNameTaken) {
+			utils.RespondClientErr(c, err, http.StatusConflict, "a file with this name already exists in the folder")
+			return
+		}
+		utils.LogError("updateFile", "error updating file", fileData.ID, err)
+		utils.RespondGenericServerErr(c, err, "could not update file")
+		return
+	}
+
+	fileData.Filename = filename
+	fileData.FolderID = folderID
+	utils.EncodeJSONBody(c, http.StatusOK, fileData)
+}
+
+// resolveFolder checks that the folder a request names exists and returns its ID, with
+// "" for the top level. It responds with an error when it doesn't.
+func (srv *Server) resolveFolder(c *gin.Context, userID, folderID, field, source string) (string, bool) {
+	folderID = rootToEmpty(folderID)
+	if folderID == "" {
+		return "", true
+	}
+
+	_, err := srv.DBHelper.GetFolder(c.Request.Context(), userID, folderID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			utils.RespondFieldErrs(c, models.ErrFolderNotFound, "folder not found", models.FieldError{Field: field, Message: "is not an existing folder"})
+			return "", false
+		}
+		utils.LogError(source, "error fetching folder", folderID, err)
+		utils.RespondGenericServerErr(c, err, "could not retrieve folder")
+		return "", false
+	}
+
+	return folderID, true
+}
+
+// checkFolderExists returns ErrFolderNotFound unless the folder exists. "" is the top
+// level, which always does.
+func (srv *Server) checkFolderExists(ctx context.Context, userID, folderID string) error {
+	if folderID == "" {
+		return nil
+	}
+
+	if _, err := srv.DBHelper.GetFolder(ctx, userID, folderID); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return models.ErrFolderNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// checkFolderNameFree responds with a conflict when another folder in the parent already has the name.
+func (srv *Server) checkFolderNameFree(c *gin.Context, userID, parentID, name, folderID, source string) bool {
+	existing, err := srv.DBHelper.GetFolderByName(c.Request.Context(), userID, parentID, name)
+	if err != nil && !errors.Is(err, models.ErrNotFound) {
+		utils.LogError(source, "error searching for folder by name", name, err)
+		utils.RespondGenericServerErr(c, err, "could not check folder name")
+		return false
+	}
+	if existing != nil && existing.ID != folderID {
+		utils.RespondClientErr(c, models.ErrNameTaken, http.StatusConflict, "a folder with this name already exists")
+		return false
+	}
+	return true
+}
+
+// fileLockKey is the key uploads and renames lock on, one per name in a folder.
+func fileLockKey(userID, folderID, filename string) string {
+	return userID + "/" + folderID + "/" + filename
+}
+
+// rootToEmpty maps the "root" alias used in requests to the "" stored for the top level.
+func rootToEmpty(folderID string) string {
+	if folderID == models.RootFolderID {
+		return ""
+	}
+	return folderID
+}
+
+func findFolder(folders []models.Folder, folderID string) *models.Folder {
+	for i := range folders {
+		if folders[i].ID == folderID {
+			return &folders[i]
+		}
+	}
+	return nil
+}
+
+// descendantFolders returns every folder below the given one, parents before their children.
+func descendantFolders(folders []models.Folder, folderID string) []models.Folder {
+	children := map[string][]models.Folder{}
+	for _, folder := range folders {
+		children[folder.ParentID] = append(children[folder.ParentID], folder)
+	}
+
+	descendants := []models.Folder{}
+	queue := []string{folderID}
+	for len(queue) > 0 {
+		for _, child := range children[queue[0]] {
+			descendants = append(descendants, child)
+			queue = append(queue, child.ID)
+		}
+		queue = queue[1:]
+	}
+	return descendants
+}
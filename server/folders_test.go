@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/file_upload/models"
+)
+
+func TestUpdateFolder(t *testing.T) {
+	srv, handler := newTestServer(t)
+	token := registerAndLogin(t, handler, "ada")
+
+	createFolder := func(name, parentID string) string {
+		t.Helper()
+		recorder, response := doJSON(t, handler, http.MethodPost, "/folders", token, map[string]string{"name": name, "parent_id": parentID})
+		if recorder.Code != http.StatusCreated {
+			t.Fatalf("create folder %q: status %d, body %v", name, recorder.Code, response)
+		}
+		return response["id"].(string)
+	}
+
+	outer := createFolder("outer", "")
+	inner := createFolder("inner", outer)
+
+	// A folder whose parent record is gone, as an interrupted delete could leave behind.
+	ada, err := srv.DBHelper.GetUserByUsername(context.Background(), "ada")
+	if err != nil {
+		t.Fatalf("GetUserByUsername: %v", err)
+	}
+	stray := models.Folder{ID: "stray-id", UserID: ada.ID, Name: "stray", ParentID: "missing-id"}
+	if err := srv.DBHelper.CreateFolder(context.Background(), stray); err != nil {
+		t.Fatalf("CreateFolder: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		folderID   string
+		parentID   string
+		wantStatus int
+	}{
+		{name: "into itself", folderID: outer, parentID: outer, wantStatus: http.StatusBadRequest},
+		{name: "into its subfolder", folderID: outer, parentID: inner, wantStatus: http.StatusBadRequest},
+		{name: "into a folder with a missing ancestor", folderID: inner, parentID: stray.ID, wantStatus: http.StatusOK},
+		{name: "to the top level", folderID: inner, parentID: models.RootFolderID, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder, response := doJSON(t, handler, http.MethodPatch, "/folders/"+tt.folderID, token, map[string]string{"parent_id": tt.parentID})
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d, body %v", recorder.Code, tt.wantStatus, response)
+			}
+		})
+	}
+}
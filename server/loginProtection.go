@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyPasswordHash is compared against when the username does not exist, so a login
+// for an unknown user takes as long as one with a wrong password.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not-a-real-password"), bcrypt.DefaultCost)
+
+func accountLoginKey(username string) string {
+	return "user:" + username
+}
+
+func ipLoginKey(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		ip = parsed.String()
+	}
+	return "ip:" + ip
+}
+
+// lockLoginKeys makes logins sharing a key run one at a time, so the lockout check, the
+// password check and recording the failure can't be overtaken by parallel attempts.
+// Keys are locked in order, so logins sharing two keys can't deadlock.
+func (srv *Server) lockLoginKeys(keys ...string) func() {
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+
+	unlocks := make([]func(), 0, len(sorted))
+	for _, key := range sorted {
+		unlocks = append(unlocks, srv.loginLocks.Lock(key))
+	}
+
+	return func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+}
+
+// loginLockedFor returns how much longer logins for any of the keys are locked, or 0.
+func (srv *Server) loginLockedFor(ctx context.Context, keys ...string) (time.Duration, error) {
+	var lockedFor time.Duration
+
+	now := time.Now()
+	for _, key := range keys {
+		attempt, err := srv.DBHelper.GetLoginAttempt(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+
+		if remaining := time.Unix(attempt.LockedUntil, 0).Sub(now); remaining > lockedFor {
+			lockedFor = remaining
+		}
+	}
+
+	return lockedFor, nil
+}
+
+// recordLoginFailure counts a failed login against each key, locking a key once it has
+// failed too often in a row. Each further failure doubles the lockout, up to the maximum.
+// Failures are recorded even if the client disconnects, so that can't dodge the lockout.
+func (srv *Server) recordLoginFailure(ctx context.Context, keys ...string) {
+	ctx = context.WithoutCancel(ctx)
+	maxFailures, baseLockout, maxLockout, failureWindow := srv.loginProtection()
+
+	now := time.Now()
+	for _, key := range keys {
+		attempt, err := srv.DBHelper.RecordLoginFailure(ctx, key, now.Unix(), now.Add(-failureWindow).Unix())
+		if err != nil {
+			utils.LogError("recordLoginFailure", "error recording failed login", key, err)
+			continue
+		}
+
+		if attempt.Failures < maxFailures {
+			continue
+		}
+
+		lockout := maxLockout
+		if doublings := attempt.Failures - maxFailures; doublings < 32 {
+			if scaled := baseLockout << doublings; scaled > 0 && scaled < maxLockout {
+				lockout = scaled
+			}
+		}
+
+		lockedUntil := now.Add(lockout)
+		if err := srv.DBHelper.LockLogin(ctx, key, lockedUntil.Unix(), lockedUntil.Add(failureWindow).Unix()); err != nil {
+			utils.LogError("recordLoginFailure", "error locking logins", fmt.Sprintf("Key: %s, Lockout: %v", key, lockout), err)
+		}
+	}
+}
+
+// clearLoginFailures forgets the failed logins of a key, e.g. after a successful login.
+func (srv *Server) clearLoginFailures(ctx context.Context, key string) {
+	if err := srv.DBHelper.ClearLoginAttempts(ctx, key); err != nil {
+		utils.LogError("clearLoginFailures", "error clearing failed logins", key, err)
+	}
+}
+
+// loginProtection returns the configured lockout settings, falling back to the defaults.
+func (srv *Server) loginProtection() (maxFailures int64, baseLockout, maxLockout, failureWindow time.Duration) {
+	settings := srv.Config.LoginProtection
+
+	maxFailures = models.DefaultLoginMaxFailures
+	if settings.MaxFailures > 0 {
+		maxFailures = settings.MaxFailures
+	}
+
+	baseLockout = models.DefaultLoginBaseLockout
+	if settings.BaseLockoutSeconds > 0 {
+		baseLockout = time.Duration(settings.BaseLockoutSeconds) * time.Second
+	}
+
+	maxLockout = models.DefaultLoginMaxLockout
+	if settings.MaxLockoutSeconds > 0 {
+		maxLockout = time.Duration(settings.MaxLockoutSeconds) * time.Second
+	}
+
+	failureWindow = models.DefaultLoginFailureWindow
+	if settings.ResetAfterMinutes > 0 {
+		failureWindow = time.Duration(settings.ResetAfterMinutes) * time.Minute
+	}
+
+	return maxFailures, baseLockout, maxLockout, failureWindow
+}
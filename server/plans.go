@@ -0,0 +1,83 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultPlanName is used when no plans are configured.
+const defaultPlanName = "default"
+
+// plan returns the configured plan with the name, with its limits in bytes.
+func (srv *Server) plan(name string) (models.Plan, bool) {
+	planConfig, ok := srv.Config.Plans[name]
+	if !ok {
+		return models.Plan{}, false
+	}
+
+	return models.Plan{
+		Name:        name,
+		Quota:       planConfig.QuotaMB * 1024 * 1024,
+		MaxFileSize: planConfig.MaxFileSizeMB * 1024 * 1024,
+		MaxFiles:    planConfig.MaxFiles,
+	}, true
+}
+
+// defaultPlan is the plan new users are put on. Without configured plans it only
+// limits total storage, to DefaultUserQuotaMB.
+func (srv *Server) defaultPlan() models.Plan {
+	if plan, ok := srv.plan(srv.Config.DefaultPlan); ok {
+		return plan
+	}
+
+	return models.Plan{
+		Name:  defaultPlanName,
+		Quota: srv.Config.DefaultUserQuotaMB * 1024 * 1024,
+	}
+}
+
+// uploadSizeLimit is the largest file the user's plan accepts at all: the per-file limit,
+// or with none the whole quota. It leaves out used storage, which the authenticated user
+// may have cached for a few seconds; reserving the storage checks that.
+func uploadSizeLimit(user *models.UserContext) int64 {
+	if user.MaxFileSize > 0 && user.MaxFileSize < user.Quota {
+		return user.MaxFileSize
+	}
+	return user.Quota
+}
+
+// maxUploadSize is the largest file the user can upload right now: what is left of
+// their quota, capped by the per-file limit of their plan.
+func maxUploadSize(user *models.UserContext) int64 {
+	remaining := user.Quota - user.UsedStorage
+	if remaining < 0 {
+		remaining = 0
+	}
+	if user.MaxFileSize > 0 && user.MaxFileSize < remaining {
+		return user.MaxFileSize
+	}
+	return remaining
+}
+
+// listPlans returns the plans users can be put on.
+func (srv *Server) listPlans(c *gin.Context) {
+
+	plans := make([]models.Plan, 0, len(srv.Config.Plans))
+	for name := range srv.Config.Plans {
+		plan, _ := srv.plan(name)
+		plans = append(plans, plan)
+	}
+
+	sort.Slice(plans, func(i, j int) bool {
+		return plans[i].Quota < plans[j].Quota
+	})
+
+	utils.EncodeJSONBody(c, http.StatusOK, map[string]interface{}{
+		"default": srv.defaultPlan().Name,
+		"plans":   plans,
+	})
+}
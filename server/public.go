@@ -1,17 +1,18 @@
 package server
 
 import (
-	"bytes"
-	"crypto/sha256"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
-	"github.com/file_upload/providers/authProvider"
 	"github.com/file_upload/utils"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
@@ -24,187 +25,614 @@ func (srv *Server) login(c *gin.Context) {
 
 	var usernameAndPassword models.UsernameAndPassword
 
-	err := json.NewDecoder(c.Request.Body).Decode(&usernameAndPassword)
+	err := utils.DecodeJSONBody(c, &usernameAndPassword)
 	if err != nil {
-		utils.LogError("login", "error decoding request body", "", err)
-		utils.RespondClientErr(c, err, http.StatusBadRequest, "error decoding request body")
+		utils.RespondRequestBodyErr(c, err)
 		return
 	}
 
-	userDetail, err := srv.DBHelper.GetUserByUsername(usernameAndPassword.Username)
+	// A username that fails validation is only counted against the client IP, so random
+	// usernames can't fill the failed login records. Such names are still looked up, as
+	// accounts registered before usernames were validated may have one.
+	validUsername := utils.ValidateUsername(usernameAndPassword.Username) == nil
+	loginKeys := []string{ipLoginKey(c.ClientIP())}
+	if validUsername {
+		loginKeys = append(loginKeys, accountLoginKey(usernameAndPassword.Username))
+	}
+
+	unlock := srv.lockLoginKeys(loginKeys...)
+	defer unlock()
+
+	lockedFor, err := srv.loginLockedFor(c.Request.Context(), loginKeys...)
 	if err != nil {
-		utils.LogError("login", "error invalid username", usernameAndPassword, err)
-		utils.RespondGenericServerErr(c, err, "error invalid username")
+		utils.LogError("login", "error checking login lockout", usernameAndPassword.Username, err)
+		utils.RespondGenericServerErr(c, err, "error checking login lockout")
+		return
+	}
+	if lockedFor > 0 {
+		c.Header("Retry-After", strconv.FormatInt(int64(math.Ceil(lockedFor.Seconds())), 10))
+		utils.RespondClientErr(c, errors.New("login locked"), http.StatusTooManyRequests, "too many failed login attempts, try again later")
+		return
+	}
+
+	// Unknown usernames and wrong passwords get the same response, after the same work.
+	userDetail, err := srv.DBHelper.GetUserByUsername(c.Request.Context(), usernameAndPassword.Username)
+	if err != nil && !errors.Is(err, models.ErrNotFound) {
+		utils.LogError("login", "error fetching user", usernameAndPassword.Username, err)
+		utils.RespondGenericServerErr(c, err, "error fetching user")
+		return
+	}
+
+	passwordHash := dummyPasswordHash
+	if err == nil {
+		passwordHash = []byte(userDetail.Password)
+	}
+
+	if bcrypt.CompareHashAndPassword(passwordHash, []byte(usernameAndPassword.Password)) != nil || err != nil {
+		utils.LogWarning("login", "failed login", usernameAndPassword.Username)
+		srv.recordLoginFailure(c.Request.Context(), loginKeys...)
+		utils.RespondClientErr(c, errors.New(models.LoginFailedMsg), http.StatusUnauthorized, models.LoginFailedMsg)
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(userDetail.Password), []byte(usernameAndPassword.Password)); err != nil {
-		utils.LogError("login", "invalid password", usernameAndPassword, err)
-		utils.RespondClientErr(c, err, http.StatusBadRequest, "invalid password")
+	srv.clearLoginFailures(c.Request.Context(), accountLoginKey(userDetail.Username))
+
+	if userDetail.Disabled {
+		utils.RespondClientErr(c, errors.New("account disabled"), http.StatusForbidden, "account disabled")
 		return
 	}
 
-	session, err := srv.DBHelper.CreateUserSession(userDetail.ID)
+	srv.evictOldestSessions(c.Request.Context(), userDetail.ID)
+
+	session, err := srv.DBHelper.CreateUserSession(c.Request.Context(), userDetail.ID, sessionClient(c))
 	if err != nil {
-		utils.LogError("login", "error creating user session", usernameAndPassword, err)
+		utils.LogError("login", "error creating user session", usernameAndPassword.Username, err)
 		utils.RespondGenericServerErr(c, err, "error creating user session")
 		return
 	}
 
-	token, err := authProvider.GenerateJWT(userDetail, session.Token)
+	token, err := srv.AuthProvider.GenerateJWT(userDetail, session.Token)
 	if err != nil {
-		utils.LogError("login", "error creating user's auth token", usernameAndPassword, err)
+		utils.LogError("login", "error creating user's auth token", usernameAndPassword.Username, err)
 		utils.RespondGenericServerErr(c, err, "error creating user's auth token")
 		return
 	}
 
 	utils.EncodeJSONBody(c, http.StatusOK, map[string]interface{}{
-		"token":  token,
-		"userID": userDetail.ID,
+		"token":         token,
+		"refresh_token": session.RefreshToken,
+		"userID":        userDetail.ID,
 	})
 }
 
-func (srv *Server) createNewUser(c *gin.Context) {
+// refreshToken exchanges a refresh token for a new access token and a new refresh token.
+func (srv *Server) refreshToken(c *gin.Context) {
 
-	var user models.User
+	var request models.RefreshTokenRequest
 
-	err := json.NewDecoder(c.Request.Body).Decode(&user)
+	err := utils.DecodeJSONBody(c, &request)
 	if err != nil {
-		utils.LogError("createNewUser", "error decoding request body", "", err)
-		utils.RespondClientErr(c, err, http.StatusBadRequest, "error decoding request body")
+		utils.RespondRequestBodyErr(c, err)
 		return
 	}
 
-	hash, _ := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
-	user.Password = string(hash)
-	user.ID = uuid.NewString()
-	user.CreatedAt = time.Now().Unix()
-	user.Quota = srv.Config.DefaultUserQuotaMB * 1024 * 1024
+	session, err := srv.DBHelper.RotateRefreshToken(c.Request.Context(), request.RefreshToken, sessionClient(c))
+	if err != nil {
+		if errors.Is(err, models.ErrRefreshTokenReused) {
+			srv.MiddlewareProvider.InvalidateUser(session.UserID)
+		}
+		if errors.Is(err, models.ErrInvalidRefreshToken) || errors.Is(err, models.ErrRefreshTokenReused) {
+			utils.RespondClientErr(c, err, http.StatusUnauthorized, "invalid refresh token")
+			return
+		}
+		utils.LogError("refreshToken", "error rotating refresh token", "", err)
+		utils.RespondGenericServerErr(c, err, "error refreshing session")
+		return
+	}
+
+	// The session the refresh token belonged to has ended.
+	srv.MiddlewareProvider.InvalidateUser(session.UserID)
 
-	err = srv.DBHelper.CreateUser(user)
+	userDetail, err := srv.DBHelper.GetUserByID(c.Request.Context(), session.UserID)
 	if err != nil {
-		utils.LogError("createNewUser", "error inserting user in the server database", user, err)
-		utils.RespondGenericServerErr(c, err, "error inserting user in the server database")
+		utils.LogError("refreshToken", "error fetching session user", session.UserID, err)
+		utils.RespondGenericServerErr(c, err, "error refreshing session")
+		return
+	}
+
+	if userDetail.Disabled {
+		if err := srv.endSession(c.Request.Context(), session); err != nil {
+			utils.LogError("refreshToken", "error ending session of disabled user", session.UserID, err)
+		}
+		utils.RespondClientErr(c, errors.New("account disabled"), http.StatusForbidden, "account disabled")
+		return
+	}
+
+	token, err := srv.AuthProvider.GenerateJWT(userDetail, session.Token)
+	if err != nil {
+		utils.LogError("refreshToken", "error creating user's auth token", session.UserID, err)
+		utils.RespondGenericServerErr(c, err, "error creating user's auth token")
 		return
 	}
 
 	utils.EncodeJSONBody(c, http.StatusOK, map[string]interface{}{
-		"message": "successfully added user",
+		"token":         token,
+		"refresh_token": session.RefreshToken,
+		"userID":        userDetail.ID,
 	})
 }
 
-func (srv *Server) remainingStorage(c *gin.Context) {
-	user := srv.MiddlewareProvider.UserFromContext(c.Request.Context())
+func (srv *Server) logout(c *gin.Context) {
+	userContext := srv.MiddlewareProvider.UserFromContext(c.Request.Context())
+
+	err := srv.DBHelper.EndUserSession(c.Request.Context(), userContext.SessionID)
+	if err != nil {
+		utils.LogError("logout", "error ending user session", userContext.SessionID, err)
+		utils.RespondGenericServerErr(c, err, "error ending user session")
+		return
+	}
+	srv.MiddlewareProvider.InvalidateSession(userContext.SessionID)
 
 	utils.EncodeJSONBody(c, http.StatusOK, map[string]interface{}{
-		"total":     user.Quota,
-		"used":      user.UsedStorage,
-		"remaining": user.Quota - user.UsedStorage,
+		"message": "successfully logged out",
 	})
 }
 
-func (srv *Server) uploadFile(c *gin.Context) {
+func (srv *Server) createNewUser(c *gin.Context) {
 
-	userContext := srv.MiddlewareProvider.UserFromContext(c.Request.Context())
+	var request models.RegisterRequest
 
-	dirPath := fmt.Sprintf("%s/%s", models.DefaultDirectory, userContext.Username)
-	err := utils.CreateDirIfNotExist(dirPath)
+	err := utils.DecodeJSONBody(c, &request)
 	if err != nil {
-		log.Fatalf("Error ensuring directory exists: %v", err)
+		utils.RespondRequestBodyErr(c, err)
+		return
 	}
 
-	file, header, err := c.Request.FormFile("file")
-	if err != nil {
-		utils.LogError("uploadFile", "error getting file from form", "", err)
-		utils.RespondClientErr(c, err, http.StatusBadRequest, "file not found in form data")
+	if err := utils.ValidatePassword(request.Password, srv.Config.PasswordPolicy); err != nil {
+		utils.RespondFieldErrs(c, err, "password does not meet the policy", utils.NewFieldError("password", err, models.ErrWeakPassword))
 		return
 	}
-	defer file.Close()
 
-	fmt.Println("file -", file, header.Size, header.Filename)
+	hash, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+	if err != nil {
+		utils.LogError("createNewUser", "error hashing password", request.Username, err)
+		utils.RespondGenericServerErr(c, err, "error hashing password")
+		return
+	}
 
-	// TODO: checking if any files are uploading again.
-	// Reason: the memroy will increase but file will not add in the directory, or we will do one thing, change the name of the file.
-	var fileBuffer bytes.Buffer
-	tee := io.TeeReader(file, &fileBuffer)
+	plan := srv.defaultPlan()
+	user := models.User{
+		ID:          uuid.NewString(),
+		Name:        request.Name,
+		Username:    request.Username,
+		Password:    string(hash),
+		CreatedAt:   time.Now().Unix(),
+		Role:        models.RoleUser,
+		Plan:        plan.Name,
+		Quota:       plan.Quota,
+		MaxFileSize: plan.MaxFileSize,
+		MaxFiles:    plan.MaxFiles,
+	}
 
-	hash := sha256.New()
-	if _, err := io.Copy(hash, tee); err != nil {
-		utils.RespondGenericServerErr(c, err, "failed to compute file hash")
+	err = srv.DBHelper.CreateUser(c.Request.Context(), user)
+	if err != nil {
+		if errors.Is(err, models.ErrUsernameTaken) {
+			utils.RespondClientErr(c, err, http.StatusConflict, "username already taken")
+			return
+		}
+		utils.LogError("createNewUser", "error inserting user in the server database", user.Username, err)
+		utils.RespondGenericServerErr(c, err, "error inserting user in the server database")
 		return
 	}
-	fileHash := fmt.Sprintf("%x", hash.Sum(nil))
 
-	// check anmy file are present with same hash or not.
-	existingFile, err := srv.DBHelper.GetFileByHash(userContext.ID, fileHash)
-	if err == nil && existingFile != nil {
-		utils.RespondClientErr(c, fmt.Errorf("duplicate file"), http.StatusConflict, "file already uploaded")
+	utils.EncodeJSONBody(c, http.StatusOK, map[string]interface{}{
+		"message": "successfully added user",
+	})
+}
+
+func (srv *Server) remainingStorage(c *gin.Context) {
+	userContext := srv.MiddlewareProvider.UserFromContext(c.Request.Context())
+
+	// The usage in the request context may come from the session cache; report the current one.
+	userDetail, err := srv.DBHelper.GetUserByID(c.Request.Context(), userContext.ID)
+	if err != nil {
+		utils.LogError("remainingStorage", "error fetching user", userContext.ID, err)
+		utils.RespondGenericServerErr(c, err, "could not retrieve storage usage")
 		return
 	}
 
-	fileReader := bytes.NewReader(fileBuffer.Bytes())
+	user := *userContext
+	user.UsedStorage = userDetail.UsedStorage
+	user.FileCount = userDetail.FileCount
+
+	var filesRemaining interface{}
+	if user.MaxFiles > 0 {
+		filesRemaining = user.MaxFiles - user.FileCount
+	}
+
+	utils.EncodeJSONBody(c, http.StatusOK, map[string]interface{}{
+		"plan":            user.Plan,
+		"total":           user.Quota,
+		"used":            user.UsedStorage,
+		"remaining":       user.Quota - user.UsedStorage,
+		"max_file_size":   user.MaxFileSize,
+		"max_upload_size": maxUploadSize(&user),
+		"max_files":       user.MaxFiles,
+		"files":           user.FileCount,
+		"files_remaining": filesRemaining,
+	})
+}
 
-	if header.Size+userContext.UsedStorage > userContext.Quota {
-		utils.RespondClientErr(c, fmt.Errorf("alert, User don't have storage to store the file :%v, size: %v, you want ", header.Filename, header.Size), http.StatusBadRequest, "insufficient Storage")
+func (srv *Server) uploadFile(c *gin.Context) {
+
+	userContext := srv.MiddlewareProvider.UserFromContext(c.Request.Context())
+
+	part, err := multipartFilePart(c.Request, "file")
+	if err != nil {
+		utils.LogError("uploadFile", "error getting file from form", "", err)
+		utils.RespondClientErr(c, err, http.StatusBadRequest, "file not found in form data")
 		return
 	}
+	defer part.Close()
 
-	uploadPath := fmt.Sprintf("%s/%s", dirPath, header.Filename)
-	outFile, err := os.Create(uploadPath)
+	filename, err := utils.SanitizeFilename(part.FileName())
 	if err != nil {
-		utils.LogError("uploadFile", "error saving file", "", err)
-		utils.RespondGenericServerErr(c, err, "unable to save uploaded file")
+		utils.RespondFieldErrs(c, err, "invalid filename", utils.NewFieldError("file", err, models.ErrInvalidFilename))
+		return
+	}
+
+	folderID, ok := srv.resolveFolder(c, userContext.ID, c.Query("folder_id"), "folder_id", "uploadFile")
+	if !ok {
 		return
 	}
-	defer outFile.Close()
 
-	_, err = io.Copy(outFile, fileReader)
+	// Stream the upload to a temp file inside storage/ while hashing it, reading at most
+	// one byte past what the plan allows so oversized uploads are cut off early. Whether
+	// it fits in what is left of the quota is decided when storeFile reserves the storage.
+	limit := uploadSizeLimit(userContext)
+	tempPath, fileHash, size, err := utils.StreamToTempFile(srv.stagingDir, utils.ContextReader(c.Request.Context(), io.LimitReader(part, limit+1)))
 	if err != nil {
-		utils.LogError("uploadFile", "error copying file data", "", err)
+		// A client that goes away breaks the body as well as cancelling the request.
+		if ctxErr := c.Request.Context().Err(); ctxErr != nil {
+			respondStoreFileErr(c, "uploadFile", filename, size, ctxErr)
+			return
+		}
+		utils.LogError("uploadFile", "error streaming file to storage", filename, err)
 		utils.RespondGenericServerErr(c, err, "error while saving file")
 		return
 	}
+	defer os.Remove(tempPath)
 
-	err = srv.DBHelper.UpdateStorageData(userContext.ID, header.Size+userContext.UsedStorage)
-	if err != nil {
-		utils.RespondGenericServerErr(c, err, "error while update the storage the file")
+	if userContext.MaxFileSize > 0 && size > userContext.MaxFileSize {
+		respondStoreFileErr(c, "uploadFile", filename, size, models.ErrFileTooLarge)
 		return
 	}
 
-	newFile := models.File{
-		ID:         uuid.NewString(),
-		UserID:     userContext.ID,
-		Filename:   header.Filename,
-		Size:       header.Size,
-		Path:       uploadPath,
-		Hash:       fileHash,
-		UploadedAt: time.Now().Unix(),
+	if size > limit {
+		respondStoreFileErr(c, "uploadFile", filename, size, models.ErrInsufficientStorage)
+		return
 	}
 
-	if err := srv.DBHelper.InsertFileMetadata(newFile); err != nil {
-		utils.RespondGenericServerErr(c, err, "failed to save file metadata")
+	newFile, err := srv.storeFile(c.Request.Context(), userContext, tempPath, folderID, filename, fileHash, size)
+	if err != nil {
+		respondStoreFileErr(c, "uploadFile", filename, size, err)
 		return
 	}
 
 	utils.EncodeJSONBody(c, http.StatusOK, map[string]interface{}{
 		"message":  "file uploaded successfully",
-		"filename": header.Filename,
+		"filename": filename,
+		"fileID":   newFile.ID,
+		"folderID": newFile.FolderID,
+		"version":  newFile.Version,
 		"userID":   userContext.ID,
 	})
 }
 
+// multipartFilePart returns the named file part of a multipart request without parsing
+// the whole form, so the body can be streamed instead of buffered.
+func multipartFilePart(r *http.Request, fieldName string) (*multipart.Part, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			if err == io.EOF {
+				return nil, http.ErrMissingFile
+			}
+			return nil, err
+		}
+
+		if part.FormName() == fieldName && part.FileName() != "" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+// getUserFiles returns a page of the user's files, newest first unless another order is asked for.
 func (srv *Server) getUserFiles(c *gin.Context) {
 	userContext := srv.MiddlewareProvider.UserFromContext(c.Request.Context())
 
-	files, err := srv.DBHelper.GetFilesByUser(userContext.ID)
+	var request models.ListFilesRequest
+
+	err := utils.DecodeQuery(c, &request)
 	if err != nil {
+		utils.RespondRequestBodyErr(c, err)
+		return
+	}
+
+	if request.MinSize != nil && request.MaxSize != nil && *request.MinSize > *request.MaxSize {
+		utils.RespondFieldErrs(c, errors.New("min_size is greater than max_size"), "invalid query parameters",
+			models.FieldError{Field: "max_size", Message: "must not be less than min_size"})
+		return
+	}
+
+	if request.FolderID != nil {
+		folderID := rootToEmpty(*request.FolderID)
+		request.FolderID = &folderID
+	}
+
+	query := models.FileQuery{
+		UserID:         userContext.ID,
+		FolderID:       request.FolderID,
+		NamePrefix:     request.NamePrefix,
+		ContentType:    request.ContentType,
+		MinSize:        request.MinSize,
+		MaxSize:        request.MaxSize,
+		UploadedAfter:  request.UploadedAfter,
+		UploadedBefore: request.UploadedBefore,
+		SortBy:         request.Sort,
+		Descending:     request.Order == "desc",
+		Limit:          request.Limit,
+		Cursor:         request.Cursor,
+	}
+	if query.SortBy == "" {
+		query.SortBy = models.FileSortByDate
+		query.Descending = request.Order != "asc"
+	}
+
+	page, err := srv.DBHelper.ListFiles(c.Request.Context(), query)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCursor) {
+			utils.RespondFieldErrs(c, err, "invalid query parameters", utils.NewFieldError("cursor", err, models.ErrInvalidCursor))
+			return
+		}
 		utils.LogError("getUserFiles", "fetching user files", "", err)
 		utils.RespondGenericServerErr(c, err, "could not retrieve user files")
 		return
 	}
 
 	utils.EncodeJSONBody(c, http.StatusOK, map[string]interface{}{
-		"user_id": userContext.ID,
-		"files":   files,
+		"user_id":     userContext.ID,
+		"files":       page.Files,
+		"total":       page.Total,
+		"next_cursor": page.NextCursor,
 	})
 }
+
+func (srv *Server) downloadFile(c *gin.Context) {
+	userContext := srv.MiddlewareProvider.UserFromContext(c.Request.Context())
+
+	fileData, ok := srv.getFile(c, userContext.ID, "downloadFile")
+	if !ok {
+		return
+	}
+
+	srv.serveStoredFile(c, fileData.Filename, fileData.Path, fileData.Hash, fileData.UploadedAt)
+}
+
+// serveStoredFile streams stored content to the client. Range, If-Range and
+// If-None-Match are handled by http.ServeContent once the ETag is set.
+func (srv *Server) serveStoredFile(c *gin.Context, filename, path, hash string, uploadedAt int64) {
+	content, err := srv.Storage.Get(utils.StorageKey(path))
+	if err != nil {
+		utils.LogError("serveStoredFile", "error opening file from storage", path, err)
+		utils.RespondGenericServerErr(c, err, "could not open stored file")
+		return
+	}
+	defer content.Close()
+
+	c.Header("ETag", fmt.Sprintf("%q", hash))
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+
+	http.ServeContent(c.Writer, c.Request, filename, time.Unix(uploadedAt, 0), content)
+}
+
+func (srv *Server) deleteFile(c *gin.Context) {
+	userContext := srv.MiddlewareProvider.UserFromContext(c.Request.Context())
+	fileID := c.Param("id")
+
+	// The metadata and the quota go first, together. The blob is only released afterwards,
+	// so a failure here leaves at worst unreferenced content on disk, never a wrong quota.
+	deletedFile, versions, err := srv.DBHelper.DeleteFile(c.Request.Context(), userContext.ID, fileID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			utils.RespondClientErr(c, err, http.StatusNotFound, "file not found")
+			return
+		}
+		utils.LogError("deleteFile", "error deleting file metadata", fileID, err)
+		utils.RespondGenericServerErr(c, err, "could not delete file")
+		return
+	}
+
+	var freed int64
+	for _, version := range versions {
+		srv.releaseBlob(c.Request.Context(), version.Path, version.Hash)
+		freed += version.Size
+	}
+
+	utils.EncodeJSONBody(c, http.StatusOK, map[string]interface{}{
+		"message":  "file deleted successfully",
+		"filename": deletedFile.Filename,
+		"versions": len(versions),
+		"freed":    freed,
+	})
+}
+
+// releaseStorage hands back a reservation made for an upload that did not complete.
+func (srv *Server) releaseStorage(ctx context.Context, userID string, size, files int64) {
+	// Give the storage back even when the request that reserved it was cancelled.
+	if err := srv.DBHelper.ReleaseStorage(context.WithoutCancel(ctx), userID, size, files); err != nil {
+		utils.LogError("releaseStorage", "error releasing reserved storage", fmt.Sprintf("UserID: %s, Size: %d, Files: %d", userID, size, files), err)
+	}
+}
+
+// storeFile turns a fully received temp file into a stored File: it rejects duplicates,
+// reserves the quota, moves the data into the blob store and records the metadata.
+// Uploading under the name of an existing file in the folder adds a new version of it. Every step is
+// undone if a later one fails, including when ctx is cancelled before the data is stored.
+func (srv *Server) storeFile(ctx context.Context, userContext *models.UserContext, tempPath, folderID, filename, fileHash string, size int64) (models.File, error) {
+
+	var newFile models.File
+
+	// Serialise uploads of the same name, so they can't both create a new file.
+	unlock := srv.fileLocks.Lock(fileLockKey(userContext.ID, folderID, filename))
+	defer unlock()
+
+	// The folder may have been deleted while a resumable upload was in progress.
+	if err := srv.checkFolderExists(ctx, userContext.ID, folderID); err != nil {
+		return newFile, err
+	}
+
+	existingFile, err := srv.DBHelper.GetFileByName(ctx, userContext.ID, folderID, filename)
+	if err != nil && !errors.Is(err, models.ErrNotFound) {
+		return newFile, err
+	}
+
+	// check anmy file are present with same hash or not.
+	duplicateFile, err := srv.DBHelper.GetFileByHash(ctx, userContext.ID, fileHash)
+	if err == nil && duplicateFile != nil {
+		return newFile, models.ErrDuplicateFile
+	}
+
+	if userContext.MaxFileSize > 0 && size > userContext.MaxFileSize {
+		return newFile, models.ErrFileTooLarge
+	}
+
+	// A new version of an existing file does not count against the file limit.
+	var files int64 = 1
+	if existingFile != nil {
+		files = 0
+	}
+
+	// Nothing has been changed yet, so a cancelled upload can stop here for free.
+	if err := ctx.Err(); err != nil {
+		return newFile, err
+	}
+
+	// Reserve the quota up front; concurrent uploads can no longer both pass a stale check.
+	// Every user pays for their own copy even when the content is shared.
+	err = srv.DBHelper.ReserveStorage(ctx, userContext.ID, size, files)
+	if err != nil {
+		return newFile, err
+	}
+
+	blobKey, err := srv.putBlob(ctx, tempPath, fileHash, size)
+	if err != nil {
+		srv.releaseStorage(ctx, userContext.ID, size, files)
+		return newFile, err
+	}
+
+	version := models.FileVersion{
+		ID:          uuid.NewString(),
+		UserID:      userContext.ID,
+		Size:        size,
+		Path:        blobKey,
+		Hash:        fileHash,
+		ContentType: utils.DetectContentType(tempPath, filename),
+		UploadedAt:  time.Now().Unix(),
+	}
+
+	// Record the file under the folder lock, checking again that the folder is there, so
+	// a folder delete can't leave it behind in a folder that no longer exists.
+	unlockFolders := srv.folderLocks.Lock(userContext.ID)
+	defer unlockFolders()
+
+	if err := srv.checkFolderExists(ctx, userContext.ID, folderID); err != nil {
+		srv.releaseBlob(ctx, blobKey, fileHash)
+		srv.releaseStorage(ctx, userContext.ID, size, files)
+		return newFile, err
+	}
+
+	if existingFile != nil {
+		version.FileID = existingFile.ID
+
+		version, err = srv.DBHelper.AddFileVersion(ctx, version)
+		if err != nil {
+			srv.releaseBlob(ctx, blobKey, fileHash)
+			srv.releaseStorage(ctx, userContext.ID, size, files)
+			return newFile, err
+		}
+
+		return fileAtVersion(*existingFile, version), nil
+	}
+
+	version.FileID = uuid.NewString()
+	version.Version = 1
+
+	newFile = models.File{
+		ID:          version.FileID,
+		UserID:      userContext.ID,
+		Filename:    filename,
+		FolderID:    folderID,
+		Size:        size,
+		Path:        blobKey,
+		Hash:        fileHash,
+		ContentType: version.ContentType,
+		UploadedAt:  version.UploadedAt,
+		Version:     version.Version,
+		LastVersion: version.Version,
+	}
+
+	if err := srv.DBHelper.InsertFileMetadata(ctx, newFile, version); err != nil {
+		srv.releaseBlob(ctx, blobKey, fileHash)
+		srv.releaseStorage(ctx, userContext.ID, size, files)
+		return newFile, err
+	}
+
+	return newFile, nil
+}
+
+// fileAtVersion returns the file as it looks with the given version current.
+func fileAtVersion(file models.File, version models.FileVersion) models.File {
+	file.Size = version.Size
+	file.Path = version.Path
+	file.Hash = version.Hash
+	file.ContentType = version.ContentType
+	file.UploadedAt = version.UploadedAt
+	file.Version = version.Version
+	return file
+}
+
+// respondStoreFileErr maps the errors returned by storeFile to a response.
+func respondStoreFileErr(c *gin.Context, source, filename string, size int64, err error) {
+	switch {
+	case errors.Is(err, models.ErrDuplicateFile):
+		utils.RespondClientErr(c, err, http.StatusConflict, "file already uploaded")
+	case errors.Is(err, models.ErrFolderNotFound):
+		utils.RespondClientErr(c, err, http.StatusNotFound, "folder not found")
+	case errors.Is(err, models.ErrNameTaken):
+		utils.RespondClientErr(c, err, http.StatusConflict, "a file with this name already exists in the folder")
+	case errors.Is(err, models.ErrFileTooLarge):
+		utils.RespondClientErr(c, fmt.Errorf("file %v is larger than the plan allows, size: %v", filename, size), http.StatusRequestEntityTooLarge, "file too large for your plan")
+	case errors.Is(err, models.ErrFileLimitReached):
+		utils.RespondClientErr(c, fmt.Errorf("file limit reached, cannot add file %v", filename), http.StatusBadRequest, "file limit of your plan reached")
+	case errors.Is(err, models.ErrInsufficientStorage):
+		utils.RespondClientErr(c, fmt.Errorf("alert, User don't have storage to store the file :%v, size: %v, you want ", filename, size), http.StatusBadRequest, "insufficient Storage")
+	case errors.Is(err, context.Canceled):
+		utils.LogWarning(source, "upload cancelled by the client", filename, err)
+		c.AbortWithStatus(models.StatusClientClosedRequest)
+	default:
+		utils.LogError(source, "error storing uploaded file", filename, err)
+		utils.RespondGenericServerErr(c, err, "unable to save uploaded file")
+	}
+}
+
+// jwks publishes the public signing keys so other services can verify access tokens.
+func (srv *Server) jwks(c *gin.Context) {
+	utils.EncodeJSONBody(c, http.StatusOK, srv.AuthProvider.JWKS())
+}
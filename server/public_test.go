@@ -0,0 +1,496 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/file_upload/config"
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+)
+
+func TestCreateNewUser(t *testing.T) {
+	srv, handler := newTestServer(t)
+	registerAndLogin(t, handler, "existing")
+
+	tests := []struct {
+		name       string
+		body       interface{}
+		wantStatus int
+		wantField  string
+	}{
+		{name: "valid", body: map[string]string{"name": "Ada", "username": "ada", "password": testPassword}, wantStatus: http.StatusOK},
+		{name: "username taken", body: map[string]string{"username": "existing", "password": testPassword}, wantStatus: http.StatusConflict},
+		{name: "weak password", body: map[string]string{"username": "weak", "password": "short"}, wantStatus: http.StatusBadRequest, wantField: "password"},
+		{name: "invalid username", body: map[string]string{"username": "no spaces", "password": testPassword}, wantStatus: http.StatusBadRequest, wantField: "username"},
+		{name: "missing password", body: map[string]string{"username": "nopassword"}, wantStatus: http.StatusBadRequest, wantField: "password"},
+		{name: "unknown field", body: map[string]string{"username": "admin", "password": testPassword, "role": "admin"}, wantStatus: http.StatusBadRequest},
+		{name: "not json", body: "{", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder, response := doJSON(t, handler, http.MethodPost, "/register", "", tt.body)
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d, body %v", recorder.Code, tt.wantStatus, response)
+			}
+			if tt.wantField != "" && !hasFieldError(response, tt.wantField) {
+				t.Fatalf("fieldErrors = %v; want an error for %q", response["fieldErrors"], tt.wantField)
+			}
+		})
+	}
+
+	user, err := srv.DBHelper.GetUserByUsername(context.Background(), "ada")
+	if err != nil {
+		t.Fatalf("GetUserByUsername: %v", err)
+	}
+	if user.Role != models.RoleUser || user.Plan != "free" || user.Quota != 2*1024*1024 || user.MaxFiles != 2 {
+		t.Fatalf("registered user = %+v; want role %q on the free plan", user, models.RoleUser)
+	}
+	if user.Password == testPassword {
+		t.Fatal("password stored in plain text")
+	}
+
+	if _, err := srv.DBHelper.GetUserByUsername(context.Background(), "admin"); err == nil {
+		t.Fatal("user registered despite unknown field")
+	}
+}
+
+func TestLogin(t *testing.T) {
+	srv, handler := newTestServer(t, func(cfg *config.Config) {
+		cfg.LoginProtection.MaxFailures = 3
+	})
+	registerAndLogin(t, handler, "ada")
+	registerAndLogin(t, handler, "grace")
+
+	grace, err := srv.DBHelper.GetUserByUsername(context.Background(), "grace")
+	if err != nil {
+		t.Fatalf("GetUserByUsername: %v", err)
+	}
+	if err := srv.DBHelper.SetUserDisabled(context.Background(), grace.ID, true); err != nil {
+		t.Fatalf("SetUserDisabled: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		username   string
+		password   string
+		wantStatus int
+	}{
+		{name: "valid", username: "ada", password: testPassword, wantStatus: http.StatusOK},
+		{name: "wrong password", username: "ada", password: "wrong-password", wantStatus: http.StatusUnauthorized},
+		{name: "unknown user", username: "nobody", password: testPassword, wantStatus: http.StatusUnauthorized},
+		{name: "disabled", username: "grace", password: testPassword, wantStatus: http.StatusForbidden},
+		{name: "missing password", username: "ada", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder, response := doJSON(t, handler, http.MethodPost, "/login", "", map[string]string{"username": tt.username, "password": tt.password})
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d, body %v", recorder.Code, tt.wantStatus, response)
+			}
+
+			switch recorder.Code {
+			case http.StatusOK:
+				if response["token"] == "" || response["refresh_token"] == "" {
+					t.Fatalf("response = %v; want token and refresh_token", response)
+				}
+			case http.StatusUnauthorized:
+				if response["messageToUser"] != models.LoginFailedMsg {
+					t.Fatalf("messageToUser = %v; want %q", response["messageToUser"], models.LoginFailedMsg)
+				}
+			}
+		})
+	}
+
+	t.Run("locked after repeated failures", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			doJSON(t, handler, http.MethodPost, "/login", "", map[string]string{"username": "ada", "password": "wrong-password"})
+		}
+
+		recorder, response := doJSON(t, handler, http.MethodPost, "/login", "", map[string]string{"username": "ada", "password": testPassword})
+		if recorder.Code != http.StatusTooManyRequests {
+			t.Fatalf("status = %d; want %d, body %v", recorder.Code, http.StatusTooManyRequests, response)
+		}
+		if recorder.Header().Get("Retry-After") == "" {
+			t.Fatal("Retry-After header missing")
+		}
+	})
+}
+
+// Usernames no account can have are not recorded, so they can't fill the records.
+func TestLoginInvalidUsername(t *testing.T) {
+	srv, handler := newTestServer(t)
+
+	username := strings.Repeat("x", 200)
+	recorder, response := doJSON(t, handler, http.MethodPost, "/login", "", map[string]string{"username": username, "password": "wrong-password"})
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d; want %d, body %v", recorder.Code, http.StatusUnauthorized, response)
+	}
+
+	if attempt, err := srv.DBHelper.GetLoginAttempt(context.Background(), accountLoginKey(username)); err != nil || attempt.Failures != 0 {
+		t.Fatalf("account record = %+v, %v; want none", attempt, err)
+	}
+	if attempt, err := srv.DBHelper.GetLoginAttempt(context.Background(), ipLoginKey("192.0.2.1")); err != nil || attempt.Failures != 1 {
+		t.Fatalf("client IP record = %+v, %v; want 1 failure", attempt, err)
+	}
+}
+
+func TestLoginClientIP(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		wantStatus     int
+	}{
+		// httptest requests come from 192.0.2.1.
+		{name: "forwarded header ignored by default", wantStatus: http.StatusTooManyRequests},
+		{name: "forwarded header from a trusted proxy", trustedProxies: []string{"192.0.2.1"}, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, handler := newTestServer(t, func(cfg *config.Config) {
+				cfg.LoginProtection.MaxFailures = 3
+				cfg.TrustedProxies = tt.trustedProxies
+			})
+			registerAndLogin(t, handler, "ada")
+
+			login := func(username, password, forwardedFor string) int {
+				t.Helper()
+				body, _ := json.Marshal(map[string]string{"username": username, "password": password})
+				request := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(body))
+				request.Header.Set("Content-Type", "application/json")
+				request.Header.Set("X-Forwarded-For", forwardedFor)
+				recorder, _ := serve(t, handler, request, "")
+				return recorder.Code
+			}
+
+			// Every failure claims to come from another address, for another username.
+			for i := 0; i < 3; i++ {
+				login(fmt.Sprintf("nobody-%d", i), "wrong-password", fmt.Sprintf("203.0.113.%d", i+1))
+			}
+
+			if code := login("ada", testPassword, "198.51.100.7"); code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d", code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestParallelLoginFailures(t *testing.T) {
+	_, handler := newTestServer(t, func(cfg *config.Config) {
+		cfg.LoginProtection.MaxFailures = 3
+	})
+	registerAndLogin(t, handler, "ada")
+
+	const attempts = 8
+	codes := make(chan int, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body, _ := json.Marshal(map[string]string{"username": "ada", "password": "wrong-password"})
+			request := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(body))
+			request.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			codes <- recorder.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	var checked int
+	for code := range codes {
+		if code == http.StatusUnauthorized {
+			checked++
+		}
+	}
+	if checked != 3 {
+		t.Fatalf("%d of %d parallel logins had their password checked; want 3", checked, attempts)
+	}
+}
+
+func TestUploadFile(t *testing.T) {
+	srv, handler := newTestServer(t)
+	token := registerAndLogin(t, handler, "ada")
+
+	content := []byte("hello, world")
+	tooLarge := bytes.Repeat([]byte("a"), 1024*1024+1)
+
+	tests := []struct {
+		name       string
+		token      string
+		filename   string
+		content    []byte
+		wantStatus int
+	}{
+		{name: "valid", token: token, filename: "hello.txt", content: content, wantStatus: http.StatusOK},
+		{name: "new version", token: token, filename: "hello.txt", content: []byte("hello again"), wantStatus: http.StatusOK},
+		{name: "duplicate content", token: token, filename: "copy.txt", content: []byte("hello again"), wantStatus: http.StatusConflict},
+		{name: "too large for plan", token: token, filename: "big.bin", content: tooLarge, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "invalid filename", token: token, filename: "..", content: []byte("dots"), wantStatus: http.StatusBadRequest},
+		{name: "not logged in", filename: "anonymous.txt", content: []byte("anonymous"), wantStatus: http.StatusUnauthorized},
+		{name: "second file", token: token, filename: "second.txt", content: []byte("second"), wantStatus: http.StatusOK},
+		{name: "file limit reached", token: token, filename: "third.txt", content: []byte("third"), wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder, response := doUpload(t, handler, tt.token, tt.filename, tt.content)
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d, body %v", recorder.Code, tt.wantStatus, response)
+			}
+		})
+	}
+
+	user, err := srv.DBHelper.GetUserByUsername(context.Background(), "ada")
+	if err != nil {
+		t.Fatalf("GetUserByUsername: %v", err)
+	}
+
+	wantUsed := int64(len(content) + len("hello again") + len("second"))
+	if user.UsedStorage != wantUsed || user.FileCount != 2 {
+		t.Fatalf("used storage = %d, files = %d; want %d and 2", user.UsedStorage, user.FileCount, wantUsed)
+	}
+
+	file, err := srv.DBHelper.GetFileByName(context.Background(), user.ID, "", "hello.txt")
+	if err != nil {
+		t.Fatalf("GetFileByName: %v", err)
+	}
+	if file.Version != 2 || !strings.HasPrefix(file.ContentType, "text/plain") {
+		t.Fatalf("file = %+v; want version 2 of a text file", file)
+	}
+
+	stored, err := srv.Storage.Get(utils.BlobKey(file.Hash))
+	if err != nil {
+		t.Fatalf("stored content: %v", err)
+	}
+	stored.Close()
+}
+
+func TestUploadAfterDelete(t *testing.T) {
+	_, handler := newTestServer(t)
+	token := registerAndLogin(t, handler, "ada")
+
+	// Two files of the plan's largest size fill the 2 MB quota.
+	fileIDs := make([]string, 2)
+	for i := range fileIDs {
+		content := bytes.Repeat([]byte{byte('a' + i)}, 1024*1024)
+		recorder, response := doUpload(t, handler, token, fmt.Sprintf("file-%d.bin", i), content)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("upload %d: status %d, body %v", i+1, recorder.Code, response)
+		}
+		fileIDs[i] = response["fileID"].(string)
+	}
+
+	// A new session is cached with the quota used up.
+	recorder, response := doJSON(t, handler, http.MethodPost, "/login", "", models.UsernameAndPassword{Username: "ada", Password: testPassword})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("login: status %d, body %v", recorder.Code, response)
+	}
+	token = response["token"].(string)
+	if recorder, response := doUpload(t, handler, token, "full.txt", []byte("no room")); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("upload over quota: status %d, body %v; want %d", recorder.Code, response, http.StatusBadRequest)
+	}
+
+	if recorder, response := doJSON(t, handler, http.MethodDelete, "/files/"+fileIDs[0], token, nil); recorder.Code != http.StatusOK {
+		t.Fatalf("delete: status %d, body %v", recorder.Code, response)
+	}
+
+	// The authenticated user is still cached with the old usage; the freed space counts anyway.
+	if recorder, response := doUpload(t, handler, token, "room.txt", []byte("room again")); recorder.Code != http.StatusOK {
+		t.Fatalf("upload after delete: status %d, body %v; want %d", recorder.Code, response, http.StatusOK)
+	}
+}
+
+// cancelReader acts like a client that goes away: when it is reached the request is
+// cancelled and reading the body fails.
+type cancelReader struct {
+	cancel context.CancelFunc
+}
+
+func (cr cancelReader) Read(p []byte) (int, error) {
+	cr.cancel()
+	return 0, io.ErrUnexpectedEOF
+}
+
+func TestCancelledUpload(t *testing.T) {
+	srv, handler := newTestServer(t)
+	token := registerAndLogin(t, handler, "ada")
+
+	tempFiles := func() int {
+		matches, _ := filepath.Glob(filepath.Join(srv.stagingDir, ".upload-*"))
+		return len(matches)
+	}
+	before := tempFiles()
+
+	t.Run("single upload", func(t *testing.T) {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, err := form.CreateFormFile("file", "hello.txt")
+		if err != nil {
+			t.Fatalf("create form file: %v", err)
+		}
+		part.Write([]byte("hello, world"))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		request := httptest.NewRequest(http.MethodPost, "/upload", io.MultiReader(&body, cancelReader{cancel}, strings.NewReader("never read")))
+		request = request.WithContext(ctx)
+		request.Header.Set("Content-Type", form.FormDataContentType())
+
+		recorder, response := serve(t, handler, request, token)
+		if recorder.Code != models.StatusClientClosedRequest {
+			t.Fatalf("status = %d; want %d, body %v", recorder.Code, models.StatusClientClosedRequest, response)
+		}
+
+		user, err := srv.DBHelper.GetUserByUsername(context.Background(), "ada")
+		if err != nil {
+			t.Fatalf("GetUserByUsername: %v", err)
+		}
+		if user.UsedStorage != 0 || user.FileCount != 0 {
+			t.Fatalf("used storage = %d, files = %d; want nothing used", user.UsedStorage, user.FileCount)
+		}
+		if after := tempFiles(); after != before {
+			t.Fatalf("%d temp files left behind", after-before)
+		}
+	})
+
+	t.Run("resumable chunk", func(t *testing.T) {
+		recorder, response := doJSON(t, handler, http.MethodPost, "/uploads", token, models.CreateUploadRequest{Filename: "resumed.txt", Size: 10})
+		if recorder.Code != http.StatusCreated {
+			t.Fatalf("create upload: status %d, body %v", recorder.Code, response)
+		}
+		path := "/uploads/" + response["id"].(string)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		request := httptest.NewRequest(http.MethodPatch, path, io.MultiReader(strings.NewReader("hello"), cancelReader{cancel}, strings.NewReader("world")))
+		request = request.WithContext(ctx)
+		request.Header.Set(models.UploadOffsetHeader, "0")
+
+		recorder, response = serve(t, handler, request, token)
+		if recorder.Code != models.StatusClientClosedRequest {
+			t.Fatalf("status = %d; want %d, body %v", recorder.Code, models.StatusClientClosedRequest, response)
+		}
+
+		// What arrived before the cancellation is kept for the client to resume from.
+		request = httptest.NewRequest(http.MethodHead, path, nil)
+		recorder, _ = serve(t, handler, request, token)
+		if offset := recorder.Header().Get(models.UploadOffsetHeader); offset != strconv.Itoa(len("hello")) {
+			t.Fatalf("offset = %q; want %d", offset, len("hello"))
+		}
+
+		recorder, response = doJSON(t, handler, http.MethodDelete, path, token, nil)
+		if recorder.Code != http.StatusNoContent {
+			t.Fatalf("cancel upload: status %d, body %v", recorder.Code, response)
+		}
+	})
+}
+
+func TestOpenUploadLimit(t *testing.T) {
+	_, handler := newTestServer(t)
+	token := registerAndLogin(t, handler, "ada")
+
+	var paths []string
+	for i := 0; i < models.MaxOpenUploads; i++ {
+		recorder, response := doJSON(t, handler, http.MethodPost, "/uploads", token, models.CreateUploadRequest{Filename: fmt.Sprintf("part-%d.txt", i), Size: 10})
+		if recorder.Code != http.StatusCreated {
+			t.Fatalf("create upload %d: status %d, body %v", i, recorder.Code, response)
+		}
+		paths = append(paths, "/uploads/"+response["id"].(string))
+	}
+
+	recorder, response := doJSON(t, handler, http.MethodPost, "/uploads", token, models.CreateUploadRequest{Filename: "one-too-many.txt", Size: 10})
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("create upload past the limit: status %d; want %d, body %v", recorder.Code, http.StatusTooManyRequests, response)
+	}
+
+	// Cancelling one makes room for another.
+	if recorder, response := doJSON(t, handler, http.MethodDelete, paths[0], token, nil); recorder.Code != http.StatusNoContent {
+		t.Fatalf("cancel upload: status %d, body %v", recorder.Code, response)
+	}
+	recorder, response = doJSON(t, handler, http.MethodPost, "/uploads", token, models.CreateUploadRequest{Filename: "one-more.txt", Size: 10})
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("create upload after cancelling: status %d, body %v", recorder.Code, response)
+	}
+	paths[0] = "/uploads/" + response["id"].(string)
+
+	for i, path := range paths {
+		if recorder, response := doJSON(t, handler, http.MethodDelete, path, token, nil); recorder.Code != http.StatusNoContent {
+			t.Fatalf("cancel upload %d: status %d, body %v", i, recorder.Code, response)
+		}
+	}
+}
+
+// TestSQLiteDatabase runs the main flows against the SQL database helper.
+func TestSQLiteDatabase(t *testing.T) {
+	srv, handler := newTestServer(t, func(cfg *config.Config) {
+		cfg.DBDriver = "sqlite"
+		cfg.SQL.DSN = ":memory:"
+	})
+	token := registerAndLogin(t, handler, "ada")
+
+	recorder, response := doJSON(t, handler, http.MethodPost, "/register", "", map[string]string{
+		"name": "Another Ada", "username": "ada", "password": testPassword,
+	})
+	if recorder.Code != http.StatusConflict {
+		t.Fatalf("register taken username: status = %d; want %d, body %v", recorder.Code, http.StatusConflict, response)
+	}
+
+	recorder, response = doUpload(t, handler, token, "hello.txt", []byte("hello, world"))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("upload: status = %d, body %v", recorder.Code, response)
+	}
+	fileID := response["fileID"].(string)
+
+	recorder, response = doUpload(t, handler, token, "hello.txt", []byte("hello again"))
+	if recorder.Code != http.StatusOK || response["version"] != float64(2) {
+		t.Fatalf("upload new version: status = %d, body %v", recorder.Code, response)
+	}
+
+	recorder, response = doJSON(t, handler, http.MethodGet, "/files?name_prefix=hel", token, nil)
+	if recorder.Code != http.StatusOK || response["total"] != float64(1) {
+		t.Fatalf("list files: status = %d, body %v", recorder.Code, response)
+	}
+
+	recorder, response = doJSON(t, handler, http.MethodDelete, "/files/"+fileID, token, nil)
+	if recorder.Code != http.StatusOK || response["versions"] != float64(2) {
+		t.Fatalf("delete file: status = %d, body %v", recorder.Code, response)
+	}
+
+	user, err := srv.DBHelper.GetUserByUsername(context.Background(), "ada")
+	if err != nil {
+		t.Fatalf("GetUserByUsername: %v", err)
+	}
+	if user.UsedStorage != 0 || user.FileCount != 0 {
+		t.Fatalf("used storage = %d, files = %d after deleting everything; want 0 and 0", user.UsedStorage, user.FileCount)
+	}
+
+	recorder, response = doJSON(t, handler, http.MethodDelete, "/files/"+fileID, token, nil)
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("delete deleted file: status = %d; want %d, body %v", recorder.Code, http.StatusNotFound, response)
+	}
+}
+
+func hasFieldError(response map[string]interface{}, field string) bool {
+	fieldErrors, _ := response["fieldErrors"].([]interface{})
+	for _, fieldError := range fieldErrors {
+		if fieldError.(map[string]interface{})["field"] == field {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,492 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// adminReconcile runs the reconciliation job now. Nothing is changed unless ?repair=true is given.
+func (srv *Server) adminReconcile(c *gin.Context) {
+	repair := c.Query("repair") == "true"
+
+	if !srv.reconcileLock.TryLock() {
+		utils.RespondClientErr(c, errors.New("reconciliation already running"), http.StatusConflict, "reconciliation already running")
+		return
+	}
+	defer srv.reconcileLock.Unlock()
+
+	report, err := srv.reconcile(c.Request.Context(), repair)
+	if err != nil {
+		utils.LogError("adminReconcile", "error reconciling storage", "", err)
+		utils.RespondGenericServerErr(c, err, "could not reconcile storage")
+		return
+	}
+
+	utils.EncodeJSONBody(c, http.StatusOK, report)
+}
+
+// runReconcileSchedule runs the reconciliation job every configured interval until the server stops.
+func (srv *Server) runReconcileSchedule() {
+	if srv.Config.Reconcile.IntervalMinutes <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(srv.Config.Reconcile.IntervalMinutes) * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-srv.stopReconcile:
+			return
+		case <-ticker.C:
+			if !srv.reconcileLock.TryLock() {
+				continue
+			}
+
+			report, err := srv.reconcile(context.Background(), srv.Config.Reconcile.Repair)
+			srv.reconcileLock.Unlock()
+			if err != nil {
+				utils.LogError("runReconcileSchedule", "error reconciling storage", "", err)
+				continue
+			}
+
+			logrus.Infof("Reconcile: %d usage corrections, %d orphaned objects, %d missing versions, %d blob ref counts, %d expired uploads, %d orphaned upload files, %d errors, repaired: %v",
+				len(report.Usage), len(report.OrphanedObjects), len(report.MissingContent), len(report.BlobRefCounts),
+				len(report.ExpiredUploads), len(report.OrphanedUploads), len(report.Errors), report.Repaired)
+		}
+	}
+}
+
+// reconcile compares the recorded usage and metadata with what is actually stored. With
+// repair set it also fixes what it finds:
+//   - versions whose content is missing are removed; a file left without versions is
+//     deleted, otherwise its newest remaining version becomes current;
+//   - blob reference counts are set to the number of versions using the blob, unless
+//     the blob was used or released since it was read;
+//   - stored objects nothing refers to are deleted, once older than ReconcileGracePeriod;
+//   - each user's used storage and file count are recomputed from their metadata, unless
+//     they changed since they were read;
+//   - expired resumable uploads are deleted with their temp files, and so are temp files
+//     no upload refers to, once older than ReconcileGracePeriod.
+//
+// Usage of a user with an upload in flight can be off by that upload until the next run.
+func (srv *Server) reconcile(ctx context.Context, repair bool) (models.ReconcileReport, error) {
+
+	report := models.ReconcileReport{
+		StartedAt:       time.Now().Unix(),
+		Repaired:        repair,
+		Usage:           []models.UsageCorrection{},
+		OrphanedObjects: []models.StorageObjectInfo{},
+		MissingContent:  []models.MissingContent{},
+		BlobRefCounts:   []models.RefCountCorrection{},
+		ExpiredUploads:  []models.Upload{},
+		OrphanedUploads: []string{},
+		Errors:          []string{},
+	}
+
+	users, err := srv.DBHelper.GetUsers(ctx)
+	if err != nil {
+		return report, err
+	}
+	files, err := srv.DBHelper.GetAllFiles(ctx)
+	if err != nil {
+		return report, err
+	}
+	versions, err := srv.DBHelper.GetAllFileVersions(ctx)
+	if err != nil {
+		return report, err
+	}
+	blobs, err := srv.DBHelper.GetBlobs(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	// List storage after reading metadata, so content written in between is never
+	// mistaken for missing; at worst it looks orphaned, and is protected by the grace period.
+	objects, err := srv.Storage.List("")
+	if err != nil {
+		return report, err
+	}
+
+	storedKeys := make(map[string]bool, len(objects))
+	for _, object := range objects {
+		storedKeys[object.Key] = true
+	}
+
+	filesByID := make(map[string]models.File, len(files))
+	for _, file := range files {
+		filesByID[file.ID] = file
+	}
+
+	// Versions whose content is missing.
+	removedVersions := map[string]bool{}
+	for _, version := range versions {
+		if storedKeys[utils.StorageKey(version.Path)] {
+			continue
+		}
+
+		report.MissingContent = append(report.MissingContent, models.MissingContent{
+			UserID:    version.UserID,
+			FileID:    version.FileID,
+			Filename:  filesByID[version.FileID].Filename,
+			VersionID: version.ID,
+			Version:   version.Version,
+			Path:      version.Path,
+		})
+
+		if repair {
+			if err := srv.DBHelper.DeleteFileVersion(ctx, version.ID); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("removing version %s: %v", version.ID, err))
+				continue
+			}
+			removedVersions[version.ID] = true
+		}
+	}
+
+	remainingVersions := make([]models.FileVersion, 0, len(versions))
+	versionsByFile := map[string][]models.FileVersion{}
+	for _, version := range versions {
+		if removedVersions[version.ID] {
+			continue
+		}
+		remainingVersions = append(remainingVersions, version)
+		versionsByFile[version.FileID] = append(versionsByFile[version.FileID], version)
+	}
+
+	if repair {
+		srv.repairFilesWithoutContent(ctx, &report, filesByID, versionsByFile, removedVersions)
+	}
+
+	srv.reconcileBlobRefCounts(ctx, &report, blobs, remainingVersions, repair)
+
+	// Objects nothing refers to.
+	referencedKeys := map[string]bool{}
+	for _, version := range remainingVersions {
+		referencedKeys[utils.StorageKey(version.Path)] = true
+	}
+	for _, file := range filesByID {
+		referencedKeys[utils.StorageKey(file.Path)] = true
+	}
+	for _, blob := range blobs {
+		referencedKeys[utils.BlobKey(blob.Hash)] = true
+	}
+
+	graceCutoff := time.Now().Add(-models.ReconcileGracePeriod).Unix()
+	for _, object := range objects {
+		if referencedKeys[object.Key] || object.ModTime > graceCutoff {
+			continue
+		}
+
+		if repair {
+			deleted, err := srv.deleteOrphanedObject(ctx, object.Key)
+			if err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("deleting object %s: %v", object.Key, err))
+				continue
+			}
+			if !deleted {
+				continue
+			}
+		}
+
+		report.OrphanedObjects = append(report.OrphanedObjects, object)
+	}
+
+	srv.reconcileUsage(ctx, &report, users, filesByID, remainingVersions, repair)
+
+	srv.reconcileUploads(ctx, &report, repair)
+
+	report.FinishedAt = time.Now().Unix()
+	return report, nil
+}
+
+// repairFilesWithoutContent deletes files whose versions were all removed, and makes
+// the newest remaining version current where the current one was removed.
+func (srv *Server) repairFilesWithoutContent(ctx context.Context, report *models.ReconcileReport, filesByID map[string]models.File, versionsByFile map[string][]models.FileVersion, removedVersions map[string]bool) {
+
+	touchedFiles := map[string]bool{}
+	for _, missing := range report.MissingContent {
+		if removedVersions[missing.VersionID] {
+			touchedFiles[missing.FileID] = true
+		}
+	}
+
+	for fileID := range touchedFiles {
+		file, ok := filesByID[fileID]
+		if !ok {
+			continue
+		}
+
+		remaining := versionsByFile[fileID]
+		if len(remaining) == 0 {
+			if _, _, err := srv.DBHelper.DeleteFile(ctx, file.UserID, file.ID); err != nil && !errors.Is(err, models.ErrNotFound) {
+				report.Errors = append(report.Errors, fmt.Sprintf("deleting file %s: %v", file.ID, err))
+				continue
+			}
+			delete(filesByID, fileID)
+			continue
+		}
+
+		sort.Slice(remaining, func(i, j int) bool {
+			return remaining[i].Version > remaining[j].Version
+		})
+		if remaining[0].Version == file.Version {
+			continue
+		}
+
+		if err := srv.DBHelper.SetCurrentFileVersion(ctx, remaining[0]); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("updating current version of file %s: %v", file.ID, err))
+			continue
+		}
+		file.Version = remaining[0].Version
+		file.Path = remaining[0].Path
+		filesByID[fileID] = file
+	}
+}
+
+// reconcileBlobRefCounts compares every blob's reference count with the number of versions using it.
+func (srv *Server) reconcileBlobRefCounts(ctx context.Context, report *models.ReconcileReport, blobs []models.Blob, versions []models.FileVersion, repair bool) {
+
+	actual := map[string]int64{}
+	sizes := map[string]int64{}
+	for _, version := range versions {
+		if utils.StorageKey(version.Path) == utils.BlobKey(version.Hash) {
+			actual[version.Hash]++
+			sizes[version.Hash] = version.Size
+		}
+	}
+
+	recorded := map[string]int64{}
+	for _, blob := range blobs {
+		recorded[blob.Hash] = blob.RefCount
+	}
+
+	hashes := map[string]bool{}
+	for hash := range actual {
+		hashes[hash] = true
+	}
+	for hash := range recorded {
+		hashes[hash] = true
+	}
+
+	for hash := range hashes {
+		if recorded[hash] == actual[hash] {
+			continue
+		}
+
+		correction := models.RefCountCorrection{Hash: hash, Recorded: recorded[hash], Actual: actual[hash]}
+
+		if repair {
+			corrected, err := srv.repairBlobRefCount(ctx, &correction, sizes[hash])
+			if err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("setting reference count of blob %s: %v", hash, err))
+				continue
+			}
+			if !corrected {
+				continue
+			}
+		}
+
+		report.BlobRefCounts = append(report.BlobRefCounts, correction)
+	}
+}
+
+// repairBlobRefCount sets the blob's reference count to the number of versions using it.
+// The versions are counted again under the blob's lock, and the count is only written
+// while it is still the recorded one, so references taken since the snapshot are kept.
+// It reports false when there turned out to be nothing to correct.
+func (srv *Server) repairBlobRefCount(ctx context.Context, correction *models.RefCountCorrection, size int64) (bool, error) {
+	unlock := srv.blobLocks.Lock(correction.Hash)
+	defer unlock()
+
+	actual, err := srv.DBHelper.CountBlobVersions(ctx, correction.Hash)
+	if err != nil {
+		return false, err
+	}
+	if actual == correction.Recorded {
+		return false, nil
+	}
+	correction.Actual = actual
+
+	err = srv.DBHelper.SetBlobRefCount(ctx, models.Blob{
+		Hash:      correction.Hash,
+		Size:      size,
+		Path:      utils.BlobKey(correction.Hash),
+		RefCount:  actual,
+		CreatedAt: time.Now().Unix(),
+	}, correction.Recorded)
+	if errors.Is(err, models.ErrConcurrentUpdate) {
+		// The blob is in use right now; the next run looks at it again.
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// deleteOrphanedObject removes an object no metadata refers to. Blob content that was
+// picked up again by an upload since the metadata was read is left alone.
+func (srv *Server) deleteOrphanedObject(ctx context.Context, key string) (bool, error) {
+
+	if hash := path.Base(key); utils.BlobKey(hash) == key {
+		unlock := srv.blobLocks.Lock(hash)
+		defer unlock()
+
+		_, err := srv.DBHelper.GetBlob(ctx, hash)
+		if err == nil {
+			return false, nil
+		}
+		if !errors.Is(err, models.ErrNotFound) {
+			return false, err
+		}
+	}
+
+	if err := srv.Storage.Delete(key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// reconcileUsage recomputes each user's used storage from their versions and their file count from their files.
+func (srv *Server) reconcileUsage(ctx context.Context, report *models.ReconcileReport, users []models.User, filesByID map[string]models.File, versions []models.FileVersion, repair bool) {
+
+	usedStorage := map[string]int64{}
+	for _, version := range versions {
+		if _, ok := filesByID[version.FileID]; ok {
+			usedStorage[version.UserID] += version.Size
+		}
+	}
+
+	fileCount := map[string]int64{}
+	for _, file := range filesByID {
+		fileCount[file.UserID]++
+	}
+
+	for _, user := range users {
+		if user.UsedStorage == usedStorage[user.ID] && user.FileCount == fileCount[user.ID] {
+			continue
+		}
+
+		correction := models.UsageCorrection{
+			UserID:          user.ID,
+			Username:        user.Username,
+			RecordedStorage: user.UsedStorage,
+			ActualStorage:   usedStorage[user.ID],
+			RecordedFiles:   user.FileCount,
+			ActualFiles:     fileCount[user.ID],
+		}
+
+		if repair {
+			err := srv.DBHelper.SetUserUsage(ctx, correction)
+			if errors.Is(err, models.ErrConcurrentUpdate) {
+				// The user uploaded or deleted something meanwhile; the next run looks again.
+				continue
+			}
+			if err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("setting usage of user %s: %v", user.ID, err))
+				continue
+			}
+		}
+
+		report.Usage = append(report.Usage, correction)
+	}
+}
+
+// reconcileUploads finds resumable uploads that expired, and files in the uploads
+// directory no upload refers to. With repair set both are deleted.
+func (srv *Server) reconcileUploads(ctx context.Context, report *models.ReconcileReport, repair bool) {
+
+	uploads, err := srv.DBHelper.GetUploads(ctx)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("listing uploads: %v", err))
+		return
+	}
+
+	// List the directory after the uploads, so a temp file created in between is at
+	// worst taken for an orphan, and is protected by the grace period.
+	entries, err := os.ReadDir(srv.uploadsDir())
+	if err != nil && !os.IsNotExist(err) {
+		report.Errors = append(report.Errors, fmt.Sprintf("listing upload files: %v", err))
+		return
+	}
+
+	now := time.Now().Unix()
+	tempPaths := make(map[string]bool, len(uploads))
+	for _, upload := range uploads {
+		tempPaths[filepath.Clean(upload.TempPath)] = true
+
+		if upload.ExpiresAt > now {
+			continue
+		}
+
+		if repair {
+			removed, err := srv.removeExpiredUpload(ctx, upload, now)
+			if err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("deleting upload %s: %v", upload.ID, err))
+				continue
+			}
+			if !removed {
+				continue
+			}
+		}
+
+		report.ExpiredUploads = append(report.ExpiredUploads, upload)
+	}
+
+	graceCutoff := time.Now().Add(-models.ReconcileGracePeriod)
+	for _, entry := range entries {
+		tempPath := filepath.Join(srv.uploadsDir(), entry.Name())
+		if entry.IsDir() || tempPaths[tempPath] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(graceCutoff) {
+			continue
+		}
+
+		if repair {
+			if err := os.Remove(tempPath); err != nil && !os.IsNotExist(err) {
+				report.Errors = append(report.Errors, fmt.Sprintf("deleting upload file %s: %v", entry.Name(), err))
+				continue
+			}
+		}
+
+		report.OrphanedUploads = append(report.OrphanedUploads, entry.Name())
+	}
+}
+
+// removeExpiredUpload deletes an expired upload with its temp file. The upload is read
+// again under its lock, so a chunk that was being received when it expired finishes
+// first. It reports false when the upload is gone already.
+func (srv *Server) removeExpiredUpload(ctx context.Context, upload models.Upload, now int64) (bool, error) {
+	unlock := srv.uploadLocks.Lock(upload.ID)
+	defer unlock()
+
+	current, err := srv.DBHelper.GetUploadByID(ctx, upload.UserID, upload.ID)
+	if errors.Is(err, models.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if current.ExpiresAt > now {
+		return false, nil
+	}
+
+	if err := srv.DBHelper.DeleteUpload(ctx, upload.ID); err != nil {
+		return false, err
+	}
+	if err := os.Remove(upload.TempPath); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	return true, nil
+}
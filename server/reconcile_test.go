@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+)
+
+func TestUsageRepair(t *testing.T) {
+	srv, handler := newTestServer(t)
+	token := registerAndLogin(t, handler, "ada")
+	content := []byte("hello, world")
+	if recorder, response := doUpload(t, handler, token, "hello.txt", content); recorder.Code != http.StatusOK {
+		t.Fatalf("upload: status %d, body %v", recorder.Code, response)
+	}
+
+	ada, err := srv.DBHelper.GetUserByUsername(context.Background(), "ada")
+	if err != nil {
+		t.Fatalf("GetUserByUsername: %v", err)
+	}
+	drift := models.UsageCorrection{UserID: ada.ID, RecordedStorage: ada.UsedStorage, ActualStorage: 100, RecordedFiles: ada.FileCount, ActualFiles: 2}
+	if err := srv.DBHelper.SetUserUsage(context.Background(), drift); err != nil {
+		t.Fatalf("SetUserUsage: %v", err)
+	}
+
+	report, err := srv.reconcile(context.Background(), true)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if len(report.Usage) != 1 || report.Usage[0].RecordedStorage != 100 || report.Usage[0].ActualStorage != int64(len(content)) {
+		t.Fatalf("usage corrections = %+v; want 100 corrected to %d", report.Usage, len(content))
+	}
+
+	ada, _ = srv.DBHelper.GetUserByUsername(context.Background(), "ada")
+	if ada.UsedStorage != int64(len(content)) || ada.FileCount != 1 {
+		t.Fatalf("used storage = %d, files = %d; want %d and 1", ada.UsedStorage, ada.FileCount, len(content))
+	}
+
+	// Applying the correction again finds different usage from what it recorded, as a
+	// repair racing an upload would, and changes nothing.
+	if err := srv.DBHelper.SetUserUsage(context.Background(), report.Usage[0]); !errors.Is(err, models.ErrConcurrentUpdate) {
+		t.Fatalf("SetUserUsage from stale usage = %v; want ErrConcurrentUpdate", err)
+	}
+}
+
+func TestBlobRefCountRepair(t *testing.T) {
+	srv, handler := newTestServer(t)
+	content := []byte("shared content")
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+
+	tokens := map[string]string{}
+	for _, username := range []string{"ada", "bob"} {
+		token := registerAndLogin(t, handler, username)
+		tokens[username] = token
+		if recorder, response := doUpload(t, handler, token, "shared.txt", content); recorder.Code != http.StatusOK {
+			t.Fatalf("upload as %s: status %d, body %v", username, recorder.Code, response)
+		}
+	}
+
+	// loseReference drops a reference, as a crash between two steps could.
+	loseReference := func() {
+		t.Helper()
+		blob, err := srv.DBHelper.GetBlob(context.Background(), hash)
+		if err != nil {
+			t.Fatalf("GetBlob: %v", err)
+		}
+		if err := srv.DBHelper.SetBlobRefCount(context.Background(), models.Blob{Hash: hash, RefCount: blob.RefCount - 1}, blob.RefCount); err != nil {
+			t.Fatalf("SetBlobRefCount: %v", err)
+		}
+	}
+	refCount := func() int64 {
+		t.Helper()
+		blob, err := srv.DBHelper.GetBlob(context.Background(), hash)
+		if err != nil {
+			t.Fatalf("GetBlob: %v", err)
+		}
+		return blob.RefCount
+	}
+
+	if err := srv.DBHelper.SetBlobRefCount(context.Background(), models.Blob{Hash: hash, RefCount: 5}, 1); !errors.Is(err, models.ErrConcurrentUpdate) {
+		t.Fatalf("SetBlobRefCount from a stale count = %v; want ErrConcurrentUpdate", err)
+	}
+
+	loseReference()
+	report, err := srv.reconcile(context.Background(), true)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if len(report.BlobRefCounts) != 1 || report.BlobRefCounts[0].Recorded != 1 || report.BlobRefCounts[0].Actual != 2 {
+		t.Fatalf("blob ref count corrections = %+v; want 1 corrected to 2", report.BlobRefCounts)
+	}
+	if got := refCount(); got != 2 {
+		t.Fatalf("ref count after repair = %d; want 2", got)
+	}
+
+	// Deleting a file of a blob whose count is too low keeps the content the other file uses.
+	loseReference()
+	bob, err := srv.DBHelper.GetUserByUsername(context.Background(), "bob")
+	if err != nil {
+		t.Fatalf("GetUserByUsername: %v", err)
+	}
+	file, err := srv.DBHelper.GetFileByName(context.Background(), bob.ID, "", "shared.txt")
+	if err != nil {
+		t.Fatalf("GetFileByName: %v", err)
+	}
+	if recorder, response := doJSON(t, handler, http.MethodDelete, "/files/"+file.ID, tokens["bob"], nil); recorder.Code != http.StatusOK {
+		t.Fatalf("delete: status %d, body %v", recorder.Code, response)
+	}
+
+	if got := refCount(); got != 1 {
+		t.Fatalf("ref count after deleting with a low count = %d; want 1 restored from the versions", got)
+	}
+	stored, err := srv.Storage.Get(utils.BlobKey(hash))
+	if err != nil {
+		t.Fatalf("content of a blob still in use: %v", err)
+	}
+	stored.Close()
+}
+
+func TestExpiredUploadRepair(t *testing.T) {
+	srv, handler := newTestServer(t)
+	token := registerAndLogin(t, handler, "ada")
+	ada, err := srv.DBHelper.GetUserByUsername(context.Background(), "ada")
+	if err != nil {
+		t.Fatalf("GetUserByUsername: %v", err)
+	}
+
+	recorder, response := doJSON(t, handler, http.MethodPost, "/uploads", token, models.CreateUploadRequest{Filename: "open.txt", Size: 10})
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("create upload: status %d, body %v", recorder.Code, response)
+	}
+	openPath := "/uploads/" + response["id"].(string)
+
+	// An upload abandoned a day ago, and a temp file left behind by a crash.
+	now := time.Now()
+	expired := models.Upload{ID: "expired-id", UserID: ada.ID, Filename: "abandoned.txt", Size: 10, CreatedAt: now.Add(-2 * models.UploadTTL).Unix(), ExpiresAt: now.Add(-time.Minute).Unix()}
+	if expired.TempPath, err = utils.UploadTempPath(srv.uploadsDir(), expired.ID); err != nil {
+		t.Fatalf("UploadTempPath: %v", err)
+	}
+	orphanPath, err := utils.UploadTempPath(srv.uploadsDir(), "orphan-id")
+	if err != nil {
+		t.Fatalf("UploadTempPath: %v", err)
+	}
+	for _, path := range []string{expired.TempPath, orphanPath} {
+		if err := os.WriteFile(path, []byte("hello"), 0644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+		old := now.Add(-2 * models.ReconcileGracePeriod)
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatalf("age temp file: %v", err)
+		}
+	}
+	if err := srv.DBHelper.CreateUpload(context.Background(), expired); err != nil {
+		t.Fatalf("CreateUpload: %v", err)
+	}
+
+	request := httptest.NewRequest(http.MethodHead, "/uploads/"+expired.ID, nil)
+	if recorder, _ := serve(t, handler, request, token); recorder.Code != http.StatusNotFound {
+		t.Fatalf("status of an expired upload = %d; want %d", recorder.Code, http.StatusNotFound)
+	}
+
+	report, err := srv.reconcile(context.Background(), true)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if len(report.ExpiredUploads) != 1 || report.ExpiredUploads[0].ID != expired.ID {
+		t.Fatalf("expired uploads = %+v; want %s", report.ExpiredUploads, expired.ID)
+	}
+	if !slices.Contains(report.OrphanedUploads, "orphan-id") {
+		t.Fatalf("orphaned upload files = %v; want orphan-id among them", report.OrphanedUploads)
+	}
+
+	for _, path := range []string{expired.TempPath, orphanPath} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("temp file %s still there after repair: %v", path, err)
+		}
+	}
+	if _, err := srv.DBHelper.GetUploadByID(context.Background(), ada.ID, expired.ID); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("GetUploadByID of the expired upload = %v; want ErrNotFound", err)
+	}
+
+	// The open upload is left alone.
+	if recorder, response := doJSON(t, handler, http.MethodDelete, openPath, token, nil); recorder.Code != http.StatusNoContent {
+		t.Fatalf("cancel open upload: status %d, body %v", recorder.Code, response)
+	}
+}
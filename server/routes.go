@@ -1,24 +1,79 @@
 package server
 
 import (
+	"github.com/file_upload/models"
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 )
 
 func (srv *Server) InjectRoutes() *gin.Engine {
 
 	router := gin.Default()
 
+	// The client IP keys the login lockout, so forwarded headers are only believed from
+	// configured proxies.
+	if err := router.SetTrustedProxies(srv.Config.TrustedProxies); err != nil {
+		logrus.Fatalf("Server Init: Invalid trusted_proxies: %v", err)
+	}
+
 	// Public routes
 	router.POST("/login", srv.login)
 	router.POST("/register", srv.createNewUser)
+	router.POST("/token/refresh", srv.refreshToken)
+	router.GET("/.well-known/jwks.json", srv.jwks)
+	router.GET(models.SharePath+":token", srv.downloadShare)
 
 	// Protected routes
 	protected := router.Group("/")
 	protected.Use(srv.MiddlewareProvider.AuthMiddleware())
 	{
+		protected.POST("/logout", srv.logout)
+		protected.GET("/sessions", srv.listSessions)
+		protected.DELETE("/sessions/:id", srv.revokeSession)
 		protected.GET("/storage/remaining", srv.remainingStorage)
+		protected.GET("/plans", srv.listPlans)
 		protected.POST("/upload", srv.uploadFile)
 		protected.GET("/files", srv.getUserFiles)
+		protected.GET("/files/:id/download", srv.downloadFile)
+		protected.PATCH("/files/:id", srv.updateFile)
+		protected.DELETE("/files/:id", srv.deleteFile)
+		protected.GET("/files/:id/versions", srv.listFileVersions)
+		protected.GET("/files/:id/versions/:version/download", srv.downloadFileVersion)
+		protected.POST("/files/:id/versions/:version/restore", srv.restoreFileVersion)
+		protected.POST("/files/:id/share", srv.createShare)
+
+		// Share links
+		protected.GET("/shares", srv.listShares)
+		protected.DELETE("/shares/:id", srv.revokeShare)
+
+		// Folders
+		protected.POST("/folders", srv.createFolder)
+		protected.GET("/folders/:id", srv.getFolder)
+		protected.PATCH("/folders/:id", srv.updateFolder)
+		protected.DELETE("/folders/:id", srv.deleteFolder)
+
+		// Resumable uploads
+		protected.POST("/uploads", srv.createUpload)
+		protected.HEAD("/uploads/:id", srv.uploadStatus)
+		protected.PATCH("/uploads/:id", srv.uploadChunk)
+		protected.POST("/uploads/:id/finalize", srv.finalizeUpload)
+		protected.DELETE("/uploads/:id", srv.cancelUpload)
+
+		// Administration
+		admin := protected.Group("/admin")
+		admin.Use(srv.MiddlewareProvider.RequireRole(models.RoleAdmin))
+		{
+			admin.GET("/users", srv.adminListUsers)
+			admin.GET("/users/:id", srv.adminGetUser)
+			admin.PUT("/users/:id/quota", srv.adminUpdateQuota)
+			admin.PUT("/users/:id/plan", srv.adminAssignPlan)
+			admin.POST("/users/:id/disable", srv.adminDisableUser)
+			admin.POST("/users/:id/enable", srv.adminEnableUser)
+			admin.DELETE("/users/:id/sessions", srv.adminEndUserSessions)
+			admin.POST("/users/:id/unlock", srv.adminUnlockUser)
+			admin.POST("/ips/:ip/unlock", srv.adminUnlockIP)
+			admin.POST("/reconcile", srv.adminReconcile)
+		}
 
 	}
 
@@ -2,15 +2,24 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/file_upload/config"
 	"github.com/file_upload/models"
 	"github.com/file_upload/providers"
+	"github.com/file_upload/providers/authProvider"
 	"github.com/file_upload/providers/dbHelper"
 	"github.com/file_upload/providers/dbProvider"
+	"github.com/file_upload/providers/memoryDBHelper"
 	middlewareprovider "github.com/file_upload/providers/middlewareProvider"
+	"github.com/file_upload/providers/sqlDBHelper"
+	"github.com/file_upload/providers/storageProvider"
+	"github.com/file_upload/utils"
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/mongo"
 )
@@ -18,27 +27,175 @@ import (
 type Server struct {
 	MongoClient        *mongo.Database
 	DBHelper           providers.DBHelperProvider
+	Storage            providers.StorageProvider
 	httpServer         *http.Server
 	MiddlewareProvider providers.MiddlewareProvider
+	AuthProvider       providers.AuthProvider
 	Config             *config.Config
+	uploadLocks        utils.KeyedMutex
+	blobLocks          utils.KeyedMutex
+	fileLocks          utils.KeyedMutex
+	folderLocks        utils.KeyedMutex
+	loginLocks         utils.KeyedMutex
+	reconcileLock      sync.Mutex
+	stopReconcile      chan struct{}
+	stagingDir         string
 }
 
 func SrvInit(config *config.Config) *Server {
-	mongoClient := dbProvider.ConnectDB(config.MongoURI, models.ConnectDBMaxAttempts)
-	if mongoClient == nil {
-		logrus.Error("Server Init: Failed to establish client connection to database, Error establishing database connection")
+	dbHelper, err := newDBHelper(config)
+	if err != nil {
+		logrus.Fatalf("Server Init: Failed to set up the database: %v", err)
 	}
 
-	dbHelper := dbHelper.NewDBHelperProvider(mongoClient.Client())
+	return newServer(config, dbHelper)
+}
+
+// newDBHelper returns the database selected in the config.
+func newDBHelper(config *config.Config) (providers.DBHelperProvider, error) {
+	timeouts := dbTimeouts(config.DBTimeouts)
+
+	switch config.DBDriver {
+	case "", "mongo":
+		mongoClient := dbProvider.ConnectDB(config.MongoURI, models.ConnectDBMaxAttempts)
+		if mongoClient == nil {
+			return nil, errors.New("error establishing database connection")
+		}
+		return dbHelper.NewDBHelperProvider(mongoClient.Client(), timeouts), nil
+	case "sqlite", "postgres":
+		db, err := dbProvider.ConnectSQL(config.DBDriver, config.SQL.DSN, models.ConnectDBMaxAttempts)
+		if err != nil {
+			return nil, err
+		}
+		return sqlDBHelper.NewSQLDBHelperProvider(db, config.DBDriver, timeouts)
+	case "memory":
+		logrus.Warn("Server Init: Using the in-memory database, nothing is kept after the server stops")
+		return memoryDBHelper.NewMemoryDBHelperProvider(), nil
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", config.DBDriver)
+	}
+}
+
+// dbTimeouts returns the configured database timeouts, falling back to the defaults.
+func dbTimeouts(settings config.DBTimeoutsConfig) models.DBTimeouts {
+	timeouts := models.DBTimeouts{
+		Query: models.DefaultDBQueryTimeout,
+		Batch: models.DefaultDBBatchTimeout,
+		Scan:  models.DefaultDBScanTimeout,
+	}
+
+	if settings.QuerySeconds > 0 {
+		timeouts.Query = time.Duration(settings.QuerySeconds) * time.Second
+	}
+	if settings.BatchSeconds > 0 {
+		timeouts.Batch = time.Duration(settings.BatchSeconds) * time.Second
+	}
+	if settings.ScanSeconds > 0 {
+		timeouts.Scan = time.Duration(settings.ScanSeconds) * time.Second
+	}
+
+	return timeouts
+}
+
+// authCacheTTL returns how long authenticated sessions are cached, 0 for not at all.
+func authCacheTTL(seconds int64) time.Duration {
+	switch {
+	case seconds < 0:
+		return 0
+	case seconds == 0:
+		return models.DefaultAuthCacheTTL
+	default:
+		return time.Duration(seconds) * time.Second
+	}
+}
+
+// newServer migrates the database and sets up the rest of the server on top of it.
+func newServer(config *config.Config, dbHelper providers.DBHelperProvider) *Server {
+
+	if err := dbHelper.MigrateLegacyFiles(context.Background()); err != nil {
+		logrus.Errorf("Server Init: Failed to migrate files uploaded before versioning: %v", err)
+	}
+
+	if err := dbHelper.MigrateContentTypes(context.Background()); err != nil {
+		logrus.Errorf("Server Init: Failed to set content types of files uploaded before they were recorded: %v", err)
+	}
 
-	middleWare := middlewareprovider.NewMiddleware(dbHelper)
+	// Without the unique indexes duplicate usernames and session tokens would be accepted.
+	if err := dbHelper.EnsureIndexes(context.Background()); err != nil {
+		if errors.Is(err, models.ErrRequiredIndex) {
+			logrus.Fatalf("Server Init: Failed to create required database indexes: %v", err)
+		}
+		logrus.Errorf("Server Init: Failed to create database indexes: %v", err)
+	}
+
+	srv := &Server{Config: config, stopReconcile: make(chan struct{})}
+	if err := dbHelper.MigrateUserPlans(context.Background(), srv.defaultPlan()); err != nil {
+		logrus.Errorf("Server Init: Failed to assign a plan to users registered before plans: %v", err)
+	}
 
-	return &Server{
-		DBHelper:           dbHelper,
-		MiddlewareProvider: middleWare,
-		Config:             config,
+	for _, username := range config.AdminUsernames {
+		if err := dbHelper.SetUserRoleByUsername(context.Background(), username, models.RoleAdmin); err != nil {
+			logrus.Errorf("Server Init: Failed to give %q the admin role: %v", username, err)
+		}
 	}
 
+	authProvider, err := authProvider.NewAuthProvider(config.JWT, config.JWTSecret)
+	if err != nil {
+		logrus.Fatalf("Server Init: Failed to load jwt signing keys: %v", err)
+	}
+
+	middleWare := middlewareprovider.NewMiddleware(dbHelper, authProvider, authCacheTTL(config.AuthCacheTTLSeconds))
+
+	storage, err := newStorageProvider(config.Storage)
+	if err != nil {
+		logrus.Fatalf("Server Init: Failed to set up file storage: %v", err)
+	}
+
+	srv.stagingDir = stagingDir(config.Storage)
+	if err := utils.CreateDirIfNotExist(srv.stagingDir); err != nil {
+		logrus.Fatalf("Server Init: Failed to create the staging directory: %v", err)
+	}
+
+	srv.DBHelper = dbHelper
+	srv.Storage = storage
+	srv.MiddlewareProvider = middleWare
+	srv.AuthProvider = authProvider
+
+	return srv
+
+}
+
+// newStorageProvider returns the storage backend selected in the config.
+func newStorageProvider(storageConfig config.StorageConfig) (providers.StorageProvider, error) {
+	switch storageConfig.Driver {
+	case "", "local":
+		root := storageConfig.LocalRoot
+		if root == "" {
+			root = models.DefaultDirectory
+		}
+		return storageProvider.NewLocalStorage(root), nil
+	case "s3":
+		return storageProvider.NewS3Storage(storageConfig.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", storageConfig.Driver)
+	}
+}
+
+// stagingDir returns where uploads are kept until they are stored: storage.staging_dir,
+// else the local storage root.
+func stagingDir(storageConfig config.StorageConfig) string {
+	switch {
+	case storageConfig.StagingDir != "":
+		return storageConfig.StagingDir
+	case storageConfig.LocalRoot != "":
+		return storageConfig.LocalRoot
+	}
+	return models.DefaultDirectory
+}
+
+// uploadsDir returns where the data of resumable uploads is kept until they are finalized.
+func (srv *Server) uploadsDir() string {
+	return filepath.Join(srv.stagingDir, models.UploadsDirectory)
 }
 
 func (srv *Server) Start() {
@@ -51,6 +208,8 @@ func (srv *Server) Start() {
 	srv.httpServer = httpServ
 	logrus.Info("Server running at PORT ", addr)
 
+	go srv.runReconcileSchedule()
+
 	if err := httpServ.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		logrus.Fatalf("Start %v", err)
 		return
@@ -63,6 +222,7 @@ func (srv *Server) Stop() {
 	defer cancel()
 
 	logrus.Info("closing server...")
+	close(srv.stopReconcile)
 	_ = srv.httpServer.Shutdown(ctx)
 	logrus.Info("Done")
 }
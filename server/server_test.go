@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/file_upload/config"
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const testPassword = "correct-horse"
+
+// newTestServer returns a server on the in-memory database, storing files in a temp dir.
+// configure may select another database driver.
+func newTestServer(t *testing.T, configure ...func(*config.Config)) (*Server, http.Handler) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	utils.Logging = zap.NewNop()
+
+	cfg := &config.Config{
+		DBDriver:    "memory",
+		JWTSecret:   "test-secret",
+		DefaultPlan: "free",
+		Plans: map[string]config.PlanConfig{
+			"free": {QuotaMB: 2, MaxFileSizeMB: 1, MaxFiles: 2},
+		},
+		Storage: config.StorageConfig{Driver: "local", LocalRoot: t.TempDir()},
+	}
+	for _, apply := range configure {
+		apply(cfg)
+	}
+
+	dbHelper, err := newDBHelper(cfg)
+	if err != nil {
+		t.Fatalf("set up %s database: %v", cfg.DBDriver, err)
+	}
+
+	srv := newServer(cfg, dbHelper)
+	return srv, srv.InjectRoutes()
+}
+
+// doJSON sends the body as JSON and decodes the JSON response into a map.
+func doJSON(t *testing.T, handler http.Handler, method, path, token string, body interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+
+	var reader io.Reader
+	switch body := body.(type) {
+	case nil:
+	case string:
+		reader = bytes.NewBufferString(body)
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("marshal request body: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	request := httptest.NewRequest(method, path, reader)
+	request.Header.Set("Content-Type", "application/json")
+	return serve(t, handler, request, token)
+}
+
+// doUpload sends content as the "file" part of a multipart form.
+func doUpload(t *testing.T, handler http.Handler, token, filename string, content []byte) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatalf("create form file: %v", err)
+	}
+	part.Write(content)
+	form.Close()
+
+	request := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	return serve(t, handler, request, token)
+}
+
+func serve(t *testing.T, handler http.Handler, request *http.Request, token string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	response := map[string]interface{}{}
+	if recorder.Body.Len() > 0 {
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%s %s: response is not JSON: %s", request.Method, request.URL, recorder.Body.String())
+		}
+	}
+	return recorder, response
+}
+
+// registerAndLogin creates a user and returns their access token.
+func registerAndLogin(t *testing.T, handler http.Handler, username string) string {
+	t.Helper()
+
+	recorder, response := doJSON(t, handler, http.MethodPost, "/register", "", map[string]string{
+		"name": "Test User", "username": username, "password": testPassword,
+	})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("register %q: status %d, body %v", username, recorder.Code, response)
+	}
+
+	recorder, response = doJSON(t, handler, http.MethodPost, "/login", "", models.UsernameAndPassword{Username: username, Password: testPassword})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("login %q: status %d, body %v", username, recorder.Code, response)
+	}
+	return response["token"].(string)
+}
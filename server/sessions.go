@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// listSessions returns every device the user is currently logged in on.
+func (srv *Server) listSessions(c *gin.Context) {
+	userContext := srv.MiddlewareProvider.UserFromContext(c.Request.Context())
+
+	sessions, err := srv.DBHelper.ReadUserSessions(c.Request.Context(), userContext.ID, true)
+	if err != nil {
+		utils.LogError("listSessions", "error reading user sessions", userContext.ID, err)
+		utils.RespondGenericServerErr(c, err, "could not retrieve sessions")
+		return
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt > sessions[j].LastSeenAt
+	})
+
+	sessionList := make([]map[string]interface{}, 0, len(sessions))
+	for _, session := range sessions {
+		sessionList = append(sessionList, map[string]interface{}{
+			"id":         session.ID,
+			"userAgent":  session.UserAgent,
+			"ipAddress":  session.IPAddress,
+			"startTime":  session.StartTime,
+			"lastSeenAt": session.LastSeenAt,
+			"current":    session.ID == userContext.SessionID,
+		})
+	}
+
+	utils.EncodeJSONBody(c, http.StatusOK, map[string]interface{}{
+		"user_id":  userContext.ID,
+		"sessions": sessionList,
+	})
+}
+
+// revokeSession logs out one of the user's devices.
+func (srv *Server) revokeSession(c *gin.Context) {
+	userContext := srv.MiddlewareProvider.UserFromContext(c.Request.Context())
+	sessionID := c.Param("id")
+
+	session, err := srv.DBHelper.ReadUserSessionBySessionID(c.Request.Context(), sessionID)
+	if err != nil {
+		utils.LogError("revokeSession", "error reading user session", sessionID, err)
+		utils.RespondGenericServerErr(c, err, "could not retrieve session")
+		return
+	}
+
+	if session.ID == "" || session.UserID != userContext.ID {
+		utils.RespondClientErr(c, errors.New("session not found"), http.StatusNotFound, "session not found")
+		return
+	}
+
+	if err := srv.endSession(c.Request.Context(), session); err != nil {
+		utils.LogError("revokeSession", "error revoking user session", sessionID, err)
+		utils.RespondGenericServerErr(c, err, "could not revoke session")
+		return
+	}
+
+	utils.EncodeJSONBody(c, http.StatusOK, map[string]interface{}{
+		"message": "session revoked",
+	})
+}
+
+// endSession ends the session together with the refresh tokens of its family.
+func (srv *Server) endSession(ctx context.Context, session models.UserSession) error {
+	if session.FamilyID == "" {
+		if err := srv.DBHelper.EndUserSession(ctx, session.ID); err != nil {
+			return err
+		}
+		srv.MiddlewareProvider.InvalidateSession(session.ID)
+		return nil
+	}
+
+	if err := srv.DBHelper.RevokeSessionFamily(ctx, session.FamilyID); err != nil {
+		return err
+	}
+	srv.MiddlewareProvider.InvalidateUser(session.UserID)
+	return nil
+}
+
+// evictOldestSessions makes room for a new login when the user is at the configured
+// session cap, by ending the sessions that were started first.
+func (srv *Server) evictOldestSessions(ctx context.Context, userID string) {
+	if srv.Config.MaxSessionsPerUser <= 0 {
+		return
+	}
+
+	sessions, err := srv.DBHelper.ReadUserSessions(ctx, userID, true)
+	if err != nil {
+		utils.LogError("evictOldestSessions", "error reading user sessions", userID, err)
+		return
+	}
+
+	excess := len(sessions) - srv.Config.MaxSessionsPerUser + 1
+	if excess <= 0 {
+		return
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].StartTime < sessions[j].StartTime
+	})
+
+	for _, session := range sessions[:excess] {
+		if err := srv.endSession(ctx, session); err != nil {
+			utils.LogError("evictOldestSessions", "error ending old session", session.ID, err)
+		}
+	}
+}
+
+func sessionClient(c *gin.Context) models.SessionClient {
+	return models.SessionClient{
+		UserAgent: c.Request.UserAgent(),
+		IPAddress: c.ClientIP(),
+	}
+}
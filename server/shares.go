@@ -0,0 +1,288 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// createShare makes a public link to one of the user's files. The link always serves
+// the file's current version.
+func (srv *Server) createShare(c *gin.Context) {
+	userContext := srv.MiddlewareProvider.UserFromContext(c.Request.Context())
+
+	var request models.CreateShareRequest
+
+	err := utils.DecodeJSONBody(c, &request)
+	if err != nil {
+		utils.RespondRequestBodyErr(c, err)
+		return
+	}
+
+	// bcrypt refuses passwords longer than this, and binding counts characters, not bytes.
+	if len(request.Password) > models.MaxPasswordBytes {
+		message := fmt.Sprintf("must be at most %d bytes long", models.MaxPasswordBytes)
+		utils.RespondFieldErrs(c, errors.New("share password too long"), "request body has invalid fields", models.FieldError{Field: "password", Message: message})
+		return
+	}
+
+	fileData, ok := srv.getFile(c, userContext.ID, "createShare")
+	if !ok {
+		return
+	}
+
+	token, err := utils.GenerateSecureToken()
+	if err != nil {
+		utils.LogError("createShare", "error generating share token", fileData.ID, err)
+		utils.RespondGenericServerErr(c, err, "could not create share link")
+		return
+	}
+
+	share := models.Share{
+		ID:           uuid.NewString(),
+		UserID:       userContext.ID,
+		FileID:       fileData.ID,
+		TokenHash:    utils.HashToken(token),
+		MaxDownloads: request.MaxDownloads,
+		CreatedAt:    time.Now().Unix(),
+		Token:        token,
+	}
+	if request.ExpiresIn > 0 {
+		share.ExpiresAt = time.Now().Add(time.Duration(request.ExpiresIn) * time.Second).Unix()
+	}
+
+	if request.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+		if err != nil {
+			utils.LogError("createShare", "error hashing share password", fileData.ID, err)
+			utils.RespondGenericServerErr(c, err, "could not create share link")
+			return
+		}
+		share.PasswordHash = string(hash)
+	}
+
+	if err := srv.DBHelper.CreateShare(c.Request.Context(), share); err != nil {
+		utils.LogError("createShare", "error saving share link", fileData.ID, err)
+		utils.RespondGenericServerErr(c, err, "could not create share link")
+		return
+	}
+
+	// The token is only ever shown here; just its hash is stored.
+	view := shareView(share)
+	view["filename"] = fileData.Filename
+	view["token"] = share.Token
+	view["url"] = models.SharePath + share.Token
+
+	utils.EncodeJSONBody(c, http.StatusCreated, view)
+}
+
+// listShares returns the user's share links, newest first.
+func (srv *Server) listShares(c *gin.Context) {
+	userContext := srv.MiddlewareProvider.UserFromContext(c.Request.Context())
+
+	shares, err := srv.DBHelper.GetSharesByUser(c.Request.Context(), userContext.ID)
+	if err != nil {
+		utils.LogError("listShares", "error fetching share links", userContext.ID, err)
+		utils.RespondGenericServerErr(c, err, "could not retrieve share links")
+		return
+	}
+
+	shareList := make([]map[string]interface{}, 0, len(shares))
+	for _, share := range shares {
+		shareList = append(shareList, shareView(share))
+	}
+
+	utils.EncodeJSONBody(c, http.StatusOK, map[string]interface{}{
+		"user_id": userContext.ID,
+		"shares":  shareList,
+	})
+}
+
+// revokeShare deletes one of the user's share links; it stops working immediately.
+func (srv *Server) revokeShare(c *gin.Context) {
+	userContext := srv.MiddlewareProvider.UserFromContext(c.Request.Context())
+	shareID := c.Param("id")
+
+	if err := srv.DBHelper.DeleteShare(c.Request.Context(), userContext.ID, shareID); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			utils.RespondClientErr(c, err, http.StatusNotFound, "share link not found")
+			return
+		}
+		utils.LogError("revokeShare", "error revoking share link", shareID, err)
+		utils.RespondGenericServerErr(c, err, "could not revoke share link")
+		return
+	}
+
+	utils.EncodeJSONBody(c, http.StatusOK, map[string]interface{}{
+		"message": "share link revoked",
+	})
+}
+
+// downloadShare serves the file behind a share link to anyone holding the token. Only
+// complete downloads count: range requests and conditional requests answered with 304
+// don't. Wrong passwords are locked out the same way failed logins are.
+func (srv *Server) downloadShare(c *gin.Context) {
+
+	share, err := srv.DBHelper.GetShareByToken(c.Request.Context(), utils.HashToken(c.Param("token")))
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			utils.RespondClientErr(c, err, http.StatusNotFound, "share link not found")
+			return
+		}
+		utils.LogError("downloadShare", "error fetching share link", "", err)
+		utils.RespondGenericServerErr(c, err, "could not retrieve share link")
+		return
+	}
+
+	now := time.Now().Unix()
+	if share.ExpiresAt > 0 && share.ExpiresAt <= now {
+		respondShareErr(c, share.ID, models.ErrShareExpired)
+		return
+	}
+	if share.MaxDownloads > 0 && share.Downloads >= share.MaxDownloads {
+		respondShareErr(c, share.ID, models.ErrShareExhausted)
+		return
+	}
+
+	if share.PasswordHash != "" && !srv.checkSharePassword(c, share) {
+		return
+	}
+
+	owner, err := srv.DBHelper.GetUserByID(c.Request.Context(), share.UserID)
+	if err != nil && !errors.Is(err, models.ErrNotFound) {
+		utils.LogError("downloadShare", "error fetching share owner", share.UserID, err)
+		utils.RespondGenericServerErr(c, err, "could not retrieve share link")
+		return
+	}
+	if err != nil || owner.Disabled {
+		utils.RespondClientErr(c, errors.New("share owner unavailable"), http.StatusNotFound, "share link not found")
+		return
+	}
+
+	fileData, err := srv.DBHelper.GetFileByID(c.Request.Context(), share.UserID, share.FileID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			utils.RespondClientErr(c, err, http.StatusNotFound, "shared file no longer exists")
+			return
+		}
+		utils.LogError("downloadShare", "error fetching shared file", share.FileID, err)
+		utils.RespondGenericServerErr(c, err, "could not retrieve shared file")
+		return
+	}
+
+	// Count the download atomically, so concurrent requests can't exceed the limit. What
+	// gets sent is only known once http.ServeContent writes the status.
+	writer := &shareDownloadWriter{ResponseWriter: c.Writer, claim: func() error {
+		_, err := srv.DBHelper.ClaimShareDownload(c.Request.Context(), share.ID, now)
+		return err
+	}}
+	c.Writer = writer
+	srv.serveStoredFile(c, fileData.Filename, fileData.Path, fileData.Hash, fileData.UploadedAt)
+	c.Writer = writer.ResponseWriter
+
+	if writer.claimErr != nil {
+		respondShareErr(c, share.ID, writer.claimErr)
+	}
+}
+
+// shareDownloadWriter claims a share link download when a full response (200) is about
+// to be written. If the claim fails nothing is written, so the caller can respond with
+// the error instead.
+type shareDownloadWriter struct {
+	gin.ResponseWriter
+	claim    func() error
+	claimErr error
+}
+
+var errShareClaimFailed = errors.New("share link download not claimed")
+
+func (w *shareDownloadWriter) WriteHeader(code int) {
+	if code == http.StatusOK && !w.Written() {
+		if w.claimErr = w.claim(); w.claimErr != nil {
+			// Drop the headers http.ServeContent set for the file.
+			for _, header := range []string{"Content-Length", "Content-Type", "Content-Range", "Content-Disposition", "Content-Encoding", "Accept-Ranges", "ETag", "Last-Modified"} {
+				w.Header().Del(header)
+			}
+			return
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *shareDownloadWriter) Write(data []byte) (int, error) {
+	if w.claimErr != nil {
+		return 0, errShareClaimFailed
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// checkSharePassword compares the password sent with the request to the share's,
+// responding with an error when it does not match.
+func (srv *Server) checkSharePassword(c *gin.Context, share *models.Share) bool {
+	key := shareLoginKey(share.ID)
+
+	lockedFor, err := srv.loginLockedFor(c.Request.Context(), key)
+	if err != nil {
+		utils.LogError("checkSharePassword", "error checking share lockout", share.ID, err)
+		utils.RespondGenericServerErr(c, err, "error checking share lockout")
+		return false
+	}
+	if lockedFor > 0 {
+		c.Header("Retry-After", strconv.FormatInt(int64(math.Ceil(lockedFor.Seconds())), 10))
+		utils.RespondClientErr(c, errors.New("share locked"), http.StatusTooManyRequests, "too many wrong passwords, try again later")
+		return false
+	}
+
+	password := c.GetHeader(models.SharePasswordHeader)
+	if bcrypt.CompareHashAndPassword([]byte(share.PasswordHash), []byte(password)) != nil {
+		if password != "" {
+			srv.recordLoginFailure(c.Request.Context(), key)
+		}
+		utils.RespondClientErr(c, errors.New("wrong share password"), http.StatusUnauthorized, "a valid password is required in the "+models.SharePasswordHeader+" header")
+		return false
+	}
+
+	srv.clearLoginFailures(c.Request.Context(), key)
+	return true
+}
+
+func shareLoginKey(shareID string) string {
+	return "share:" + shareID
+}
+
+// respondShareErr maps the errors returned when a share link is used to a response.
+func respondShareErr(c *gin.Context, shareID string, err error) {
+	switch {
+	case errors.Is(err, models.ErrShareExpired):
+		utils.RespondClientErr(c, err, http.StatusGone, "share link expired")
+	case errors.Is(err, models.ErrShareExhausted):
+		utils.RespondClientErr(c, err, http.StatusGone, "share link download limit reached")
+	case errors.Is(err, models.ErrNotFound):
+		utils.RespondClientErr(c, err, http.StatusNotFound, "share link not found")
+	default:
+		utils.LogError("downloadShare", "error using share link", shareID, err)
+		utils.RespondGenericServerErr(c, err, "could not use share link")
+	}
+}
+
+// shareView is the share link as shown to its owner, without the token or password hash.
+func shareView(share models.Share) map[string]interface{} {
+	return map[string]interface{}{
+		"id":                 share.ID,
+		"file_id":            share.FileID,
+		"expires_at":         share.ExpiresAt,
+		"max_downloads":      share.MaxDownloads,
+		"downloads":          share.Downloads,
+		"password_protected": share.PasswordHash != "",
+		"created_at":         share.CreatedAt,
+	}
+}
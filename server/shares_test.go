@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/file_upload/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestCreateShare(t *testing.T) {
+	_, handler := newTestServer(t)
+	token := registerAndLogin(t, handler, "ada")
+
+	recorder, response := doUpload(t, handler, token, "hello.txt", []byte("hello, world"))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("upload: status %d, body %v", recorder.Code, response)
+	}
+	fileID := response["fileID"].(string)
+
+	tests := []struct {
+		name       string
+		body       map[string]interface{}
+		wantStatus int
+		wantField  string
+	}{
+		{name: "valid", body: map[string]interface{}{"expires_in": 3600, "password": "secret", "max_downloads": 3}, wantStatus: http.StatusCreated},
+		{name: "expiry a year out", body: map[string]interface{}{"expires_in": 365 * 24 * 3600}, wantStatus: http.StatusCreated},
+		{name: "expiry past a year", body: map[string]interface{}{"expires_in": 365*24*3600 + 1}, wantStatus: http.StatusBadRequest, wantField: "expires_in"},
+		{name: "overflowing expiry", body: map[string]interface{}{"expires_in": int64(1) << 62}, wantStatus: http.StatusBadRequest, wantField: "expires_in"},
+		{name: "negative expiry", body: map[string]interface{}{"expires_in": -1}, wantStatus: http.StatusBadRequest, wantField: "expires_in"},
+		// 36 characters, but 72 bytes.
+		{name: "multibyte password at the limit", body: map[string]interface{}{"password": strings.Repeat("é", 36)}, wantStatus: http.StatusCreated},
+		// 37 characters, but 74 bytes.
+		{name: "multibyte password too long", body: map[string]interface{}{"password": strings.Repeat("é", 37)}, wantStatus: http.StatusBadRequest, wantField: "password"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder, response := doJSON(t, handler, http.MethodPost, "/files/"+fileID+"/share", token, tt.body)
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d, body %v", recorder.Code, tt.wantStatus, response)
+			}
+			if tt.wantField != "" && !hasFieldError(response, tt.wantField) {
+				t.Errorf("response %v has no error for %q", response, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestDownloadShareLimit(t *testing.T) {
+	_, handler := newTestServer(t)
+	token := registerAndLogin(t, handler, "ada")
+
+	content := []byte("hello, world")
+	recorder, response := doUpload(t, handler, token, "hello.txt", content)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("upload: status %d, body %v", recorder.Code, response)
+	}
+
+	recorder, response = doJSON(t, handler, http.MethodPost, "/files/"+response["fileID"].(string)+"/share", token, map[string]interface{}{"max_downloads": 1})
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("create share: status %d, body %v", recorder.Code, response)
+	}
+	url := response["url"].(string)
+
+	download := func(header, value string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, url, nil)
+		if header != "" {
+			request.Header.Set(header, value)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	// None of these send the whole file, so none of them use up the only download.
+	for i := 0; i < 3; i++ {
+		recorder := download("Range", "bytes=0-4")
+		if recorder.Code != http.StatusPartialContent || recorder.Body.String() != "hello" {
+			t.Fatalf("range request %d: status %d, body %q", i, recorder.Code, recorder.Body.String())
+		}
+		if recorder := download("If-None-Match", recorder.Header().Get("ETag")); recorder.Code != http.StatusNotModified {
+			t.Fatalf("conditional request %d: status %d, body %q", i, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	recorder = download("", "")
+	if recorder.Code != http.StatusOK || !bytes.Equal(recorder.Body.Bytes(), content) {
+		t.Fatalf("download: status %d, body %q", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = download("", "")
+	if recorder.Code != http.StatusGone {
+		t.Fatalf("download past the limit: status %d, body %q", recorder.Code, recorder.Body.String())
+	}
+}
+
+// A download claimed by another request between the limit check and the response is
+// refused without sending any of the file.
+func TestShareDownloadWriterClaimFailed(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodGet, models.SharePath+"token", nil)
+
+	writer := &shareDownloadWriter{ResponseWriter: c.Writer, claim: func() error { return models.ErrShareExhausted }}
+	c.Writer = writer
+	http.ServeContent(c.Writer, c.Request, "hello.txt", time.Time{}, strings.NewReader("hello, world"))
+	c.Writer = writer.ResponseWriter
+	respondShareErr(c, "share-id", writer.claimErr)
+
+	if recorder.Code != http.StatusGone {
+		t.Fatalf("status = %d; want %d", recorder.Code, http.StatusGone)
+	}
+	if strings.Contains(recorder.Body.String(), "hello") || recorder.Header().Get("Accept-Ranges") != "" {
+		t.Fatalf("file sent with the error: headers %v, body %q", recorder.Header(), recorder.Body.String())
+	}
+}
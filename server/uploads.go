@@ -0,0 +1,343 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// createUpload opens a resumable upload session. The client then sends the data with
+// PATCH requests at the offset reported by HEAD, and finishes with finalize. A session
+// expires UploadTTL after it last received data.
+func (srv *Server) createUpload(c *gin.Context) {
+	userContext := srv.MiddlewareProvider.UserFromContext(c.Request.Context())
+
+	var request models.CreateUploadRequest
+
+	err := utils.DecodeJSONBody(c, &request)
+	if err != nil {
+		utils.RespondRequestBodyErr(c, err)
+		return
+	}
+
+	filename, err := utils.SanitizeFilename(request.Filename)
+	if err != nil {
+		utils.RespondFieldErrs(c, err, "invalid filename", utils.NewFieldError("filename", err, models.ErrInvalidFilename))
+		return
+	}
+
+	folderID, ok := srv.resolveFolder(c, userContext.ID, request.FolderID, "folder_id", "createUpload")
+	if !ok {
+		return
+	}
+
+	// Fail fast on what the plan can never allow. Used storage is only checked when the
+	// quota is reserved as the upload is finalized.
+	if userContext.MaxFileSize > 0 && request.Size > userContext.MaxFileSize {
+		respondStoreFileErr(c, "createUpload", filename, request.Size, models.ErrFileTooLarge)
+		return
+	}
+	if request.Size > uploadSizeLimit(userContext) {
+		respondStoreFileErr(c, "createUpload", filename, request.Size, models.ErrInsufficientStorage)
+		return
+	}
+
+	// Counting and creating under one lock keeps parallel requests from opening more
+	// than MaxOpenUploads between them.
+	unlock := srv.uploadLocks.Lock("user:" + userContext.ID)
+	defer unlock()
+
+	now := time.Now()
+	openUploads, err := srv.DBHelper.CountOpenUploads(c.Request.Context(), userContext.ID, now.Unix())
+	if err != nil {
+		utils.LogError("createUpload", "error counting open uploads", userContext.ID, err)
+		utils.RespondGenericServerErr(c, err, "could not create upload")
+		return
+	}
+	if openUploads >= models.MaxOpenUploads {
+		utils.RespondClientErr(c, models.ErrTooManyUploads, http.StatusTooManyRequests, "too many open uploads, finish or cancel one first")
+		return
+	}
+
+	err = utils.CreateDirIfNotExist(srv.uploadsDir())
+	if err != nil {
+		utils.LogError("createUpload", "error creating uploads directory", "", err)
+		utils.RespondGenericServerErr(c, err, "could not create upload")
+		return
+	}
+
+	upload := models.Upload{
+		ID:        uuid.NewString(),
+		UserID:    userContext.ID,
+		Filename:  filename,
+		FolderID:  folderID,
+		Size:      request.Size,
+		CreatedAt: now.Unix(),
+		UpdatedAt: now.Unix(),
+		ExpiresAt: now.Add(models.UploadTTL).Unix(),
+	}
+	upload.TempPath, err = utils.UploadTempPath(srv.uploadsDir(), upload.ID)
+	if err != nil {
+		utils.LogError("createUpload", "error deriving upload temp path", upload, err)
+		utils.RespondGenericServerErr(c, err, "could not create upload")
+		return
+	}
+
+	tempFile, err := os.Create(upload.TempPath)
+	if err != nil {
+		utils.LogError("createUpload", "error creating upload temp file", upload, err)
+		utils.RespondGenericServerErr(c, err, "could not create upload")
+		return
+	}
+	tempFile.Close()
+
+	if err := srv.DBHelper.CreateUpload(c.Request.Context(), upload); err != nil {
+		os.Remove(upload.TempPath)
+		utils.LogError("createUpload", "error saving upload session", upload, err)
+		utils.RespondGenericServerErr(c, err, "could not create upload")
+		return
+	}
+
+	c.Header("Location", "/uploads/"+upload.ID)
+	c.Header(models.UploadOffsetHeader, "0")
+	c.Header(models.UploadLengthHeader, strconv.FormatInt(upload.Size, 10))
+	utils.EncodeJSONBody(c, http.StatusCreated, upload)
+}
+
+// uploadStatus reports how many bytes of the upload the server already has.
+func (srv *Server) uploadStatus(c *gin.Context) {
+	userContext := srv.MiddlewareProvider.UserFromContext(c.Request.Context())
+
+	upload, ok := srv.getUpload(c, userContext.ID, "uploadStatus")
+	if !ok {
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header(models.UploadOffsetHeader, strconv.FormatInt(upload.Offset, 10))
+	c.Header(models.UploadLengthHeader, strconv.FormatInt(upload.Size, 10))
+	c.Status(http.StatusOK)
+}
+
+// uploadChunk appends the request body to the upload at the offset given in the
+// Upload-Offset header. Whatever arrives before a dropped connection or a cancelled
+// request is kept, so the client can resume from there.
+func (srv *Server) uploadChunk(c *gin.Context) {
+	userContext := srv.MiddlewareProvider.UserFromContext(c.Request.Context())
+
+	offset, err := strconv.ParseInt(c.GetHeader(models.UploadOffsetHeader), 10, 64)
+	if err != nil {
+		utils.RespondClientErr(c, err, http.StatusBadRequest, "invalid Upload-Offset header")
+		return
+	}
+
+	unlock := srv.uploadLocks.Lock(c.Param("id"))
+	defer unlock()
+
+	upload, ok := srv.getUpload(c, userContext.ID, "uploadChunk")
+	if !ok {
+		return
+	}
+
+	if offset != upload.Offset {
+		c.Header(models.UploadOffsetHeader, strconv.FormatInt(upload.Offset, 10))
+		utils.RespondClientErr(c, fmt.Errorf("offset %d does not match upload offset %d", offset, upload.Offset), http.StatusConflict, "upload offset mismatch")
+		return
+	}
+
+	if c.Request.ContentLength > upload.Size-upload.Offset {
+		utils.RespondClientErr(c, errors.New("chunk exceeds declared upload size"), http.StatusRequestEntityTooLarge, "chunk too large")
+		return
+	}
+
+	fileHash, err := restoreHash(upload.HashState)
+	if err != nil {
+		utils.LogError("uploadChunk", "error restoring upload hash state", upload.ID, err)
+		utils.RespondGenericServerErr(c, err, "could not resume upload")
+		return
+	}
+
+	tempFile, err := os.OpenFile(upload.TempPath, os.O_WRONLY, 0644)
+	if err != nil {
+		utils.LogError("uploadChunk", "error opening upload temp file", upload.ID, err)
+		utils.RespondGenericServerErr(c, err, "could not resume upload")
+		return
+	}
+	defer tempFile.Close()
+
+	// Drop anything past the recorded offset, left over from an interrupted chunk.
+	if err := tempFile.Truncate(upload.Offset); err != nil {
+		utils.LogError("uploadChunk", "error truncating upload temp file", upload.ID, err)
+		utils.RespondGenericServerErr(c, err, "could not resume upload")
+		return
+	}
+	if _, err := tempFile.Seek(upload.Offset, io.SeekStart); err != nil {
+		utils.LogError("uploadChunk", "error seeking upload temp file", upload.ID, err)
+		utils.RespondGenericServerErr(c, err, "could not resume upload")
+		return
+	}
+
+	body := utils.ContextReader(c.Request.Context(), io.LimitReader(c.Request.Body, upload.Size-upload.Offset))
+	written, copyErr := io.Copy(io.MultiWriter(tempFile, fileHash), body)
+	if written > 0 {
+		hashState, err := fileHash.(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			utils.LogError("uploadChunk", "error saving upload hash state", upload.ID, err)
+			utils.RespondGenericServerErr(c, err, "could not save upload progress")
+			return
+		}
+
+		// Record the progress even when the request was cancelled during the copy.
+		err = srv.DBHelper.UpdateUploadOffset(context.WithoutCancel(c.Request.Context()), upload.ID, upload.Offset, upload.Offset+written, hashState)
+		if err != nil {
+			// Without the new offset the data is unaccounted for; drop it now rather than
+			// leaving it for the next chunk to truncate.
+			if err := tempFile.Truncate(upload.Offset); err != nil {
+				utils.LogError("uploadChunk", "error truncating upload temp file", upload.ID, err)
+			}
+			if errors.Is(err, models.ErrUploadOffsetChanged) {
+				utils.RespondClientErr(c, err, http.StatusConflict, "upload offset mismatch")
+				return
+			}
+			utils.LogError("uploadChunk", "error saving upload progress", upload.ID, err)
+			utils.RespondGenericServerErr(c, err, "could not save upload progress")
+			return
+		}
+	}
+
+	c.Header(models.UploadOffsetHeader, strconv.FormatInt(upload.Offset+written, 10))
+
+	if copyErr != nil {
+		if ctxErr := c.Request.Context().Err(); ctxErr != nil {
+			utils.LogWarning("uploadChunk", "upload chunk cancelled by the client", upload.ID, ctxErr)
+			c.AbortWithStatus(models.StatusClientClosedRequest)
+			return
+		}
+		utils.LogError("uploadChunk", "error receiving upload chunk", upload.ID, copyErr)
+		utils.RespondClientErr(c, copyErr, http.StatusBadRequest, "upload chunk interrupted")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// finalizeUpload turns a complete upload into a file, with the same dedupe and quota
+// accounting as a single-shot upload.
+func (srv *Server) finalizeUpload(c *gin.Context) {
+	userContext := srv.MiddlewareProvider.UserFromContext(c.Request.Context())
+
+	unlock := srv.uploadLocks.Lock(c.Param("id"))
+	defer unlock()
+
+	upload, ok := srv.getUpload(c, userContext.ID, "finalizeUpload")
+	if !ok {
+		return
+	}
+
+	if upload.Offset != upload.Size {
+		c.Header(models.UploadOffsetHeader, strconv.FormatInt(upload.Offset, 10))
+		utils.RespondClientErr(c, fmt.Errorf("received %d of %d bytes", upload.Offset, upload.Size), http.StatusConflict, "upload is not complete")
+		return
+	}
+
+	fileHash, err := restoreHash(upload.HashState)
+	if err != nil {
+		utils.LogError("finalizeUpload", "error restoring upload hash state", upload.ID, err)
+		utils.RespondGenericServerErr(c, err, "could not finalize upload")
+		return
+	}
+
+	newFile, err := srv.storeFile(c.Request.Context(), userContext, upload.TempPath, upload.FolderID, upload.Filename, fmt.Sprintf("%x", fileHash.Sum(nil)), upload.Size)
+	if err != nil {
+		// A duplicate will never succeed, so the session is dropped. Anything else can be retried.
+		if errors.Is(err, models.ErrDuplicateFile) {
+			srv.removeUpload(c.Request.Context(), upload)
+		}
+		respondStoreFileErr(c, "finalizeUpload", upload.Filename, upload.Size, err)
+		return
+	}
+
+	srv.removeUpload(c.Request.Context(), upload)
+
+	utils.EncodeJSONBody(c, http.StatusOK, map[string]interface{}{
+		"message":  "file uploaded successfully",
+		"filename": newFile.Filename,
+		"fileID":   newFile.ID,
+		"userID":   userContext.ID,
+	})
+}
+
+// cancelUpload abandons an upload and throws away the data received so far.
+func (srv *Server) cancelUpload(c *gin.Context) {
+	userContext := srv.MiddlewareProvider.UserFromContext(c.Request.Context())
+
+	unlock := srv.uploadLocks.Lock(c.Param("id"))
+	defer unlock()
+
+	upload, ok := srv.getUpload(c, userContext.ID, "cancelUpload")
+	if !ok {
+		return
+	}
+
+	srv.removeUpload(c.Request.Context(), upload)
+
+	c.Status(http.StatusNoContent)
+}
+
+// getUpload loads the upload named in the route and writes the error response if it
+// can't. Expired uploads are not found; the reconciliation job deletes them.
+func (srv *Server) getUpload(c *gin.Context, userID, source string) (*models.Upload, bool) {
+	upload, err := srv.DBHelper.GetUploadByID(c.Request.Context(), userID, c.Param("id"))
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			utils.RespondClientErr(c, err, http.StatusNotFound, "upload not found")
+			return nil, false
+		}
+		utils.LogError(source, "error fetching upload session", c.Param("id"), err)
+		utils.RespondGenericServerErr(c, err, "could not retrieve upload")
+		return nil, false
+	}
+	if upload.ExpiresAt <= time.Now().Unix() {
+		utils.RespondClientErr(c, fmt.Errorf("upload %s expired", upload.ID), http.StatusNotFound, "upload expired")
+		return nil, false
+	}
+
+	return upload, true
+}
+
+// removeUpload deletes the upload session and its data. It runs to the end even when
+// ctx is cancelled, so a finalized upload never leaves its session behind.
+func (srv *Server) removeUpload(ctx context.Context, upload *models.Upload) {
+	if err := srv.DBHelper.DeleteUpload(context.WithoutCancel(ctx), upload.ID); err != nil {
+		utils.LogError("removeUpload", "error deleting upload session", upload.ID, err)
+	}
+	if err := os.Remove(upload.TempPath); err != nil && !os.IsNotExist(err) {
+		utils.LogError("removeUpload", "error removing upload temp file", upload.ID, err)
+	}
+}
+
+// restoreHash rebuilds the running SHA-256 of an upload from its saved state.
+func restoreHash(state []byte) (hash.Hash, error) {
+	fileHash := sha256.New()
+	if len(state) == 0 {
+		return fileHash, nil
+	}
+
+	if err := fileHash.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		return nil, err
+	}
+	return fileHash, nil
+}
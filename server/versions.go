@@ -0,0 +1,176 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/file_upload/models"
+	"github.com/file_upload/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func (srv *Server) listFileVersions(c *gin.Context) {
+	userContext := srv.MiddlewareProvider.UserFromContext(c.Request.Context())
+
+	fileData, ok := srv.getFile(c, userContext.ID, "listFileVersions")
+	if !ok {
+		return
+	}
+
+	versions, err := srv.DBHelper.GetFileVersions(c.Request.Context(), userContext.ID, fileData.ID)
+	if err != nil {
+		utils.LogError("listFileVersions", "fetching file versions", fileData.ID, err)
+		utils.RespondGenericServerErr(c, err, "could not retrieve file versions")
+		return
+	}
+
+	utils.EncodeJSONBody(c, http.StatusOK, map[string]interface{}{
+		"file_id":         fileData.ID,
+		"filename":        fileData.Filename,
+		"current_version": fileData.Version,
+		"versions":        versions,
+	})
+}
+
+func (srv *Server) downloadFileVersion(c *gin.Context) {
+	userContext := srv.MiddlewareProvider.UserFromContext(c.Request.Context())
+
+	fileData, ok := srv.getFile(c, userContext.ID, "downloadFileVersion")
+	if !ok {
+		return
+	}
+
+	version, ok := srv.getFileVersion(c, userContext.ID, fileData.ID, "downloadFileVersion")
+	if !ok {
+		return
+	}
+
+	srv.serveStoredFile(c, fileData.Filename, version.Path, version.Hash, version.UploadedAt)
+}
+
+// restoreFileVersion makes an old version current again by adding it as a new version.
+// The restored copy counts against the quota like any other version. It runs under the
+// file lock, so it can't race an upload of a new version or another restore.
+func (srv *Server) restoreFileVersion(c *gin.Context) {
+	userContext := srv.MiddlewareProvider.UserFromContext(c.Request.Context())
+
+	fileData, unlock, ok := srv.lockFile(c, userContext.ID, "restoreFileVersion")
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	oldVersion, ok := srv.getFileVersion(c, userContext.ID, fileData.ID, "restoreFileVersion")
+	if !ok {
+		return
+	}
+
+	if oldVersion.Version == fileData.Version {
+		utils.RespondClientErr(c, errors.New("version is already current"), http.StatusConflict, "version is already the current version")
+		return
+	}
+
+	err := srv.DBHelper.ReserveStorage(c.Request.Context(), userContext.ID, oldVersion.Size, 0)
+	if err != nil {
+		respondStoreFileErr(c, "restoreFileVersion", fileData.Filename, oldVersion.Size, err)
+		return
+	}
+
+	if err := srv.acquireBlob(c.Request.Context(), oldVersion.Path, oldVersion.Hash, oldVersion.Size); err != nil {
+		srv.releaseStorage(c.Request.Context(), userContext.ID, oldVersion.Size, 0)
+		utils.LogError("restoreFileVersion", "error referencing version content", oldVersion, err)
+		utils.RespondGenericServerErr(c, err, "could not restore file version")
+		return
+	}
+
+	newVersion, err := srv.DBHelper.AddFileVersion(c.Request.Context(), models.FileVersion{
+		ID:          uuid.NewString(),
+		FileID:      fileData.ID,
+		UserID:      userContext.ID,
+		Size:        oldVersion.Size,
+		Path:        oldVersion.Path,
+		Hash:        oldVersion.Hash,
+		ContentType: oldVersion.ContentType,
+		UploadedAt:  time.Now().Unix(),
+	})
+	if err != nil {
+		srv.releaseBlob(c.Request.Context(), oldVersion.Path, oldVersion.Hash)
+		srv.releaseStorage(c.Request.Context(), userContext.ID, oldVersion.Size, 0)
+		utils.LogError("restoreFileVersion", "error adding restored version", oldVersion, err)
+		utils.RespondGenericServerErr(c, err, "could not restore file version")
+		return
+	}
+
+	utils.EncodeJSONBody(c, http.StatusOK, map[string]interface{}{
+		"message":       "file version restored",
+		"file_id":       fileData.ID,
+		"restored_from": oldVersion.Version,
+		"version":       newVersion.Version,
+	})
+}
+
+// getFile loads the file named in the route and writes the error response if it can't.
+func (srv *Server) getFile(c *gin.Context, userID, source string) (*models.File, bool) {
+	fileData, err := srv.DBHelper.GetFileByID(c.Request.Context(), userID, c.Param("id"))
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			utils.RespondClientErr(c, err, http.StatusNotFound, "file not found")
+			return nil, false
+		}
+		utils.LogError(source, "error fetching file metadata", c.Param("id"), err)
+		utils.RespondGenericServerErr(c, err, "could not retrieve file metadata")
+		return nil, false
+	}
+
+	return fileData, true
+}
+
+// lockFile loads the file named in the route and takes the lock on its name, the one
+// uploads and moves take. The file is read again under the lock, and the lock taken
+// again if the file was moved or renamed in between.
+func (srv *Server) lockFile(c *gin.Context, userID, source string) (*models.File, func(), bool) {
+	fileData, ok := srv.getFile(c, userID, source)
+	if !ok {
+		return nil, nil, false
+	}
+
+	for {
+		key := fileLockKey(userID, fileData.FolderID, fileData.Filename)
+		unlock := srv.fileLocks.Lock(key)
+
+		fileData, ok = srv.getFile(c, userID, source)
+		if !ok {
+			unlock()
+			return nil, nil, false
+		}
+		if fileLockKey(userID, fileData.FolderID, fileData.Filename) == key {
+			return fileData, unlock, true
+		}
+		unlock()
+	}
+}
+
+// getFileVersion loads the version named in the route and writes the error response if it can't.
+func (srv *Server) getFileVersion(c *gin.Context, userID, fileID, source string) (*models.FileVersion, bool) {
+	versionNumber, err := strconv.ParseInt(c.Param("version"), 10, 64)
+	if err != nil {
+		utils.RespondClientErr(c, err, http.StatusBadRequest, "invalid version number")
+		return nil, false
+	}
+
+	version, err := srv.DBHelper.GetFileVersion(c.Request.Context(), userID, fileID, versionNumber)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			utils.RespondClientErr(c, err, http.StatusNotFound, "file version not found")
+			return nil, false
+		}
+		utils.LogError(source, "error fetching file version", c.Param("version"), err)
+		utils.RespondGenericServerErr(c, err, "could not retrieve file version")
+		return nil, false
+	}
+
+	return version, true
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// ContentTypeByName guesses a media type from the file name's extension, or returns "".
+func ContentTypeByName(filename string) string {
+	return mediaType(mime.TypeByExtension(filepath.Ext(filename)))
+}
+
+// DetectContentType returns the media type of a stored upload, from its name when the
+// extension is known and otherwise by sniffing the first bytes of its content.
+// Parameters such as charset are dropped, so the result can be filtered on exactly.
+func DetectContentType(path, filename string) string {
+	if contentType := ContentTypeByName(filename); contentType != "" {
+		return contentType
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "application/octet-stream"
+	}
+
+	return mediaType(http.DetectContentType(head[:n]))
+}
+
+func mediaType(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
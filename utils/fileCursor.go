@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/file_upload/models"
+)
+
+// FileCursor marks the last file of a page. The next page starts after it in the
+// same sort order, so pages stay stable while files are added or removed.
+type FileCursor struct {
+	SortBy     string `json:"s"`
+	Descending bool   `json:"d"`
+	Filename   string `json:"f,omitempty"`
+	Number     int64  `json:"n,omitempty"`
+	ID         string `json:"i"`
+}
+
+// EncodeFileCursor returns the opaque cursor for the page of the query ending with last.
+func EncodeFileCursor(query models.FileQuery, last models.File) string {
+	after := FileCursor{SortBy: query.SortBy, Descending: query.Descending, ID: last.ID}
+
+	switch query.SortBy {
+	case models.FileSortByName:
+		after.Filename = last.Filename
+	case models.FileSortBySize:
+		after.Number = last.Size
+	default:
+		after.Number = last.UploadedAt
+	}
+
+	data, _ := json.Marshal(after)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeFileCursor reads the query's cursor, refusing cursors from a listing in another order.
+func DecodeFileCursor(query models.FileQuery) (FileCursor, error) {
+	var after FileCursor
+
+	data, err := base64.RawURLEncoding.DecodeString(query.Cursor)
+	if err != nil {
+		return after, models.ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &after); err != nil || after.ID == "" {
+		return after, models.ErrInvalidCursor
+	}
+	if after.SortBy != query.SortBy || after.Descending != query.Descending {
+		return after, fmt.Errorf("%w: it belongs to a listing in another order", models.ErrInvalidCursor)
+	}
+
+	return after, nil
+}
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/file_upload/models"
+)
+
+func TestFileCursor(t *testing.T) {
+	last := models.File{ID: "file-id", Filename: "report.pdf", Size: 2048, UploadedAt: 1700000000}
+
+	tests := []struct {
+		name       string
+		sortBy     string
+		descending bool
+		want       FileCursor
+	}{
+		{name: "name", sortBy: models.FileSortByName, want: FileCursor{SortBy: models.FileSortByName, Filename: "report.pdf", ID: "file-id"}},
+		{name: "size descending", sortBy: models.FileSortBySize, descending: true, want: FileCursor{SortBy: models.FileSortBySize, Descending: true, Number: 2048, ID: "file-id"}},
+		{name: "date", sortBy: models.FileSortByDate, want: FileCursor{SortBy: models.FileSortByDate, Number: 1700000000, ID: "file-id"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := models.FileQuery{SortBy: tt.sortBy, Descending: tt.descending}
+			query.Cursor = EncodeFileCursor(query, last)
+
+			got, err := DecodeFileCursor(query)
+			if err != nil {
+				t.Fatalf("DecodeFileCursor: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("DecodeFileCursor = %+v; want %+v", got, tt.want)
+			}
+
+			query.Descending = !query.Descending
+			if _, err := DecodeFileCursor(query); !errors.Is(err, models.ErrInvalidCursor) {
+				t.Fatalf("DecodeFileCursor in the other order = %v; want ErrInvalidCursor", err)
+			}
+		})
+	}
+
+	for _, cursor := range []string{"not base64!", "bm90IGpzb24", "e30"} {
+		query := models.FileQuery{SortBy: models.FileSortByName, Cursor: cursor}
+		if _, err := DecodeFileCursor(query); !errors.Is(err, models.ErrInvalidCursor) {
+			t.Errorf("DecodeFileCursor(%q) = %v; want ErrInvalidCursor", cursor, err)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/file_upload/config"
+	"github.com/file_upload/models"
+)
+
+// ValidatePassword checks a new password against the policy. Passwords are never
+// trimmed or normalised, as they are compared byte for byte at login.
+func ValidatePassword(password string, policy config.PasswordPolicy) error {
+	minLength := policy.MinLength
+	if minLength <= 0 {
+		minLength = models.MinPasswordLength
+	}
+
+	if len([]rune(password)) < minLength {
+		return fmt.Errorf("%w: must be at least %d characters long", models.ErrWeakPassword, minLength)
+	}
+	if len(password) > models.MaxPasswordBytes {
+		return fmt.Errorf("%w: must be at most %d bytes long", models.ErrWeakPassword, models.MaxPasswordBytes)
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			hasSymbol = true
+		}
+	}
+
+	var missing []string
+	if policy.RequireUpper && !hasUpper {
+		missing = append(missing, "an upper case letter")
+	}
+	if policy.RequireLower && !hasLower {
+		missing = append(missing, "a lower case letter")
+	}
+	if policy.RequireDigit && !hasDigit {
+		missing = append(missing, "a digit")
+	}
+	if policy.RequireSymbol && !hasSymbol {
+		missing = append(missing, "a symbol")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: must contain %s", models.ErrWeakPassword, strings.Join(missing, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/file_upload/config"
+	"github.com/file_upload/models"
+)
+
+func TestValidatePassword(t *testing.T) {
+	strict := config.PasswordPolicy{MinLength: 10, RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSymbol: true}
+
+	tests := []struct {
+		name     string
+		password string
+		policy   config.PasswordPolicy
+		valid    bool
+	}{
+		{name: "default minimum", password: "abcdefgh", valid: true},
+		{name: "empty", password: ""},
+		{name: "below default minimum", password: "abcdefg"},
+		{name: "minimum counts characters", password: "ééééééé", policy: config.PasswordPolicy{MinLength: 7}, valid: true},
+		{name: "longer than bcrypt accepts", password: strings.Repeat("a", models.MaxPasswordBytes+1)},
+		{name: "exactly bcrypt limit", password: strings.Repeat("a", models.MaxPasswordBytes), valid: true},
+		{name: "strict", password: "Correct-Horse1", policy: strict, valid: true},
+		{name: "strict too short", password: "Short-1a", policy: strict},
+		{name: "strict without upper", password: "correct-horse1", policy: strict},
+		{name: "strict without lower", password: "CORRECT-HORSE1", policy: strict},
+		{name: "strict without digit", password: "Correct-Horse", policy: strict},
+		{name: "strict without symbol", password: "CorrectHorse1", policy: strict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePassword(tt.password, tt.policy)
+			if tt.valid && err != nil {
+				t.Fatalf("ValidatePassword(%q) = %v; want nil", tt.password, err)
+			}
+			if !tt.valid && !errors.Is(err, models.ErrWeakPassword) {
+				t.Fatalf("ValidatePassword(%q) = %v; want ErrWeakPassword", tt.password, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/file_upload/models"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Everything written to storage lives at a location derived from server generated
+// values (content hashes and IDs). Names supplied by clients are only ever stored as
+// metadata, after passing SanitizeFilename or ValidateUsername.
+
+var (
+	sha256HexPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	usernamePattern  = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	idPattern        = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+)
+
+// Device names Windows refuses as file names, with or without an extension.
+var reservedFilenames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// BlobKey returns the storage key of the content with the given SHA-256, fanned out by
+// the first two byte pairs of the hash so no directory grows too large.
+func BlobKey(hash string) string {
+	if !sha256HexPattern.MatchString(hash) {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s/%s/%s", models.BlobsPrefix, hash[0:2], hash[2:4], hash)
+}
+
+// StorageKey returns the storage key for a File.Path. Files stored before storage
+// backends existed recorded a path below the storage directory instead of a key.
+func StorageKey(path string) string {
+	return strings.TrimPrefix(path, models.DefaultDirectory+"/")
+}
+
+// UploadTempPath returns where the data of a resumable upload is kept below dir until it is finalized.
+func UploadTempPath(dir, uploadID string) (string, error) {
+	if !idPattern.MatchString(uploadID) {
+		return "", models.ErrInvalidStorageKey
+	}
+	return filepath.Join(dir, uploadID), nil
+}
+
+// SafeJoin maps a slash separated storage key to a path below root. Keys that are
+// empty, absolute, contain NUL bytes or backslashes, or have empty, "." or ".."
+// segments are refused, so the result can never escape root.
+func SafeJoin(root, key string) (string, error) {
+	if key == "" || strings.ContainsAny(key, "\x00\\") || strings.HasPrefix(key, "/") || filepath.IsAbs(key) {
+		return "", models.ErrInvalidStorageKey
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", models.ErrInvalidStorageKey
+		}
+	}
+
+	return filepath.Join(root, filepath.FromSlash(key)), nil
+}
+
+// SanitizeFilename normalises a display file name supplied by a client and checks it
+// is safe to show and to hand back in a Content-Disposition header. The name is put
+// in Unicode NFC form and surrounding spaces are trimmed; it must then be valid UTF-8
+// without control characters or path separators, at most MaxFilenameBytes long, and
+// neither "." nor ".." nor a reserved device name.
+func SanitizeFilename(name string) (string, error) {
+	if !utf8.ValidString(name) {
+		return "", fmt.Errorf("%w: not valid UTF-8", models.ErrInvalidFilename)
+	}
+
+	name = strings.TrimSpace(norm.NFC.String(name))
+
+	if name == "" {
+		return "", fmt.Errorf("%w: empty name", models.ErrInvalidFilename)
+	}
+	if len(name) > models.MaxFilenameBytes {
+		return "", fmt.Errorf("%w: longer than %d bytes", models.ErrInvalidFilename, models.MaxFilenameBytes)
+	}
+	if name == "." || name == ".." {
+		return "", fmt.Errorf("%w: %q is not a file name", models.ErrInvalidFilename, name)
+	}
+
+	for _, r := range name {
+		if r == '/' || r == '\\' {
+			return "", fmt.Errorf("%w: contains a path separator", models.ErrInvalidFilename)
+		}
+		if unicode.IsControl(r) {
+			return "", fmt.Errorf("%w: contains a control character", models.ErrInvalidFilename)
+		}
+	}
+
+	if strings.HasSuffix(name, ".") {
+		return "", fmt.Errorf("%w: ends with a dot", models.ErrInvalidFilename)
+	}
+
+	base := strings.ToUpper(strings.SplitN(name, ".", 2)[0])
+	if reservedFilenames[strings.TrimSpace(base)] {
+		return "", fmt.Errorf("%w: %q is a reserved name", models.ErrInvalidFilename, name)
+	}
+
+	return name, nil
+}
+
+// ValidateUsername checks a username can be used as a single path segment: ASCII
+// letters, digits, '.', '_' and '-', starting with a letter or digit.
+func ValidateUsername(username string) error {
+	if len(username) < models.MinUsernameLength || len(username) > models.MaxUsernameLength {
+		return fmt.Errorf("%w: must be %d to %d characters long", models.ErrInvalidUsername, models.MinUsernameLength, models.MaxUsernameLength)
+	}
+
+	if !usernamePattern.MatchString(username) {
+		return fmt.Errorf("%w: only letters, digits, '.', '_' and '-' are allowed, starting with a letter or digit", models.ErrInvalidUsername)
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/file_upload/models"
+)
+
+func TestSafeJoin(t *testing.T) {
+	root := filepath.Join("storage", "root")
+
+	tests := []struct {
+		name string
+		key  string
+		want string
+	}{
+		{name: "blob key", key: "blobs/ab/cd/abcd", want: filepath.Join(root, "blobs", "ab", "cd", "abcd")},
+		{name: "single segment", key: "file.txt", want: filepath.Join(root, "file.txt")},
+		{name: "dot inside segment", key: "a/..b/c..", want: filepath.Join(root, "a", "..b", "c..")},
+		{name: "empty", key: ""},
+		{name: "parent", key: ".."},
+		{name: "parent prefix", key: "../etc/passwd"},
+		{name: "parent in the middle", key: "blobs/../../etc/passwd"},
+		{name: "parent at the end", key: "blobs/.."},
+		{name: "current directory", key: "./blobs"},
+		{name: "absolute", key: "/etc/passwd"},
+		{name: "windows separator", key: `..\..\etc\passwd`},
+		{name: "windows absolute", key: `C:\Windows\win.ini`},
+		{name: "empty segment", key: "blobs//ab"},
+		{name: "trailing slash", key: "blobs/"},
+		{name: "nul byte", key: "blobs/ab\x00.txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SafeJoin(root, tt.key)
+			if tt.want == "" {
+				if !errors.Is(err, models.ErrInvalidStorageKey) {
+					t.Fatalf("SafeJoin(%q) = %q, %v; want ErrInvalidStorageKey", tt.key, got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("SafeJoin(%q) = %q, %v; want %q", tt.key, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		want     string
+	}{
+		{name: "plain", filename: "report.pdf", want: "report.pdf"},
+		{name: "spaces trimmed", filename: "  report.pdf \t", want: "report.pdf"},
+		{name: "unicode normalised to NFC", filename: "cafe\u0301.txt", want: "caf\u00e9.txt"},
+		{name: "unicode kept", filename: "Übersicht 2024.xlsx", want: "Übersicht 2024.xlsx"},
+		{name: "hidden file", filename: ".env", want: ".env"},
+		{name: "reserved word inside name", filename: "console.log", want: "console.log"},
+		{name: "exactly max length", filename: strings.Repeat("a", models.MaxFilenameBytes), want: strings.Repeat("a", models.MaxFilenameBytes)},
+		{name: "empty", filename: ""},
+		{name: "only spaces", filename: "   "},
+		{name: "dot", filename: "."},
+		{name: "dot dot", filename: ".."},
+		{name: "traversal", filename: "../../etc/passwd"},
+		{name: "windows traversal", filename: `..\..\boot.ini`},
+		{name: "absolute", filename: "/etc/passwd"},
+		{name: "windows absolute", filename: `C:\Users\me\file.txt`},
+		{name: "nul byte", filename: "file.txt\x00.jpg"},
+		{name: "newline", filename: "file\n.txt"},
+		{name: "invalid utf8", filename: "file\xff.txt"},
+		{name: "too long", filename: strings.Repeat("a", models.MaxFilenameBytes+1)},
+		{name: "too long in bytes", filename: strings.Repeat("é", models.MaxFilenameBytes/2+1)},
+		{name: "trailing dot", filename: "file."},
+		{name: "reserved device", filename: "CON"},
+		{name: "reserved device lower case", filename: "nul"},
+		{name: "reserved device with extension", filename: "com1.txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SanitizeFilename(tt.filename)
+			if tt.want == "" {
+				if !errors.Is(err, models.ErrInvalidFilename) {
+					t.Fatalf("SanitizeFilename(%q) = %q, %v; want ErrInvalidFilename", tt.filename, got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("SanitizeFilename(%q) = %q, %v; want %q", tt.filename, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateUsername(t *testing.T) {
+	tests := []struct {
+		username string
+		valid    bool
+	}{
+		{username: "alice", valid: true},
+		{username: "alice.smith_01-x", valid: true},
+		{username: "Bob", valid: true},
+		{username: "ab"},
+		{username: strings.Repeat("a", models.MaxUsernameLength+1)},
+		{username: ".."},
+		{username: "../admin"},
+		{username: "alice/bob"},
+		{username: `alice\bob`},
+		{username: "/root"},
+		{username: ".hidden"},
+		{username: "-rf"},
+		{username: "alice\x00"},
+		{username: "ali ce"},
+		{username: "élise"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.username, func(t *testing.T) {
+			err := ValidateUsername(tt.username)
+			if tt.valid && err != nil {
+				t.Fatalf("ValidateUsername(%q) = %v; want nil", tt.username, err)
+			}
+			if !tt.valid && !errors.Is(err, models.ErrInvalidUsername) {
+				t.Fatalf("ValidateUsername(%q) = %v; want ErrInvalidUsername", tt.username, err)
+			}
+		})
+	}
+}
+
+func TestBlobKey(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+	if got, want := BlobKey(hash), "blobs/ab/ab/"+hash; got != want {
+		t.Fatalf("BlobKey(%q) = %q; want %q", hash, got, want)
+	}
+
+	for _, hash := range []string{"", "abcd", "../" + strings.Repeat("a", 61), strings.Repeat("A", 64)} {
+		if got := BlobKey(hash); got != "" {
+			t.Fatalf("BlobKey(%q) = %q; want empty", hash, got)
+		}
+	}
+}
+
+func TestUploadTempPath(t *testing.T) {
+	got, err := UploadTempPath("uploads", "0b7f3f0e-6c53-4f0e-9a57-3a8d2f1c9e10")
+	if err != nil || got != filepath.Join("uploads", "0b7f3f0e-6c53-4f0e-9a57-3a8d2f1c9e10") {
+		t.Fatalf("UploadTempPath = %q, %v", got, err)
+	}
+
+	for _, id := range []string{"", "..", "../x", "a/b", "a\x00b"} {
+		if _, err := UploadTempPath("uploads", id); !errors.Is(err, models.ErrInvalidStorageKey) {
+			t.Fatalf("UploadTempPath(%q) = %v; want ErrInvalidStorageKey", id, err)
+		}
+	}
+}
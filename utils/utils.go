@@ -1,6 +1,13 @@
 package utils
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -73,9 +80,57 @@ func CreateDirIfNotExist(path string) error {
 	return nil
 }
 
-func init() {
-	err := CreateDirIfNotExist(models.DefaultDirectory)
+// GenerateSecureToken returns a random, URL safe token with 256 bits of entropy.
+func GenerateSecureToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashToken returns the SHA-256 of a token, for storing secrets that only need to be compared.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ContextReader returns a reader that fails with the context's error once it is done,
+// so copying a large body stops when the request is cancelled.
+func ContextReader(ctx context.Context, r io.Reader) io.Reader {
+	return &contextReader{ctx: ctx, r: r}
+}
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// StreamToTempFile copies r into a new temporary file inside dir and computes the
+// SHA-256 of the data in the same pass, so the content is never held in memory.
+// The caller owns the returned file and must rename or remove it.
+func StreamToTempFile(dir string, r io.Reader) (string, string, int64, error) {
+	tempFile, err := os.CreateTemp(dir, ".upload-*")
 	if err != nil {
-		log.Fatalf("Error ensuring directory exists: %v", err)
+		return "", "", 0, err
 	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tempFile, hash), r)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return "", "", 0, err
+	}
+
+	return tempFile.Name(), fmt.Sprintf("%x", hash.Sum(nil)), size, nil
 }
@@ -0,0 +1,256 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/file_upload/models"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// RequestBodyError describes why a request body or query string was refused, field by
+// field where possible.
+type RequestBodyError struct {
+	Message     string
+	FieldErrors []models.FieldError
+}
+
+func (e *RequestBodyError) Error() string {
+	if len(e.FieldErrors) == 0 {
+		return e.Message
+	}
+
+	fields := make([]string, 0, len(e.FieldErrors))
+	for _, fieldErr := range e.FieldErrors {
+		fields = append(fields, fieldErr.Field+" "+fieldErr.Message)
+	}
+	return e.Message + ": " + strings.Join(fields, "; ")
+}
+
+func init() {
+	validate, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+
+	// Report fields by their JSON names.
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	validate.RegisterValidation("username", func(fl validator.FieldLevel) bool {
+		return ValidateUsername(fl.Field().String()) == nil
+	})
+}
+
+// DecodeJSONBody decodes the request body into dst and checks its binding tags.
+// Unknown fields, trailing data and type mismatches are refused.
+func DecodeJSONBody(c *gin.Context, dst interface{}) error {
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(dst); err != nil {
+		return decodeError(err)
+	}
+	if decoder.More() {
+		return &RequestBodyError{Message: "request body must contain a single JSON object"}
+	}
+
+	if err := binding.Validator.ValidateStruct(dst); err != nil {
+		return validationError("request body has invalid fields", err)
+	}
+
+	return nil
+}
+
+// DecodeQuery binds the query string into dst, using its form tags, and checks its
+// binding tags. Unknown parameters are ignored.
+func DecodeQuery(c *gin.Context, dst interface{}) error {
+	// Binding reports a value of the wrong type without saying which parameter it was in.
+	if fieldErrs := queryTypeErrors(c, dst); len(fieldErrs) > 0 {
+		return &RequestBodyError{Message: "invalid query parameters", FieldErrors: fieldErrs}
+	}
+
+	if err := c.ShouldBindQuery(dst); err != nil {
+		return validationError("invalid query parameters", err)
+	}
+
+	return nil
+}
+
+// RespondRequestBodyErr responds to a request refused by DecodeJSONBody or DecodeQuery.
+func RespondRequestBodyErr(c *gin.Context, err error) {
+	var bodyErr *RequestBodyError
+	if !errors.As(err, &bodyErr) {
+		RespondClientErr(c, err, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	RespondFieldErrs(c, bodyErr, bodyErr.Message, bodyErr.FieldErrors...)
+}
+
+// RespondFieldErrs responds with a 400 naming the fields that are wrong.
+func RespondFieldErrs(c *gin.Context, err error, messageToUser string, fieldErrs ...models.FieldError) {
+	c.JSON(http.StatusBadRequest, models.ClientError{
+		MessageToUser: messageToUser,
+		Err:           err.Error(),
+		StatusCode:    http.StatusBadRequest,
+		IsClientError: true,
+		FieldErrors:   fieldErrs,
+	})
+}
+
+// NewFieldError describes a field refused by a validator returning an error that wraps
+// kind, such as models.ErrWeakPassword, without repeating the kind in the message.
+func NewFieldError(field string, err, kind error) models.FieldError {
+	return models.FieldError{
+		Field:   field,
+		Message: strings.TrimPrefix(err.Error(), kind.Error()+": "),
+	}
+}
+
+func decodeError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.Is(err, io.EOF):
+		return &RequestBodyError{Message: "request body is required"}
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return &RequestBodyError{Message: "request body is not valid JSON"}
+	case errors.As(err, &typeErr):
+		return &RequestBodyError{
+			Message:     "request body has invalid fields",
+			FieldErrors: []models.FieldError{{Field: typeErr.Field, Message: fmt.Sprintf("must be a %s", jsonTypeName(typeErr.Type))}},
+		}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return &RequestBodyError{
+			Message:     "request body has unknown fields",
+			FieldErrors: []models.FieldError{{Field: field, Message: "is not allowed"}},
+		}
+	default:
+		return &RequestBodyError{Message: err.Error()}
+	}
+}
+
+func validationError(message string, err error) error {
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return &RequestBodyError{Message: message + ": " + err.Error()}
+	}
+
+	bodyErr := &RequestBodyError{Message: message}
+	for _, fieldErr := range validationErrs {
+		bodyErr.FieldErrors = append(bodyErr.FieldErrors, models.FieldError{
+			Field:   fieldErr.Field(),
+			Message: validationMessage(fieldErr),
+		})
+	}
+	return bodyErr
+}
+
+func validationMessage(fieldErr validator.FieldError) string {
+	isString := fieldErr.Kind() == reflect.String
+
+	switch fieldErr.Tag() {
+	case "required":
+		return "is required"
+	case "username":
+		return fmt.Sprintf("must be %d to %d letters, digits, '.', '_' or '-', starting with a letter or digit", models.MinUsernameLength, models.MaxUsernameLength)
+	case "min":
+		if isString {
+			return fmt.Sprintf("must be at least %s characters long", fieldErr.Param())
+		}
+		return fmt.Sprintf("must be at least %s", fieldErr.Param())
+	case "max":
+		if isString {
+			return fmt.Sprintf("must be at most %s characters long", fieldErr.Param())
+		}
+		return fmt.Sprintf("must be at most %s", fieldErr.Param())
+	case "gt":
+		return fmt.Sprintf("must be greater than %s", fieldErr.Param())
+	case "oneof":
+		return fmt.Sprintf("must be one of %s", strings.ReplaceAll(fieldErr.Param(), " ", ", "))
+	default:
+		return fmt.Sprintf("failed the %q check", fieldErr.Tag())
+	}
+}
+
+// queryTypeErrors lists the query parameters whose values can't be parsed as the type
+// of the field of dst they bind to. Empty values bind as zero and are left alone.
+func queryTypeErrors(c *gin.Context, dst interface{}) []models.FieldError {
+	dstType := reflect.TypeOf(dst)
+	for dstType.Kind() == reflect.Pointer {
+		dstType = dstType.Elem()
+	}
+	if dstType.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var fieldErrs []models.FieldError
+	for i := 0; i < dstType.NumField(); i++ {
+		field := dstType.Field(i)
+		name := strings.SplitN(field.Tag.Get("form"), ",", 2)[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+
+		for _, value := range c.QueryArray(name) {
+			if value != "" && !parsesAs(value, fieldType) {
+				fieldErrs = append(fieldErrs, models.FieldError{Field: name, Message: fmt.Sprintf("must be a %s", jsonTypeName(fieldType))})
+				break
+			}
+		}
+	}
+	return fieldErrs
+}
+
+func parsesAs(value string, t reflect.Type) bool {
+	var err error
+	switch t.Kind() {
+	case reflect.Bool:
+		_, err = strconv.ParseBool(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		_, err = strconv.ParseInt(value, 10, t.Bits())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		_, err = strconv.ParseUint(value, 10, t.Bits())
+	case reflect.Float32, reflect.Float64:
+		_, err = strconv.ParseFloat(value, t.Bits())
+	}
+	return err == nil
+}
+
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "list"
+	default:
+		return "object"
+	}
+}
@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/file_upload/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestDecodeJSONBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name   string
+		body   string
+		fields []models.FieldError
+		valid  bool
+	}{
+		{name: "valid", body: `{"username": "alice", "password": "secret"}`, valid: true},
+		{name: "empty body"},
+		{name: "not json", body: `{"username": `},
+		{name: "trailing data", body: `{"username": "alice", "password": "secret"} {}`},
+		{name: "unknown field", body: `{"username": "alice", "password": "secret", "quota": 1}`, fields: []models.FieldError{{Field: "quota", Message: "is not allowed"}}},
+		{name: "wrong type", body: `{"username": "alice", "password": 7}`, fields: []models.FieldError{{Field: "password", Message: "must be a string"}}},
+		{name: "missing fields", body: `{"name": "Alice"}`, fields: []models.FieldError{
+			{Field: "username", Message: "is required"},
+			{Field: "password", Message: "is required"},
+		}},
+		{name: "invalid username", body: `{"username": "../admin", "password": "secret"}`, fields: []models.FieldError{
+			{Field: "username", Message: "must be 3 to 32 letters, digits, '.', '_' or '-', starting with a letter or digit"},
+		}},
+		{name: "too long", body: `{"name": "` + strings.Repeat("a", 101) + `", "username": "alice", "password": "secret"}`, fields: []models.FieldError{
+			{Field: "name", Message: "must be at most 100 characters long"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(tt.body))
+
+			var request models.RegisterRequest
+			err := DecodeJSONBody(c, &request)
+
+			if tt.valid {
+				if err != nil {
+					t.Fatalf("DecodeJSONBody(%s) = %v; want nil", tt.body, err)
+				}
+				return
+			}
+
+			var bodyErr *RequestBodyError
+			if !errors.As(err, &bodyErr) {
+				t.Fatalf("DecodeJSONBody(%s) = %v; want *RequestBodyError", tt.body, err)
+			}
+			if len(bodyErr.FieldErrors) != len(tt.fields) {
+				t.Fatalf("DecodeJSONBody(%s) field errors = %+v; want %+v", tt.body, bodyErr.FieldErrors, tt.fields)
+			}
+			for i, field := range tt.fields {
+				if bodyErr.FieldErrors[i] != field {
+					t.Fatalf("DecodeJSONBody(%s) field error %d = %+v; want %+v", tt.body, i, bodyErr.FieldErrors[i], field)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name   string
+		query  string
+		fields []models.FieldError
+		valid  bool
+	}{
+		{name: "valid", query: "limit=10&min_size=1&sort=size&order=desc", valid: true},
+		{name: "empty number", query: "min_size=", valid: true},
+		{name: "not a number", query: "limit=ten", fields: []models.FieldError{{Field: "limit", Message: "must be a number"}}},
+		{name: "pointer field", query: "min_size=1kb", fields: []models.FieldError{{Field: "min_size", Message: "must be a number"}}},
+		{name: "overflow", query: "uploaded_after=99999999999999999999", fields: []models.FieldError{{Field: "uploaded_after", Message: "must be a number"}}},
+		{name: "several fields", query: "limit=x&max_size=1.5", fields: []models.FieldError{
+			{Field: "limit", Message: "must be a number"},
+			{Field: "max_size", Message: "must be a number"},
+		}},
+		{name: "out of range", query: "limit=500", fields: []models.FieldError{{Field: "limit", Message: "must be at most 200"}}},
+		{name: "unknown sort", query: "sort=owner", fields: []models.FieldError{{Field: "sort", Message: "must be one of name, size, date"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/files?"+tt.query, nil)
+
+			var request models.ListFilesRequest
+			err := DecodeQuery(c, &request)
+
+			if tt.valid {
+				if err != nil {
+					t.Fatalf("DecodeQuery(%s) = %v; want nil", tt.query, err)
+				}
+				return
+			}
+
+			var bodyErr *RequestBodyError
+			if !errors.As(err, &bodyErr) {
+				t.Fatalf("DecodeQuery(%s) = %v; want *RequestBodyError", tt.query, err)
+			}
+			if len(bodyErr.FieldErrors) != len(tt.fields) {
+				t.Fatalf("DecodeQuery(%s) field errors = %+v; want %+v", tt.query, bodyErr.FieldErrors, tt.fields)
+			}
+			for i, field := range tt.fields {
+				if bodyErr.FieldErrors[i] != field {
+					t.Fatalf("DecodeQuery(%s) field error %d = %+v; want %+v", tt.query, i, bodyErr.FieldErrors[i], field)
+				}
+			}
+		})
+	}
+}